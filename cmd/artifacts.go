@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
+	"github.com/CloudNativeWorks/elchi-client/internal/services"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var artifactsCmd = &cobra.Command{
+	Use:   "artifacts",
+	Short: "Manage envoy and WAF artifacts",
+}
+
+var importForce bool
+
+var artifactsImportCmd = &cobra.Command{
+	Use:   "import <tarball>",
+	Short: "Install envoy and WAF versions from an offline bundle",
+	Long: `Install the envoy and WAF versions contained in a tar or tar.gz bundle for
+hosts without access to the artifact index. The bundle carries an index.json
+at its root (same schema as the public archive index) with download_url
+entries relative to it, plus an optional "<file>.sig" next to each binary.
+Checksums and signatures are verified exactly as for online downloads.
+Versions already installed are skipped unless --force is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log := logger.NewLogger("artifacts")
+		if err := configureArtifacts(log); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+
+		results, err := services.ImportArtifacts(ctx, args[0], importForce, log)
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				fmt.Printf("%s %s: failed: %s\n", r.Component, r.Version, r.Error)
+			case r.Skipped:
+				fmt.Printf("%s %s: already installed\n", r.Component, r.Version)
+			default:
+				fmt.Printf("%s %s: installed %s\n", r.Component, r.Version, r.Path)
+			}
+		}
+		if err == nil && len(results) == 0 {
+			fmt.Println("Bundle contains no versions for this architecture")
+		}
+		return err
+	},
+}
+
+// configureArtifacts applies the artifacts config section: where the index is
+// fetched from, how fast downloads may go and which keys signatures are
+// checked against. A malformed key or index URL is an error rather than a
+// silent fallback that would weaken verification or fetch from the wrong place.
+func configureArtifacts(log *logger.Logger) error {
+	source, err := common.NewArtifactSource(Cfg.Artifacts.IndexURL, Cfg.Artifacts.Headers)
+	if err != nil {
+		return fmt.Errorf("invalid artifacts.index_url: %w", err)
+	}
+	common.SetArtifactSource(source)
+	common.SetDownloadRateLimit(Cfg.Artifacts.DownloadRateLimit)
+
+	signing := Cfg.Artifacts.Signing
+	policy, err := common.NewSignaturePolicy(signing.PublicKeys, signing.AllowUnsigned)
+	if err != nil {
+		return fmt.Errorf("invalid artifacts.signing config: %w", err)
+	}
+	common.SetSignaturePolicy(policy)
+
+	if len(signing.PublicKeys) == 0 && !signing.AllowUnsigned {
+		log.Warn("No artifact signing keys configured; envoy, WAF and shield downloads will be rejected")
+	} else if signing.AllowUnsigned {
+		log.Warn("artifacts.signing.allow_unsigned is set; unsigned downloads will be accepted")
+	}
+	return nil
+}
+
+func init() {
+	artifactsImportCmd.Flags().BoolVar(&importForce, "force", false, "reinstall versions that are already present")
+	artifactsCmd.AddCommand(artifactsImportCmd)
+	RootCmd.AddCommand(artifactsCmd)
+}
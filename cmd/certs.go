@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/certs"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Manage the TLS bundles envoy loads through SDS",
+}
+
+var certsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List managed and bootstrap-referenced certificates",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		list := certs.Inventory(time.Now())
+		if len(list) == 0 {
+			fmt.Println("No certificates")
+			return nil
+		}
+		for _, c := range list {
+			if c.Error != "" {
+				fmt.Printf("%s  %s  error: %s\n", c.Source, c.Path, c.Error)
+				continue
+			}
+			fmt.Printf("%s  %s\n", c.Source, c.Path)
+			fmt.Printf("  subject %s, %d days left (%s)\n", c.Subject, c.DaysLeft, c.NotAfter.Format("2006-01-02"))
+			if len(c.SANs) > 0 {
+				fmt.Printf("  SANs %v\n", c.SANs)
+			}
+			fmt.Printf("  sha256 %s\n", c.SHA256)
+		}
+		return nil
+	},
+}
+
+var certsInstallCmd = &cobra.Command{
+	Use:   "install <name>",
+	Short: "Install or rotate a bundle and print its sds_config",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var b certs.Bundle
+		for _, f := range []struct {
+			flag string
+			dst  *[]byte
+		}{{"cert", &b.Cert}, {"key", &b.Key}, {"ca", &b.CA}} {
+			path, _ := cmd.Flags().GetString(f.flag)
+			if path == "" {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			*f.dst = data
+		}
+		info, err := certs.Install(args[0], b)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s: %s, %d days left, sha256 %s\n\n", args[0], info.Subject, info.DaysLeft, info.SHA256)
+		out, err := yaml.Marshal(map[string]any{"sds_config": certs.SDSConfig(args[0])})
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+		return nil
+	},
+}
+
+var certsRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a bundle no bootstrap references",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return certs.Remove(args[0])
+	},
+}
+
+func init() {
+	certsInstallCmd.Flags().String("cert", "", "PEM certificate chain")
+	certsInstallCmd.Flags().String("key", "", "PEM private key")
+	certsInstallCmd.Flags().String("ca", "", "PEM CA bundle for validating peers (optional)")
+	certsInstallCmd.MarkFlagRequired("cert")
+	certsInstallCmd.MarkFlagRequired("key")
+
+	certsCmd.AddCommand(certsListCmd)
+	certsCmd.AddCommand(certsInstallCmd)
+	certsCmd.AddCommand(certsRemoveCmd)
+	RootCmd.AddCommand(certsCmd)
+}
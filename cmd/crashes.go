@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/crashes"
+	"github.com/spf13/cobra"
+)
+
+var crashesCmd = &cobra.Command{
+	Use:   "crashes",
+	Short: "Inspect captured envoy crash loops",
+}
+
+var crashesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List crash captures, newest first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		list, err := crashes.NewStore(crashes.DefaultDir, 0, 0).List()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			fmt.Println("No crash captures")
+			return nil
+		}
+		for _, r := range list {
+			exit := fmt.Sprintf("exit %d", r.ExitCode)
+			if r.Signal != 0 {
+				exit = fmt.Sprintf("signal %d", r.Signal)
+			}
+			if r.EnvoySignal != 0 {
+				exit += fmt.Sprintf(", envoy signal %d", r.EnvoySignal)
+			} else if r.EnvoyExitCode != nil {
+				exit += fmt.Sprintf(", envoy exit %d", *r.EnvoyExitCode)
+			}
+			core := "no core"
+			if r.Core != "" {
+				core = "core " + r.Core
+			}
+			fmt.Printf("%s  %d restarts in %s, %s, %s\n", r.ID, r.RestartsInWindow, r.Window, exit, core)
+		}
+		return nil
+	},
+}
+
+var crashesShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Print one crash capture with its log tail",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rec, tail, err := crashes.NewStore(crashes.DefaultDir, 0, 0).Get(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("ID:          %s\n", rec.ID)
+		fmt.Printf("Service:     %s\n", rec.Service)
+		fmt.Printf("Detected:    %s\n", rec.DetectedAt.Format("2006-01-02 15:04:05 MST"))
+		fmt.Printf("Restarts:    %d within %s (%d total)\n", rec.RestartsInWindow, rec.Window, rec.Restarts)
+		fmt.Printf("Unit result: %s, exit code %d, signal %d\n", rec.Result, rec.ExitCode, rec.Signal)
+		if rec.EnvoyExitCode != nil {
+			fmt.Printf("Envoy exit:  code %d, signal %d\n", *rec.EnvoyExitCode, rec.EnvoySignal)
+		}
+		if rec.Core != "" {
+			fmt.Printf("Core:        %s/%s/%s (%d bytes, from %s)\n", crashes.DefaultDir, rec.ID, rec.Core, rec.CoreBytes, rec.CoreSource)
+		} else if rec.CoreNote != "" {
+			fmt.Printf("Core:        none (%s)\n", rec.CoreNote)
+		}
+		if len(tail) > 0 {
+			fmt.Printf("\nLast %d lines of %s:\n%s", rec.LogLines, rec.LogSource, tail)
+		}
+		return nil
+	},
+}
+
+func init() {
+	crashesCmd.AddCommand(crashesListCmd)
+	crashesCmd.AddCommand(crashesShowCmd)
+	RootCmd.AddCommand(crashesCmd)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/hotrestart"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// hotrestartCmd is the native replacement for hotrestarter.py. It takes the
+// envoy command line as ONE argument, exactly like the Python wrapper, so a unit
+// only swaps the ExecStart prefix (see template.NativeSystemdTemplate).
+//
+// It runs as envoyuser inside the envoy unit, so it is marked standalone: it
+// must not read /etc/elchi/config.yaml (which holds the controller token and is
+// not readable by envoyuser).
+var hotrestartCmd = &cobra.Command{
+	Use:   "hotrestart <envoy command>",
+	Short: "Run envoy under the hot-restart supervisor",
+	Long: `Run an envoy command under the hot-restart epoch/base-id protocol.
+SIGHUP starts a new epoch, SIGTERM/SIGINT are propagated to all children and
+SIGUSR1 is forwarded. The current epoch and child PIDs are written to
+/var/lib/elchi/hotrestarter/<base-id>.status.json. A command line staged in
+/var/lib/elchi/hotrestarter/<base-id>.next replaces the envoy command from the
+next SIGHUP on (used by blue/green upgrades).`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{standaloneAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log := logger.NewLogger("hotrestart")
+
+		sup, err := hotrestart.NewSupervisor(args[0], log)
+		if err != nil {
+			return err
+		}
+
+		if code := sup.Run(context.Background()); code != 0 {
+			os.Exit(code)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(hotrestartCmd)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var netplanCmd = &cobra.Command{
+	Use:   "netplan",
+	Short: "Inspect the netplan configuration managed by the client",
+}
+
+var netplanPlanCmd = &cobra.Command{
+	Use:   "plan <file>",
+	Short: "Show what applying a netplan YAML would change, without applying it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		plan, err := network.NewNetplanManager(logger.NewLogger("netplan")).PlanNetplanConfig(string(data))
+		if err != nil {
+			return err
+		}
+
+		if !plan.Changed {
+			fmt.Printf("%s is unchanged\n", plan.File)
+		} else {
+			fmt.Print(plan.Diff)
+		}
+		if plan.Generate.OK {
+			fmt.Printf("\nnetplan generate: ok, %d backend files\n", len(plan.Generate.Files))
+			files := make([]string, 0, len(plan.Generate.Files))
+			for f := range plan.Generate.Files {
+				files = append(files, f)
+			}
+			sort.Strings(files)
+			for _, f := range files {
+				fmt.Printf("  %s\n", f)
+			}
+		} else {
+			fmt.Printf("\nnetplan generate failed: %s\n", plan.Generate.Output)
+		}
+
+		fmt.Println("\nPredicted changes:")
+		for _, l := range plan.Links {
+			fmt.Printf("  link    %-7s %s (%s) %s\n", l.Action, l.Name, l.Kind, l.Detail)
+		}
+		for _, a := range plan.Addresses {
+			fmt.Printf("  address %-7s %s on %s\n", a.Action, a.Address, a.Interface)
+		}
+		for _, r := range plan.Routes {
+			fmt.Printf("  route   %-7s %s dev %s table %d (%s)\n", r.Action, r.Destination, r.Interface, r.Table, r.Reason)
+		}
+		if len(plan.Links)+len(plan.Addresses)+len(plan.Routes) == 0 {
+			fmt.Println("  none")
+		}
+		for _, w := range plan.Warnings {
+			fmt.Printf("warning: %s\n", w)
+		}
+		return nil
+	},
+}
+
+func init() {
+	netplanCmd.AddCommand(netplanPlanCmd)
+	RootCmd.AddCommand(netplanCmd)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/CloudNativeWorks/elchi-client/internal/config"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
 	"github.com/spf13/cobra"
 )
 
@@ -13,8 +14,18 @@ var (
 	clientName string
 	Cfg        *config.Config
 	Version    string
+
+	// standalone is set for subcommands that run without the client config
+	// (see standaloneAnnotation).
+	standalone bool
 )
 
+// standaloneAnnotation marks a subcommand that must not load the client config.
+// Such commands run outside the client's own unit (e.g. hotrestart runs as
+// envoyuser inside every envoy unit) where config.yaml is either absent or
+// deliberately unreadable, and a config error would otherwise os.Exit them.
+const standaloneAnnotation = "elchi-standalone"
+
 var RootCmd = &cobra.Command{
 	Use:   "elchi-client",
 	Short: "Elchi Client - A gRPC client that communicates with a remote server",
@@ -23,6 +34,9 @@ var RootCmd = &cobra.Command{
 
 func Execute(version string) error {
 	Version = version
+	if c, _, err := RootCmd.Find(os.Args[1:]); err == nil && c.Annotations[standaloneAnnotation] == "true" {
+		standalone = true
+	}
 	return RootCmd.Execute()
 }
 
@@ -33,6 +47,16 @@ func init() {
 }
 
 func initConfig() {
+	if standalone {
+		// No config file, but the logger must still be usable. JSON keeps the
+		// journal output of the envoy unit machine-readable.
+		if err := logger.Init(logger.Config{Level: "info", Format: "json", Module: "main"}); err != nil {
+			fmt.Printf("Fatal: logger could not be initialized: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// LoadConfig handles both an explicit --config path and default-path
 	// discovery (cwd, $HOME/.elchi, /etc/elchi) when cfgFile is empty. It reads
 	// env vars, unmarshals the file into the struct, applies defaults for
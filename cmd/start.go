@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -18,9 +20,16 @@ import (
 	grpcClient "github.com/CloudNativeWorks/elchi-client/internal/grpc"
 	"github.com/CloudNativeWorks/elchi-client/internal/handlers"
 	"github.com/CloudNativeWorks/elchi-client/internal/initializer"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/certs"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/drain"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/proxy"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/stats"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/upgrade"
 	"github.com/CloudNativeWorks/elchi-client/internal/services"
 	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
 	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	"github.com/CloudNativeWorks/elchi-client/pkg/template"
 	client "github.com/CloudNativeWorks/elchi-proto/client"
 	"github.com/sony/gobreaker"
 	"github.com/spf13/cobra"
@@ -101,8 +110,31 @@ func (m *SessionManager) Run() error {
 	// delivered. Self-contained (own runner) and bound to the process context, so it
 	// stops cleanly on shutdown.
 	reconciler := services.NewReconciler(m.logger)
+	gcOpts, warns := versionGCOptions()
+	for _, w := range warns {
+		m.logger.Warn(w)
+	}
+	reconciler.SetVersionGC(gcOpts)
 	go reconciler.Start(m.ctx)
 
+	// Scrape every deployment's stats for the local endpoint and the
+	// control-plane summary.
+	if interval := m.statsInterval(); interval > 0 {
+		go services.NewStatsCollector(m.logger).Start(m.ctx, interval, Cfg.Stats.Listen)
+	}
+
+	if opts, ok := m.crashWatchOptions(); ok {
+		go services.NewCrashWatcher(m.logger, opts).Start(m.ctx)
+	}
+
+	if opts, ok := m.certWatchOptions(); ok {
+		go services.NewCertWatcher(m.logger, opts).Start(m.ctx)
+	}
+
+	if opts, ok := m.netWatchOptions(); ok {
+		go services.NewNetWatcher(m.logger, opts).Start(m.ctx)
+	}
+
 	return m.mainLoop()
 }
 
@@ -118,6 +150,26 @@ func (m *SessionManager) initialize() error {
 		return fmt.Errorf("configuration not loaded")
 	}
 
+	m.selectHotRestarter()
+
+	if err := configureArtifacts(m.logger); err != nil {
+		return err
+	}
+
+	policy, err := proxy.NewPolicy(Cfg.EnvoyAdmin.Allow, Cfg.EnvoyAdmin.VirtualAllow, Cfg.EnvoyAdmin.MaxResponseBytes)
+	if err != nil {
+		return fmt.Errorf("invalid envoy_admin config: %w", err)
+	}
+	proxy.SetPolicy(policy)
+
+	if err := configureUpgrade(); err != nil {
+		return err
+	}
+
+	if err := configureDrain(); err != nil {
+		return err
+	}
+
 	session, err := m.createSession()
 	if err != nil {
 		return err
@@ -129,6 +181,197 @@ func (m *SessionManager) initialize() error {
 	return nil
 }
 
+// selectHotRestarter picks the supervisor envoy units are rendered with. The
+// Python wrapper stays in place either way, so switching back is only a config
+// change; existing units follow on their next deployment check.
+func (m *SessionManager) selectHotRestarter() {
+	switch Cfg.Client.HotRestarter {
+	case "", config.HotRestarterPython:
+		return
+	case config.HotRestarterNative:
+		binary, err := os.Executable()
+		if err != nil {
+			m.logger.Warnf("Cannot resolve elchi-client path for native hot restarter, keeping hotrestarter.py: %v", err)
+			return
+		}
+		template.UseNativeHotRestarter(binary)
+		m.logger.Infof("Envoy units will use the native hot restarter (%s hotrestart)", binary)
+	default:
+		m.logger.Warnf("Unknown client.hot_restarter %q, keeping hotrestarter.py", Cfg.Client.HotRestarter)
+	}
+}
+
+// configureUpgrade applies the upgrade section. Blue/green needs the native hot
+// restarter; units still on hotrestarter.py are refused (or, for a graceful
+// request under in_place, restarted) per upgrade, not here, since they are
+// re-rendered on their next deployment check.
+func configureUpgrade() error {
+	upgrade.SetBatchDefaults(Cfg.Upgrade.BatchConcurrency, Cfg.Upgrade.BatchRollback)
+
+	switch Cfg.Upgrade.Strategy {
+	case "", config.UpgradeInPlace, config.UpgradeBlueGreen:
+	default:
+		return fmt.Errorf("invalid upgrade.strategy %q, want %q or %q", Cfg.Upgrade.Strategy, config.UpgradeInPlace, config.UpgradeBlueGreen)
+	}
+
+	// The blue/green options apply to graceful requests under in_place too.
+	opts := upgrade.BlueGreenOptions{Max5xxRatioIncrease: Cfg.Upgrade.Max5xxRatioIncrease}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"upgrade.soak", Cfg.Upgrade.Soak, &opts.Soak},
+		{"upgrade.check_interval", Cfg.Upgrade.CheckInterval, &opts.CheckInterval},
+		{"upgrade.ready_timeout", Cfg.Upgrade.ReadyTimeout, &opts.ReadyTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		*d.dst = v
+	}
+	for _, c := range Cfg.Upgrade.Checks {
+		rule, err := stats.ParseRule(c)
+		if err != nil {
+			return fmt.Errorf("invalid upgrade.checks: %w", err)
+		}
+		opts.Rules = append(opts.Rules, rule)
+	}
+	upgrade.SetBlueGreen(opts, Cfg.Upgrade.Strategy == config.UpgradeBlueGreen)
+	return nil
+}
+
+// configureDrain applies the drain section used before undeploy and restarts.
+func configureDrain() error {
+	opts := drain.Options{Strategy: Cfg.Drain.Strategy, Threshold: Cfg.Drain.Threshold, OnRestart: Cfg.Drain.OnRestart}
+	switch opts.Strategy {
+	case "":
+		opts.Strategy = config.DrainGraceful
+	case config.DrainGraceful, config.DrainHealthcheckFail, config.DrainNone:
+	default:
+		return fmt.Errorf("invalid drain.strategy %q, want %q, %q or %q", opts.Strategy, config.DrainGraceful, config.DrainHealthcheckFail, config.DrainNone)
+	}
+	timeout := Cfg.Drain.Timeout
+	if timeout == "" {
+		timeout = config.DefaultConfig().Drain.Timeout
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("invalid drain.timeout: %w", err)
+	}
+	opts.Timeout = d
+	drain.Set(opts)
+	return nil
+}
+
+// statsInterval parses stats.interval, falling back to the default when it is
+// malformed; a value <= 0 disables collection.
+func (m *SessionManager) statsInterval() time.Duration {
+	if Cfg.Stats.Interval == "" {
+		return services.DefaultStatsInterval
+	}
+	d, err := time.ParseDuration(Cfg.Stats.Interval)
+	if err != nil {
+		m.logger.Warnf("Invalid stats.interval %q (%v), using default %s", Cfg.Stats.Interval, err, services.DefaultStatsInterval)
+		return services.DefaultStatsInterval
+	}
+	return d
+}
+
+// crashWatchOptions turns the crash_watch section into watcher options; ok is
+// false when the watcher is disabled. Malformed durations fall back to the
+// defaults with a warning, like stats.interval.
+func (m *SessionManager) crashWatchOptions() (services.CrashWatchOptions, bool) {
+	def := config.DefaultConfig().CrashWatch
+	duration := func(name, value, fallback string) time.Duration {
+		if value == "" {
+			value = fallback
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			m.logger.Warnf("Invalid crash_watch.%s %q (%v), using default %s", name, value, err, fallback)
+			d, _ = time.ParseDuration(fallback)
+		}
+		return d
+	}
+
+	opts := services.CrashWatchOptions{
+		Interval:   duration("interval", Cfg.CrashWatch.Interval, def.Interval),
+		Window:     duration("window", Cfg.CrashWatch.Window, def.Window),
+		Threshold:  Cfg.CrashWatch.Threshold,
+		LogLines:   Cfg.CrashWatch.LogLines,
+		MaxEntries: Cfg.CrashWatch.MaxEntries,
+		MaxBytes:   Cfg.CrashWatch.MaxBytes,
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = def.Threshold
+	}
+	if opts.LogLines <= 0 {
+		opts.LogLines = def.LogLines
+	}
+	return opts, opts.Interval > 0
+}
+
+// certWatchOptions turns the cert_watch section into scanner options; ok is
+// false when the scanner is disabled.
+func (m *SessionManager) certWatchOptions() (services.CertWatchOptions, bool) {
+	def := config.DefaultConfig().CertWatch
+	value := Cfg.CertWatch.Interval
+	if value == "" {
+		value = def.Interval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		m.logger.Warnf("Invalid cert_watch.interval %q (%v), using default %s", value, err, def.Interval)
+		interval, _ = time.ParseDuration(def.Interval)
+	}
+
+	opts := services.CertWatchOptions{
+		Interval: interval,
+		Thresholds: certs.Thresholds{
+			WarningDays:  Cfg.CertWatch.WarningDays,
+			CriticalDays: Cfg.CertWatch.CriticalDays,
+		},
+		Sources: certs.Sources{ShieldDir: models.ShieldConfigPath},
+	}
+	if opts.Thresholds.WarningDays <= 0 {
+		opts.Thresholds.WarningDays = def.WarningDays
+	}
+	if opts.Thresholds.CriticalDays < 0 {
+		opts.Thresholds.CriticalDays = def.CriticalDays
+	}
+	if Cfg.Server.TLS {
+		opts.Sources.ServerAddr = net.JoinHostPort(Cfg.Server.Host, strconv.Itoa(Cfg.Server.Port))
+		opts.Sources.ServerName = Cfg.Server.Host
+	}
+	return opts, opts.Interval > 0
+}
+
+// netWatchOptions turns the net_watch section into watcher options; ok is
+// false when the watcher is disabled.
+func (m *SessionManager) netWatchOptions() (services.NetWatchOptions, bool) {
+	def := config.DefaultConfig().NetWatch
+	value := Cfg.NetWatch.Debounce
+	if value == "" {
+		value = def.Debounce
+	}
+	debounce, err := time.ParseDuration(value)
+	if err != nil {
+		m.logger.Warnf("Invalid net_watch.debounce %q (%v), using default %s", value, err, def.Debounce)
+		debounce, _ = time.ParseDuration(def.Debounce)
+	}
+
+	opts := services.NetWatchOptions{Debounce: debounce, BufferSize: Cfg.NetWatch.BufferSize}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = def.BufferSize
+	}
+	return opts, opts.Debounce > 0
+}
+
 // cleanup performs cleanup operations
 func (m *SessionManager) cleanup() {
 	m.logger.Info("Cleaning up resources...")
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/upgrade"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var (
+	upgradeFrom        string
+	upgradeTo          string
+	upgradeSelector    string
+	upgradeConcurrency int
+	upgradeRollback    bool
+	upgradeGraceful    bool
+	upgradeDryRun      bool
+)
+
+// upgradeCmd moves every listener on a host from one envoy version to another,
+// the local counterpart of an UPGRADE_LISTENER command with port 0.
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade --from <version> --to <version>",
+	Short: "Upgrade all envoy listeners on this host",
+	Long: `Upgrade every envoy service whose unit runs --from to --to, using the
+configured upgrade strategy (in place or blue/green). --selector limits the run
+to services whose name ("<name>-<port>") matches a glob. Services are upgraded
+--concurrency at a time; the first failure stops the run, and with
+--rollback-on-failure the services already upgraded are moved back to --from.
+The target version must already be installed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log := logger.NewLogger("upgrade")
+		if err := configureUpgrade(); err != nil {
+			return err
+		}
+
+		if upgradeDryRun {
+			services, err := upgrade.DiscoverServices(upgradeFrom, upgradeSelector)
+			if err != nil {
+				return err
+			}
+			for _, svc := range services {
+				fmt.Printf("%s: would upgrade %s -> %s\n", svc, upgradeFrom, upgradeTo)
+			}
+			if len(services) == 0 {
+				fmt.Println("No matching services")
+			}
+			return nil
+		}
+
+		opts := upgrade.CurrentBatchDefaults()
+		opts.FromVersion = upgradeFrom
+		opts.ToVersion = upgradeTo
+		opts.Selector = upgradeSelector
+		opts.Graceful = upgradeGraceful
+		if cmd.Flags().Changed("concurrency") {
+			opts.Concurrency = upgradeConcurrency
+		}
+		if cmd.Flags().Changed("rollback-on-failure") {
+			opts.RollbackOnFailure = upgradeRollback
+		}
+		if opts.Concurrency < 1 {
+			return fmt.Errorf("--concurrency must be at least 1")
+		}
+
+		results, err := upgrade.UpgradeListeners(context.Background(), opts, log, cmdrunner.NewCommandsRunner())
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				fmt.Printf("%s: %s: %s\n", r.Service, r.Status, r.Error)
+			case r.Restart != "":
+				fmt.Printf("%s: %s (%s)\n", r.Service, r.Status, r.Restart)
+			default:
+				fmt.Printf("%s: %s\n", r.Service, r.Status)
+			}
+		}
+		fmt.Println(upgrade.SummarizeBatch(results))
+		return err
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().StringVar(&upgradeFrom, "from", "", "envoy version the services run now")
+	upgradeCmd.Flags().StringVar(&upgradeTo, "to", "", "envoy version to upgrade to")
+	upgradeCmd.Flags().StringVar(&upgradeSelector, "selector", "", `glob on the service name, e.g. "web-*" (default: all)`)
+	upgradeCmd.Flags().IntVar(&upgradeConcurrency, "concurrency", 0, "services upgraded at once (default: upgrade.batch_concurrency)")
+	upgradeCmd.Flags().BoolVar(&upgradeRollback, "rollback-on-failure", false, "move already upgraded services back when one fails (default: upgrade.batch_rollback)")
+	upgradeCmd.Flags().BoolVar(&upgradeGraceful, "graceful", false, "upgrade blue/green (new hot-restart epoch and soak) even when upgrade.strategy is in_place")
+	upgradeCmd.Flags().BoolVar(&upgradeDryRun, "dry-run", false, "list the services that would be upgraded")
+	upgradeCmd.MarkFlagRequired("from")
+	upgradeCmd.MarkFlagRequired("to")
+	RootCmd.AddCommand(upgradeCmd)
+}
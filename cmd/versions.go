@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/config"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
+	"github.com/CloudNativeWorks/elchi-client/internal/services"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var versionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "Manage downloaded envoy and WAF versions",
+}
+
+var (
+	gcKeep          int
+	gcKeepNewerThan time.Duration
+	gcDryRun        bool
+)
+
+// versionsGCCmd runs the same GC as the scheduled pass in the reconcile loop.
+// Flags override the version_gc config section.
+var versionsGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete envoy and WAF versions no deployment uses",
+	Long: `Delete downloaded envoy and WAF versions that are not referenced by any
+installed unit, bootstrap, running process or (for WAF) live envoy config.
+The --keep highest unused versions and those downloaded within
+--keep-newer-than are retained. In-use versions are never removed.
+Partial downloads untouched for a day are removed as well.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log := logger.NewLogger("version-gc")
+
+		opts, warns := versionGCOptions()
+		for _, w := range warns {
+			log.Warn(w)
+		}
+		policy := opts.Policy
+		if cmd.Flags().Changed("keep") {
+			policy.KeepN = gcKeep
+		}
+		if cmd.Flags().Changed("keep-newer-than") {
+			policy.KeepNewerThan = gcKeepNewerThan
+		}
+		if policy.KeepN < 0 {
+			return fmt.Errorf("--keep must not be negative")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		report, err := services.RunVersionGC(ctx, policy, gcDryRun, log)
+		if report != nil {
+			printGCResult("envoy", report.Envoy)
+			if report.WAFSkipped != "" {
+				fmt.Printf("waf: skipped (%s)\n", report.WAFSkipped)
+			} else {
+				printGCResult("waf", report.WAF)
+			}
+			if report.Partials != nil {
+				fmt.Printf("partial downloads: removed [%s] (%d bytes)\n",
+					strings.Join(report.Partials.Removed, " "), report.Partials.ReclaimedBytes)
+			}
+			verb := "Reclaimed"
+			if gcDryRun {
+				verb = "Would reclaim"
+			}
+			fmt.Printf("%s %d bytes\n", verb, report.ReclaimedBytes())
+		}
+		return err
+	},
+}
+
+// versionGCOptions turns the version_gc section into GC options, falling back to
+// the defaults for malformed values. Warnings are returned for the caller to log.
+func versionGCOptions() (services.VersionGCOptions, []string) {
+	def := config.DefaultConfig().VersionGC
+	var warns []string
+	duration := func(key, value, fallback string) time.Duration {
+		if value == "" {
+			value = fallback
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			warns = append(warns, fmt.Sprintf("Invalid version_gc.%s %q (%v), using default %s", key, value, err, fallback))
+			d, _ = time.ParseDuration(fallback)
+		}
+		return d
+	}
+
+	opts := services.VersionGCOptions{
+		Interval: duration("interval", Cfg.VersionGC.Interval, def.Interval),
+		Policy: common.GCPolicy{
+			KeepN:         Cfg.VersionGC.Keep,
+			KeepNewerThan: duration("keep_newer_than", Cfg.VersionGC.KeepNewerThan, def.KeepNewerThan),
+		},
+	}
+	if opts.Policy.KeepN < 0 {
+		warns = append(warns, fmt.Sprintf("Invalid version_gc.keep %d, using default %d", opts.Policy.KeepN, def.Keep))
+		opts.Policy.KeepN = def.Keep
+	}
+	return opts, warns
+}
+
+func printGCResult(component string, res *common.GCResult) {
+	if res == nil {
+		fmt.Printf("%s: not collected\n", component)
+		return
+	}
+	fmt.Printf("%s: in use [%s], kept [%s], removed [%s] (%d bytes)\n", component,
+		strings.Join(res.InUse, " "), strings.Join(res.Kept, " "), strings.Join(res.Removed, " "), res.ReclaimedBytes)
+}
+
+func init() {
+	versionsGCCmd.Flags().IntVar(&gcKeep, "keep", 0, "number of newest unused versions to keep (default version_gc.keep)")
+	versionsGCCmd.Flags().DurationVar(&gcKeepNewerThan, "keep-newer-than", 0, "keep unused versions downloaded within this duration, 0 disables (default version_gc.keep_newer_than)")
+	versionsGCCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "report what would be deleted without deleting")
+	versionsCmd.AddCommand(versionsGCCmd)
+	RootCmd.AddCommand(versionsCmd)
+}
@@ -76,6 +76,9 @@ SHIELD_METRICS_INSECURE="${ELCHI_SHIELD_METRICS_OTLP_INSECURE:-}"
 # System users
 ELCHI_USER="elchi"
 ENVOY_USER="envoyuser"
+# Group of the managed TLS keys: envoyuser and the elchi user that owns them,
+# unlike the elchi group
+CERTS_GROUP="elchi-certs"
 
 # Logging configuration
 ELCHI_LOG_DIR="/var/log/elchi"
@@ -89,7 +92,7 @@ ELCHI_DIR="/etc/elchi"
 ELCHI_BIN_DIR="$ELCHI_DIR/bin"
 ELCHI_CONFIG="$ELCHI_DIR/config.yaml"
 ELCHI_VAR_LIB="/var/lib/elchi"
-ELCHI_VAR_DIRS=( bootstraps envoys waf hotrestarter lua tmp )
+ELCHI_VAR_DIRS=( bootstraps envoys waf hotrestarter lua tmp sysctl )
 
 # Configuration files
 SYSCTL_FILE="/etc/sysctl.d/elchi.conf"
@@ -1283,6 +1286,10 @@ id "$ELCHI_USER"  &>/dev/null && ok "$ELCHI_USER exists"  || run useradd --syste
 id "$ENVOY_USER"  &>/dev/null && ok "$ENVOY_USER exists" || run useradd --system --no-create-home --shell /usr/sbin/nologin "$ENVOY_USER"
 run usermod -aG "$ELCHI_USER" "$ENVOY_USER"
 run usermod -aG adm "$ENVOY_USER"
+getent group "$CERTS_GROUP" >/dev/null && ok "$CERTS_GROUP exists" || run groupadd --system "$CERTS_GROUP"
+run usermod -aG "$CERTS_GROUP" "$ENVOY_USER"
+# The owner must be in the group too: chmod by a non-member clears setgid
+run usermod -aG "$CERTS_GROUP" "$ELCHI_USER"
 
 # Configure sudoers
 info "configuring sudoers rule"
@@ -1312,9 +1319,13 @@ Cmnd_Alias ELCHI_CMDS = \
  /usr/bin/tee /etc/netplan/99-elchi-*.yaml, \
  /usr/bin/tee /etc/netplan/99-elchi-*.yaml.backup, \
  /usr/bin/tee /etc/netplan/90-*.yaml, \
+ /usr/bin/tee /etc/netplan/50-elchi-if-*.yaml, \
+ /usr/bin/tee /etc/netplan/50-elchi-if-*.yaml.backup, \
  /usr/bin/chmod 0600 /etc/netplan/99-elchi-*.yaml, \
  /usr/bin/chmod 0600 /etc/netplan/99-elchi-*.yaml.backup, \
  /usr/bin/chmod 0600 /etc/netplan/90-*.yaml, \
+ /usr/bin/chmod 0600 /etc/netplan/50-elchi-if-*.yaml, \
+ /usr/bin/chmod 0600 /etc/netplan/50-elchi-if-*.yaml.backup, \
  /usr/bin/netplan generate, \
  /usr/bin/netplan apply, \
  /usr/bin/netplan try, \
@@ -1387,7 +1398,17 @@ Cmnd_Alias LOGROTATE_CMDS = \
  /usr/bin/tee /etc/cron.d/logrotate-5min, \
  /usr/bin/chmod 644 /etc/cron.d/logrotate-5min
 
-elchi ALL=(ALL) NOPASSWD: ELCHI_CMDS, FRR_CMDS, FILEBEAT_CMDS, RSYSLOG_CMDS, LOGROTATE_CMDS
+Cmnd_Alias FIREWALL_CMDS = \
+ /usr/sbin/nft -c -f /var/lib/elchi/firewall/pending.nft, \
+ /usr/sbin/nft -f /var/lib/elchi/firewall/pending.nft, \
+ /usr/sbin/nft -f /var/lib/elchi/firewall/previous.nft, \
+ /usr/sbin/nft list table inet elchi
+
+Cmnd_Alias SYSCTL_CMDS = \
+ /usr/sbin/sysctl -p /etc/sysctl.d/90-elchi.conf, \
+ /sbin/sysctl -p /etc/sysctl.d/90-elchi.conf
+
+elchi ALL=(ALL) NOPASSWD: ELCHI_CMDS, FRR_CMDS, FILEBEAT_CMDS, RSYSLOG_CMDS, LOGROTATE_CMDS, FIREWALL_CMDS, SYSCTL_CMDS
 Defaults:elchi !pam_session
 
 EOF
@@ -1560,6 +1581,13 @@ run chmod -R g+rX,o-rwx "$ELCHI_VAR_LIB"
 # Ensure kernel can access routing tables directory for symlink
 run chmod o+x "$ELCHI_VAR_LIB"
 
+# Managed TLS bundles: setgid so every bundle directory and key the client
+# writes gets the elchi-certs group rather than elchi
+info "configuring certificate store"
+run mkdir -p "$ELCHI_VAR_LIB/certs"
+run chgrp -R "$CERTS_GROUP" "$ELCHI_VAR_LIB/certs"
+run find "$ELCHI_VAR_LIB/certs" -type d -exec chmod 2750 {} +
+
 # Setup routing tables symlink
 info "configuring routing tables symlink"
 ELCHI_RT_TABLES="$ELCHI_VAR_LIB/rt_tables.conf"
@@ -1589,6 +1617,30 @@ run rm -f "$KERNEL_RT_TABLES"
 run ln -sf "$ELCHI_RT_TABLES" "$KERNEL_RT_TABLES"
 ok "routing tables symlink: $KERNEL_RT_TABLES -> $ELCHI_RT_TABLES"
 
+# Setup managed sysctl symlink; the client writes the file and applies it
+# with sysctl -p (SYSCTL_CMDS), systemd-sysctl loads it at boot.
+info "configuring managed sysctl symlink"
+ELCHI_SYSCTL="$ELCHI_VAR_LIB/sysctl/90-elchi.conf"
+KERNEL_SYSCTL="/etc/sysctl.d/90-elchi.conf"
+
+if [[ ! -f "$ELCHI_SYSCTL" ]]; then
+    cat >"$ELCHI_SYSCTL" <<'EOF'
+# Managed by elchi-client. Manual changes are overwritten.
+EOF
+    run chown "$ELCHI_USER:$ELCHI_USER" "$ELCHI_SYSCTL"
+fi
+run chmod o+x "$ELCHI_VAR_LIB/sysctl"
+run chmod 644 "$ELCHI_SYSCTL"
+
+if [[ -f "$KERNEL_SYSCTL" ]] && [[ ! -L "$KERNEL_SYSCTL" ]]; then
+    ok "backing up existing $KERNEL_SYSCTL"
+    run mv "$KERNEL_SYSCTL" "$KERNEL_SYSCTL.backup"
+fi
+
+run rm -f "$KERNEL_SYSCTL"
+run ln -sf "$ELCHI_SYSCTL" "$KERNEL_SYSCTL"
+ok "managed sysctl symlink: $KERNEL_SYSCTL -> $ELCHI_SYSCTL"
+
 # Create systemd service
 info "writing systemd unit"
 cat >"$SERVICE_FILE"<<EOF
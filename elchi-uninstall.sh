@@ -15,6 +15,7 @@ set -euo pipefail
 # System users
 ELCHI_USER="elchi"
 ENVOY_USER="envoyuser"
+CERTS_GROUP="elchi-certs"
 
 # Directory paths
 ELCHI_DIR="/etc/elchi"
@@ -27,6 +28,7 @@ MODPROBE_FILE="/etc/modprobe.d/elchi.conf"
 SUDO_FILE="/etc/sudoers.d/99-${ELCHI_USER}"
 SERVICE_FILE="/etc/systemd/system/elchi-client.service"
 ROUTING_TABLE_LINK="/etc/iproute2/rt_tables.d/elchi.conf"
+MANAGED_SYSCTL_LINK="/etc/sysctl.d/90-elchi.conf"
 
 # ANSI colors
 C_RST='\033[0m'
@@ -123,6 +125,12 @@ for service in /etc/systemd/system/elchi-*.service; do
     fi
 done
 
+# Remove the host firewall table owned by the client
+if command -v nft >/dev/null 2>&1 && nft list table inet elchi >/dev/null 2>&1; then
+    info "Removing nftables table inet elchi..."
+    nft delete table inet elchi 2>/dev/null || true
+fi
+
 # Kill any remaining elchi processes
 info "Killing remaining elchi processes..."
 pkill -u "$ELCHI_USER" 2>/dev/null || true
@@ -136,7 +144,7 @@ sleep 2
 info "Removing netplan configurations..."
 
 # Remove elchi-managed netplan files
-for netplan_file in /etc/netplan/99-elchi-*.yaml /etc/netplan/90-*.yaml; do
+for netplan_file in /etc/netplan/99-elchi-*.yaml /etc/netplan/90-*.yaml /etc/netplan/50-elchi-if-*.yaml; do
     if [[ -f "$netplan_file" ]]; then
         info "Removing $(basename "$netplan_file")"
         rm -f "$netplan_file"
@@ -221,6 +229,12 @@ if [[ -f "$SYSCTL_FILE" ]]; then
     # Note: We don't reset sysctl values as they might be used by other services
 fi
 
+# Remove managed sysctl symlink (its target goes with /var/lib/elchi)
+if [[ -L "$MANAGED_SYSCTL_LINK" ]]; then
+    rm -f "$MANAGED_SYSCTL_LINK"
+    ok "Removed managed sysctl symlink"
+fi
+
 # Remove limits config
 if [[ -f "$LIMITS_FILE" ]]; then
     rm -f "$LIMITS_FILE"
@@ -305,6 +319,11 @@ if getent group "$ENVOY_USER" >/dev/null; then
     ok "Removed group: $ENVOY_USER"
 fi
 
+if getent group "$CERTS_GROUP" >/dev/null; then
+    groupdel "$CERTS_GROUP" 2>/dev/null || true
+    ok "Removed group: $CERTS_GROUP"
+fi
+
 ###############################################################################
 # APT SOURCES CLEANUP
 ###############################################################################
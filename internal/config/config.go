@@ -18,9 +18,18 @@ const (
 
 // Config holds all application configuration
 type Config struct {
-	Server  ServerConfig  `mapstructure:"server"`
-	Logging LoggingConfig `mapstructure:"logging"`
-	Client  ClientConfig  `mapstructure:"client"`
+	Server     ServerConfig     `mapstructure:"server"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
+	Client     ClientConfig     `mapstructure:"client"`
+	Artifacts  ArtifactsConfig  `mapstructure:"artifacts"`
+	EnvoyAdmin EnvoyAdminConfig `mapstructure:"envoy_admin"`
+	Stats      StatsConfig      `mapstructure:"stats"`
+	Upgrade    UpgradeConfig    `mapstructure:"upgrade"`
+	CrashWatch CrashWatchConfig `mapstructure:"crash_watch"`
+	Drain      DrainConfig      `mapstructure:"drain"`
+	CertWatch  CertWatchConfig  `mapstructure:"cert_watch"`
+	NetWatch   NetWatchConfig   `mapstructure:"net_watch"`
+	VersionGC  VersionGCConfig  `mapstructure:"version_gc"`
 }
 
 // ServerConfig holds GRPC server configuration
@@ -44,6 +53,162 @@ type ClientConfig struct {
 	Name  string `mapstructure:"name"`
 	BGP   *bool  `mapstructure:"bgp"` // Pointer to detect if explicitly set
 	Cloud string `mapstructure:"cloud"`
+	// HotRestarter selects the envoy unit supervisor: "python" (hotrestarter.py,
+	// the default) or "native" (`elchi-client hotrestart`).
+	HotRestarter string `mapstructure:"hot_restarter"`
+}
+
+// Hot restarter implementations accepted in client.hot_restarter.
+const (
+	HotRestarterPython = "python"
+	HotRestarterNative = "native"
+)
+
+// ArtifactsConfig holds settings for downloaded envoy, WAF and shield artifacts
+type ArtifactsConfig struct {
+	// IndexURL points at the artifact index: the public archive (default), an
+	// internal HTTP mirror or a local file:// path for air-gapped sites.
+	IndexURL string `mapstructure:"index_url"`
+	// Headers are sent with requests to the index host (e.g. mirror auth).
+	Headers map[string]string `mapstructure:"headers"`
+	// DownloadRateLimit caps the combined artifact download throughput in
+	// bytes per second. Zero means unlimited.
+	DownloadRateLimit int64         `mapstructure:"download_rate_limit"`
+	Signing           SigningConfig `mapstructure:"signing"`
+}
+
+// SigningConfig pins the Ed25519 keys artifact signatures are checked against
+type SigningConfig struct {
+	// PublicKeys are base64-encoded raw 32-byte Ed25519 public keys.
+	PublicKeys []string `mapstructure:"public_keys"`
+	// AllowUnsigned accepts artifacts that carry no signature at all. A present
+	// but invalid signature is always rejected.
+	AllowUnsigned bool `mapstructure:"allow_unsigned"`
+}
+
+// EnvoyAdminConfig limits what the control plane can reach through the envoy
+// admin proxy
+type EnvoyAdminConfig struct {
+	// Allow lists "METHOD /path" entries (a trailing * matches a prefix). Empty
+	// keeps the built-in read-only allowlist.
+	Allow []string `mapstructure:"allow"`
+	// VirtualAllow lists the same kind of entries for the /elchi/* paths the
+	// client answers itself. Empty allows GET only, so host changes (firewall,
+	// sysctl, interfaces...) must be allowed by name.
+	VirtualAllow []string `mapstructure:"virtual_allow"`
+	// MaxResponseBytes caps a forwarded response body; larger bodies are
+	// truncated and can be fetched in chunks. Zero keeps the default (3 MiB).
+	MaxResponseBytes int64 `mapstructure:"max_response_bytes"`
+}
+
+// StatsConfig controls the periodic envoy stats scrape
+type StatsConfig struct {
+	// Interval between scrapes of every deployment ("0" disables collection).
+	Interval string `mapstructure:"interval"`
+	// Listen is the local address serving /metrics and /summary ("" disables
+	// the endpoint; the summary stays available to the control plane).
+	Listen string `mapstructure:"listen"`
+}
+
+// UpgradeConfig selects how listener upgrades are rolled out
+type UpgradeConfig struct {
+	// Strategy is "in_place" (rewrite and restart, the default) or
+	// "blue_green" (new hot-restart epoch, soak, automatic revert; needs
+	// client.hot_restarter: native) for every upgrade. Under in_place a
+	// graceful request still upgrades blue/green.
+	Strategy string `mapstructure:"strategy"`
+	// Soak is how long a blue/green epoch must stay healthy.
+	Soak string `mapstructure:"soak"`
+	// CheckInterval is the time between health checks during the soak.
+	CheckInterval string `mapstructure:"check_interval"`
+	// ReadyTimeout bounds how long the new epoch may take to report ready.
+	ReadyTimeout string `mapstructure:"ready_timeout"`
+	// Max5xxRatioIncrease is how far the 5xx share of responses may rise
+	// during the soak (0.01 = one percentage point).
+	Max5xxRatioIncrease float64 `mapstructure:"max_5xx_ratio_increase"`
+	// Checks are extra "<metric> <op> <value>" stats rules for the soak.
+	Checks []string `mapstructure:"checks"`
+	// BatchConcurrency is how many services a host-wide upgrade upgrades at
+	// once when the request does not say.
+	BatchConcurrency int `mapstructure:"batch_concurrency"`
+	// BatchRollback downgrades the already upgraded services when a host-wide
+	// upgrade fails and the request does not say.
+	BatchRollback bool `mapstructure:"batch_rollback"`
+}
+
+// Upgrade strategies accepted in upgrade.strategy.
+const (
+	UpgradeInPlace   = "in_place"
+	UpgradeBlueGreen = "blue_green"
+)
+
+// CrashWatchConfig controls crash-loop detection for envoy units
+type CrashWatchConfig struct {
+	// Interval between restart-count polls ("0" disables the watcher).
+	Interval string `mapstructure:"interval"`
+	// Window and Threshold define a crash loop: Threshold automatic restarts
+	// within Window.
+	Window    string `mapstructure:"window"`
+	Threshold int    `mapstructure:"threshold"`
+	// LogLines is how much of <name>_system.log is captured.
+	LogLines int `mapstructure:"log_lines"`
+	// MaxEntries and MaxBytes bound /var/lib/elchi/crashes; the oldest
+	// captures are removed first.
+	MaxEntries int   `mapstructure:"max_entries"`
+	MaxBytes   int64 `mapstructure:"max_bytes"`
+}
+
+// DrainConfig controls how listeners are drained before a unit is stopped
+type DrainConfig struct {
+	// Strategy is "graceful" (admin /drain_listeners?graceful, the default),
+	// "healthcheck_fail" (admin /healthcheck/fail) or "none".
+	Strategy string `mapstructure:"strategy"`
+	// Timeout bounds the wait for connections to go away.
+	Timeout string `mapstructure:"timeout"`
+	// Threshold is the number of active downstream connections at or below
+	// which the unit is stopped without waiting further.
+	Threshold int `mapstructure:"threshold"`
+	// OnRestart drains before a restart requested by the control plane too,
+	// not only before undeploy.
+	OnRestart bool `mapstructure:"on_restart"`
+}
+
+// Drain strategies accepted in drain.strategy.
+const (
+	DrainGraceful        = "graceful"
+	DrainHealthcheckFail = "healthcheck_fail"
+	DrainNone            = "none"
+)
+
+// CertWatchConfig controls the certificate expiry scanner
+type CertWatchConfig struct {
+	// Interval between scans ("0" disables the scanner).
+	Interval string `mapstructure:"interval"`
+	// WarningDays and CriticalDays are the days-to-expiry at or below which a
+	// certificate raises a warning or critical event.
+	WarningDays  int `mapstructure:"warning_days"`
+	CriticalDays int `mapstructure:"critical_days"`
+}
+
+// NetWatchConfig controls the netlink change watcher
+type NetWatchConfig struct {
+	// Debounce folds the updates of one object within the window into a
+	// single event ("0" disables the watcher).
+	Debounce string `mapstructure:"debounce"`
+	// BufferSize is the number of recent events kept for inspection.
+	BufferSize int `mapstructure:"buffer_size"`
+}
+
+// VersionGCConfig controls the garbage collection of unused envoy and WAF versions
+type VersionGCConfig struct {
+	// Interval between scheduled passes ("0" disables them; `versions gc`
+	// still works).
+	Interval string `mapstructure:"interval"`
+	// Keep is the number of highest unused versions retained.
+	Keep int `mapstructure:"keep"`
+	// KeepNewerThan retains unused versions downloaded within this duration
+	// ("0" disables the age check).
+	KeepNewerThan string `mapstructure:"keep_newer_than"`
 }
 
 // GetStoredClientID reads the client ID from the storage file
@@ -86,6 +251,33 @@ func LoadConfig(path string) (*Config, error) {
 	v.SetDefault("logging.format", "json")
 
 	v.SetDefault("client.tls", false)
+	v.SetDefault("client.hot_restarter", HotRestarterPython)
+	v.SetDefault("artifacts.signing.allow_unsigned", false)
+	v.SetDefault("stats.interval", "15s")
+	v.SetDefault("stats.listen", "127.0.0.1:9911")
+	v.SetDefault("upgrade.strategy", UpgradeInPlace)
+	v.SetDefault("upgrade.soak", "60s")
+	v.SetDefault("upgrade.check_interval", "5s")
+	v.SetDefault("upgrade.ready_timeout", "30s")
+	v.SetDefault("upgrade.max_5xx_ratio_increase", 0.01)
+	v.SetDefault("upgrade.batch_concurrency", 1)
+	v.SetDefault("upgrade.batch_rollback", false)
+	v.SetDefault("crash_watch.interval", "30s")
+	v.SetDefault("crash_watch.window", "10m")
+	v.SetDefault("crash_watch.threshold", 3)
+	v.SetDefault("crash_watch.log_lines", 200)
+	v.SetDefault("crash_watch.max_entries", 20)
+	v.SetDefault("crash_watch.max_bytes", 2<<30)
+	v.SetDefault("drain.strategy", DrainGraceful)
+	v.SetDefault("drain.timeout", "30s")
+	v.SetDefault("drain.threshold", 0)
+	v.SetDefault("drain.on_restart", false)
+	v.SetDefault("cert_watch.interval", "1h")
+	v.SetDefault("cert_watch.warning_days", 30)
+	v.SetDefault("cert_watch.critical_days", 7)
+	v.SetDefault("version_gc.interval", "24h")
+	v.SetDefault("version_gc.keep", 2)
+	v.SetDefault("version_gc.keep_newer_than", "168h")
 
 	// Configuration file name and path
 	if path != "" {
@@ -150,7 +342,46 @@ func DefaultConfig() *Config {
 			Format: "json",
 		},
 		Client: ClientConfig{
-			Name: "new-client",
+			Name:         "new-client",
+			HotRestarter: HotRestarterPython,
+		},
+		Stats: StatsConfig{
+			Interval: "15s",
+			Listen:   "127.0.0.1:9911",
+		},
+		Upgrade: UpgradeConfig{
+			Strategy:            UpgradeInPlace,
+			Soak:                "60s",
+			CheckInterval:       "5s",
+			ReadyTimeout:        "30s",
+			Max5xxRatioIncrease: 0.01,
+			BatchConcurrency:    1,
+		},
+		CrashWatch: CrashWatchConfig{
+			Interval:   "30s",
+			Window:     "10m",
+			Threshold:  3,
+			LogLines:   200,
+			MaxEntries: 20,
+			MaxBytes:   2 << 30,
+		},
+		Drain: DrainConfig{
+			Strategy: DrainGraceful,
+			Timeout:  "30s",
+		},
+		CertWatch: CertWatchConfig{
+			Interval:     "1h",
+			WarningDays:  30,
+			CriticalDays: 7,
+		},
+		NetWatch: NetWatchConfig{
+			Debounce:   "2s",
+			BufferSize: 256,
+		},
+		VersionGC: VersionGCConfig{
+			Interval:      "24h",
+			Keep:          2,
+			KeepNewerThan: "168h",
 		},
 	}
 }
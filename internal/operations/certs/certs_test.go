@@ -0,0 +1,317 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// newCert returns a self-signed PEM certificate and its PEM key.
+func newCert(t *testing.T, cn string, notAfter time.Time) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     []string{cn, "www." + cn},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestInspect(t *testing.T) {
+	cert, _ := newCert(t, "example.com", now.Add(36*time.Hour))
+	info, err := Inspect(cert, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "CN=example.com" || info.Serial != "2a" || info.ChainLength != 1 {
+		t.Errorf("info = %+v", info)
+	}
+	if got := strings.Join(info.SANs, ","); got != "example.com,www.example.com,10.0.0.1" {
+		t.Errorf("SANs = %s", got)
+	}
+	if info.DaysLeft != 1 {
+		t.Errorf("DaysLeft = %d, want 1", info.DaysLeft)
+	}
+	if len(info.SHA256) != 32*3-1 || strings.ToUpper(info.SHA256) != info.SHA256 {
+		t.Errorf("SHA256 = %s", info.SHA256)
+	}
+
+	if _, err := Inspect([]byte("not pem"), now); err == nil {
+		t.Error("Inspect accepted garbage")
+	}
+	if DaysLeft(now.Add(-time.Minute), now) != -1 {
+		t.Error("an expired certificate must have negative days left")
+	}
+}
+
+func TestInstallLayout(t *testing.T) {
+	root := t.TempDir()
+	cert, key := newCert(t, "example.com", now.Add(90*24*time.Hour))
+	ca, _ := newCert(t, "ca", now.Add(365*24*time.Hour))
+
+	info, err := install(root, "web", Bundle{Cert: cert, Key: key, CA: ca}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "CN=example.com" || info.Source != "managed:web" {
+		t.Errorf("info = %+v", info)
+	}
+
+	dir := filepath.Join(root, "web")
+	for _, f := range []string{CertFile, KeyFile, CAFile, SDSFile} {
+		target, err := os.Readlink(filepath.Join(dir, f))
+		if err != nil || target != filepath.Join(dataLink, f) {
+			t.Errorf("%s links to %q (%v)", f, target, err)
+		}
+		st, err := os.Stat(filepath.Join(dir, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm() != fileMode {
+			t.Errorf("%s mode = %v", f, st.Mode().Perm())
+		}
+	}
+	sds, _ := os.ReadFile(filepath.Join(dir, SDSFile))
+	for _, want := range []string{"name: web\n", "name: web-ca\n", "filename: " + filepath.Join(dir, KeyFile), "path: " + dir} {
+		if !strings.Contains(string(sds), want) {
+			t.Errorf("sds.yaml lacks %q:\n%s", want, sds)
+		}
+	}
+	if got, _ := names(root); len(got) != 1 || got[0] != "web" {
+		t.Errorf("names = %v", got)
+	}
+}
+
+func TestCheckGroup(t *testing.T) {
+	g, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Skipf("no name for the test's group: %v", err)
+	}
+	root := t.TempDir()
+	if err := checkGroup(root, g.Name); err == nil {
+		t.Fatal("store without setgid accepted")
+	}
+	if err := os.Chmod(root, dirMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGroup(root, g.Name); err != nil {
+		t.Fatalf("setgid store rejected: %v", err)
+	}
+	if err := checkGroup(root, "elchi-no-such-group"); err == nil {
+		t.Fatal("missing group accepted")
+	}
+
+	// Bundles inherit the store's group and setgid bit.
+	cert, key := newCert(t, "example.com", now.Add(90*24*time.Hour))
+	if _, err := install(root, "web", Bundle{Cert: cert, Key: key}, now); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(filepath.Join(root, "web", dataLink))
+	if err != nil || st.Mode()&os.ModeSetgid == 0 {
+		t.Fatalf("version dir = %v, %v", st.Mode(), err)
+	}
+}
+
+func TestInstallRotates(t *testing.T) {
+	root := t.TempDir()
+	cert, key := newCert(t, "old.example.com", now.Add(24*time.Hour))
+	ca, _ := newCert(t, "ca", now.Add(365*24*time.Hour))
+	if _, err := install(root, "web", Bundle{Cert: cert, Key: key, CA: ca}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key = newCert(t, "new.example.com", now.Add(90*24*time.Hour))
+	info, err := install(root, "web", Bundle{Cert: cert, Key: key}, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "CN=new.example.com" {
+		t.Errorf("current certificate is %s", info.Subject)
+	}
+
+	dir := filepath.Join(root, "web")
+	entries, _ := os.ReadDir(dir)
+	versions := 0
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), versionPrefix) {
+			versions++
+		}
+	}
+	if versions != 1 {
+		t.Errorf("%d version directories left, want 1", versions)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, CAFile)); !os.IsNotExist(err) {
+		t.Error("ca.pem link kept after installing a bundle without CA")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, dataLink+".tmp")); !os.IsNotExist(err) {
+		t.Error("temporary link left behind")
+	}
+}
+
+func TestInstallRejects(t *testing.T) {
+	root := t.TempDir()
+	cert, _ := newCert(t, "example.com", now.Add(24*time.Hour))
+	_, otherKey := newCert(t, "other", now.Add(24*time.Hour))
+
+	if _, err := install(root, "web", Bundle{Cert: cert, Key: otherKey}, now); err == nil {
+		t.Error("installed a certificate with a key that does not match")
+	}
+	if _, err := install(root, "../web", Bundle{Cert: cert, Key: otherKey}, now); err == nil {
+		t.Error("installed a bundle with a path in its name")
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("rejected installs left %d entries", len(entries))
+	}
+}
+
+func TestInventoryAndRemove(t *testing.T) {
+	certDir, bootstrapDir, other := t.TempDir(), t.TempDir(), t.TempDir()
+	cert, key := newCert(t, "example.com", now.Add(90*24*time.Hour))
+	if _, err := install(certDir, "web", Bundle{Cert: cert, Key: key}, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := install(certDir, "spare", Bundle{Cert: cert, Key: key}, now); err != nil {
+		t.Fatal(err)
+	}
+	direct := filepath.Join(other, "direct.pem")
+	os.WriteFile(direct, cert, 0600)
+
+	// One listener loads the managed bundle through SDS, another reads a file
+	// directly; the private key is never listed.
+	bootstrap := `{"static_resources": {"listeners": [
+  {"filter_chains": [{"transport_socket": {"typed_config": {"common_tls_context": {
+    "tls_certificate_sds_secret_configs": [{"name": "web", "sds_config": {"path_config_source": {"path": "` + filepath.Join(certDir, "web", SDSFile) + `"}}}]}}}}]},
+  {"filter_chains": [{"transport_socket": {"typed_config": {"common_tls_context": {
+    "tls_certificates": [{"certificate_chain": {"filename": "` + direct + `"}, "private_key": {"filename": "/etc/key.pem"}}]}}}}]}
+]}}`
+	os.WriteFile(filepath.Join(bootstrapDir, "web-443.yaml"), []byte(bootstrap), 0600)
+
+	list := inventory(certDir, bootstrapDir, now)
+	var got []string
+	for _, info := range list {
+		got = append(got, info.Source+" "+info.Path)
+	}
+	want := []string{
+		"managed:spare " + filepath.Join(certDir, "spare", CertFile),
+		"managed:web " + filepath.Join(certDir, "web", CertFile),
+		"bootstrap:web-443 " + direct,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("inventory:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := remove(certDir, bootstrapDir, "web"); err == nil || !strings.Contains(err.Error(), "web-443") {
+		t.Errorf("removing a bundle in use: %v", err)
+	}
+	if err := remove(certDir, bootstrapDir, "spare"); err != nil {
+		t.Errorf("removing an unused bundle: %v", err)
+	}
+	if err := remove(certDir, bootstrapDir, "spare"); err != ErrNotFound {
+		t.Errorf("removing a missing bundle: %v", err)
+	}
+}
+
+func TestThresholdsLevel(t *testing.T) {
+	th := Thresholds{WarningDays: 30, CriticalDays: 7}
+	for _, tc := range []struct {
+		info Info
+		want string
+	}{
+		{Info{DaysLeft: 90}, LevelOK},
+		{Info{DaysLeft: 30}, LevelWarning},
+		{Info{DaysLeft: 7}, LevelCritical},
+		{Info{DaysLeft: -3}, LevelCritical},
+		{Info{Error: "unreadable"}, LevelError},
+	} {
+		if got := th.Level(tc.info); got != tc.want {
+			t.Errorf("Level(%+v) = %s, want %s", tc.info, got, tc.want)
+		}
+	}
+	if (Thresholds{WarningDays: 30}).Level(Info{DaysLeft: -1}) != LevelCritical {
+		t.Error("an expired certificate must be critical even without a critical threshold")
+	}
+}
+
+func TestScan(t *testing.T) {
+	certDir, bootstrapDir, shieldDir := t.TempDir(), t.TempDir(), t.TempDir()
+	cert, key := newCert(t, "managed.example.com", now.Add(5*24*time.Hour))
+	if _, err := install(certDir, "web", Bundle{Cert: cert, Key: key}, now); err != nil {
+		t.Fatal(err)
+	}
+	shieldCert, _ := newCert(t, "shield.example.com", now.Add(20*24*time.Hour))
+	os.MkdirAll(filepath.Join(shieldDir, "tls"), 0750)
+	os.WriteFile(filepath.Join(shieldDir, "tls", "upstream.crt"), shieldCert, 0600)
+	os.WriteFile(filepath.Join(shieldDir, "tls", "next.crt.tmp"), shieldCert, 0600)
+	os.WriteFile(filepath.Join(shieldDir, "policy.yaml"), []byte("rules: []\n"), 0600)
+
+	report := scan(context.Background(), certDir, bootstrapDir, Sources{ShieldDir: shieldDir},
+		Thresholds{WarningDays: 30, CriticalDays: 7}, now)
+	var got []string
+	for _, c := range report.Certificates {
+		got = append(got, c.Source+" "+c.Level)
+	}
+	if strings.Join(got, ",") != "managed:web critical,shield warning" {
+		t.Errorf("scanned %v", got)
+	}
+	if report.Counts[LevelCritical] != 1 || report.Counts[LevelWarning] != 1 || report.Counts[LevelOK] != 0 {
+		t.Errorf("counts = %v", report.Counts)
+	}
+}
+
+func TestServerCert(t *testing.T) {
+	// gRPC servers insist on ALPN h2, so serverCert offers it.
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	info := serverCert(context.Background(), srv.Listener.Addr().String(), "example.com", time.Now())
+	if info.Error != "" {
+		t.Fatal(info.Error)
+	}
+	want := srv.Certificate()
+	if info.Source != "client:server" || !info.NotAfter.Equal(want.NotAfter) {
+		t.Errorf("info = %+v", info)
+	}
+	if !strings.Contains(strings.Join(info.SANs, ","), "example.com") {
+		t.Errorf("SANs = %v", info.SANs)
+	}
+
+	srv.Close()
+	if info := serverCert(context.Background(), srv.Listener.Addr().String(), "", time.Now()); info.Error == "" {
+		t.Error("no error for an unreachable server")
+	}
+}
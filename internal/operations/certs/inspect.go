@@ -0,0 +1,126 @@
+// Package certs manages the TLS material envoy reads from disk: bundles
+// installed in an SDS file-watch layout under Dir, and an inventory of those
+// and of every certificate a bootstrap references.
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Info describes the leaf certificate of one PEM file.
+type Info struct {
+	Path string `json:"path"`
+	// Source says why the file is listed, e.g. "managed:web" or
+	// "bootstrap:web-443".
+	Source    string    `json:"source"`
+	Subject   string    `json:"subject,omitempty"`
+	Issuer    string    `json:"issuer,omitempty"`
+	SANs      []string  `json:"sans,omitempty"`
+	Serial    string    `json:"serial,omitempty"`
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+	// DaysLeft is whole days until NotAfter; negative once expired.
+	DaysLeft    int    `json:"days_left"`
+	SHA256      string `json:"sha256,omitempty"`
+	ChainLength int    `json:"chain_length,omitempty"`
+	IsCA        bool   `json:"is_ca,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// InspectFile reads the PEM file at path. Read and parse errors are reported
+// in Info.Error so an inventory lists broken files too.
+func InspectFile(path, source string, now time.Time) Info {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Info{Path: path, Source: source, Error: err.Error()}
+	}
+	info, err := Inspect(data, now)
+	info.Path, info.Source = path, source
+	if err != nil {
+		info.Error = err.Error()
+	}
+	return info
+}
+
+// Inspect describes the first certificate of a PEM chain.
+func Inspect(data []byte, now time.Time) (Info, error) {
+	chain, err := parseChain(data)
+	if err != nil {
+		return Info{}, err
+	}
+	leaf := chain[0]
+	sum := sha256.Sum256(leaf.Raw)
+	info := Info{
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		Serial:      leaf.SerialNumber.Text(16),
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		DaysLeft:    DaysLeft(leaf.NotAfter, now),
+		SHA256:      formatFingerprint(sum[:]),
+		ChainLength: len(chain),
+		IsCA:        leaf.IsCA,
+	}
+	info.SANs = append(info.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	for _, u := range leaf.URIs {
+		info.SANs = append(info.SANs, u.String())
+	}
+	info.SANs = append(info.SANs, leaf.EmailAddresses...)
+	return info, nil
+}
+
+// DaysLeft is the number of whole days from now until notAfter, rounded down
+// (so a certificate expiring in 12 hours has 0 days left, an expired one < 0).
+func DaysLeft(notAfter, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
+}
+
+func parseChain(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return chain, nil
+}
+
+// formatFingerprint renders a digest as colon-separated upper-case hex, the
+// form openssl prints.
+func formatFingerprint(sum []byte) string {
+	h := strings.ToUpper(hex.EncodeToString(sum))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(h); i += 2 {
+		parts = append(parts, h[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+func pemEncode(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
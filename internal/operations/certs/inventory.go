@@ -0,0 +1,150 @@
+package certs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/files"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory describes every managed bundle (certificate and CA) and every
+// certificate file a bootstrap references, directly or through an SDS file.
+// Each file is listed once; managed ones keep their "managed:" source.
+func Inventory(now time.Time) []Info {
+	return inventory(Dir, models.BootstrapsPath, now)
+}
+
+func inventory(certDir, bootstrapDir string, now time.Time) []Info {
+	seen := map[string]bool{}
+	var out []Info
+
+	bundles, _ := names(certDir)
+	for _, name := range bundles {
+		for _, info := range bundled(certDir, name, now) {
+			seen[info.Path] = true
+			out = append(out, info)
+		}
+	}
+
+	bootstraps, _ := filepath.Glob(filepath.Join(bootstrapDir, "*.yaml"))
+	sort.Strings(bootstraps)
+	for _, bootstrap := range bootstraps {
+		source := "bootstrap:" + strings.TrimSuffix(filepath.Base(bootstrap), ".yaml")
+		for _, path := range BootstrapCertFiles(bootstrap) {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			out = append(out, InspectFile(path, source, now))
+		}
+	}
+	return out
+}
+
+// usedBy returns the services whose bootstrap references a file in dir.
+func usedBy(dir, bootstrapDir string) []string {
+	bootstraps, _ := filepath.Glob(filepath.Join(bootstrapDir, "*.yaml"))
+	sort.Strings(bootstraps)
+	var users []string
+	for _, bootstrap := range bootstraps {
+		for _, path := range BootstrapCertFiles(bootstrap) {
+			if filepath.Dir(path) == dir {
+				users = append(users, strings.TrimSuffix(filepath.Base(bootstrap), ".yaml"))
+				break
+			}
+		}
+	}
+	return users
+}
+
+// Bundled describes the certificate and CA of managed bundle name, or returns
+// nil when there is no such bundle.
+func Bundled(name string, now time.Time) []Info {
+	if files.ValidateServiceName(name) != nil {
+		return nil
+	}
+	return bundled(Dir, name, now)
+}
+
+func bundled(root, name string, now time.Time) []Info {
+	var out []Info
+	for _, file := range []string{CertFile, CAFile} {
+		path := filepath.Join(root, name, file)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		out = append(out, InspectFile(path, "managed:"+name, now))
+	}
+	return out
+}
+
+// BootstrapCertFiles returns the certificate and CA files the bootstrap (or
+// SDS file) at path references, following path-based SDS configs one level.
+// Private keys are never included.
+func BootstrapCertFiles(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	certFiles, sdsFiles := references(data)
+	for _, sds := range sdsFiles {
+		if data, err := os.ReadFile(sds); err == nil {
+			more, _ := references(data)
+			certFiles = append(certFiles, more...)
+		}
+	}
+	return dedupe(certFiles)
+}
+
+// references walks a YAML or JSON document for certificate_chain and
+// trusted_ca data sources with a filename, and for SDS path config sources.
+func references(data []byte) (certFiles, sdsFiles []string) {
+	var doc any
+	if yaml.Unmarshal(data, &doc) != nil {
+		return nil, nil
+	}
+	var walk func(v any)
+	walk = func(v any) {
+		switch t := v.(type) {
+		case map[string]any:
+			for k, val := range t {
+				child, _ := val.(map[string]any)
+				switch k {
+				case "certificate_chain", "trusted_ca":
+					if f, ok := child["filename"].(string); ok && f != "" {
+						certFiles = append(certFiles, f)
+					}
+				case "path_config_source", "sds_config":
+					if p, ok := child["path"].(string); ok && p != "" {
+						sdsFiles = append(sdsFiles, p)
+					}
+				}
+				walk(val)
+			}
+		case []any:
+			for _, val := range t {
+				walk(val)
+			}
+		}
+	}
+	walk(doc)
+	return certFiles, sdsFiles
+}
+
+func dedupe(paths []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, p := range paths {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
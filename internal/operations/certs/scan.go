@@ -0,0 +1,161 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// Expiry levels of a scanned certificate.
+const (
+	LevelOK       = "ok"
+	LevelWarning  = "warning"
+	LevelCritical = "critical"
+	// LevelError marks a file that could not be read or parsed.
+	LevelError = "error"
+)
+
+// Thresholds are the days-to-expiry at or below which a certificate is a
+// warning or critical. An expired certificate is always critical.
+type Thresholds struct {
+	WarningDays  int `json:"warning_days"`
+	CriticalDays int `json:"critical_days"`
+}
+
+// Level classifies info.
+func (t Thresholds) Level(info Info) string {
+	switch {
+	case info.Error != "":
+		return LevelError
+	case info.DaysLeft < 0 || info.DaysLeft <= t.CriticalDays:
+		return LevelCritical
+	case info.DaysLeft <= t.WarningDays:
+		return LevelWarning
+	}
+	return LevelOK
+}
+
+// Checked is a scanned certificate with its expiry level.
+type Checked struct {
+	Info
+	Level string `json:"level"`
+}
+
+// Report is the result of one Scan.
+type Report struct {
+	ScannedAt  time.Time  `json:"scanned_at"`
+	Thresholds Thresholds `json:"thresholds"`
+	// Counts is the number of certificates per level.
+	Counts       map[string]int `json:"counts"`
+	Certificates []Checked      `json:"certificates"`
+}
+
+// Sources is what a scan covers besides the managed bundles and bootstraps.
+type Sources struct {
+	// ShieldDir is searched for PEM certificates ("" skips it).
+	ShieldDir string
+	// ServerAddr is the control plane's host:port when the client connects
+	// with TLS; the certificate it presents is checked ("" skips it).
+	ServerAddr string
+	ServerName string
+}
+
+// serverDialTimeout bounds the handshake that reads the control plane's
+// certificate.
+const serverDialTimeout = 10 * time.Second
+
+// Scan checks the inventory, shield's config and the control plane's
+// certificate against t.
+func Scan(ctx context.Context, src Sources, t Thresholds, now time.Time) Report {
+	return scan(ctx, Dir, models.BootstrapsPath, src, t, now)
+}
+
+func scan(ctx context.Context, certDir, bootstrapDir string, src Sources, t Thresholds, now time.Time) Report {
+	infos := inventory(certDir, bootstrapDir, now)
+	if src.ShieldDir != "" {
+		infos = append(infos, shieldCerts(src.ShieldDir, now)...)
+	}
+	if src.ServerAddr != "" {
+		infos = append(infos, serverCert(ctx, src.ServerAddr, src.ServerName, now))
+	}
+
+	report := Report{
+		ScannedAt:    now,
+		Thresholds:   t,
+		Counts:       map[string]int{LevelOK: 0, LevelWarning: 0, LevelCritical: 0, LevelError: 0},
+		Certificates: make([]Checked, 0, len(infos)),
+	}
+	for _, info := range infos {
+		c := Checked{Info: info, Level: t.Level(info)}
+		report.Counts[c.Level]++
+		report.Certificates = append(report.Certificates, c)
+	}
+	return report
+}
+
+// shieldCerts inspects every file under root that holds a PEM certificate.
+// Staged ".tmp" files are skipped like shield itself does.
+func shieldCerts(root string, now time.Time) []Info {
+	var out []Info
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || !bytes.Contains(data, []byte("-----BEGIN CERTIFICATE-----")) {
+			return nil
+		}
+		info, err := Inspect(data, now)
+		info.Path, info.Source = path, "shield"
+		if err != nil {
+			info.Error = err.Error()
+		}
+		out = append(out, info)
+		return nil
+	})
+	return out
+}
+
+// serverCert describes the certificate the control plane at addr presents.
+// It is read, not verified: the point is to see its expiry even when
+// server.insecure_skip_verify is set.
+func serverCert(ctx context.Context, addr, serverName string, now time.Time) Info {
+	info := Info{Path: addr, Source: "client:server"}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: serverDialTimeout},
+		Config:    &tls.Config{ServerName: serverName, InsecureSkipVerify: true, NextProtos: []string{"h2"}},
+	}
+	ctx, cancel := context.WithTimeout(ctx, serverDialTimeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	defer conn.Close()
+
+	peers := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peers) == 0 {
+		info.Error = "server presented no certificate"
+		return info
+	}
+	var chain bytes.Buffer
+	for _, cert := range peers {
+		chain.Write(pemEncode(cert.Raw))
+	}
+	parsed, err := Inspect(chain.Bytes(), now)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	parsed.Path, parsed.Source = info.Path, info.Source
+	return parsed
+}
@@ -0,0 +1,52 @@
+package certs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const secretType = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+// sdsResources renders the sds.yaml of a bundle: a tls_certificate secret
+// named name and, with a CA, a validation_context secret named name-ca. The
+// files are referenced through the bundle's top-level symlinks and watched
+// through the bundle directory, so a swap of ..data reloads them.
+func sdsResources(dir, name string, withCA bool) string {
+	var b strings.Builder
+	b.WriteString("resources:\n")
+	fmt.Fprintf(&b, `- "@type": %s
+  name: %s
+  tls_certificate:
+    certificate_chain:
+      filename: %s
+    private_key:
+      filename: %s
+    watched_directory:
+      path: %s
+`, secretType, name, filepath.Join(dir, CertFile), filepath.Join(dir, KeyFile), dir)
+	if withCA {
+		fmt.Fprintf(&b, `- "@type": %s
+  name: %s-ca
+  validation_context:
+    trusted_ca:
+      filename: %s
+    watched_directory:
+      path: %s
+`, secretType, name, filepath.Join(dir, CAFile), dir)
+	}
+	return b.String()
+}
+
+// SDSConfig is the sds_config a bootstrap uses to load secret name (or
+// "<bundle>-ca") from the bundle of that name, e.g. under
+// common_tls_context.tls_certificate_sds_secret_configs.
+func SDSConfig(bundle string) map[string]any {
+	dir := filepath.Join(Dir, bundle)
+	return map[string]any{
+		"path_config_source": map[string]any{
+			"path":              filepath.Join(dir, SDSFile),
+			"watched_directory": map[string]any{"path": dir},
+		},
+	}
+}
@@ -0,0 +1,250 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/files"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// Dir holds one directory per managed bundle.
+var Dir = filepath.Join(models.ElchiLibPath, "certs")
+
+// Files of a bundle. Each is a symlink through dataLink into the current
+// version directory, the layout envoy's watched_directory expects: replacing
+// dataLink with one rename swaps every file at once and fires a single move
+// event that makes envoy reload the secret.
+const (
+	CertFile = "cert.pem"
+	KeyFile  = "key.pem"
+	CAFile   = "ca.pem"
+	SDSFile  = "sds.yaml"
+
+	dataLink      = "..data"
+	versionPrefix = "..v"
+)
+
+// Group owns the bundles. The installer makes Dir setgid to it, so every
+// directory and file below inherits it. Its members are envoyuser, which
+// loads the keys through group read, and the client user that owns them;
+// the rest of the elchi group is locked out.
+var Group = "elchi-certs"
+
+const (
+	dirMode  = 0750 | os.ModeSetgid
+	fileMode = 0640
+)
+
+// ErrNotFound is returned for an unknown bundle.
+var ErrNotFound = errors.New("certificate bundle not found")
+
+// mu serialises changes: a concurrent install would otherwise prune the
+// version directory the other one just published.
+var mu sync.Mutex
+
+// Bundle is the PEM material of one managed certificate. CA is optional and
+// becomes a validation context secret named "<name>-ca".
+type Bundle struct {
+	Cert []byte
+	Key  []byte
+	CA   []byte
+}
+
+// Validate checks that the key matches the certificate and that the CA
+// bundle, if any, parses.
+func (b Bundle) Validate() error {
+	if _, err := tls.X509KeyPair(b.Cert, b.Key); err != nil {
+		return fmt.Errorf("certificate and key do not form a valid pair: %w", err)
+	}
+	if len(b.CA) > 0 {
+		if _, err := parseChain(b.CA); err != nil {
+			return fmt.Errorf("invalid CA bundle: %w", err)
+		}
+	}
+	return nil
+}
+
+// Install writes b as bundle name and atomically makes it current. Envoy
+// picks it up through SDS (see SDSConfig) without a restart.
+func Install(name string, b Bundle) (*Info, error) {
+	if err := checkGroup(Dir, Group); err != nil {
+		return nil, err
+	}
+	return install(Dir, name, b, time.Now())
+}
+
+// checkGroup refuses to write keys into root unless it is setgid to group:
+// they would otherwise get the client's primary group.
+func checkGroup(root, group string) error {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return fmt.Errorf("certificate group %s: %w; re-run the installer", group, err)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("%w; re-run the installer", err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || strconv.FormatUint(uint64(st.Gid), 10) != g.Gid || info.Mode()&os.ModeSetgid == 0 {
+		return fmt.Errorf("%s must be setgid to group %s so only envoy can read the keys; re-run the installer", root, group)
+	}
+	return nil
+}
+
+func install(root, name string, b Bundle, now time.Time) (*Info, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := files.ValidateServiceName(name); err != nil {
+		return nil, err
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	// MkdirAll leaves existing directories (and the umask) alone.
+	if err := os.Chmod(dir, dirMode); err != nil {
+		return nil, err
+	}
+
+	version := versionPrefix + now.UTC().Format("20060102T150405.000000000")
+	versionDir := filepath.Join(dir, version)
+	if err := os.Mkdir(versionDir, dirMode); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", versionDir, err)
+	}
+	contents := map[string][]byte{
+		CertFile: b.Cert,
+		KeyFile:  b.Key,
+		SDSFile:  []byte(sdsResources(dir, name, len(b.CA) > 0)),
+	}
+	if len(b.CA) > 0 {
+		contents[CAFile] = b.CA
+	}
+	for file, data := range contents {
+		if err := writeFile(filepath.Join(versionDir, file), data); err != nil {
+			os.RemoveAll(versionDir)
+			return nil, err
+		}
+	}
+
+	tmpLink := filepath.Join(dir, dataLink+".tmp")
+	os.Remove(tmpLink)
+	if err := os.Symlink(version, tmpLink); err != nil {
+		os.RemoveAll(versionDir)
+		return nil, fmt.Errorf("failed to link new version: %w", err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, dataLink)); err != nil {
+		os.Remove(tmpLink)
+		os.RemoveAll(versionDir)
+		return nil, fmt.Errorf("failed to switch to new version: %w", err)
+	}
+
+	for file := range contents {
+		link := filepath.Join(dir, file)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(dataLink, file), link); err != nil {
+			return nil, fmt.Errorf("failed to link %s: %w", file, err)
+		}
+	}
+	if len(b.CA) == 0 {
+		// The new sds.yaml no longer references it.
+		os.Remove(filepath.Join(dir, CAFile))
+	}
+	removeOldVersions(dir, version)
+
+	info := InspectFile(filepath.Join(dir, CertFile), "managed:"+name, now)
+	return &info, nil
+}
+
+// writeFile writes data with fileMode regardless of the umask and syncs it,
+// so the rename that publishes it never exposes a partial file.
+func writeFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(fileMode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func removeOldVersions(dir, keep string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), versionPrefix) && e.Name() != keep {
+			os.RemoveAll(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// Remove deletes bundle name. A bundle still referenced by a bootstrap is
+// kept, since envoy would fail to load the secret on its next restart.
+func Remove(name string) error {
+	return remove(Dir, models.BootstrapsPath, name)
+}
+
+func remove(root, bootstrapDir, name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := files.ValidateServiceName(name); err != nil {
+		return err
+	}
+	dir := filepath.Join(root, name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if users := usedBy(dir, bootstrapDir); len(users) > 0 {
+		return fmt.Errorf("certificate bundle %s is used by %s", name, strings.Join(users, ", "))
+	}
+	return os.RemoveAll(dir)
+}
+
+// Names returns the installed bundles, sorted.
+func Names() ([]string, error) {
+	return names(Dir)
+}
+
+func names(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(root, e.Name(), dataLink)); e.IsDir() && err == nil {
+			out = append(out, e.Name())
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -8,14 +8,46 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
-// CopyWithContext copies data from src to dst with context cancellation support
+var (
+	downloadLimitMu sync.RWMutex
+	downloadLimiter *rate.Limiter // nil: unlimited
+)
+
+// SetDownloadRateLimit caps the combined throughput of all artifact downloads
+// in bytes per second, so a version rollout cannot saturate a production link.
+// Zero or a negative value removes the cap.
+func SetDownloadRateLimit(bytesPerSec int64) {
+	downloadLimitMu.Lock()
+	defer downloadLimitMu.Unlock()
+	if bytesPerSec <= 0 {
+		downloadLimiter = nil
+		return
+	}
+	// The burst must cover one full copy buffer or WaitN can never succeed.
+	burst := max(int(bytesPerSec), copyBufferSize)
+	downloadLimiter = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
+
+func currentDownloadLimiter() *rate.Limiter {
+	downloadLimitMu.RLock()
+	defer downloadLimitMu.RUnlock()
+	return downloadLimiter
+}
+
+const copyBufferSize = 32 * 1024
+
+// CopyWithContext copies data from src to dst with context cancellation support,
+// throttled by the download rate limit when one is set
 func CopyWithContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
-	buf := make([]byte, 32*1024)
+	buf := make([]byte, copyBufferSize)
 	var written int64
+	limiter := currentDownloadLimiter()
 
 	for {
 		// Check for cancellation before each read
@@ -27,6 +59,11 @@ func CopyWithContext(ctx context.Context, dst io.Writer, src io.Reader) (int64,
 
 		nr, readErr := src.Read(buf)
 		if nr > 0 {
+			if limiter != nil {
+				if err := limiter.WaitN(ctx, nr); err != nil {
+					return written, err
+				}
+			}
 			nw, writeErr := dst.Write(buf[:nr])
 			if nw > 0 {
 				written += int64(nw)
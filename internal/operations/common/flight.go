@@ -0,0 +1,48 @@
+package common
+
+import "sync"
+
+// FlightGroup collapses concurrent calls with the same key into one execution
+// whose result every caller receives. Envoy and WAF installs use it so two
+// requests for the same version share a single download instead of racing to
+// write the same destination.
+//
+// The shared call runs with the first caller's context: if that caller is
+// cancelled, the waiting callers see the cancellation error too.
+type FlightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done   chan struct{}
+	result string
+	err    error
+}
+
+// Do runs fn once per key at a time. shared reports whether the result came
+// from another caller's execution.
+func (g *FlightGroup) Do(key string, fn func() (string, error)) (result string, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.result, c.err, true
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.result, c.err = fn()
+	return c.result, c.err, false
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GCPolicy decides which unused downloaded versions survive a garbage
+// collection. A version is kept if ANY rule retains it; an in-use version is
+// never a candidate in the first place.
+type GCPolicy struct {
+	// KeepN keeps the N highest unused versions (rollback targets).
+	KeepN int
+	// KeepNewerThan keeps unused versions downloaded within this window. Zero
+	// disables the rule.
+	KeepNewerThan time.Duration
+}
+
+// GCResult reports what a garbage collection did (or would do, when dry-run).
+type GCResult struct {
+	InUse          []string `json:"in_use"`
+	Kept           []string `json:"kept"`
+	Removed        []string `json:"removed"`
+	ReclaimedBytes int64    `json:"reclaimed_bytes"`
+	DryRun         bool     `json:"dry_run"`
+}
+
+// PruneVersions removes downloaded versions under baseDir that are not in
+// inUse and not retained by policy. downloaded is the list returned by
+// GetDownloadedVersions; passing it in keeps the scan and the prune in one
+// place per manager. Removal failures are returned after the remaining
+// versions have been attempted so one stuck directory does not stop the rest.
+func PruneVersions(baseDir string, downloaded []string, inUse map[string]bool, policy GCPolicy, dryRun bool, logger *logrus.Entry) (*GCResult, error) {
+	result := &GCResult{
+		InUse:   make([]string, 0),
+		Kept:    make([]string, 0),
+		Removed: make([]string, 0),
+		DryRun:  dryRun,
+	}
+
+	var candidates []string
+	for _, v := range downloaded {
+		if inUse[v] {
+			result.InUse = append(result.InUse, v)
+			continue
+		}
+		candidates = append(candidates, v)
+	}
+
+	// Highest version first so KeepN retains the most recent rollback targets.
+	sort.Slice(candidates, func(i, j int) bool { return CompareVersions(candidates[i], candidates[j]) > 0 })
+
+	var errs []string
+	now := time.Now()
+	for i, v := range candidates {
+		dir := filepath.Join(baseDir, v)
+
+		if i < policy.KeepN {
+			result.Kept = append(result.Kept, v)
+			continue
+		}
+		if policy.KeepNewerThan > 0 {
+			if info, err := os.Stat(dir); err == nil && now.Sub(info.ModTime()) < policy.KeepNewerThan {
+				result.Kept = append(result.Kept, v)
+				continue
+			}
+		}
+
+		size, err := DirSize(dir)
+		if err != nil {
+			logger.WithError(err).WithField("version", v).Warn("Failed to size version directory")
+		}
+		if !dryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				logger.WithError(err).WithField("version", v).Error("Failed to remove unused version")
+				errs = append(errs, fmt.Sprintf("%s: %v", v, err))
+				continue
+			}
+		}
+		result.Removed = append(result.Removed, v)
+		result.ReclaimedBytes += size
+	}
+
+	logger.WithFields(logrus.Fields{
+		"in_use":          result.InUse,
+		"kept":            result.Kept,
+		"removed":         result.Removed,
+		"reclaimed_bytes": result.ReclaimedBytes,
+		"dry_run":         dryRun,
+	}).Info("Version garbage collection finished")
+
+	if len(errs) > 0 {
+		return result, fmt.Errorf("failed to remove %d version(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return result, nil
+}
+
+// FindVersionReferences returns the versions referenced as
+// <baseDir>/<version>/<binaryName> anywhere in the given contents.
+func FindVersionReferences(baseDir, binaryName string, contents ...[]byte) map[string]bool {
+	re := regexp.MustCompile(regexp.QuoteMeta(strings.TrimSuffix(baseDir, "/")) + `/([^/\s"']+)/` + regexp.QuoteMeta(binaryName))
+	refs := make(map[string]bool)
+	for _, c := range contents {
+		for _, m := range re.FindAllSubmatch(c, -1) {
+			refs[string(m[1])] = true
+		}
+	}
+	return refs
+}
+
+// DirSize returns the total size of the regular files under dir.
+func DirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// CompareVersions orders "v1.34.2"-style versions numerically, component by
+// component, falling back to a string comparison for non-numeric parts. It
+// returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	pa := strings.FieldsFunc(strings.TrimPrefix(a, "v"), versionSep)
+	pb := strings.FieldsFunc(strings.TrimPrefix(b, "v"), versionSep)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		if i >= len(pa) {
+			return -1
+		}
+		if i >= len(pb) {
+			return 1
+		}
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		default:
+			if c := strings.Compare(pa[i], pb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func versionSep(r rune) bool {
+	return r == '.' || r == '-' || r == '+'
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func makeVersion(t *testing.T, base, version string, size int, age time.Duration) {
+	t.Helper()
+	dir := filepath.Join(base, version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "envoy"), make([]byte, size), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// In-use versions are never candidates, KeepN retains the highest unused
+// versions (numerically, so v1.10 beats v1.9), and only the rest are removed
+// and counted as reclaimed.
+func TestPruneVersions_KeepNAndInUse(t *testing.T) {
+	base := t.TempDir()
+	old := 30 * 24 * time.Hour
+	for _, v := range []string{"v1.8.0", "v1.9.0", "v1.10.0", "v1.11.0"} {
+		makeVersion(t, base, v, 100, old)
+	}
+
+	downloaded := []string{"v1.8.0", "v1.9.0", "v1.10.0", "v1.11.0"}
+	res, err := PruneVersions(base, downloaded, map[string]bool{"v1.8.0": true}, GCPolicy{KeepN: 1}, false, testLogger())
+	if err != nil {
+		t.Fatalf("PruneVersions: %v", err)
+	}
+
+	if !reflect.DeepEqual(res.InUse, []string{"v1.8.0"}) {
+		t.Errorf("InUse = %v", res.InUse)
+	}
+	if !reflect.DeepEqual(res.Kept, []string{"v1.11.0"}) {
+		t.Errorf("Kept = %v, want [v1.11.0]", res.Kept)
+	}
+	removed := append([]string(nil), res.Removed...)
+	sort.Strings(removed)
+	if !reflect.DeepEqual(removed, []string{"v1.10.0", "v1.9.0"}) {
+		t.Errorf("Removed = %v", res.Removed)
+	}
+	if res.ReclaimedBytes != 200 {
+		t.Errorf("ReclaimedBytes = %d, want 200", res.ReclaimedBytes)
+	}
+	for _, v := range []string{"v1.8.0", "v1.11.0"} {
+		if _, err := os.Stat(filepath.Join(base, v)); err != nil {
+			t.Errorf("%s should still exist: %v", v, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(base, "v1.9.0")); !os.IsNotExist(err) {
+		t.Errorf("v1.9.0 should have been removed")
+	}
+}
+
+func TestPruneVersions_KeepNewerThanAndDryRun(t *testing.T) {
+	base := t.TempDir()
+	makeVersion(t, base, "v1.0.0", 10, 30*24*time.Hour)
+	makeVersion(t, base, "v1.1.0", 10, time.Hour)
+
+	res, err := PruneVersions(base, []string{"v1.0.0", "v1.1.0"}, nil, GCPolicy{KeepNewerThan: 24 * time.Hour}, true, testLogger())
+	if err != nil {
+		t.Fatalf("PruneVersions: %v", err)
+	}
+	if !reflect.DeepEqual(res.Kept, []string{"v1.1.0"}) || !reflect.DeepEqual(res.Removed, []string{"v1.0.0"}) {
+		t.Fatalf("kept=%v removed=%v", res.Kept, res.Removed)
+	}
+	if res.ReclaimedBytes != 10 || !res.DryRun {
+		t.Errorf("dry run result = %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(base, "v1.0.0")); err != nil {
+		t.Errorf("dry run must not delete: %v", err)
+	}
+}
+
+func TestFindVersionReferences(t *testing.T) {
+	unit := []byte(`ExecStartPre=/var/lib/elchi/envoys/v1.34.0/envoy \
+  -c /var/lib/elchi/bootstraps/web-80.yaml --mode validate`)
+	cmdline := []byte("/var/lib/elchi/envoys/v1.33.2/envoy\x00-c\x00x.yaml")
+	dump := []byte(`"filename": "/var/lib/elchi/waf/v0.5.0/coraza.wasm"`)
+
+	got := FindVersionReferences("/var/lib/elchi/envoys", "envoy", unit, cmdline, dump)
+	want := map[string]bool{"v1.34.0": true, "v1.33.2": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("envoy refs = %v, want %v", got, want)
+	}
+
+	waf := FindVersionReferences("/var/lib/elchi/waf", "coraza.wasm", unit, dump)
+	if !reflect.DeepEqual(waf, map[string]bool{"v0.5.0": true}) {
+		t.Errorf("waf refs = %v", waf)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.10.0", "v1.9.9", 1},
+		{"v1.34.0", "v1.34.0", 0},
+		{"v1.34", "v1.34.1", -1},
+		{"v1.34.0-rc1", "v1.34.0-rc2", -1},
+	}
+	for _, c := range cases {
+		if got := CompareVersions(c.a, c.b); got != c.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
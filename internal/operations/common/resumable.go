@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DownloadCacheDir holds partially downloaded artifacts between attempts.
+const DownloadCacheDir = "/var/lib/elchi/downloads"
+
+// PartialDownloadTTL is how long an untouched partial file is kept for a later
+// resume. An active download writes to its partial continuously, so anything
+// idle this long belongs to an artifact nobody is fetching any more.
+const PartialDownloadTTL = 24 * time.Hour
+
+// maxDownloadAttempts bounds consecutive attempts that make no progress; an
+// attempt that adds bytes to the partial file resets the count, so a slow or
+// rate-limited link keeps going as long as it keeps moving.
+const maxDownloadAttempts = 5
+
+// PartialDownloadPath names the partial file for an artifact by its expected
+// sha256. Content addressing is what makes resuming safe: bytes already on
+// disk for this digest can only belong to this artifact, whichever URL or
+// version asked for it, and the final checksum catches anything else.
+func PartialDownloadPath(dir, sha256Hex string) (string, error) {
+	digest := strings.ToLower(sha256Hex)
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != 32 {
+		return "", fmt.Errorf("invalid sha256 %q in artifact index", sha256Hex)
+	}
+	return filepath.Join(dir, digest+".partial"), nil
+}
+
+// PrunePartialDownloads removes *.partial files in dir not modified within
+// ttl. A missing dir is not an error. Removal failures are returned after the
+// remaining files have been attempted.
+func PrunePartialDownloads(dir string, ttl time.Duration, dryRun bool) (*GCResult, error) {
+	result := &GCResult{Removed: make([]string, 0), DryRun: dryRun}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.partial"))
+	if err != nil {
+		return result, err
+	}
+	var errs []string
+	now := time.Now()
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() || now.Sub(info.ModTime()) < ttl {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(p); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", filepath.Base(p), err))
+				continue
+			}
+		}
+		result.Removed = append(result.Removed, filepath.Base(p))
+		result.ReclaimedBytes += info.Size()
+	}
+
+	if len(errs) > 0 {
+		return result, fmt.Errorf("failed to remove %d partial download(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return result, nil
+}
+
+// DownloadResumable downloads url into partial, resuming from partial's
+// current size with an HTTP Range request. Each attempt is bounded by
+// attemptTimeout and failed attempts are retried (see maxDownloadAttempts), so
+// a flaky link makes progress instead of restarting from zero. The partial
+// file is left in place on failure for the next call to resume.
+func DownloadResumable(ctx context.Context, logger *logrus.Entry, httpClient *http.Client, url, partial string, attemptTimeout time.Duration) error {
+	if err := os.MkdirAll(filepath.Dir(partial), 0o700); err != nil {
+		return fmt.Errorf("failed to create download cache: %w", err)
+	}
+
+	var lastErr error
+	for failures := 1; failures <= maxDownloadAttempts; failures++ {
+		written, err := downloadAttempt(ctx, logger, httpClient, url, partial, attemptTimeout)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrArtifactNotFound) {
+			return err
+		}
+		if written > 0 {
+			failures = 0
+		}
+		lastErr = err
+		logger.WithError(err).WithFields(logrus.Fields{
+			"url":     url,
+			"written": written,
+		}).Warn("Download attempt failed, will resume")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(max(failures, 1)) * time.Second):
+		}
+	}
+	return fmt.Errorf("download failed after %d attempts without progress: %w", maxDownloadAttempts, lastErr)
+}
+
+// downloadAttempt returns the number of bytes it added to partial.
+func downloadAttempt(ctx context.Context, logger *logrus.Entry, httpClient *http.Client, url, partial string, timeout time.Duration) (int64, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offset := info.Size()
+
+	body, resumed, err := CurrentArtifactSource().OpenAt(attemptCtx, httpClient, url, offset)
+	if errors.Is(err, ErrRangeNotSatisfiable) {
+		// The partial is as long as (or longer than) the artifact: start over.
+		if truncErr := f.Truncate(0); truncErr != nil {
+			return 0, truncErr
+		}
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	if !resumed {
+		offset = 0
+		if err := f.Truncate(0); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		logger.WithFields(logrus.Fields{"url": url, "offset": offset}).Info("Resuming download")
+	}
+
+	written, err := CopyWithContext(attemptCtx, f, body)
+	if err != nil {
+		return written, fmt.Errorf("failed to save file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return written, err
+	}
+	logger.WithField("bytes", offset+written).Debug("File download completed")
+	return written, nil
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var artifactBody = bytes.Repeat([]byte("0123456789abcdef"), 4096) // 64 KiB
+
+func TestDownloadResumableResumesPartialFile(t *testing.T) {
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "envoy", time.Time{}, bytes.NewReader(artifactBody))
+	}))
+	defer srv.Close()
+
+	partial := filepath.Join(t.TempDir(), "x.partial")
+	half := len(artifactBody) / 2
+	if err := os.WriteFile(partial, artifactBody[:half], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DownloadResumable(context.Background(), testLogger(), srv.Client(), srv.URL+"/envoy", partial, time.Minute); err != nil {
+		t.Fatalf("DownloadResumable: %v", err)
+	}
+	got, _ := os.ReadFile(partial)
+	if !bytes.Equal(got, artifactBody) {
+		t.Fatalf("resumed file has %d bytes, want %d identical bytes", len(got), len(artifactBody))
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes="+strconv.Itoa(half)+"-" {
+		t.Errorf("requests sent Range %q, want one resume from %d", ranges, half)
+	}
+}
+
+// A dropped connection is retried from where it stopped, not from zero.
+func TestDownloadResumableRetriesAfterDrop(t *testing.T) {
+	var calls atomic.Int32
+	var secondRange string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(artifactBody)))
+			_, _ = w.Write(artifactBody[:1000]) // short body: the client sees an unexpected EOF
+			return
+		}
+		secondRange = r.Header.Get("Range")
+		http.ServeContent(w, r, "envoy", time.Time{}, bytes.NewReader(artifactBody))
+	}))
+	defer srv.Close()
+
+	partial := filepath.Join(t.TempDir(), "x.partial")
+	if err := DownloadResumable(context.Background(), testLogger(), srv.Client(), srv.URL+"/envoy", partial, time.Minute); err != nil {
+		t.Fatalf("DownloadResumable: %v", err)
+	}
+	if secondRange != "bytes=1000-" {
+		t.Errorf("retry sent Range %q, want bytes=1000-", secondRange)
+	}
+	if got, _ := os.ReadFile(partial); !bytes.Equal(got, artifactBody) {
+		t.Fatal("retried download does not match the artifact")
+	}
+}
+
+// A server that ignores Range answers 200 with the full body; the stale
+// partial must be replaced, not appended to.
+func TestDownloadResumableRestartsWhenRangeIgnored(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(artifactBody)
+	}))
+	defer srv.Close()
+
+	partial := filepath.Join(t.TempDir(), "x.partial")
+	if err := os.WriteFile(partial, []byte("stale-prefix"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := DownloadResumable(context.Background(), testLogger(), srv.Client(), srv.URL+"/envoy", partial, time.Minute); err != nil {
+		t.Fatalf("DownloadResumable: %v", err)
+	}
+	if got, _ := os.ReadFile(partial); !bytes.Equal(got, artifactBody) {
+		t.Fatal("partial was appended to instead of restarted")
+	}
+}
+
+func TestPartialDownloadPathRejectsBadDigest(t *testing.T) {
+	if _, err := PartialDownloadPath("/tmp", "../../etc/passwd"); err == nil {
+		t.Error("non-hex digest accepted as a file name")
+	}
+}
+
+func TestPrunePartialDownloads(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "aa.partial")
+	fresh := filepath.Join(dir, "bb.partial")
+	other := filepath.Join(dir, "cc.tar.gz")
+	for _, p := range []string{stale, fresh, other} {
+		os.WriteFile(p, []byte("data"), 0600)
+	}
+	old := time.Now().Add(-2 * PartialDownloadTTL)
+	os.Chtimes(stale, old, old)
+	os.Chtimes(other, old, old)
+
+	res, err := PrunePartialDownloads(dir, PartialDownloadTTL, true)
+	if err != nil || len(res.Removed) != 1 || res.ReclaimedBytes != 4 {
+		t.Fatalf("dry run = %+v, %v", res, err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Error("dry run removed a file")
+	}
+
+	res, err = PrunePartialDownloads(dir, PartialDownloadTTL, false)
+	if err != nil || len(res.Removed) != 1 || res.Removed[0] != "aa.partial" {
+		t.Fatalf("prune = %+v, %v", res, err)
+	}
+	for p, want := range map[string]bool{stale: false, fresh: true, other: true} {
+		if _, err := os.Stat(p); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", filepath.Base(p), err == nil, want)
+		}
+	}
+
+	if _, err := PrunePartialDownloads(filepath.Join(dir, "missing"), PartialDownloadTTL, false); err != nil {
+		t.Errorf("missing dir: %v", err)
+	}
+}
+
+func TestCopyWithContextRateLimit(t *testing.T) {
+	SetDownloadRateLimit(int64(len(artifactBody)))
+	t.Cleanup(func() { SetDownloadRateLimit(0) })
+
+	// The first second's worth passes as burst; the second has to wait.
+	start := time.Now()
+	var out bytes.Buffer
+	if _, err := CopyWithContext(context.Background(), &out, bytes.NewReader(append(artifactBody, artifactBody...))); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Errorf("2s of data at the cap copied in %s, want the limit enforced", elapsed)
+	}
+}
+
+func TestFlightGroupSharesConcurrentCalls(t *testing.T) {
+	var g FlightGroup
+	var runs atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _, _ = g.Do("v1.34.2", func() (string, error) {
+				runs.Add(1)
+				<-release
+				return "/var/lib/elchi/envoys/v1.34.2/envoy", nil
+			})
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // let every caller join the in-flight call
+	close(release)
+	wg.Wait()
+
+	if n := runs.Load(); n != 1 {
+		t.Errorf("fn ran %d times, want 1", n)
+	}
+	for _, r := range results {
+		if r != "/var/lib/elchi/envoys/v1.34.2/envoy" {
+			t.Errorf("caller got %q", r)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Artifact signatures are detached Ed25519 signatures over the artifact's raw
+// 32-byte SHA-256 digest, base64 encoded. Signing the digest (rather than the
+// whole file) lets a 100+ MB envoy binary be verified without holding it in
+// memory, and binds the signature to the same digest VerifyChecksum already
+// checked against the file. The signature comes from the index entry's
+// "signature" field or, failing that, from the download URL with ".sig"
+// appended to its path.
+//
+// The checksum alone only proves the file matches the index; whoever controls
+// the index controls both. The signature is checked against public keys pinned
+// in the local config, so a tampered index cannot produce an accepted artifact.
+
+// maxSignatureBytes caps a fetched .sig file; a base64 Ed25519 signature is 88
+// bytes, anything much larger is not a signature.
+const maxSignatureBytes = 4096
+
+// ErrUnsigned reports that an artifact carried no signature at all.
+var ErrUnsigned = errors.New("artifact is not signed")
+
+// SignaturePolicy holds the pinned signing keys and whether unsigned artifacts
+// are tolerated.
+type SignaturePolicy struct {
+	keys          map[string]ed25519.PublicKey // by key ID
+	allowUnsigned bool
+}
+
+// NewSignaturePolicy parses base64 Ed25519 public keys (32 raw bytes each).
+func NewSignaturePolicy(encodedKeys []string, allowUnsigned bool) (*SignaturePolicy, error) {
+	p := &SignaturePolicy{keys: make(map[string]ed25519.PublicKey), allowUnsigned: allowUnsigned}
+	for i, enc := range encodedKeys {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(enc))
+		if err != nil {
+			return nil, fmt.Errorf("signing key %d is not valid base64: %w", i, err)
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("signing key %d has %d bytes, want %d", i, len(raw), ed25519.PublicKeySize)
+		}
+		pub := ed25519.PublicKey(raw)
+		p.keys[KeyID(pub)] = pub
+	}
+	return p, nil
+}
+
+// KeyID is a short, log-friendly fingerprint of a public key.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+var (
+	signaturePolicyMu sync.RWMutex
+	// The zero policy has no keys and rejects unsigned artifacts: until the
+	// config says otherwise, nothing downloaded is trusted.
+	signaturePolicy = &SignaturePolicy{keys: map[string]ed25519.PublicKey{}}
+)
+
+// SetSignaturePolicy installs the process-wide policy (from the client config
+// at startup).
+func SetSignaturePolicy(p *SignaturePolicy) {
+	signaturePolicyMu.Lock()
+	defer signaturePolicyMu.Unlock()
+	signaturePolicy = p
+}
+
+// CurrentSignaturePolicy returns the process-wide policy.
+func CurrentSignaturePolicy() *SignaturePolicy {
+	signaturePolicyMu.RLock()
+	defer signaturePolicyMu.RUnlock()
+	return signaturePolicy
+}
+
+// Verify checks signature (base64) over the artifact digest sha256Hex. It
+// returns the ID of the key that verified it. An empty signature is accepted
+// only when the policy allows unsigned artifacts, reported as keyID "".
+func (p *SignaturePolicy) Verify(sha256Hex, signature string) (string, error) {
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		if p.allowUnsigned {
+			return "", nil
+		}
+		return "", fmt.Errorf("%w and the local policy requires signatures (set artifacts.signing.allow_unsigned to accept it)", ErrUnsigned)
+	}
+	if len(p.keys) == 0 {
+		return "", fmt.Errorf("artifact is signed but no signing keys are pinned in artifacts.signing.public_keys")
+	}
+
+	digest, err := hex.DecodeString(strings.ToLower(strings.TrimSpace(sha256Hex)))
+	if err != nil || len(digest) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 digest %q", sha256Hex)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed artifact signature")
+	}
+
+	for id, pub := range p.keys {
+		if ed25519.Verify(pub, digest, sig) {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("artifact signature does not match any pinned signing key")
+}
+
+// FetchDetachedSignature downloads the detached signature of rawURL (see
+// detachedSignatureURL) through the current artifact source. A missing
+// signature means the artifact is unsigned and yields "" with no error; any
+// other failure is an error, so a flaky mirror cannot downgrade a signed
+// artifact to "unsigned".
+func FetchDetachedSignature(ctx context.Context, httpClient *http.Client, rawURL string) (string, error) {
+	sigURL, err := detachedSignatureURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	body, err := CurrentArtifactSource().Open(ctx, httpClient, sigURL)
+	if errors.Is(err, ErrArtifactNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch signature: %w", err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxSignatureBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read signature: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// detachedSignatureURL appends ".sig" to the path of rawURL, keeping any
+// query string after it. A pre-signed URL's query usually covers only the
+// artifact itself, so such artifacts should carry an inline signature.
+func detachedSignatureURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid artifact URL %q: %w", rawURL, err)
+	}
+	u.Path += ".sig"
+	if u.RawPath != "" {
+		u.RawPath += ".sig"
+	}
+	return u.String(), nil
+}
+
+// VerifyArchiveSignature checks an index entry's signature against the current
+// policy, using the inline signature when the index carries one and the
+// detached signature next to the download URL otherwise. Call it after VerifyChecksum so the
+// signed digest is known to match the downloaded file.
+func VerifyArchiveSignature(ctx context.Context, logger *logrus.Entry, httpClient *http.Client, binary *ArchiveBinary) error {
+	signature := binary.Signature
+	if signature == "" {
+		var err error
+		if signature, err = FetchDetachedSignature(ctx, httpClient, binary.DownloadURL); err != nil {
+			return fmt.Errorf("failed to get signature for %s: %w", binary.DownloadURL, err)
+		}
+	}
+
+	keyID, err := CurrentSignaturePolicy().Verify(binary.SHA256, signature)
+	if err != nil {
+		logger.WithError(err).WithField("url", binary.DownloadURL).Error("Signature verification failed")
+		return fmt.Errorf("signature verification failed for %s: %w", binary.DownloadURL, err)
+	}
+	if keyID == "" {
+		logger.WithField("url", binary.DownloadURL).Warn("Accepting unsigned artifact (allow_unsigned is set)")
+		return nil
+	}
+	logger.WithField("key_id", keyID).Debug("Signature verified")
+	return nil
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func signDigest(t *testing.T, priv ed25519.PrivateKey, body []byte) (string, string) {
+	t.Helper()
+	digest := sha256.Sum256(body)
+	return hex.EncodeToString(digest[:]), base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest[:]))
+}
+
+func newTestPolicy(t *testing.T, allowUnsigned bool) (*SignaturePolicy, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewSignaturePolicy([]string{base64.StdEncoding.EncodeToString(pub)}, allowUnsigned)
+	if err != nil {
+		t.Fatalf("NewSignaturePolicy: %v", err)
+	}
+	return p, priv
+}
+
+func TestSignaturePolicyVerify(t *testing.T) {
+	p, priv := newTestPolicy(t, false)
+	digest, sig := signDigest(t, priv, []byte("envoy-binary"))
+
+	keyID, err := p.Verify(digest, sig)
+	if err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if keyID != KeyID(priv.Public().(ed25519.PublicKey)) {
+		t.Errorf("keyID = %q, want the pinned key", keyID)
+	}
+
+	otherDigest, _ := signDigest(t, priv, []byte("tampered"))
+	if _, err := p.Verify(otherDigest, sig); err == nil {
+		t.Error("signature accepted for a different digest")
+	}
+
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	_, foreign := signDigest(t, otherPriv, []byte("envoy-binary"))
+	if _, err := p.Verify(digest, foreign); err == nil {
+		t.Error("signature from an unpinned key accepted")
+	}
+
+	if _, err := p.Verify(digest, "not-base64!"); err == nil {
+		t.Error("malformed signature accepted")
+	}
+}
+
+func TestSignaturePolicyUnsigned(t *testing.T) {
+	strict, _ := newTestPolicy(t, false)
+	if _, err := strict.Verify("ab", ""); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("strict policy: err = %v, want ErrUnsigned", err)
+	}
+
+	lax, _ := newTestPolicy(t, true)
+	if keyID, err := lax.Verify("ab", ""); err != nil || keyID != "" {
+		t.Errorf("allow_unsigned policy: keyID=%q err=%v, want accepted unsigned", keyID, err)
+	}
+}
+
+func TestNewSignaturePolicyRejectsBadKeys(t *testing.T) {
+	for _, key := range []string{"%%%", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewSignaturePolicy([]string{key}, false); err == nil {
+			t.Errorf("NewSignaturePolicy(%q) succeeded, want error", key)
+		}
+	}
+}
+
+func TestVerifyArchiveSignatureDetached(t *testing.T) {
+	p, priv := newTestPolicy(t, false)
+	prev := CurrentSignaturePolicy()
+	SetSignaturePolicy(p)
+	t.Cleanup(func() { SetSignaturePolicy(prev) })
+
+	digest, sig := signDigest(t, priv, []byte("coraza.wasm"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/signed.wasm.sig":
+			_, _ = w.Write([]byte(sig + "\n"))
+		case "/presigned.wasm.sig":
+			if r.URL.Query().Get("X-Amz-Signature") != "abc" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(sig))
+		case "/broken.wasm.sig":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ok := &ArchiveBinary{DownloadURL: srv.URL + "/signed.wasm", SHA256: digest}
+	if err := VerifyArchiveSignature(ctx, testLogger(), srv.Client(), ok); err != nil {
+		t.Errorf("detached signature rejected: %v", err)
+	}
+
+	// The query of a pre-signed URL stays after the .sig path.
+	presigned := &ArchiveBinary{DownloadURL: srv.URL + "/presigned.wasm?X-Amz-Signature=abc", SHA256: digest}
+	if err := VerifyArchiveSignature(ctx, testLogger(), srv.Client(), presigned); err != nil {
+		t.Errorf("detached signature of a URL with a query rejected: %v", err)
+	}
+
+	inline := &ArchiveBinary{DownloadURL: srv.URL + "/missing.wasm", SHA256: digest, Signature: sig}
+	if err := VerifyArchiveSignature(ctx, testLogger(), srv.Client(), inline); err != nil {
+		t.Errorf("inline signature rejected: %v", err)
+	}
+
+	unsigned := &ArchiveBinary{DownloadURL: srv.URL + "/missing.wasm", SHA256: digest}
+	if err := VerifyArchiveSignature(ctx, testLogger(), srv.Client(), unsigned); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("unsigned artifact: err = %v, want ErrUnsigned", err)
+	}
+
+	// A server error must not be mistaken for "unsigned".
+	broken := &ArchiveBinary{DownloadURL: srv.URL + "/broken.wasm", SHA256: digest}
+	if err := VerifyArchiveSignature(ctx, testLogger(), srv.Client(), broken); err == nil || errors.Is(err, ErrUnsigned) {
+		t.Errorf("signature fetch error: err = %v, want a non-ErrUnsigned failure", err)
+	}
+}
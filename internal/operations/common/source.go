@@ -0,0 +1,170 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultIndexURL is the public artifact index used when no mirror is configured.
+const DefaultIndexURL = "https://archive.elchi.io/index.json"
+
+var (
+	// ErrArtifactNotFound reports a 404 from a mirror or a missing file:// path.
+	ErrArtifactNotFound = errors.New("artifact not found")
+	// ErrRangeNotSatisfiable reports a resume offset past the end of the artifact.
+	ErrRangeNotSatisfiable = errors.New("requested range not satisfiable")
+)
+
+// ArtifactSource locates the artifact index and opens the files it references.
+// The index may live on the public archive, an internal HTTP mirror or a local
+// directory (file://). download_url entries are resolved relative to the index,
+// so a mirror can carry relative paths and be copied around as a tree.
+type ArtifactSource struct {
+	indexURL *url.URL
+	// headers are sent only to the index's own scheme and host, so mirror
+	// credentials never leak to an absolute download_url elsewhere.
+	headers map[string]string
+}
+
+// NewArtifactSource validates indexURL (http, https or file; empty selects
+// DefaultIndexURL).
+func NewArtifactSource(indexURL string, headers map[string]string) (*ArtifactSource, error) {
+	if indexURL == "" {
+		indexURL = DefaultIndexURL
+	}
+	u, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index url %q: %w", indexURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("index url %q has no host", indexURL)
+		}
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("index url %q has no path", indexURL)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported index url scheme %q (want http, https or file)", u.Scheme)
+	}
+	return &ArtifactSource{indexURL: u, headers: headers}, nil
+}
+
+var (
+	artifactSourceMu sync.RWMutex
+	artifactSource   = &ArtifactSource{indexURL: mustParseURL(DefaultIndexURL)}
+)
+
+// SetArtifactSource installs the process-wide source (from the client config at
+// startup, or a temporary one during `artifacts import`).
+func SetArtifactSource(s *ArtifactSource) {
+	artifactSourceMu.Lock()
+	defer artifactSourceMu.Unlock()
+	artifactSource = s
+}
+
+// CurrentArtifactSource returns the process-wide source.
+func CurrentArtifactSource() *ArtifactSource {
+	artifactSourceMu.RLock()
+	defer artifactSourceMu.RUnlock()
+	return artifactSource
+}
+
+// IndexURL returns the index location.
+func (s *ArtifactSource) IndexURL() string {
+	return s.indexURL.String()
+}
+
+// Resolve turns a download_url from the index into an absolute URL.
+func (s *ArtifactSource) Resolve(ref string) (string, error) {
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid download url %q: %w", ref, err)
+	}
+	return s.indexURL.ResolveReference(r).String(), nil
+}
+
+// Open returns a reader for rawURL. A missing artifact is reported as
+// ErrArtifactNotFound; any other non-200 status is a plain error.
+func (s *ArtifactSource) Open(ctx context.Context, httpClient *http.Client, rawURL string) (io.ReadCloser, error) {
+	body, _, err := s.OpenAt(ctx, httpClient, rawURL, 0)
+	return body, err
+}
+
+// OpenAt is Open starting at byte offset. resumed reports whether the reader
+// really starts at offset: a server that ignores the Range header answers 200
+// with the whole body, and the caller has to start over. A Range beyond the
+// end of the artifact is reported as ErrRangeNotSatisfiable.
+func (s *ArtifactSource) OpenAt(ctx context.Context, httpClient *http.Client, rawURL string, offset int64) (body io.ReadCloser, resumed bool, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid url %q: %w", rawURL, err)
+	}
+
+	if u.Scheme == "file" {
+		f, err := os.Open(u.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, fmt.Errorf("%w: %s", ErrArtifactNotFound, u.Path)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if offset > 0 {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				f.Close()
+				return nil, false, err
+			}
+		}
+		return f, offset > 0, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	if strings.EqualFold(u.Scheme, s.indexURL.Scheme) && strings.EqualFold(u.Host, s.indexURL.Host) {
+		for k, v := range s.headers {
+			req.Header.Set(k, v)
+		}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, false, nil
+	case http.StatusPartialContent:
+		if offset > 0 {
+			return resp.Body, true, nil
+		}
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("%w: %s", ErrArtifactNotFound, rawURL)
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("%w: %s", ErrRangeNotSatisfiable, rawURL)
+	}
+	resp.Body.Close()
+	return nil, false, fmt.Errorf("%s returned status %d", rawURL, resp.StatusCode)
+}
+
+func mustParseURL(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifactSourceResolve(t *testing.T) {
+	s, err := NewArtifactSource("file:///mnt/mirror/index.json", nil)
+	if err != nil {
+		t.Fatalf("NewArtifactSource: %v", err)
+	}
+	for ref, want := range map[string]string{
+		"envoy/v1.34.2/envoy":              "file:///mnt/mirror/envoy/v1.34.2/envoy",
+		"https://archive.elchi.io/x/envoy": "https://archive.elchi.io/x/envoy",
+	} {
+		got, err := s.Resolve(ref)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", ref, got, err, want)
+		}
+	}
+}
+
+func TestNewArtifactSourceValidates(t *testing.T) {
+	if s, err := NewArtifactSource("", nil); err != nil || s.IndexURL() != DefaultIndexURL {
+		t.Errorf("empty url: %v, %v; want the default index", s, err)
+	}
+	for _, bad := range []string{"ftp://mirror/index.json", "http:///index.json", "file://", "::"} {
+		if _, err := NewArtifactSource(bad, nil); err == nil {
+			t.Errorf("NewArtifactSource(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestArtifactSourceOpenFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := NewArtifactSource("file://"+filepath.Join(dir, "index.json"), nil)
+
+	r, err := s.Open(context.Background(), http.DefaultClient, s.IndexURL())
+	if err != nil {
+		t.Fatalf("Open index: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != `{}` {
+		t.Errorf("index content = %q", data)
+	}
+
+	missing, _ := s.Resolve("missing.sig")
+	if _, err := s.Open(context.Background(), http.DefaultClient, missing); !errors.Is(err, ErrArtifactNotFound) {
+		t.Errorf("missing file: err = %v, want ErrArtifactNotFound", err)
+	}
+}
+
+// Mirror credentials go to the index host only, never to an absolute
+// download_url on another host.
+func TestArtifactSourceHeadersScopedToIndexHost(t *testing.T) {
+	var mirrorAuth, otherAuth string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorAuth = r.Header.Get("Authorization")
+	}))
+	defer mirror.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherAuth = r.Header.Get("Authorization")
+		http.NotFound(w, r)
+	}))
+	defer other.Close()
+
+	s, _ := NewArtifactSource(mirror.URL+"/index.json", map[string]string{"Authorization": "Bearer secret"})
+	r, err := s.Open(context.Background(), http.DefaultClient, s.IndexURL())
+	if err != nil {
+		t.Fatalf("Open index: %v", err)
+	}
+	r.Close()
+	if mirrorAuth != "Bearer secret" {
+		t.Errorf("mirror got Authorization %q", mirrorAuth)
+	}
+
+	if _, err := s.Open(context.Background(), http.DefaultClient, other.URL+"/envoy"); !errors.Is(err, ErrArtifactNotFound) {
+		t.Errorf("other host 404: err = %v, want ErrArtifactNotFound", err)
+	}
+	if otherAuth != "" {
+		t.Errorf("credentials leaked to another host: %q", otherAuth)
+	}
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name string
+	body string
+	typ  byte
+}
+
+func writeTarball(t *testing.T, gz bool, entries ...tarEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var tw *tar.Writer
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(zw)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	for _, e := range entries {
+		typ := e.typ
+		if typ == 0 {
+			typ = tar.TypeReg
+		}
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: typ}
+		if typ == tar.TypeSymlink {
+			hdr.Size, hdr.Linkname = 0, "/etc/passwd"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if typ == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tw.Close()
+	if zw != nil {
+		zw.Close()
+	}
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractTarball(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		path := writeTarball(t, gz,
+			tarEntry{name: "index.json", body: "{}"},
+			tarEntry{name: "envoy/v1.34.2/envoy", body: "binary"},
+		)
+		dest := t.TempDir()
+		if err := ExtractTarball(path, dest, 1<<20); err != nil {
+			t.Fatalf("gzip=%v: ExtractTarball: %v", gz, err)
+		}
+		got, err := os.ReadFile(filepath.Join(dest, "envoy", "v1.34.2", "envoy"))
+		if err != nil || string(got) != "binary" {
+			t.Errorf("gzip=%v: extracted binary = %q, %v", gz, got, err)
+		}
+	}
+}
+
+func TestExtractTarballRejectsUnsafeEntries(t *testing.T) {
+	cases := map[string]string{
+		"traversal": writeTarball(t, false, tarEntry{name: "../escape", body: "x"}),
+		"symlink":   writeTarball(t, false, tarEntry{name: "link", typ: tar.TypeSymlink}),
+		"oversize":  writeTarball(t, false, tarEntry{name: "big", body: "0123456789"}),
+	}
+	for name, path := range cases {
+		if err := ExtractTarball(path, t.TempDir(), 5); err == nil {
+			t.Errorf("%s: ExtractTarball succeeded, want error", name)
+		}
+	}
+}
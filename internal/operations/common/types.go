@@ -14,4 +14,7 @@ type ArchiveBinary struct {
 	Arch        string `json:"arch"`
 	DownloadURL string `json:"download_url"`
 	SHA256      string `json:"sha256"`
+	// Signature is the optional inline Ed25519 signature (see signature.go);
+	// when empty the detached signature (".sig" on the URL path) is used.
+	Signature string `json:"signature,omitempty"`
 }
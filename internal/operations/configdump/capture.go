@@ -0,0 +1,98 @@
+package configdump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/proxy"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// How long Await waits for the new envoy and its xDS to settle, and how often
+// it looks.
+var (
+	SettleTimeout = 30 * time.Second
+	pollInterval  = time.Second
+)
+
+// Fetch returns the config dump of the envoy on port.
+func Fetch(ctx context.Context, port uint32) ([]byte, error) {
+	resp, err := proxy.Do(ctx, &client.RequestEnvoyAdmin{Port: port, Method: client.HttpMethod_GET, Path: "/config_dump"})
+	if err != nil {
+		return nil, fmt.Errorf("config dump failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("config dump returned %d", resp.StatusCode)
+	}
+	return []byte(resp.Body), nil
+}
+
+// epochUptime returns how long the envoy epoch answering on port has been up
+// and whether it is LIVE.
+func epochUptime(ctx context.Context, port uint32) (time.Duration, bool, error) {
+	resp, err := proxy.Do(ctx, &client.RequestEnvoyAdmin{Port: port, Method: client.HttpMethod_GET, Path: "/server_info"})
+	if err != nil {
+		return 0, false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("server_info returned %d", resp.StatusCode)
+	}
+	var info struct {
+		State              string `json:"state"`
+		UptimeCurrentEpoch string `json:"uptime_current_epoch"`
+	}
+	if err := json.Unmarshal([]byte(resp.Body), &info); err != nil {
+		return 0, false, fmt.Errorf("failed to parse server_info: %w", err)
+	}
+	uptime, err := time.ParseDuration(info.UptimeCurrentEpoch)
+	if err != nil {
+		return 0, false, fmt.Errorf("bad uptime_current_epoch %q", info.UptimeCurrentEpoch)
+	}
+	return uptime, info.State == "LIVE", nil
+}
+
+// Await returns the config dump of the envoy that started after changedAt
+// (a hot-restart epoch or a restarted process) once it is LIVE and two
+// consecutive dumps are identical, i.e. xDS has settled. settled is false when
+// SettleTimeout ran out first; the last dump seen is returned then.
+func Await(ctx context.Context, port uint32, changedAt time.Time) (dump []byte, settled bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, SettleTimeout)
+	defer cancel()
+
+	var last []byte
+	lastErr := fmt.Errorf("no envoy started after the change")
+	for {
+		uptime, live, err := epochUptime(ctx, port)
+		switch {
+		case err != nil:
+			lastErr = err
+		case uptime > time.Since(changedAt)+time.Second:
+			// Still the epoch from before the change.
+		case !live:
+			lastErr = fmt.Errorf("envoy is not LIVE yet")
+		default:
+			current, err := Fetch(ctx, port)
+			if err != nil {
+				lastErr = err
+				break
+			}
+			if last != nil && bytes.Equal(last, current) {
+				return current, true, nil
+			}
+			last = current
+		}
+
+		select {
+		case <-ctx.Done():
+			if last != nil {
+				return last, false, nil
+			}
+			return nil, false, lastErr
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package configdump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const dumpBefore = `{"configs": [
+  {"@type": "type.googleapis.com/envoy.admin.v3.BootstrapConfigDump", "bootstrap": {"node": {"id": "n"}}},
+  {"@type": "type.googleapis.com/envoy.admin.v3.ListenersConfigDump",
+   "static_listeners": [{"listener": {"name": "admin-l", "address": {"socket_address": {"port_value": 9000}}}}],
+   "dynamic_listeners": [{"name": "web", "active_state": {"version_info": "1", "listener": {"name": "web", "per_connection_buffer_limit_bytes": 1024, "filter_chains": [{"filters": [{"name": "hcm"}]}]}}}]},
+  {"@type": "type.googleapis.com/envoy.admin.v3.ClustersConfigDump",
+   "dynamic_active_clusters": [{"cluster": {"name": "api", "connect_timeout": "1s"}}, {"cluster": {"name": "old", "connect_timeout": "1s"}}]},
+  {"@type": "type.googleapis.com/envoy.admin.v3.RoutesConfigDump",
+   "dynamic_route_configs": [{"route_config": {"name": "main", "virtual_hosts": [{"name": "vh", "domains": ["*"]}]}}]},
+  {"@type": "type.googleapis.com/envoy.admin.v3.SecretsConfigDump",
+   "dynamic_active_secrets": [{"name": "cert", "secret": {"name": "cert", "tls_certificate": {"private_key": {"inline_string": "KEY-ONE"}}}}]}
+]}`
+
+const dumpAfter = `{"configs": [
+  {"@type": "type.googleapis.com/envoy.admin.v3.ListenersConfigDump",
+   "static_listeners": [{"listener": {"name": "admin-l", "address": {"socket_address": {"port_value": 9000}}}}],
+   "dynamic_listeners": [{"name": "web", "active_state": {"version_info": "2", "listener": {"name": "web", "per_connection_buffer_limit_bytes": 2048, "filter_chains": [{"filters": [{"name": "hcm2"}]}]}}}]},
+  {"@type": "type.googleapis.com/envoy.admin.v3.ClustersConfigDump",
+   "dynamic_active_clusters": [{"cluster": {"name": "api", "connect_timeout": "1s"}}, {"cluster": {"name": "new", "connect_timeout": "2s"}}]},
+  {"@type": "type.googleapis.com/envoy.admin.v3.RoutesConfigDump",
+   "dynamic_route_configs": [{"route_config": {"name": "main", "virtual_hosts": [{"name": "vh", "domains": ["*"]}]}}]},
+  {"@type": "type.googleapis.com/envoy.admin.v3.SecretsConfigDump",
+   "dynamic_active_secrets": [{"name": "cert", "secret": {"name": "cert", "tls_certificate": {"private_key": {"inline_string": "KEY-TWO"}}}}]}
+]}`
+
+func TestCompare(t *testing.T) {
+	before, err := Parse([]byte(dumpBefore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Parse([]byte(dumpAfter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Compare(before, after)
+
+	if got := d.Summary(); got != "listeners ~1, clusters +1 -1, secrets ~1" {
+		t.Errorf("summary = %q", got)
+	}
+	l := d[KindListeners]
+	if len(l.Changed) != 1 || l.Changed[0].Name != "web" {
+		t.Fatalf("listener changes = %+v", l.Changed)
+	}
+	var paths []string
+	for _, f := range l.Changed[0].Fields {
+		paths = append(paths, f.Path)
+	}
+	if strings.Join(paths, ",") != "filter_chains[0].filters[0].name,per_connection_buffer_limit_bytes" {
+		t.Errorf("field paths = %v", paths)
+	}
+	c := d[KindClusters]
+	if len(c.Added) != 1 || c.Added[0] != "new" || len(c.Removed) != 1 || c.Removed[0] != "old" {
+		t.Errorf("cluster diff = %+v", c)
+	}
+
+	// Secrets are reported by name only; their content never reaches the diff.
+	s := d[KindSecrets]
+	if len(s.Changed) != 1 || s.Changed[0].Name != "cert" || len(s.Changed[0].Fields) != 0 {
+		t.Errorf("secret diff = %+v", s)
+	}
+	if out := fmt.Sprintf("%v", d); strings.Contains(out, "KEY-") {
+		t.Errorf("secret material leaked into the diff: %s", out)
+	}
+
+	if !Compare(before, before).Empty() {
+		t.Error("a snapshot differs from itself")
+	}
+}
+
+func TestRedactsSensitiveFields(t *testing.T) {
+	snap, err := Parse([]byte(`{"configs": [{"@type": "type.googleapis.com/envoy.admin.v3.ClustersConfigDump",
+	  "static_clusters": [{"cluster": {"name": "db", "transport_socket": {"typed_config": {"common_tls_context": {"tls_certificates": [{"private_key": {"inline_string": "SECRET"}}]}}}}}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := fmt.Sprintf("%v", snap[KindClusters]["db"]); strings.Contains(out, "SECRET") || !strings.Contains(out, Redacted) {
+		t.Errorf("cluster not redacted: %s", out)
+	}
+}
+
+func TestFieldChangesAreCapped(t *testing.T) {
+	before, after := map[string]any{}, map[string]any{}
+	for i := range maxFieldChanges + 5 {
+		before[fmt.Sprintf("k%02d", i)] = 1.0
+		after[fmt.Sprintf("k%02d", i)] = 2.0
+	}
+	d := Compare(Snapshot{KindRoutes: {"r": before}}, Snapshot{KindRoutes: {"r": after}})
+	ch := d[KindRoutes].Changed[0]
+	if len(ch.Fields) != maxFieldChanges || !ch.Truncated {
+		t.Errorf("%d fields, truncated=%v", len(ch.Fields), ch.Truncated)
+	}
+}
+
+// fakeEnvoy serves /server_info and /config_dump; the epoch "restarts" when
+// restarted is set and its dump changes once before settling.
+func fakeEnvoy(t *testing.T, restarted *atomic.Bool) uint32 {
+	t.Helper()
+	var dumps atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server_info":
+			uptime := "3600s"
+			if restarted.Load() {
+				uptime = "0.2s"
+			}
+			fmt.Fprintf(w, `{"state": "LIVE", "uptime_current_epoch": %q}`, uptime)
+		case "/config_dump":
+			if !restarted.Load() {
+				w.Write([]byte(dumpBefore))
+				return
+			}
+			if dumps.Add(1) == 1 {
+				// xDS still arriving.
+				w.Write([]byte(`{"configs": []}`))
+				return
+			}
+			w.Write([]byte(dumpAfter))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return uint32(port)
+}
+
+func TestRecorder(t *testing.T) {
+	savedDir, savedPoll := HistoryDir, pollInterval
+	HistoryDir, pollInterval = t.TempDir(), 10*time.Millisecond
+	defer func() { HistoryDir, pollInterval = savedDir, savedPoll }()
+
+	var restarted atomic.Bool
+	port := fakeEnvoy(t, &restarted)
+	bootstrap := filepath.Join(t.TempDir(), "web-80.yaml")
+	os.WriteFile(bootstrap, []byte("admin: {}\n"), 0644)
+
+	rec := Begin(context.Background(), "web-80", port, SourceUpdateBootstrap)
+	restarted.Store(true)
+	entry, err := rec.Finish(context.Background(), bootstrap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Settled || entry.Summary != "listeners ~1, clusters +1 -1, secrets ~1" || entry.Error != "" {
+		t.Fatalf("entry = %+v", entry)
+	}
+
+	got, err := GetEntry("web-80", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != entry.ID || got.Diff[KindClusters] == nil {
+		t.Errorf("stored entry = %+v", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(HistoryDir, "web-80", entry.ID, bootstrapFile)); string(data) != "admin: {}\n" {
+		t.Errorf("bootstrap not kept with the entry: %q", data)
+	}
+	list, _ := ListEntries("web-80")
+	if len(list) != 1 || list[0].Diff != nil {
+		t.Errorf("list = %+v, want one entry without its diff", list)
+	}
+	for _, id := range []string{"../web-80", "nope", ".."} {
+		if _, err := GetEntry("web-80", id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetEntry(%q) = %v", id, err)
+		}
+	}
+}
+
+func TestRecorderWithoutRunningEnvoy(t *testing.T) {
+	savedDir := HistoryDir
+	HistoryDir = t.TempDir()
+	defer func() { HistoryDir = savedDir }()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	srv.Close()
+
+	start := time.Now()
+	entry, err := Begin(context.Background(), "web-80", uint32(port), SourceDeployUpdate).Finish(context.Background(), "/nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(entry.Error, "no config dump before the change") || entry.Summary != "unknown" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("waited for an envoy that was not running")
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	dir := t.TempDir()
+	for i := range historyKeep + 3 {
+		e := &Entry{ID: fmt.Sprintf("20260101T0000%02d.000Z", i), Service: "web-80"}
+		if err := saveEntry(dir, e, "/nonexistent"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, _ := listEntries(dir, "web-80")
+	if len(list) != historyKeep || list[0].ID != fmt.Sprintf("20260101T0000%02d.000Z", historyKeep+2) {
+		t.Errorf("%d entries, newest %s", len(list), list[0].ID)
+	}
+}
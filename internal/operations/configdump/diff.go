@@ -0,0 +1,292 @@
+// Package configdump reduces envoy /config_dump output to its listeners,
+// clusters, routes and secrets, diffs two of them and keeps the result with
+// the bootstrap that caused it.
+package configdump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Resource kinds in a Snapshot and a Diff.
+const (
+	KindListeners = "listeners"
+	KindClusters  = "clusters"
+	KindRoutes    = "routes"
+	KindSecrets   = "secrets"
+)
+
+// Redacted replaces sensitive values in stored diffs.
+const Redacted = "[redacted]"
+
+// maxFieldChanges bounds the field changes reported for one resource.
+const maxFieldChanges = 25
+
+// sensitiveKeys are redacted wherever they appear in a resource. Secrets are
+// never shown at all, only whether they changed.
+var sensitiveKeys = map[string]bool{
+	"private_key":         true,
+	"password":            true,
+	"session_ticket_keys": true,
+	"hmac_secret":         true,
+	"client_secret":       true,
+	"token_secret":        true,
+}
+
+// Snapshot is the named resources of one config dump, by kind. Secrets hold
+// a fingerprint of their content instead of the content.
+type Snapshot map[string]map[string]any
+
+// sections maps a config dump type to the repeated fields holding its
+// resources and the key of the resource inside each entry.
+var sections = map[string]struct {
+	kind   string
+	fields []string
+	inner  string
+}{
+	"ListenersConfigDump": {KindListeners, []string{"static_listeners", "dynamic_listeners"}, "listener"},
+	"ClustersConfigDump":  {KindClusters, []string{"static_clusters", "dynamic_active_clusters", "dynamic_warming_clusters"}, "cluster"},
+	"RoutesConfigDump":    {KindRoutes, []string{"static_route_configs", "dynamic_route_configs"}, "route_config"},
+	"SecretsConfigDump":   {KindSecrets, []string{"static_secrets", "dynamic_active_secrets", "dynamic_warming_secrets"}, "secret"},
+}
+
+// Parse reads a /config_dump body.
+func Parse(body []byte) (Snapshot, error) {
+	var dump struct {
+		Configs []map[string]any `json:"configs"`
+	}
+	if err := json.Unmarshal(body, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse config dump: %w", err)
+	}
+
+	snap := Snapshot{KindListeners: {}, KindClusters: {}, KindRoutes: {}, KindSecrets: {}}
+	for _, cfg := range dump.Configs {
+		typeURL, _ := cfg["@type"].(string)
+		sec, ok := sections[typeURL[strings.LastIndex(typeURL, ".")+1:]]
+		if !ok {
+			continue
+		}
+		for _, field := range sec.fields {
+			entries, _ := cfg[field].([]any)
+			for _, e := range entries {
+				entry, _ := e.(map[string]any)
+				name, res := resourceOf(entry, sec.inner)
+				if name == "" || res == nil {
+					continue
+				}
+				// A resource in both active and warming state keeps the first.
+				if _, seen := snap[sec.kind][name]; seen {
+					continue
+				}
+				if sec.kind == KindSecrets {
+					snap[sec.kind][name] = fingerprint(res)
+				} else {
+					snap[sec.kind][name] = redact(res)
+				}
+			}
+		}
+	}
+	return snap, nil
+}
+
+// resourceOf unwraps one config dump entry. Dynamic listeners nest the
+// listener in active_state (or warming_state while it warms up).
+func resourceOf(entry map[string]any, inner string) (string, map[string]any) {
+	if entry == nil {
+		return "", nil
+	}
+	res, _ := entry[inner].(map[string]any)
+	if res == nil {
+		for _, state := range []string{"active_state", "warming_state"} {
+			if s, ok := entry[state].(map[string]any); ok {
+				if res, _ = s[inner].(map[string]any); res != nil {
+					break
+				}
+			}
+		}
+	}
+	name, _ := entry["name"].(string)
+	if res != nil {
+		if n, ok := res["name"].(string); ok && n != "" {
+			name = n
+		}
+	}
+	return name, res
+}
+
+func fingerprint(v any) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func redact(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			if sensitiveKeys[k] {
+				out[k] = Redacted
+				continue
+			}
+			out[k] = redact(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, val := range t {
+			out[i] = redact(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Diff is what changed between two snapshots, by kind.
+type Diff map[string]*KindDiff
+
+// KindDiff lists the added, removed and changed resources of one kind.
+type KindDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []Change `json:"changed,omitempty"`
+}
+
+// Change is one modified resource. Secrets have no Fields.
+type Change struct {
+	Name      string        `json:"name"`
+	Fields    []FieldChange `json:"fields,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+// FieldChange is one changed value; Path is like
+// "filter_chains[0].filters[0].name". A missing side is nil.
+type FieldChange struct {
+	Path   string `json:"path"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// Compare diffs before against after.
+func Compare(before, after Snapshot) Diff {
+	diff := Diff{}
+	for _, kind := range []string{KindListeners, KindClusters, KindRoutes, KindSecrets} {
+		kd := &KindDiff{}
+		b, a := before[kind], after[kind]
+		for _, name := range sortedKeys(a) {
+			old, ok := b[name]
+			if !ok {
+				kd.Added = append(kd.Added, name)
+				continue
+			}
+			if reflect.DeepEqual(old, a[name]) {
+				continue
+			}
+			ch := Change{Name: name}
+			if kind != KindSecrets {
+				walk("", old, a[name], &ch)
+			}
+			kd.Changed = append(kd.Changed, ch)
+		}
+		for _, name := range sortedKeys(b) {
+			if _, ok := a[name]; !ok {
+				kd.Removed = append(kd.Removed, name)
+			}
+		}
+		diff[kind] = kd
+	}
+	return diff
+}
+
+// Empty reports whether nothing changed.
+func (d Diff) Empty() bool {
+	for _, kd := range d {
+		if len(kd.Added)+len(kd.Removed)+len(kd.Changed) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary counts the changes, e.g. "listeners +1 ~2, clusters -1".
+func (d Diff) Summary() string {
+	var parts []string
+	for _, kind := range []string{KindListeners, KindClusters, KindRoutes, KindSecrets} {
+		kd := d[kind]
+		if kd == nil {
+			continue
+		}
+		var counts []string
+		for _, c := range []struct {
+			sign string
+			n    int
+		}{{"+", len(kd.Added)}, {"-", len(kd.Removed)}, {"~", len(kd.Changed)}} {
+			if c.n > 0 {
+				counts = append(counts, fmt.Sprintf("%s%d", c.sign, c.n))
+			}
+		}
+		if len(counts) > 0 {
+			parts = append(parts, kind+" "+strings.Join(counts, " "))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func walk(path string, before, after any, ch *Change) {
+	if ch.Truncated || reflect.DeepEqual(before, after) {
+		return
+	}
+	bm, bIsMap := before.(map[string]any)
+	am, aIsMap := after.(map[string]any)
+	if bIsMap && aIsMap {
+		keys := sortedKeys(bm)
+		for _, k := range sortedKeys(am) {
+			if _, ok := bm[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walk(join(path, k), bm[k], am[k], ch)
+		}
+		return
+	}
+	bl, bIsList := before.([]any)
+	al, aIsList := after.([]any)
+	if bIsList && aIsList && len(bl) == len(al) {
+		for i := range bl {
+			walk(fmt.Sprintf("%s[%d]", path, i), bl[i], al[i], ch)
+		}
+		return
+	}
+	if len(ch.Fields) == maxFieldChanges {
+		ch.Truncated = true
+		return
+	}
+	ch.Fields = append(ch.Fields, FieldChange{Path: path, Before: before, After: after})
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,210 @@
+package configdump
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// HistoryDir keeps, per service, every bootstrap change with its diff.
+var HistoryDir = filepath.Join(models.ElchiLibPath, "bootstrap-history")
+
+// historyKeep is how many entries are kept per service.
+const historyKeep = 20
+
+// Files inside one history entry. entryFile is written last.
+const (
+	entryFile     = "entry.json"
+	bootstrapFile = "bootstrap.yaml"
+)
+
+// Sources of a change.
+const (
+	SourceUpdateBootstrap = "update_bootstrap"
+	SourceDeployUpdate    = "deploy_update"
+)
+
+// ErrNotFound is returned by GetEntry for an unknown entry.
+var ErrNotFound = errors.New("bootstrap history entry not found")
+
+// Entry is one bootstrap change and what it did to the running envoy.
+type Entry struct {
+	ID      string    `json:"id"`
+	Service string    `json:"service"`
+	Source  string    `json:"source"`
+	At      time.Time `json:"at"`
+	Summary string    `json:"summary"`
+	// Settled is false when envoy's config was still changing at the end of
+	// the wait; the diff is then against the last dump seen.
+	Settled bool   `json:"settled"`
+	Diff    Diff   `json:"diff,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Recorder captures the config dump before a change and records the diff
+// after it.
+type Recorder struct {
+	service   string
+	port      uint32
+	source    string
+	before    Snapshot
+	beforeErr error
+	changedAt time.Time
+}
+
+// Begin snapshots the running envoy of service. Call it right before the
+// change; a failure (envoy down) is recorded in the entry, not returned.
+func Begin(ctx context.Context, service string, port uint32, source string) *Recorder {
+	r := &Recorder{service: service, port: port, source: source}
+	if body, err := Fetch(ctx, port); err != nil {
+		r.beforeErr = err
+	} else {
+		r.before, r.beforeErr = Parse(body)
+	}
+	r.changedAt = time.Now()
+	return r
+}
+
+// Finish waits for the changed envoy to settle, diffs it against the
+// snapshot from Begin and stores the entry with a copy of bootstrapPath.
+func (r *Recorder) Finish(ctx context.Context, bootstrapPath string) (*Entry, error) {
+	e := &Entry{ID: r.changedAt.UTC().Format("20060102T150405.000Z"), Service: r.service, Source: r.source, At: r.changedAt}
+	// Without a baseline (envoy was down) there is nothing to wait for.
+	if r.beforeErr != nil {
+		e.Error = "no config dump before the change: " + r.beforeErr.Error()
+	} else if err := r.diff(ctx, e); err != nil {
+		e.Error = err.Error()
+	}
+	if e.Summary == "" {
+		e.Summary = "unknown"
+	}
+	return e, saveEntry(HistoryDir, e, bootstrapPath)
+}
+
+func (r *Recorder) diff(ctx context.Context, e *Entry) error {
+	body, settled, err := Await(ctx, r.port, r.changedAt)
+	if err != nil {
+		return fmt.Errorf("no config dump after the change: %w", err)
+	}
+	after, err := Parse(body)
+	if err != nil {
+		return err
+	}
+	e.Settled = settled
+	e.Diff = Compare(r.before, after)
+	e.Summary = e.Diff.Summary()
+	return nil
+}
+
+func saveEntry(dir string, e *Entry, bootstrapPath string) error {
+	entryDir := filepath.Join(dir, e.Service, e.ID)
+	// Bootstraps may carry inline credentials.
+	if err := os.MkdirAll(entryDir, 0700); err != nil {
+		return fmt.Errorf("failed to create history entry %s: %w", entryDir, err)
+	}
+	if bootstrap, err := os.ReadFile(bootstrapPath); err == nil {
+		if err := os.WriteFile(filepath.Join(entryDir, bootstrapFile), bootstrap, 0600); err != nil {
+			return fmt.Errorf("failed to keep bootstrap in history: %w", err)
+		}
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, entryFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write history entry: %w", err)
+	}
+	return pruneHistory(filepath.Join(dir, e.Service), historyKeep)
+}
+
+func pruneHistory(serviceDir string, keep int) error {
+	entries, err := os.ReadDir(serviceDir)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Strings(ids)
+	for len(ids) > keep {
+		if err := os.RemoveAll(filepath.Join(serviceDir, ids[0])); err != nil {
+			return fmt.Errorf("failed to prune history entry %s: %w", ids[0], err)
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// ListEntries returns the history of service, newest first, without diffs.
+func ListEntries(service string) ([]Entry, error) {
+	return listEntries(HistoryDir, service)
+}
+
+func listEntries(dir, service string) ([]Entry, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, service))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Entry
+	for i := len(entries) - 1; i >= 0; i-- {
+		e, err := readEntry(dir, service, entries[i].Name())
+		if err != nil {
+			continue
+		}
+		e.Diff = nil
+		out = append(out, *e)
+	}
+	return out, nil
+}
+
+// GetEntry returns one entry of service; id "latest" is the newest.
+func GetEntry(service, id string) (*Entry, error) {
+	return getEntry(HistoryDir, service, id)
+}
+
+func getEntry(dir, service, id string) (*Entry, error) {
+	if id == "latest" {
+		list, err := listEntries(dir, service)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return nil, ErrNotFound
+		}
+		id = list[0].ID
+	}
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, ErrNotFound
+	}
+	e, err := readEntry(dir, service, id)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+func readEntry(dir, service, id string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, service, id, entryFile))
+	if err != nil {
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("corrupt history entry %s: %w", id, err)
+	}
+	return &e, nil
+}
@@ -0,0 +1,159 @@
+package crashes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// systemd's ExecMainCode values (siginfo si_code of the main process exit).
+const (
+	codeExited = 1
+	codeKilled = 2
+	codeDumped = 3
+)
+
+// UnitState is the part of `systemctl show` the watcher needs.
+type UnitState struct {
+	NRestarts uint64
+	Result    string
+	// ExitCode is the main process exit status; Signal is set instead when it
+	// was killed by a signal.
+	ExitCode int
+	Signal   int
+	Dumped   bool
+}
+
+// UnitStateProperties are the properties ParseUnitState reads, for
+// `systemctl show -p`.
+var UnitStateProperties = []string{"NRestarts", "Result", "ExecMainCode", "ExecMainStatus"}
+
+// ParseUnitState parses `systemctl show` KEY=VALUE output.
+func ParseUnitState(output string) UnitState {
+	var st UnitState
+	var code, status int
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "NRestarts":
+			st.NRestarts, _ = strconv.ParseUint(value, 10, 64)
+		case "Result":
+			st.Result = value
+		case "ExecMainCode":
+			code, _ = strconv.Atoi(value)
+		case "ExecMainStatus":
+			status, _ = strconv.Atoi(value)
+		}
+	}
+	switch code {
+	case codeKilled, codeDumped:
+		st.Signal = status
+		st.Dumped = code == codeDumped
+	default:
+		st.ExitCode = status
+	}
+	return st
+}
+
+// TailLines returns the last n lines of path, reading at most maxBytes from
+// its end.
+func TailLines(path string, n int, maxBytes int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-maxBytes, 0)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxBytes))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		// Drop the partial first line.
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	data = bytes.TrimRight(data, "\n")
+	lines := bytes.Split(data, []byte("\n"))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append(bytes.Join(lines, []byte("\n")), '\n'), nil
+}
+
+// coreLocation is a place envoy cores end up, depending on core_pattern.
+type coreLocation struct {
+	dir     string
+	pattern string
+	// owned cores are moved into the store; others (systemd-coredump) copied.
+	owned bool
+}
+
+var coreLocations = []coreLocation{
+	{dir: "/var/lib/systemd/coredump", pattern: "core.envoy.*"},
+	// A plain core_pattern writes into the unit's WorkingDirectory.
+	{dir: models.ElchiLibPath, pattern: "core*", owned: true},
+}
+
+// FindCore returns the newest envoy core written after since by one of pids,
+// and whether the client owns it (see Store.Save). Every service shares the
+// core locations, so a core whose pid is unknown or not in pids is never
+// attributed to the crashed service.
+func FindCore(since time.Time, pids map[int]bool) (string, bool) {
+	var (
+		best     string
+		bestTime time.Time
+		owned    bool
+	)
+	for _, loc := range coreLocations {
+		matches, _ := filepath.Glob(filepath.Join(loc.dir, loc.pattern))
+		for _, m := range matches {
+			if pid, ok := corePID(filepath.Base(m)); !ok || !pids[pid] {
+				continue
+			}
+			info, err := os.Stat(m)
+			if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(since) {
+				continue
+			}
+			if info.ModTime().After(bestTime) {
+				best, bestTime, owned = m, info.ModTime(), loc.owned
+			}
+		}
+	}
+	return best, owned
+}
+
+// corePID reads the pid from a core file name: systemd-coredump writes
+// core.<exe>.<uid>.<boot id>.<pid>.<usec>[.<compression>], a core_pattern
+// with %p (or core_uses_pid) core.<pid>.
+func corePID(name string) (int, bool) {
+	parts := strings.Split(name, ".")
+	var field string
+	switch {
+	case len(parts) >= 6:
+		field = parts[4]
+	case len(parts) == 2:
+		field = parts[1]
+	default:
+		return 0, false
+	}
+	pid, err := strconv.Atoi(field)
+	return pid, err == nil && pid > 0
+}
@@ -0,0 +1,266 @@
+package crashes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectorFiresOncePerLoop(t *testing.T) {
+	d := NewDetector(10*time.Minute, 3)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The first reading is a baseline, however high.
+	if fire, _ := d.Observe("web-80", 40, t0); fire {
+		t.Fatal("baseline reading fired")
+	}
+	if fire, n := d.Observe("web-80", 42, t0.Add(time.Minute)); fire || n != 2 {
+		t.Fatalf("2 restarts: fire=%v n=%d", fire, n)
+	}
+	if fire, n := d.Observe("web-80", 43, t0.Add(2*time.Minute)); !fire || n != 3 {
+		t.Fatalf("3 restarts: fire=%v n=%d, want a detection", fire, n)
+	}
+	if fire, _ := d.Observe("web-80", 45, t0.Add(3*time.Minute)); fire {
+		t.Fatal("an ongoing loop fired twice")
+	}
+
+	// A whole window without restarts ends the loop; a new one is reported.
+	if fire, n := d.Observe("web-80", 45, t0.Add(14*time.Minute)); fire || n != 0 {
+		t.Fatalf("quiet window: fire=%v n=%d", fire, n)
+	}
+	if fire, _ := d.Observe("web-80", 48, t0.Add(15*time.Minute)); !fire {
+		t.Fatal("a new loop after a quiet window was not reported")
+	}
+}
+
+func TestDetectorWindowAndReset(t *testing.T) {
+	d := NewDetector(5*time.Minute, 3)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.Observe("web-80", 0, t0)
+	d.Observe("web-80", 2, t0.Add(time.Minute))
+	// The first two restarts age out before the third.
+	if fire, n := d.Observe("web-80", 3, t0.Add(7*time.Minute)); fire || n != 1 {
+		t.Fatalf("spread-out restarts: fire=%v n=%d", fire, n)
+	}
+
+	// A lower count (manual restart) resets the history.
+	d.Observe("web-80", 5, t0.Add(8*time.Minute))
+	if fire, n := d.Observe("web-80", 0, t0.Add(9*time.Minute)); fire || n != 0 {
+		t.Fatalf("after reset: fire=%v n=%d", fire, n)
+	}
+
+	d.Forget(map[string]bool{})
+	if fire, _ := d.Observe("web-80", 10, t0.Add(10*time.Minute)); fire {
+		t.Fatal("a forgotten unit did not start from a new baseline")
+	}
+}
+
+func TestParseUnitState(t *testing.T) {
+	st := ParseUnitState("NRestarts=7\nResult=exit-code\nExecMainCode=1\nExecMainStatus=1\n")
+	if st.NRestarts != 7 || st.Result != "exit-code" || st.ExitCode != 1 || st.Signal != 0 || st.Dumped {
+		t.Errorf("exited: %+v", st)
+	}
+	st = ParseUnitState("NRestarts=3\nResult=core-dump\nExecMainCode=3\nExecMainStatus=11\n")
+	if st.Signal != 11 || !st.Dumped || st.ExitCode != 0 {
+		t.Errorf("dumped: %+v", st)
+	}
+	st = ParseUnitState("ExecMainCode=2\nExecMainStatus=9\n")
+	if st.Signal != 9 || st.Dumped {
+		t.Errorf("killed: %+v", st)
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system.log")
+	var b strings.Builder
+	for i := range 100 {
+		b.WriteString(strings.Repeat("x", 9) + string(rune('a'+i%26)) + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := TailLines(path, 3, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(tail), "\n"); got != 3 {
+		t.Errorf("got %d lines, want 3:\n%s", got, tail)
+	}
+	if !strings.HasSuffix(string(tail), "xxxxxxxxxv\n") {
+		t.Errorf("tail does not end with the last line:\n%s", tail)
+	}
+
+	// With a byte cap the partial first line is dropped.
+	tail, err = TailLines(path, 100, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(tail), "\n"), "\n") {
+		if len(line) != 10 {
+			t.Errorf("partial line %q in capped tail", line)
+		}
+	}
+}
+
+func saveRecord(t *testing.T, s *Store, service string, at time.Time, logTail string) *Record {
+	t.Helper()
+	rec := &Record{ID: NewID(service, at), Service: service, DetectedAt: at}
+	if err := s.Save(rec, []byte(logTail), "", false); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestStoreSaveListGet(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir, 0, 0)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	saveRecord(t, s, "web-80", t0, "boom\n")
+	saveRecord(t, s, "api-8080", t0.Add(time.Minute), "")
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Service != "api-8080" {
+		t.Fatalf("list = %+v, want 2 captures newest first", list)
+	}
+
+	rec, tail, err := s.Get(NewID("web-80", t0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Service != "web-80" || string(tail) != "boom\n" {
+		t.Errorf("get: %+v %q", rec, tail)
+	}
+	info, err := os.Stat(filepath.Join(dir, rec.ID, logFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("log tail mode = %v, %v", info.Mode().Perm(), err)
+	}
+
+	for _, id := range []string{"", "..", "../etc", ".hidden", "nope"} {
+		if _, _, err := s.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func TestStorePrunes(t *testing.T) {
+	dir := t.TempDir()
+	// An interrupted capture is dropped on the next save.
+	if err := os.MkdirAll(filepath.Join(dir, "20250101T000000Z-old-80"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(dir, 2, 0)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		saveRecord(t, s, "web-80", t0.Add(time.Duration(i)*time.Minute), "")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("%d entries after pruning to 2", len(entries))
+	}
+	if entries[0].Name() != NewID("web-80", t0.Add(2*time.Minute)) {
+		t.Errorf("kept %s, want the newest two", entries[0].Name())
+	}
+
+	// The byte bound keeps only what fits, but never the capture just written.
+	s = NewStore(dir, 0, 100)
+	saveRecord(t, s, "web-80", t0.Add(time.Hour), strings.Repeat("x", 500))
+	list, _ := s.List()
+	if len(list) != 1 || list[0].ID != NewID("web-80", t0.Add(time.Hour)) {
+		t.Errorf("after byte pruning: %+v", list)
+	}
+}
+
+func TestStoreCores(t *testing.T) {
+	src := t.TempDir()
+	owned := filepath.Join(src, "core.1234")
+	foreign := filepath.Join(src, "core.envoy.5678")
+	for _, p := range []string{owned, foreign} {
+		if err := os.WriteFile(p, []byte("core"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewStore(t.TempDir(), 0, 0)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rec := &Record{ID: NewID("web-80", t0), Service: "web-80"}
+	if err := s.Save(rec, nil, owned, true); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Core != "core.1234" || rec.CoreBytes != 4 {
+		t.Errorf("moved core: %+v", rec)
+	}
+	if _, err := os.Stat(owned); !os.IsNotExist(err) {
+		t.Error("owned core was not moved")
+	}
+
+	rec = &Record{ID: NewID("web-80", t0.Add(time.Minute)), Service: "web-80"}
+	if err := s.Save(rec, nil, foreign, false); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Core != "core.envoy.5678" {
+		t.Errorf("copied core: %+v", rec)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Error("systemd-coredump core was removed")
+	}
+
+	// A core over half the budget stays where it is.
+	s = NewStore(t.TempDir(), 0, 6)
+	rec = &Record{ID: NewID("web-80", t0), Service: "web-80"}
+	if err := s.Save(rec, nil, foreign, false); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Core != "" || !strings.Contains(rec.CoreNote, "over half the store budget") {
+		t.Errorf("oversize core: %+v", rec)
+	}
+}
+
+func TestFindCore(t *testing.T) {
+	coredump, workdir := t.TempDir(), t.TempDir()
+	saved := coreLocations
+	coreLocations = []coreLocation{
+		{dir: coredump, pattern: "core.envoy.*"},
+		{dir: workdir, pattern: "core*", owned: true},
+	}
+	defer func() { coreLocations = saved }()
+
+	now := time.Now()
+	write := func(path string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("core"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(coredump, "core.envoy.998.0123abcd.1.1700000000000000.zst"), now.Add(-time.Hour))
+	write(filepath.Join(coredump, "core.envoy.998.0123abcd.2.1700000000000000.zst"), now)
+	write(filepath.Join(workdir, "core.3"), now.Add(-time.Minute))
+	write(filepath.Join(workdir, "core"), now)
+
+	since := now.Add(-10 * time.Minute)
+	path, owned := FindCore(since, map[int]bool{1: true, 3: true})
+	if path != filepath.Join(workdir, "core.3") || !owned {
+		t.Errorf("FindCore = %s, %v", path, owned)
+	}
+	// The newest core belongs to pid 2 only.
+	if path, owned := FindCore(since, map[int]bool{2: true}); path != filepath.Join(coredump, "core.envoy.998.0123abcd.2.1700000000000000.zst") || owned {
+		t.Errorf("FindCore = %s, %v", path, owned)
+	}
+	if path, _ := FindCore(since, nil); path != "" {
+		t.Errorf("FindCore attributed %s without a pid", path)
+	}
+	if path, _ := FindCore(now.Add(time.Minute), map[int]bool{2: true, 3: true}); path != "" {
+		t.Errorf("FindCore found %s, want nothing that recent", path)
+	}
+}
@@ -0,0 +1,71 @@
+package crashes
+
+import "time"
+
+// Detector turns periodic NRestarts readings into crash-loop detections.
+type Detector struct {
+	window    time.Duration
+	threshold int
+
+	units map[string]*unitHistory
+}
+
+type unitHistory struct {
+	lastCount uint64
+	restarts  []time.Time
+	looping   bool
+}
+
+// NewDetector reports a crash loop once a unit restarts threshold times within
+// window.
+func NewDetector(window time.Duration, threshold int) *Detector {
+	return &Detector{window: window, threshold: max(threshold, 1), units: make(map[string]*unitHistory)}
+}
+
+// Observe records a unit's NRestarts at now. It returns true once per loop:
+// when the restarts within the window first reach the threshold. The loop ends
+// (and can be reported again) when the unit stays up for a whole window. The
+// first reading of a unit is the baseline; a smaller count than before means
+// someone restarted the unit by hand, which resets its history.
+func (d *Detector) Observe(unit string, nRestarts uint64, now time.Time) (bool, int) {
+	h, ok := d.units[unit]
+	if !ok {
+		d.units[unit] = &unitHistory{lastCount: nRestarts}
+		return false, 0
+	}
+	if nRestarts < h.lastCount {
+		h.restarts, h.looping = nil, false
+	} else {
+		for range nRestarts - h.lastCount {
+			h.restarts = append(h.restarts, now)
+		}
+	}
+	h.lastCount = nRestarts
+
+	cutoff := now.Add(-d.window)
+	for len(h.restarts) > 0 && h.restarts[0].Before(cutoff) {
+		h.restarts = h.restarts[1:]
+	}
+
+	n := len(h.restarts)
+	if n < d.threshold {
+		if n == 0 {
+			h.looping = false
+		}
+		return false, n
+	}
+	if h.looping {
+		return false, n
+	}
+	h.looping = true
+	return true, n
+}
+
+// Forget drops units not in keep (undeployed services).
+func (d *Detector) Forget(keep map[string]bool) {
+	for unit := range d.units {
+		if !keep[unit] {
+			delete(d.units, unit)
+		}
+	}
+}
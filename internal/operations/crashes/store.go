@@ -0,0 +1,271 @@
+// Package crashes keeps forensic captures of envoy crash loops: the unit's
+// exit status, the tail of the envoy system log and the core file, in a
+// bounded on-disk store. Detection lives in Detector; the caller polls systemd.
+package crashes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// DefaultDir is where crash captures are kept.
+var DefaultDir = filepath.Join(models.ElchiLibPath, "crashes")
+
+// Files inside one capture directory. recordFile is written last, so a
+// directory without it is an interrupted capture.
+const (
+	recordFile = "crash.json"
+	logFile    = "system.log"
+)
+
+// ErrNotFound is returned by Get for an unknown capture ID.
+var ErrNotFound = errors.New("crash capture not found")
+
+// Record describes one captured crash loop.
+type Record struct {
+	ID               string    `json:"id"`
+	Service          string    `json:"service"`
+	DetectedAt       time.Time `json:"detected_at"`
+	Restarts         uint64    `json:"restarts"`
+	RestartsInWindow int       `json:"restarts_in_window"`
+	Window           string    `json:"window"`
+	// Result, ExitCode and Signal are systemd's view of the unit's last main
+	// process exit (the supervisor for envoy units).
+	Result   string `json:"result,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Signal   int    `json:"signal,omitempty"`
+	// EnvoyExitCode and EnvoySignal come from the native hot restarter, which
+	// sees the envoy child itself; they are unset under hotrestarter.py.
+	EnvoyExitCode *int   `json:"envoy_exit_code,omitempty"`
+	EnvoySignal   int    `json:"envoy_signal,omitempty"`
+	LogSource     string `json:"log_source,omitempty"`
+	LogLines      int    `json:"log_lines"`
+	Core          string `json:"core,omitempty"`
+	CoreSource    string `json:"core_source,omitempty"`
+	CoreBytes     int64  `json:"core_bytes,omitempty"`
+	CoreNote      string `json:"core_note,omitempty"`
+}
+
+// Store is a directory of captures, one subdirectory per Record, bounded by
+// entry count and total size.
+type Store struct {
+	dir        string
+	maxEntries int
+	maxBytes   int64
+}
+
+// NewStore returns a store in dir. maxEntries and maxBytes <= 0 leave that
+// bound off (fine for a read-only store).
+func NewStore(dir string, maxEntries int, maxBytes int64) *Store {
+	return &Store{dir: dir, maxEntries: maxEntries, maxBytes: maxBytes}
+}
+
+// NewID returns the capture ID for service at t; IDs sort chronologically.
+func NewID(service string, t time.Time) string {
+	return t.UTC().Format("20060102T150405Z") + "-" + service
+}
+
+// Save writes rec with its log tail and, when core is not "", the core file.
+// A core is moved when move is set (it would otherwise pile up in the envoy
+// working directory) and copied otherwise (systemd-coredump owns its files).
+// Cores larger than half the store budget are not kept. Older captures are
+// pruned afterwards to stay within the bounds.
+func (s *Store) Save(rec *Record, logTail []byte, core string, move bool) error {
+	entry := filepath.Join(s.dir, rec.ID)
+	// Cores and logs may hold request data: keep them owner-only.
+	if err := os.MkdirAll(entry, 0700); err != nil {
+		return fmt.Errorf("failed to create crash capture %s: %w", entry, err)
+	}
+
+	if len(logTail) > 0 {
+		if err := os.WriteFile(filepath.Join(entry, logFile), logTail, 0600); err != nil {
+			return fmt.Errorf("failed to write crash log tail: %w", err)
+		}
+	}
+
+	if core != "" {
+		rec.CoreSource = core
+		if err := s.keepCore(rec, entry, core, move); err != nil {
+			rec.CoreNote = err.Error()
+		}
+	}
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(entry, recordFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write crash record: %w", err)
+	}
+	return s.prune(rec.ID)
+}
+
+func (s *Store) keepCore(rec *Record, entry, core string, move bool) error {
+	info, err := os.Stat(core)
+	if err != nil {
+		return fmt.Errorf("core %s vanished: %w", core, err)
+	}
+	if s.maxBytes > 0 && info.Size() > s.maxBytes/2 {
+		return fmt.Errorf("core %s is %d bytes, over half the store budget; left in place", core, info.Size())
+	}
+	dst := filepath.Join(entry, filepath.Base(core))
+	if move {
+		if err := os.Rename(core, dst); err == nil {
+			rec.Core, rec.CoreBytes = filepath.Base(core), info.Size()
+			return nil
+		}
+	}
+	if err := copyFile(core, dst); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to copy core %s: %w", core, err)
+	}
+	if move {
+		os.Remove(core)
+	}
+	rec.Core, rec.CoreBytes = filepath.Base(core), info.Size()
+	return nil
+}
+
+// List returns every complete capture, newest first.
+func (s *Store) List() ([]Record, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Record
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		rec, err := s.readRecord(e.Name())
+		if err != nil {
+			continue
+		}
+		out = append(out, *rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out, nil
+}
+
+// Get returns one capture and its log tail.
+func (s *Store) Get(id string) (*Record, []byte, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, nil, ErrNotFound
+	}
+	rec, err := s.readRecord(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	tail, err := os.ReadFile(filepath.Join(s.dir, id, logFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return rec, tail, nil
+}
+
+func (s *Store) readRecord(id string) (*Record, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, id, recordFile))
+	if err != nil {
+		return nil, err
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("corrupt crash record %s: %w", id, err)
+	}
+	return &rec, nil
+}
+
+// prune drops interrupted captures and then the oldest complete ones until the
+// store is within bounds. keep (the capture just written) is never removed.
+func (s *Store) prune(keep string) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	type capture struct {
+		id   string
+		size int64
+	}
+	var complete []capture
+	var total int64
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, e.Name())
+		if _, err := os.Stat(filepath.Join(path, recordFile)); err != nil {
+			if e.Name() != keep {
+				os.RemoveAll(path)
+			}
+			continue
+		}
+		size := dirSize(path)
+		total += size
+		complete = append(complete, capture{id: e.Name(), size: size})
+	}
+	sort.Slice(complete, func(i, j int) bool { return complete[i].id < complete[j].id })
+
+	for len(complete) > 0 {
+		overCount := s.maxEntries > 0 && len(complete) > s.maxEntries
+		overSize := s.maxBytes > 0 && total > s.maxBytes
+		if !overCount && !overSize {
+			break
+		}
+		oldest := complete[0]
+		if oldest.id == keep {
+			break
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, oldest.id)); err != nil {
+			return fmt.Errorf("failed to prune crash capture %s: %w", oldest.id, err)
+		}
+		total -= oldest.size
+		complete = complete[1:]
+	}
+	return nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
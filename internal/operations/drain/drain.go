@@ -0,0 +1,178 @@
+// Package drain takes a deployment's listeners out of service through the
+// envoy admin and waits for its downstream connections to go away, so a unit
+// can be stopped without cutting live traffic.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/proxy"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// Strategies; they match the drain.strategy config values.
+const (
+	Graceful        = "graceful"
+	HealthcheckFail = "healthcheck_fail"
+	None            = "none"
+)
+
+// Options tune a drain.
+type Options struct {
+	Strategy  string
+	Timeout   time.Duration
+	Threshold int
+	// OnRestart drains before control-plane restarts too.
+	OnRestart bool
+}
+
+var (
+	optionsMu sync.RWMutex
+	options   = Options{Strategy: Graceful, Timeout: 30 * time.Second}
+)
+
+// pollInterval is how often active connections are read while waiting.
+var pollInterval = time.Second
+
+// Set replaces the process-wide drain options.
+func Set(opts Options) {
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+	options = opts
+}
+
+// Current returns the options set by Set.
+func Current() Options {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+	return options
+}
+
+// Result is the outcome of one drain.
+type Result struct {
+	Strategy string
+	// Initial and Remaining are the active downstream connections before the
+	// drain and when the wait ended.
+	Initial   int
+	Remaining int
+	Waited    time.Duration
+	// Drained is true when Remaining reached the threshold before the timeout.
+	Drained bool
+	// Error is set when the admin could not be reached or refused the drain;
+	// the caller stops the unit anyway.
+	Error string
+}
+
+func (r Result) String() string {
+	switch {
+	case r.Strategy == None:
+		return "drain skipped (strategy none)"
+	case r.Error != "":
+		return fmt.Sprintf("drain (%s) failed: %s", r.Strategy, r.Error)
+	case r.Drained:
+		return fmt.Sprintf("drained (%s): %d -> %d active connections in %s", r.Strategy, r.Initial, r.Remaining, r.Waited.Round(time.Second))
+	default:
+		return fmt.Sprintf("drain (%s) timed out after %s: %d of %d active connections left", r.Strategy, r.Waited.Round(time.Second), r.Remaining, r.Initial)
+	}
+}
+
+// Run drains the envoy on port with opts and waits until its active
+// downstream connections are at or below opts.Threshold, or opts.Timeout
+// passes. It never fails: an unreachable envoy has nothing to drain.
+func Run(ctx context.Context, port uint32, opts Options) Result {
+	res := Result{Strategy: opts.Strategy}
+	if res.Strategy == "" {
+		res.Strategy = Graceful
+	}
+	if res.Strategy == None {
+		return res
+	}
+
+	start := time.Now()
+	initial, err := ActiveConnections(ctx, port)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Initial, res.Remaining = initial, initial
+
+	path, query := "/drain_listeners", map[string]string{"graceful": ""}
+	if res.Strategy == HealthcheckFail {
+		path, query = "/healthcheck/fail", nil
+	}
+	// These are disruptive endpoints the control plane may not call; this is
+	// the client's own use.
+	resp, err := proxy.Do(ctx, &client.RequestEnvoyAdmin{Port: port, Method: client.HttpMethod_POST, Path: path, Queries: query})
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if resp.StatusCode != http.StatusOK {
+		res.Error = fmt.Sprintf("%s returned %d", path, resp.StatusCode)
+		return res
+	}
+
+	deadline := time.NewTimer(opts.Timeout)
+	defer deadline.Stop()
+	for res.Remaining > opts.Threshold {
+		select {
+		case <-ctx.Done():
+			res.Waited = time.Since(start)
+			return res
+		case <-deadline.C:
+			res.Waited = time.Since(start)
+			return res
+		case <-time.After(pollInterval):
+		}
+		n, err := ActiveConnections(ctx, port)
+		if err != nil {
+			// Envoy closed its admin (e.g. it exited): nothing left to drain.
+			res.Remaining = 0
+			break
+		}
+		res.Remaining = n
+	}
+	res.Waited = time.Since(start)
+	res.Drained = true
+	return res
+}
+
+// ActiveConnections sums downstream_cx_active over the envoy's listeners,
+// leaving out the admin listener (this request's own connection) and the
+// per-worker copies of each listener stat.
+func ActiveConnections(ctx context.Context, port uint32) (int, error) {
+	resp, err := proxy.Do(ctx, &client.RequestEnvoyAdmin{
+		Port:    port,
+		Method:  client.HttpMethod_GET,
+		Path:    "/stats",
+		Queries: map[string]string{"filter": `^listener\..*downstream_cx_active$`},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("stats returned %d", resp.StatusCode)
+	}
+	return parseActive(resp.Body), nil
+}
+
+func parseActive(body string) int {
+	total := 0
+	for _, line := range strings.Split(body, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(name, "listener.") || !strings.HasSuffix(name, ".downstream_cx_active") ||
+			strings.HasPrefix(name, "listener.admin.") || strings.Contains(name, ".worker_") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			total += n
+		}
+	}
+	return total
+}
@@ -0,0 +1,112 @@
+package drain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseActive(t *testing.T) {
+	body := `listener.0.0.0.0_443.downstream_cx_active: 5
+listener.0.0.0.0_443.worker_0.downstream_cx_active: 3
+listener.0.0.0.0_443.worker_1.downstream_cx_active: 2
+listener.ingress_http.downstream_cx_active: 4
+listener.admin.downstream_cx_active: 1
+listener.0.0.0.0_443.downstream_cx_total: 900
+`
+	if got := parseActive(body); got != 9 {
+		t.Errorf("parseActive = %d, want 9", got)
+	}
+}
+
+// fakeEnvoy reports active connections that fall by one per stats read once
+// a drain has been requested.
+func fakeEnvoy(t *testing.T, start int, drainPath *atomic.Value) uint32 {
+	t.Helper()
+	var active atomic.Int32
+	active.Store(int32(start))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stats":
+			n := active.Load()
+			if drainPath.Load() != nil && n > 0 {
+				active.Add(-1)
+			}
+			fmt.Fprintf(w, "listener.0.0.0.0_443.downstream_cx_active: %d\nlistener.admin.downstream_cx_active: 1\n", n)
+		case "/drain_listeners", "/healthcheck/fail":
+			if r.Method != http.MethodPost {
+				http.Error(w, "method", http.StatusMethodNotAllowed)
+				return
+			}
+			drainPath.Store(r.URL.RequestURI())
+			w.Write([]byte("OK\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return uint32(port)
+}
+
+func TestRunWaitsForConnections(t *testing.T) {
+	saved := pollInterval
+	pollInterval = 5 * time.Millisecond
+	defer func() { pollInterval = saved }()
+
+	var called atomic.Value
+	port := fakeEnvoy(t, 4, &called)
+	res := Run(context.Background(), port, Options{Strategy: Graceful, Timeout: 5 * time.Second, Threshold: 1})
+	if !res.Drained || res.Initial != 4 || res.Remaining != 1 || res.Error != "" {
+		t.Fatalf("result = %+v", res)
+	}
+	if uri, _ := called.Load().(string); !strings.HasPrefix(uri, "/drain_listeners?graceful") {
+		t.Errorf("drain request = %q", uri)
+	}
+	if !strings.HasPrefix(res.String(), "drained (graceful): 4 -> 1 active connections") {
+		t.Errorf("String() = %q", res)
+	}
+}
+
+func TestRunHealthcheckFailTimesOut(t *testing.T) {
+	saved := pollInterval
+	pollInterval = 5 * time.Millisecond
+	defer func() { pollInterval = saved }()
+
+	var called atomic.Value
+	port := fakeEnvoy(t, 1000, &called)
+	res := Run(context.Background(), port, Options{Strategy: HealthcheckFail, Timeout: 50 * time.Millisecond})
+	if res.Drained || res.Remaining == 0 || res.Remaining >= 1000 {
+		t.Fatalf("result = %+v, want a timeout with connections left", res)
+	}
+	if uri, _ := called.Load().(string); uri != "/healthcheck/fail" {
+		t.Errorf("drain request = %q", uri)
+	}
+	if !strings.HasPrefix(res.String(), "drain (healthcheck_fail) timed out") {
+		t.Errorf("String() = %q", res)
+	}
+}
+
+func TestRunWithoutEnvoy(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	srv.Close()
+
+	res := Run(context.Background(), uint32(port), Options{Strategy: Graceful, Timeout: time.Minute})
+	if res.Error == "" || res.Drained {
+		t.Errorf("result = %+v, want an error", res)
+	}
+
+	if res := Run(context.Background(), uint32(port), Options{Strategy: None}); res.Error != "" || res.String() != "drain skipped (strategy none)" {
+		t.Errorf("strategy none = %+v", res)
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 type Downloader struct {
 	httpClient *http.Client
+	partialDir string
 	logger     *logrus.Entry
 }
 
@@ -23,24 +24,21 @@ func NewDownloader() *Downloader {
 		httpClient: &http.Client{
 			Timeout: time.Duration(DownloadTimeout) * time.Second,
 		},
-		logger: logrus.WithField("component", "envoy-downloader"),
+		partialDir: common.DownloadCacheDir,
+		logger:     logrus.WithField("component", "envoy-downloader"),
 	}
 }
 
 // GetAvailableVersions fetches available versions from archive API
 func (d *Downloader) GetAvailableVersions(ctx context.Context) (*ArchiveResponse, error) {
-	d.logger.WithField("url", ArchiveURL).Info("Fetching available versions")
+	source := common.CurrentArtifactSource()
+	d.logger.WithField("url", source.IndexURL()).Info("Fetching available versions")
 
 	// Create a child context with timeout, respecting parent cancellation
 	reqCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(reqCtx, "GET", ArchiveURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := d.httpClient.Do(req)
+	body, err := source.Open(reqCtx, d.httpClient, source.IndexURL())
 	if err != nil {
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
@@ -48,14 +46,10 @@ func (d *Downloader) GetAvailableVersions(ctx context.Context) (*ArchiveResponse
 		d.logger.WithError(err).Error("Failed to fetch archive index")
 		return nil, fmt.Errorf("failed to fetch archive index: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("archive API returned status %d", resp.StatusCode)
-	}
+	defer body.Close()
 
 	var archiveResp ArchiveResponse
-	if err := json.NewDecoder(resp.Body).Decode(&archiveResp); err != nil {
+	if err := json.NewDecoder(body).Decode(&archiveResp); err != nil {
 		d.logger.WithError(err).Error("Failed to decode archive response")
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
@@ -104,29 +98,36 @@ func (d *Downloader) DownloadBinary(ctx context.Context, version string, destPat
 		return fmt.Errorf("binary for architecture %s not found for version %s", arch, version)
 	}
 
-	// Create temporary file
-	tempFile, err := os.CreateTemp("", "envoy-download-*")
+	// Index entries may be relative to the index (mirrors, file:// trees)
+	if targetBinary.DownloadURL, err = common.CurrentArtifactSource().Resolve(targetBinary.DownloadURL); err != nil {
+		return err
+	}
+
+	// Download into a content-addressed partial file so an interrupted
+	// transfer resumes on the next attempt instead of starting from zero
+	partial, err := common.PartialDownloadPath(d.partialDir, targetBinary.SHA256)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return err
+	}
+	if err := common.DownloadResumable(ctx, d.logger, d.httpClient, targetBinary.DownloadURL, partial, time.Duration(DownloadTimeout)*time.Second); err != nil {
+		return err
 	}
-	defer func() {
-		tempFile.Close()
-		os.Remove(tempFile.Name())
-	}()
 
-	// Download binary
-	if err := d.downloadFile(ctx, targetBinary.DownloadURL, tempFile); err != nil {
+	// Verify checksum; a mismatching partial can never complete, so drop it
+	if err := common.VerifyChecksum(d.logger, partial, targetBinary.SHA256); err != nil {
+		os.Remove(partial)
 		return err
 	}
 
-	// Verify checksum
-	if err := common.VerifyChecksum(d.logger, tempFile.Name(), targetBinary.SHA256); err != nil {
+	// Verify signature against the pinned keys; the checksum alone only proves
+	// the file matches the index it came from
+	if err := common.VerifyArchiveSignature(ctx, d.logger, d.httpClient, targetBinary); err != nil {
+		os.Remove(partial)
 		return err
 	}
 
 	// Move to destination (handle cross-device links)
-	tempFile.Close()
-	if err := common.MoveFile(d.logger, tempFile.Name(), destPath); err != nil {
+	if err := common.MoveFile(d.logger, partial, destPath); err != nil {
 		return fmt.Errorf("failed to move binary to destination: %w", err)
 	}
 
@@ -134,41 +135,25 @@ func (d *Downloader) DownloadBinary(ctx context.Context, version string, destPat
 	return nil
 }
 
-// downloadFile downloads a file from URL to the given file handle with context-aware cancellation
-func (d *Downloader) downloadFile(ctx context.Context, url string, dest *os.File) error {
-	d.logger.WithField("url", url).Debug("Downloading file")
-
-	// Create a child context with timeout for the download
-	downloadCtx, cancel := context.WithTimeout(ctx, time.Duration(DownloadTimeout)*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(downloadCtx, "GET", url, nil)
+// InstallableVersions lists the index versions that have a binary for the
+// current architecture
+func (d *Downloader) InstallableVersions(ctx context.Context) ([]string, error) {
+	archiveResp, err := d.GetAvailableVersions(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create download request: %w", err)
+		return nil, err
 	}
 
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		if ctx.Err() != nil {
-			return ctx.Err()
+	arch := d.getCurrentArch()
+	versions := make([]string, 0)
+	for _, release := range archiveResp.Releases {
+		for _, binary := range release.Binaries {
+			if binary.Arch == arch {
+				versions = append(versions, release.Version)
+				break
+			}
 		}
-		d.logger.WithError(err).Error("Failed to download file")
-		return fmt.Errorf("failed to download file: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
-	}
-
-	// Context-aware copy instead of io.Copy
-	written, err := common.CopyWithContext(ctx, dest, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to save file: %w", err)
-	}
-
-	d.logger.WithField("bytes", written).Debug("File download completed")
-	return nil
+	return versions, nil
 }
 
 // getCurrentArch returns the current architecture in the format expected by the archive
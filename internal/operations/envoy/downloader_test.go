@@ -0,0 +1,67 @@
+package envoy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
+)
+
+// An air-gapped index: a file:// index whose download_url is relative to it.
+func TestDownloadBinaryFromFileIndex(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDownloader()
+	d.partialDir = t.TempDir()
+	binary := []byte("#!/bin/sh\necho envoy\n")
+	digest := sha256.Sum256(binary)
+
+	if err := os.MkdirAll(filepath.Join(dir, "v1.34.2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "v1.34.2", "envoy"), binary, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	index, _ := json.Marshal(ArchiveResponse{Releases: []common.ArchiveRelease{{
+		Version: "v1.34.2",
+		Binaries: []common.ArchiveBinary{{
+			Arch:        d.getCurrentArch(),
+			DownloadURL: "v1.34.2/envoy",
+			SHA256:      hex.EncodeToString(digest[:]),
+		}},
+	}}})
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := common.NewArtifactSource("file://"+filepath.Join(dir, "index.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevSource, prevPolicy := common.CurrentArtifactSource(), common.CurrentSignaturePolicy()
+	unsigned, _ := common.NewSignaturePolicy(nil, true)
+	common.SetArtifactSource(source)
+	common.SetSignaturePolicy(unsigned)
+	t.Cleanup(func() {
+		common.SetArtifactSource(prevSource)
+		common.SetSignaturePolicy(prevPolicy)
+	})
+
+	versions, err := d.InstallableVersions(context.Background())
+	if err != nil || len(versions) != 1 || versions[0] != "v1.34.2" {
+		t.Fatalf("InstallableVersions = %v, %v", versions, err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "envoy")
+	if err := d.DownloadBinary(context.Background(), "v1.34.2", dest); err != nil {
+		t.Fatalf("DownloadBinary: %v", err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || string(got) != string(binary) {
+		t.Errorf("installed binary = %q, %v", got, err)
+	}
+}
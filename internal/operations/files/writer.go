@@ -33,12 +33,13 @@ func WriteBootstrapFile(filename string, content []byte) (string, error) {
 }
 
 func WriteDummyNetplanFile(ifaceName, downstreamAddress string, port uint32) (string, error) {
-	ipv4CIDR, err := tools.GetIPv4CIDR(downstreamAddress)
+	cidrs, err := tools.GetHostCIDRs(downstreamAddress)
 	if err != nil {
 		return "", fmt.Errorf("invalid IP address format: %w", err)
 	}
 
-	networkContent := fmt.Sprintf(template.DummyNetPlan, ifaceName, ipv4CIDR)
+	// The template holds one list item; a dual-stack address continues it.
+	networkContent := fmt.Sprintf(template.DummyNetPlan, ifaceName, strings.Join(cidrs, "\n        - "))
 	networkPath := filepath.Join(models.NetplanPath, fmt.Sprintf("90-%s.yaml", ifaceName))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -61,7 +62,7 @@ func WriteDummyNetplanFile(ifaceName, downstreamAddress string, port uint32) (st
 
 func WriteSystemdServiceFile(filename, name, version string, port uint32) (string, error) {
 	path := filepath.Join(models.SystemdPath, filename+".service")
-	content := fmt.Sprintf(template.SystemdTemplate,
+	content := fmt.Sprintf(template.UnitTemplate(),
 		name,     // Description (%s)
 		version,  // ExecStartPre envoy path (%s)
 		filename, // ExecStartPre bootstrap (%s)
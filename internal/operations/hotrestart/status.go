@@ -0,0 +1,137 @@
+package hotrestart
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// Supervisor states recorded in the status file.
+const (
+	StateRunning = "running"
+	StateStopped = "stopped"
+)
+
+// Status is the on-disk view of a supervisor. CurrentEpoch is the epoch of the
+// newest child; while a hot restart is draining, Children holds both the old
+// and the new epoch.
+type Status struct {
+	BaseID        string        `json:"base_id"`
+	State         string        `json:"state"`
+	SupervisorPID int           `json:"supervisor_pid"`
+	CurrentEpoch  int           `json:"current_epoch"`
+	Children      []ChildStatus `json:"children"`
+	Command       []string      `json:"command"`
+	StartedAt     time.Time     `json:"started_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	// LastExit is the most recent abnormal child exit. It is carried over
+	// when systemd restarts the supervisor, so a crash stays visible after the
+	// unit came back.
+	LastExit *ExitStatus `json:"last_exit,omitempty"`
+}
+
+// ExitStatus describes how an envoy child ended.
+type ExitStatus struct {
+	PID    int       `json:"pid"`
+	Epoch  int       `json:"epoch"`
+	Code   int       `json:"code"`
+	Signal int       `json:"signal,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// ChildStatus describes one live envoy process.
+type ChildStatus struct {
+	PID       int       `json:"pid"`
+	Epoch     int       `json:"epoch"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// StatusPath returns the status file for a base-id. It lives next to
+// hotrestarter.py, which is inside the unit's ReadWritePaths.
+func StatusPath(baseID string) string {
+	return filepath.Join(models.ElchiLibPath, "hotrestarter", baseID+".status.json")
+}
+
+// NextCommandPath returns the file an upgrade stages the next epoch's command
+// in (see StageNextCommand).
+func NextCommandPath(baseID string) string {
+	return filepath.Join(models.ElchiLibPath, "hotrestarter", baseID+".next")
+}
+
+// StageNextCommand makes the next SIGHUP start its epoch with args instead of
+// the supervisor's current command, which is how a blue/green upgrade brings a
+// different envoy binary up as a new epoch. args must keep the same --base-id.
+func StageNextCommand(baseID string, args []string) error {
+	path := NextCommandPath(baseID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(args, " ")+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// ReadStatus loads the status file written by the supervisor for baseID.
+func ReadStatus(baseID string) (*Status, error) {
+	return readStatus(StatusPath(baseID))
+}
+
+func readStatus(path string) (*Status, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st Status
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse hot-restart status %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// writeStatus snapshots the supervisor state to disk. It writes a sibling temp
+// file and renames it over the old one so readers never see a torn file.
+// Failures are logged only: a missing status file must never take envoy down.
+func (s *Supervisor) writeStatus(state string) {
+	st := Status{
+		BaseID:        s.baseID,
+		State:         state,
+		SupervisorPID: os.Getpid(),
+		CurrentEpoch:  s.nextEpoch - 1,
+		Children:      s.childStatuses(),
+		Command:       s.args,
+		StartedAt:     s.startedAt,
+		UpdatedAt:     time.Now(),
+		LastExit:      s.lastExit,
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		s.logger.Warnf("failed to encode hot-restart status: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statusPath), 0755); err != nil {
+		s.logger.Warnf("failed to create hot-restart status directory: %v", err)
+		return
+	}
+	tmp := s.statusPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		s.logger.Warnf("failed to write hot-restart status %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, s.statusPath); err != nil {
+		os.Remove(tmp)
+		s.logger.Warnf("failed to publish hot-restart status %s: %v", s.statusPath, err)
+	}
+}
@@ -0,0 +1,361 @@
+// Package hotrestart is a native Go implementation of Envoy's hot-restart
+// wrapper. It speaks the same epoch/base-id protocol as hotrestarter.py (see
+// template.PythonWrapper) so a unit can switch between the two by changing only
+// the ExecStart prefix:
+//
+//   - SIGHUP forks a new envoy child with the next --restart-epoch for the
+//     command's --base-id; the previous epoch drains and exits on its own.
+//   - SIGTERM/SIGINT are propagated to every child; stragglers are SIGKILLed
+//     after TermWait.
+//   - SIGUSR1 is propagated (envoy reopens its access logs).
+//   - Children are reaped as they exit. A non-zero or signalled exit tears the
+//     whole group down and exits non-zero so systemd's Restart=on-failure kicks
+//     in; the last child exiting cleanly ends the supervisor with status 0.
+//
+// Unlike the Python wrapper, the current epoch and live child PIDs are written
+// to a status file (see StatusPath) after every change, so the state of a hot
+// restart is observable without walking /proc. A command staged with
+// StageNextCommand replaces the envoy command for the next SIGHUP, which lets
+// an upgrade start a different binary as a new epoch.
+package hotrestart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+// DefaultTermWait matches TERM_WAIT_SECONDS in hotrestarter.py. It must stay
+// below systemd's TimeoutStopSec, otherwise systemd SIGKILLs the supervisor
+// before it gets to clean up its children.
+const DefaultTermWait = 30 * time.Second
+
+// Supervisor runs one envoy command under the hot-restart protocol.
+type Supervisor struct {
+	args        []string
+	baseID      string
+	statusPath  string
+	commandPath string
+	termWait    time.Duration
+	logger      *logger.Logger
+
+	// Owned by the Run goroutine; no locking needed.
+	nextEpoch int
+	children  map[int]*child
+	exits     chan childExit
+	startedAt time.Time
+	lastExit  *ExitStatus
+}
+
+type child struct {
+	cmd       *exec.Cmd
+	epoch     int
+	startedAt time.Time
+}
+
+type childExit struct {
+	pid   int
+	epoch int
+	err   error
+}
+
+// NewSupervisor parses the envoy command line (a single string, exactly as the
+// unit passes it to hotrestarter.py) and prepares a supervisor for it. The
+// command must carry --base-id: the epoch counter is scoped to it and envoy
+// needs it to find the previous epoch's shared memory.
+func NewSupervisor(commandLine string, logger *logger.Logger) (*Supervisor, error) {
+	args, baseID, err := parseCommand(commandLine)
+	if err != nil {
+		return nil, err
+	}
+	return &Supervisor{
+		args:        args,
+		baseID:      baseID,
+		statusPath:  StatusPath(baseID),
+		commandPath: NextCommandPath(baseID),
+		termWait:    DefaultTermWait,
+		logger:      logger,
+		children:    make(map[int]*child),
+		exits:       make(chan childExit, 8),
+	}, nil
+}
+
+// BaseID returns the --base-id the supervisor was started with.
+func (s *Supervisor) BaseID() string {
+	return s.baseID
+}
+
+// Run starts epoch 0 and then serves signals until the supervisor has no
+// reason to live. The returned value is the process exit code.
+func (s *Supervisor) Run(ctx context.Context) int {
+	s.startedAt = time.Now()
+	if prev, err := readStatus(s.statusPath); err == nil {
+		s.lastExit = prev.LastExit
+	}
+
+	sigs := make(chan os.Signal, 8)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	s.logger.Infof("starting hot-restarter for base-id %s: %s", s.baseID, strings.Join(s.args, " "))
+
+	if err := s.spawn(); err != nil {
+		s.logger.Errorf("failed to start initial child: %v", err)
+		s.writeStatus(StateStopped)
+		return 1
+	}
+
+	for {
+		select {
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGHUP:
+				s.logger.Info("got SIGHUP")
+				prev := s.adoptNextCommand()
+				if err := s.spawn(); err != nil {
+					// The running epoch keeps serving; only the restart failed.
+					s.logger.Errorf("hot restart failed, keeping epoch %d: %v", s.nextEpoch-1, err)
+					s.args = prev
+				}
+			case syscall.SIGUSR1:
+				s.signalAll(syscall.SIGUSR1)
+			default:
+				s.logger.Warnf("got %s", sig)
+				return s.shutdown()
+			}
+
+		case exit := <-s.exits:
+			if code, done := s.reap(exit); done {
+				return code
+			}
+
+		case <-ctx.Done():
+			return s.shutdown()
+		}
+	}
+}
+
+// spawn starts the next epoch. The epoch counter only advances once the child
+// is actually running: envoy's hot restart expects epoch N to find N-1, so
+// burning a number on a failed exec would strand every later restart.
+func (s *Supervisor) spawn() error {
+	epoch := s.nextEpoch
+	args := withEpoch(s.args, epoch)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("exec %s: %w", args[0], err)
+	}
+
+	pid := cmd.Process.Pid
+	s.nextEpoch++
+	s.children[pid] = &child{cmd: cmd, epoch: epoch, startedAt: time.Now()}
+	s.logger.Infof("forked new child process at epoch %d for base-id %s with PID=%d", epoch, s.baseID, pid)
+
+	go func() {
+		err := cmd.Wait()
+		s.exits <- childExit{pid: pid, epoch: epoch, err: err}
+	}()
+
+	s.writeStatus(StateRunning)
+	return nil
+}
+
+// adoptNextCommand switches to a command staged in commandPath, if any, and
+// returns the previous command so a failed spawn can go back to it. The file is
+// consumed: the staged command becomes the supervisor's command for this and
+// every later epoch, like the unit's command line was before.
+func (s *Supervisor) adoptNextCommand() []string {
+	prev := s.args
+	data, err := os.ReadFile(s.commandPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.Warnf("failed to read staged command %s: %v", s.commandPath, err)
+		}
+		return prev
+	}
+	if err := os.Remove(s.commandPath); err != nil {
+		s.logger.Warnf("failed to remove staged command %s: %v", s.commandPath, err)
+	}
+
+	args, baseID, err := parseCommand(string(data))
+	if err != nil {
+		s.logger.Errorf("ignoring staged command: %v", err)
+		return prev
+	}
+	if baseID != s.baseID {
+		s.logger.Errorf("ignoring staged command for base-id %s (supervising %s)", baseID, s.baseID)
+		return prev
+	}
+	s.logger.Infof("next epoch uses staged command: %s", strings.Join(args, " "))
+	s.args = args
+	return prev
+}
+
+// reap handles one child exit. It reports done=true (with the exit code) when
+// the supervisor should exit.
+func (s *Supervisor) reap(exit childExit) (int, bool) {
+	delete(s.children, exit.pid)
+
+	abnormal := false
+	var exitErr *exec.ExitError
+	switch {
+	case exit.err == nil:
+		// Normal exit: the expected end of a drained previous epoch.
+		s.logger.Infof("PID=%d (epoch %d) exited with code=0", exit.pid, exit.epoch)
+	case errors.As(exit.err, &exitErr):
+		abnormal = true
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			s.logger.Warnf("PID=%d (epoch %d) was killed with signal=%d", exit.pid, exit.epoch, ws.Signal())
+		} else {
+			s.logger.Warnf("PID=%d (epoch %d) exited with code=%d", exit.pid, exit.epoch, exitErr.ExitCode())
+		}
+	default:
+		abnormal = true
+		s.logger.Warnf("PID=%d (epoch %d) wait failed: %v", exit.pid, exit.epoch, exit.err)
+	}
+
+	if abnormal {
+		s.lastExit = &ExitStatus{PID: exit.pid, Epoch: exit.epoch, Code: -1, At: time.Now()}
+		if exitErr != nil {
+			s.lastExit.Code = exitErr.ExitCode()
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				s.lastExit.Signal = int(ws.Signal())
+			}
+		}
+		// Tear everything down so systemd notices and restarts the whole unit,
+		// same as the Python wrapper: a half-alive hot-restart group is worse
+		// than a clean restart.
+		s.logger.Warn("Due to abnormal exit, force killing all child processes and exiting")
+		s.killAll()
+		s.writeStatus(StateStopped)
+		return 1, true
+	}
+
+	if len(s.children) == 0 {
+		s.logger.Warn("exiting due to lack of child processes")
+		s.writeStatus(StateStopped)
+		return 0, true
+	}
+
+	s.writeStatus(StateRunning)
+	return 0, false
+}
+
+// shutdown propagates SIGTERM and waits up to termWait for every child to
+// exit, force killing whatever is left.
+func (s *Supervisor) shutdown() int {
+	s.signalAll(syscall.SIGTERM)
+
+	deadline := time.NewTimer(s.termWait)
+	defer deadline.Stop()
+
+	for len(s.children) > 0 {
+		select {
+		case exit := <-s.exits:
+			delete(s.children, exit.pid)
+			s.logger.Infof("PID=%d (epoch %d) exited during shutdown", exit.pid, exit.epoch)
+		case <-deadline.C:
+			for pid := range s.children {
+				s.logger.Warnf("child PID=%d did not exit cleanly, killing", pid)
+			}
+			s.killAll()
+			s.writeStatus(StateStopped)
+			return 1
+		}
+	}
+
+	s.logger.Info("all children exited cleanly")
+	s.writeStatus(StateStopped)
+	return 0
+}
+
+func (s *Supervisor) signalAll(sig syscall.Signal) {
+	for pid, c := range s.children {
+		s.logger.Infof("sending %s to PID=%d", sig, pid)
+		if err := c.cmd.Process.Signal(sig); err != nil {
+			s.logger.Errorf("error sending %s to PID=%d, continuing: %v", sig, pid, err)
+		}
+	}
+}
+
+// killAll SIGKILLs every child and collects the exits so no zombies are left
+// behind. The Wait goroutines are the only reapers, so draining s.exits is
+// what actually reaps them.
+func (s *Supervisor) killAll() {
+	for pid, c := range s.children {
+		s.logger.Warnf("force killing PID=%d", pid)
+		if err := c.cmd.Process.Kill(); err != nil {
+			s.logger.Errorf("error force killing PID=%d, continuing: %v", pid, err)
+		}
+	}
+	for len(s.children) > 0 {
+		exit := <-s.exits
+		delete(s.children, exit.pid)
+	}
+}
+
+// childStatuses returns the live children ordered by epoch (oldest first).
+func (s *Supervisor) childStatuses() []ChildStatus {
+	out := make([]ChildStatus, 0, len(s.children))
+	for pid, c := range s.children {
+		out = append(out, ChildStatus{PID: pid, Epoch: c.epoch, StartedAt: c.startedAt})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Epoch < out[j].Epoch })
+	return out
+}
+
+// parseCommand splits the envoy command string the same way hotrestarter.py
+// does (plain whitespace split) and extracts the --base-id value. Any
+// --restart-epoch already present is dropped; the supervisor owns the epoch.
+func parseCommand(commandLine string) ([]string, string, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, "", fmt.Errorf("empty envoy command")
+	}
+
+	var args []string
+	baseID := ""
+	for i := 0; i < len(fields); i++ {
+		arg := fields[i]
+		switch {
+		case arg == "--restart-epoch":
+			i++ // skip its value too
+			continue
+		case strings.HasPrefix(arg, "--restart-epoch="):
+			continue
+		case arg == "--base-id" && i+1 < len(fields):
+			baseID = fields[i+1]
+		case strings.HasPrefix(arg, "--base-id="):
+			baseID = strings.TrimPrefix(arg, "--base-id=")
+		}
+		args = append(args, arg)
+	}
+
+	if baseID == "" {
+		return nil, "", fmt.Errorf("missing required --base-id argument in: %s", commandLine)
+	}
+	if _, err := strconv.ParseUint(baseID, 10, 32); err != nil {
+		return nil, "", fmt.Errorf("invalid --base-id %q: %w", baseID, err)
+	}
+	return args, baseID, nil
+}
+
+// withEpoch returns a copy of args with --restart-epoch set to epoch.
+func withEpoch(args []string, epoch int) []string {
+	out := make([]string, 0, len(args)+2)
+	out = append(out, args...)
+	return append(out, "--restart-epoch", strconv.Itoa(epoch))
+}
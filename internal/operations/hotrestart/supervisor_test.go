@@ -0,0 +1,215 @@
+package hotrestart
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+func TestParseCommand(t *testing.T) {
+	args, baseID, err := parseCommand(`/var/lib/elchi/envoys/v1.34.0/envoy
+	   -c /var/lib/elchi/bootstraps/web-80.yaml --base-id 80 --drain-time-s 10`)
+	if err != nil {
+		t.Fatalf("parseCommand: %v", err)
+	}
+	if baseID != "80" {
+		t.Errorf("baseID = %q, want 80", baseID)
+	}
+	want := []string{"/var/lib/elchi/envoys/v1.34.0/envoy", "-c", "/var/lib/elchi/bootstraps/web-80.yaml", "--base-id", "80", "--drain-time-s", "10"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestParseCommandEqualsFormAndStaleEpoch(t *testing.T) {
+	// hotrestarter.py stripped "--restart-epoch" but left its value behind as a
+	// stray positional argument; both forms must be removed entirely.
+	args, baseID, err := parseCommand("envoy --base-id=7 --restart-epoch 3 --restart-epoch=4 -c x.yaml")
+	if err != nil {
+		t.Fatalf("parseCommand: %v", err)
+	}
+	if baseID != "7" {
+		t.Errorf("baseID = %q, want 7", baseID)
+	}
+	want := []string{"envoy", "--base-id=7", "-c", "x.yaml"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestParseCommandRejectsMissingOrBadBaseID(t *testing.T) {
+	for _, cmdline := range []string{"", "envoy -c x.yaml", "envoy --base-id abc"} {
+		if _, _, err := parseCommand(cmdline); err == nil {
+			t.Errorf("parseCommand(%q) succeeded, want error", cmdline)
+		}
+	}
+}
+
+func TestWithEpochDoesNotAliasArgs(t *testing.T) {
+	base := make([]string, 2, 8) // spare capacity would expose aliasing
+	copy(base, []string{"envoy", "--base-id=1"})
+
+	e0 := withEpoch(base, 0)
+	e1 := withEpoch(base, 1)
+	if e0[len(e0)-1] != "0" || e1[len(e1)-1] != "1" {
+		t.Fatalf("epochs clobbered each other: %v / %v", e0, e1)
+	}
+}
+
+// End to end against a stand-in "envoy": a hot restart adds a second child at
+// the next epoch, the status file tracks both, and SIGTERM propagation empties
+// the group and records the supervisor as stopped.
+func TestSupervisorHotRestartAndShutdown(t *testing.T) {
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatalf("logger init: %v", err)
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "fake-envoy")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSupervisor(script+" --base-id 99", logger.NewLogger("hotrestart-test"))
+	if err != nil {
+		t.Fatalf("NewSupervisor: %v", err)
+	}
+	s.statusPath = filepath.Join(dir, "99.status.json")
+	s.termWait = 5 * time.Second
+
+	if err := s.spawn(); err != nil {
+		t.Fatalf("spawn epoch 0: %v", err)
+	}
+	if err := s.spawn(); err != nil {
+		t.Fatalf("spawn epoch 1: %v", err)
+	}
+
+	st := readStatusFile(t, s.statusPath)
+	if st.State != StateRunning || st.CurrentEpoch != 1 || len(st.Children) != 2 {
+		t.Fatalf("status after hot restart = %+v, want running epoch 1 with 2 children", st)
+	}
+	if st.Children[0].Epoch != 0 || st.Children[1].Epoch != 1 {
+		t.Errorf("children not ordered by epoch: %+v", st.Children)
+	}
+
+	s.shutdown()
+
+	st = readStatusFile(t, s.statusPath)
+	if st.State != StateStopped || len(st.Children) != 0 {
+		t.Fatalf("status after shutdown = %+v, want stopped with no children", st)
+	}
+}
+
+func readStatusFile(t *testing.T, path string) *Status {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read status: %v", err)
+	}
+	var st Status
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatalf("parse status: %v", err)
+	}
+	return &st
+}
+
+func TestAdoptNextCommand(t *testing.T) {
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatalf("logger init: %v", err)
+	}
+	s, err := NewSupervisor("/var/lib/elchi/envoys/v1.34.0/envoy --base-id 80", logger.NewLogger("hotrestart-test"))
+	if err != nil {
+		t.Fatalf("NewSupervisor: %v", err)
+	}
+	s.commandPath = filepath.Join(t.TempDir(), "80.next")
+	original := s.args
+
+	// Nothing staged: the command is unchanged.
+	if prev := s.adoptNextCommand(); !reflect.DeepEqual(prev, original) || !reflect.DeepEqual(s.args, original) {
+		t.Fatalf("args changed without a staged command: %v", s.args)
+	}
+
+	// A command for another base-id is dropped, not adopted.
+	os.WriteFile(s.commandPath, []byte("/var/lib/elchi/envoys/v1.35.0/envoy --base-id 81\n"), 0644)
+	s.adoptNextCommand()
+	if !reflect.DeepEqual(s.args, original) {
+		t.Fatalf("adopted a command for another base-id: %v", s.args)
+	}
+	if _, err := os.Stat(s.commandPath); !os.IsNotExist(err) {
+		t.Errorf("rejected staged command was not consumed: %v", err)
+	}
+
+	os.WriteFile(s.commandPath, []byte("/var/lib/elchi/envoys/v1.35.0/envoy --base-id 80 --restart-epoch 4\n"), 0644)
+	prev := s.adoptNextCommand()
+	want := []string{"/var/lib/elchi/envoys/v1.35.0/envoy", "--base-id", "80"}
+	if !reflect.DeepEqual(s.args, want) {
+		t.Errorf("args = %v, want %v", s.args, want)
+	}
+	if !reflect.DeepEqual(prev, original) {
+		t.Errorf("prev = %v, want %v", prev, original)
+	}
+	if _, err := os.Stat(s.commandPath); !os.IsNotExist(err) {
+		t.Errorf("staged command was not consumed: %v", err)
+	}
+}
+
+// A crashing child is recorded in the status file, and the record survives the
+// supervisor restart systemd does next so the crash watcher can still read it.
+func TestSupervisorRecordsLastExit(t *testing.T) {
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatalf("logger init: %v", err)
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "fake-envoy")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSupervisor(script+" --base-id 98", logger.NewLogger("hotrestart-test"))
+	if err != nil {
+		t.Fatalf("NewSupervisor: %v", err)
+	}
+	s.statusPath = filepath.Join(dir, "98.status.json")
+	s.termWait = time.Second
+	if code := s.Run(context.Background()); code != 1 {
+		t.Fatalf("Run = %d, want 1 after an abnormal exit", code)
+	}
+	st := readStatusFile(t, s.statusPath)
+	if st.LastExit == nil || st.LastExit.Code != 3 || st.LastExit.Epoch != 0 {
+		t.Fatalf("last exit = %+v, want code 3 at epoch 0", st.LastExit)
+	}
+
+	// The next supervisor carries it over while its child runs.
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	next, err := NewSupervisor(script+" --base-id 98", logger.NewLogger("hotrestart-test"))
+	if err != nil {
+		t.Fatalf("NewSupervisor: %v", err)
+	}
+	next.statusPath = s.statusPath
+	next.termWait = 5 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() { done <- next.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if st, err := readStatus(next.statusPath); err == nil && st.State == StateRunning {
+			if st.LastExit == nil || st.LastExit.Code != 3 {
+				t.Errorf("restarted supervisor dropped the last exit: %+v", st.LastExit)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("restarted supervisor never reported running")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
@@ -3,6 +3,7 @@ package journal
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -272,3 +273,38 @@ func priorityToLevel(priority string) string {
 		return "INFO"
 	}
 }
+
+// CoredumpPIDs returns the pids systemd-coredump recorded for unit since the
+// given time. Without systemd-coredump the set is simply empty.
+func CoredumpPIDs(unit string, since time.Time) (map[int]bool, error) {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open systemd journal: %w", err)
+	}
+	defer j.Close()
+
+	if err := j.AddMatch("COREDUMP_UNIT=" + unit); err != nil {
+		return nil, fmt.Errorf("failed to add coredump unit match: %w", err)
+	}
+	if err := j.SeekRealtimeUsec(uint64(since.UnixMicro())); err != nil {
+		return nil, fmt.Errorf("failed to seek journal: %w", err)
+	}
+
+	pids := make(map[int]bool)
+	for {
+		n, err := j.Next()
+		if err != nil {
+			return pids, fmt.Errorf("failed to read journal entry: %w", err)
+		}
+		if n == 0 {
+			return pids, nil
+		}
+		value, err := j.GetDataValue("COREDUMP_PID")
+		if err != nil {
+			continue
+		}
+		if pid, err := strconv.Atoi(value); err == nil {
+			pids[pid] = true
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network/parsers"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"github.com/vishvananda/netlink"
+)
+
+// routeStateLock keeps the drift repair from re-adding a route or rule that
+// a route/policy operation has removed from the kernel but not yet from its
+// netplan file.
+var routeStateLock sync.Mutex
+
+// persistedRouteGlobs are the netplan files RouteManagerNew and PolicyManager
+// persist to, plus the 50-elchi-r-* route file layout.
+var persistedRouteGlobs = []string{
+	"99-elchi-route-*.yaml",
+	"99-elchi-policy-*.yaml",
+	models.NetplanRoutePrefix + "*.yaml",
+}
+
+// RouteDrift compares the persisted Elchi routes and rules with the kernel.
+// Routes are compared in Elchi-managed tables, rules in the Elchi priority
+// range.
+type RouteDrift struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// Missing entries are persisted but not in the kernel; Extra ones are
+	// in the kernel but in no netplan file.
+	MissingRoutes []*client.Route         `json:"missing_routes"`
+	ExtraRoutes   []*client.Route         `json:"extra_routes"`
+	MissingRules  []*client.RoutingPolicy `json:"missing_rules"`
+	ExtraRules    []*client.RoutingPolicy `json:"extra_rules"`
+	// MismatchedRoutes are routes in RouteAttributesFile whose live route,
+	// in any table, lacks a nexthop, weight or attribute (or is gone).
+	MismatchedRoutes []RouteSpec `json:"mismatched_routes"`
+	// Repaired lists the missing entries re-added by Repair; RepairErrors
+	// the ones that could not be.
+	Repaired     []string `json:"repaired,omitempty"`
+	RepairErrors []string `json:"repair_errors,omitempty"`
+	Warnings     []string `json:"warnings,omitempty"`
+}
+
+// InSync reports whether the kernel matches the persisted state.
+func (d *RouteDrift) InSync() bool {
+	return len(d.MissingRoutes)+len(d.ExtraRoutes)+len(d.MissingRules)+len(d.ExtraRules)+len(d.MismatchedRoutes) == 0
+}
+
+// Summary is a one-line count of the drift.
+func (d *RouteDrift) Summary() string {
+	summary := fmt.Sprintf("%d missing routes, %d extra routes, %d missing rules, %d extra rules",
+		len(d.MissingRoutes), len(d.ExtraRoutes), len(d.MissingRules), len(d.ExtraRules))
+	if len(d.MismatchedRoutes) > 0 {
+		summary += fmt.Sprintf(", %d routes without their nexthops or attributes", len(d.MismatchedRoutes))
+	}
+	return summary
+}
+
+type DriftChecker struct {
+	netplanPath    string
+	attributesPath string
+	logger         *logger.Logger
+}
+
+func NewDriftChecker(logger *logger.Logger) *DriftChecker {
+	return &DriftChecker{netplanPath: models.NetplanPath, attributesPath: RouteAttributesFile, logger: logger}
+}
+
+// Check computes the drift without changing anything.
+func (dc *DriftChecker) Check() (*RouteDrift, error) {
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
+	return dc.check()
+}
+
+func (dc *DriftChecker) check() (*RouteDrift, error) {
+	drift := &RouteDrift{CheckedAt: time.Now().UTC()}
+	routes, rules := dc.persisted(drift)
+
+	liveSpecs, err := getCurrentRouteSpecs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+	var liveRoutes []*client.Route
+	for _, spec := range liveSpecs {
+		liveRoutes = append(liveRoutes, spec.legs()...)
+	}
+	liveRules, err := NewPolicyManager(dc.logger).GetCurrentPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	drift.MissingRoutes, drift.ExtraRoutes = compareRoutes(routes, liveRoutes)
+	if attributes, err := readRouteAttributes(dc.attributesPath); err != nil {
+		drift.Warnings = append(drift.Warnings, fmt.Sprintf("route attributes not checked: %v", err))
+	} else {
+		drift.MismatchedRoutes = compareRouteAttributes(attributes, liveSpecs)
+	}
+	drift.MissingRules, drift.ExtraRules = compareRules(rules, liveRules)
+	return drift, nil
+}
+
+// Repair re-adds the missing routes and rules to the kernel. Extra entries
+// are only reported: they may be added by hand on purpose.
+func (dc *DriftChecker) Repair() (*RouteDrift, error) {
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
+
+	drift, err := dc.check()
+	if err != nil {
+		return nil, err
+	}
+
+	rm := NewRouteManagerNew(dc.logger)
+	mismatched := make(map[string]bool)
+	for _, spec := range drift.MismatchedRoutes {
+		mismatched[spec.key()] = true
+		desc := fmt.Sprintf("route to %s table %d with %d nexthops", spec.To, spec.Table, len(spec.Nexthops))
+		nlRoute, err := rm.routeSpecToNetlink(spec)
+		if err == nil {
+			err = netlink.RouteReplace(nlRoute)
+		}
+		if err != nil {
+			drift.RepairErrors = append(drift.RepairErrors, fmt.Sprintf("%s: %v", desc, err))
+			continue
+		}
+		drift.Repaired = append(drift.Repaired, desc)
+	}
+	for _, route := range drift.MissingRoutes {
+		// A missing nexthop came back with its whole route above.
+		if mismatched[routeSpecKey(route)] {
+			continue
+		}
+		desc := fmt.Sprintf("route to %s via %s dev %s table %d", route.To, route.Via, route.Interface, route.Table)
+		nlRoute, err := rm.clientRouteToNetlink(route)
+		if err == nil {
+			if err = netlink.RouteAdd(nlRoute); err == syscall.EEXIST {
+				err = nil
+			}
+		}
+		if err != nil {
+			drift.RepairErrors = append(drift.RepairErrors, fmt.Sprintf("%s: %v", desc, err))
+			continue
+		}
+		drift.Repaired = append(drift.Repaired, desc)
+	}
+
+	pm := NewPolicyManager(dc.logger)
+	for _, rule := range drift.MissingRules {
+		desc := fmt.Sprintf("rule from %s to %s table %d priority %d", rule.From, rule.To, rule.Table, rule.Priority)
+		if err := pm.addRuntimePolicy(rule); err != nil {
+			drift.RepairErrors = append(drift.RepairErrors, fmt.Sprintf("%s: %v", desc, err))
+			continue
+		}
+		drift.Repaired = append(drift.Repaired, desc)
+	}
+
+	if len(drift.Repaired) > 0 {
+		dc.logger.Infof("Re-added %d missing routes and rules", len(drift.Repaired))
+	}
+	return drift, nil
+}
+
+// persisted parses the Elchi route and policy files. Routes outside the
+// Elchi-managed tables are left out, as are unparseable files (with a
+// warning).
+func (dc *DriftChecker) persisted(drift *RouteDrift) ([]*client.Route, []*client.RoutingPolicy) {
+	var routes []*client.Route
+	var rules []*client.RoutingPolicy
+	for _, glob := range persistedRouteGlobs {
+		paths, _ := filepath.Glob(filepath.Join(dc.netplanPath, glob))
+		for _, path := range paths {
+			infos, err := parsers.ParseNetplanRouteFile(path)
+			if err != nil {
+				drift.Warnings = append(drift.Warnings, fmt.Sprintf("%s not checked: %v", path, err))
+				continue
+			}
+			for _, info := range infos {
+				for _, route := range info.Routes {
+					if isElchiManagedTableID(int(route.Table)) {
+						routes = append(routes, route)
+					}
+				}
+				rules = append(rules, info.RoutingPolicies...)
+			}
+		}
+	}
+	return routes, rules
+}
+
+// compareRoutes returns the persisted routes missing from live and the live
+// routes in Elchi-managed tables that are not persisted.
+func compareRoutes(persisted, live []*client.Route) (missing, extra []*client.Route) {
+	liveKeys := make(map[string]bool)
+	for _, route := range live {
+		liveKeys[driftRouteKey(route)] = true
+	}
+	persistedKeys := make(map[string]bool)
+	for _, route := range persisted {
+		key := driftRouteKey(route)
+		if !persistedKeys[key] && !liveKeys[key] {
+			missing = append(missing, route)
+		}
+		persistedKeys[key] = true
+	}
+	for _, route := range live {
+		// Connected routes the kernel adds for addresses (VRF tables) are
+		// never persisted.
+		if route.Protocol == "kernel" {
+			continue
+		}
+		if isElchiManagedTableID(int(route.Table)) && !persistedKeys[driftRouteKey(route)] {
+			extra = append(extra, route)
+		}
+	}
+	sortRoutes(missing)
+	sortRoutes(extra)
+	return missing, extra
+}
+
+// compareRouteAttributes returns the persisted specs whose live route
+// differs from them or is gone.
+func compareRouteAttributes(persisted map[string]RouteSpec, live []RouteSpec) []RouteSpec {
+	liveByKey := make(map[string]RouteSpec)
+	for _, spec := range live {
+		liveByKey[spec.key()] = spec
+	}
+	var mismatched []RouteSpec
+	for key, want := range persisted {
+		if got, ok := liveByKey[key]; !ok || !sameRouteAttributes(want, got) {
+			mismatched = append(mismatched, want)
+		}
+	}
+	sort.Slice(mismatched, func(i, j int) bool { return mismatched[i].key() < mismatched[j].key() })
+	return mismatched
+}
+
+// compareRules returns the persisted rules missing from live and the live
+// Elchi-priority rules that are not persisted.
+func compareRules(persisted, live []*client.RoutingPolicy) (missing, extra []*client.RoutingPolicy) {
+	liveKeys := make(map[string]bool)
+	for _, rule := range live {
+		liveKeys[driftRuleKey(rule)] = true
+	}
+	persistedKeys := make(map[string]bool)
+	for _, rule := range persisted {
+		key := driftRuleKey(rule)
+		if !persistedKeys[key] && !liveKeys[key] {
+			missing = append(missing, rule)
+		}
+		persistedKeys[key] = true
+	}
+	for _, rule := range live {
+		if !persistedKeys[driftRuleKey(rule)] {
+			extra = append(extra, rule)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Priority < missing[j].Priority })
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Priority < extra[j].Priority })
+	return missing, extra
+}
+
+// driftRouteKey identifies a route by what the kernel keys it on. The
+// interface is left out: a persisted route may omit it.
+func driftRouteKey(route *client.Route) string {
+	via := canonicalAddr(route.Via)
+	// The same link-local gateway is a different neighbour on each link.
+	if ip := net.ParseIP(via); ip != nil && ip.IsLinkLocalUnicast() {
+		via += "%" + route.Interface
+	}
+	dst := canonicalRouteDst(route.To, route.Via)
+	return fmt.Sprintf("%d|%s|%s|%d", route.Table, dst, via, canonicalMetric(dst, route.Metric))
+}
+
+// ipv6DefaultMetric is the priority the kernel gives an IPv6 route added
+// without a metric; IPv4 routes keep 0.
+const ipv6DefaultMetric = 1024
+
+// canonicalMetric returns the metric the kernel stores for a route to the
+// canonical destination dst.
+func canonicalMetric(dst string, metric uint32) uint32 {
+	if metric == 0 && strings.Contains(dst, ":") {
+		return ipv6DefaultMetric
+	}
+	return metric
+}
+
+func driftRuleKey(rule *client.RoutingPolicy) string {
+	return fmt.Sprintf("%s|%s|%d|%d", canonicalPrefix(rule.From), canonicalPrefix(rule.To), rule.Table, rule.Priority)
+}
+
+// canonicalPrefix returns the network of a CIDR, with "default" and bare
+// addresses spelled the way netlink reports them.
+func canonicalPrefix(s string) string {
+	if s == "" {
+		return ""
+	}
+	if s == "default" {
+		return "0.0.0.0/0"
+	}
+	if _, ipnet, err := net.ParseCIDR(s); err == nil {
+		return ipnet.String()
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32"
+		}
+		return ip.String() + "/128"
+	}
+	return s
+}
+
+// canonicalRouteDst is canonicalPrefix for a route destination: an empty or
+// "default" destination takes the address family of the gateway.
+func canonicalRouteDst(to, via string) string {
+	if to == "" || to == "default" {
+		if ip := net.ParseIP(via); ip != nil && ip.To4() == nil {
+			return "::/0"
+		}
+		return "0.0.0.0/0"
+	}
+	return canonicalPrefix(to)
+}
+
+// canonicalAddr spells an address the way netlink reports it; IPv6 has
+// many spellings of the same address.
+func canonicalAddr(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+	return s
+}
+
+func sortRoutes(routes []*client.Route) {
+	sort.Slice(routes, func(i, j int) bool { return driftRouteKey(routes[i]) < driftRouteKey(routes[j]) })
+}
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+const persistedRoutes = `network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    eth1:
+      routes:
+        - to: default
+          via: 10.1.0.1
+          table: 100
+        - to: 10.20.0.0/16
+          via: 10.1.0.1
+          table: 100
+          metric: 50
+        - to: 192.168.0.0/24
+          via: 10.1.0.1
+`
+
+const persistedPolicies = `network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    eth1:
+      routing-policy:
+        - from: 10.1.0.5/32
+          table: 100
+          priority: 100
+`
+
+func TestDriftCheckerPersisted(t *testing.T) {
+	logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"})
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "99-elchi-route-eth1.yaml"), []byte(persistedRoutes), 0600)
+	os.WriteFile(filepath.Join(dir, "99-elchi-policy-eth1.yaml"), []byte(persistedPolicies), 0600)
+	os.WriteFile(filepath.Join(dir, "99-elchi-route-bad.yaml"), []byte("network: ["), 0600)
+	dc := &DriftChecker{netplanPath: dir, logger: logger.NewLogger("test")}
+
+	drift := &RouteDrift{}
+	routes, rules := dc.persisted(drift)
+	// The main-table route is outside the Elchi-managed tables.
+	if len(routes) != 2 || routes[1].Metric != 50 || routes[1].Table != 100 || routes[1].Interface != "eth1" {
+		t.Fatalf("persisted routes = %v", routes)
+	}
+	if len(rules) != 1 || rules[0].Priority != 100 || rules[0].Table != 100 || rules[0].Interface != "eth1" {
+		t.Fatalf("persisted rules = %v", rules)
+	}
+	if len(drift.Warnings) != 1 {
+		t.Errorf("warnings = %v", drift.Warnings)
+	}
+}
+
+func TestCompareRoutes(t *testing.T) {
+	persisted := []*client.Route{
+		{To: "default", Via: "10.1.0.1", Table: 100, Interface: "eth1"},
+		{To: "10.20.0.0/16", Via: "10.1.0.1", Table: 100, Metric: 50, Interface: "eth1"},
+	}
+	live := []*client.Route{
+		{To: "0.0.0.0/0", Via: "10.1.0.1", Table: 100, Interface: "eth1", IsDefault: true, Protocol: "static"},
+		{To: "10.30.0.0/16", Via: "10.1.0.1", Table: 100, Interface: "eth1", Protocol: "boot"},
+		{To: "10.1.0.0/24", Table: 100, Interface: "eth1", Protocol: "kernel"},
+		{To: "172.16.0.0/12", Via: "10.0.0.1", Table: 254, Protocol: "static"},
+	}
+	missing, extra := compareRoutes(persisted, live)
+	if len(missing) != 1 || missing[0].To != "10.20.0.0/16" {
+		t.Errorf("missing = %v", missing)
+	}
+	if len(extra) != 1 || extra[0].To != "10.30.0.0/16" {
+		t.Errorf("extra = %v", extra)
+	}
+
+	// A metric is part of a route's identity.
+	live = append(live, &client.Route{To: "10.20.0.0/16", Via: "10.1.0.1", Table: 100, Metric: 60})
+	if missing, _ := compareRoutes(persisted, live); len(missing) != 1 {
+		t.Errorf("route with another metric counted as present: %v", missing)
+	}
+
+	// The kernel stores an IPv6 route added without a metric at 1024.
+	persisted = []*client.Route{{To: "2001:db8:1::/48", Via: "fe80::1", Table: 100, Interface: "eth1"}}
+	live = []*client.Route{{To: "2001:db8:1::/48", Via: "fe80::1", Table: 100, Metric: 1024, Interface: "eth1", Protocol: "static"}}
+	if missing, extra := compareRoutes(persisted, live); len(missing) != 0 || len(extra) != 0 {
+		t.Errorf("IPv6 route without a metric: missing = %v, extra = %v", missing, extra)
+	}
+}
+
+func TestCompareRules(t *testing.T) {
+	persisted := []*client.RoutingPolicy{
+		{From: "10.1.0.5/32", Table: 100, Priority: 100},
+		{From: "10.1.0.6", Table: 100, Priority: 101},
+		{To: "10.9.0.0/16", Table: 101, Priority: 200},
+	}
+	live := []*client.RoutingPolicy{
+		{From: "10.1.0.5/32", Table: 100, Priority: 100},
+		{From: "10.1.0.6/32", Table: 100, Priority: 101},
+		{From: "10.1.0.7/32", Table: 100, Priority: 102},
+	}
+	missing, extra := compareRules(persisted, live)
+	if len(missing) != 1 || missing[0].Priority != 200 {
+		t.Errorf("missing = %v", missing)
+	}
+	if len(extra) != 1 || extra[0].Priority != 102 {
+		t.Errorf("extra = %v", extra)
+	}
+	drift := &RouteDrift{MissingRules: missing, ExtraRules: extra}
+	if drift.InSync() || drift.Summary() != "0 missing routes, 0 extra routes, 1 missing rules, 1 extra rules" {
+		t.Errorf("summary = %q", drift.Summary())
+	}
+}
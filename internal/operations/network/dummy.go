@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
@@ -48,14 +49,18 @@ func SetupDummyInterfaceWithNetlink(ifaceName, downstreamAddress string, logger
 
 	logger.Debugf("Setting up interface %s with IP %s", ifaceName, downstreamAddress)
 
-	ipv4CIDR, err := tools.GetIPv4CIDR(downstreamAddress)
+	cidrs, err := tools.GetHostCIDRs(downstreamAddress)
 	if err != nil {
 		return fmt.Errorf("invalid IP address format: %w", err)
 	}
 
-	ipAddr, ipNet, err := net.ParseCIDR(ipv4CIDR)
-	if err != nil {
-		return fmt.Errorf("invalid IP address format: %w", err)
+	var wanted []*net.IPNet
+	for _, cidr := range cidrs {
+		ipAddr, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid IP address format: %w", err)
+		}
+		wanted = append(wanted, &net.IPNet{IP: ipAddr, Mask: ipNet.Mask})
 	}
 
 	link, err := netlink.LinkByName(ifaceName)
@@ -88,7 +93,10 @@ func SetupDummyInterfaceWithNetlink(ifaceName, downstreamAddress string, logger
 		return fmt.Errorf("failed to set interface up: %w", err)
 	}
 
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	// A recreated dummy goes back into the VRF its netplan file lists it in.
+	rejoinVRF(link, logger)
+
+	addrs, err := dummyAddresses(link)
 	if err != nil {
 		return fmt.Errorf("failed to list addresses: %w", err)
 	}
@@ -102,10 +110,10 @@ func SetupDummyInterfaceWithNetlink(ifaceName, downstreamAddress string, logger
 		logger.Debugf("Interface %s currently has IPs: %v", ifaceName, existingIPs)
 	}
 
-	// Always ensure we have the correct IP - remove others and add target IP
-	// First, remove all IPs that are NOT the target IP
+	// Always ensure we have the correct IPs - remove others and add the targets
+	// First, remove all IPs that are NOT a target IP
 	for _, addr := range addrs {
-		if !addr.IP.Equal(ipAddr) {
+		if !containsIP(wanted, addr.IP) {
 			logger.Debugf("Removing unwanted IP %s from interface %s", addr.IP.String(), ifaceName)
 			if err := netlink.AddrDel(link, &addr); err != nil {
 				logger.Warnf("Failed to remove unwanted IP %s: %v", addr.IP.String(), err)
@@ -115,27 +123,28 @@ func SetupDummyInterfaceWithNetlink(ifaceName, downstreamAddress string, logger
 		}
 	}
 
-	// Now add the target IP (netlink will ignore if it already exists)
-	addr := &netlink.Addr{
-		IPNet: &net.IPNet{
-			IP:   ipAddr,
-			Mask: ipNet.Mask,
-		},
-	}
-
-	if err := netlink.AddrAdd(link, addr); err != nil {
-		// Check if error is "file exists" (IP already exists) - this is OK
-		if strings.Contains(err.Error(), "file exists") {
-			logger.Debugf("IP %s already exists on interface %s", downstreamAddress, ifaceName)
+	// Now add the target IPs (netlink will ignore if they already exist)
+	for _, ipNet := range wanted {
+		addr := &netlink.Addr{IPNet: ipNet}
+		// Duplicate address detection would hold a new IPv6 address
+		// tentative; a dummy has no neighbours to collide with.
+		if ipNet.IP.To4() == nil {
+			addr.Flags = syscall.IFA_F_NODAD
+		}
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			// Check if error is "file exists" (IP already exists) - this is OK
+			if strings.Contains(err.Error(), "file exists") {
+				logger.Debugf("IP %s already exists on interface %s", ipNet.IP, ifaceName)
+			} else {
+				return fmt.Errorf("failed to add IP address %s: %w", ipNet.IP, err)
+			}
 		} else {
-			return fmt.Errorf("failed to add IP address: %w", err)
+			logger.Debugf("Successfully added IP %s to interface %s", ipNet.IP, ifaceName)
 		}
-	} else {
-		logger.Debugf("Successfully added IP %s to interface %s", downstreamAddress, ifaceName)
 	}
 
 	// Verify the final state
-	finalAddrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	finalAddrs, err := dummyAddresses(link)
 	if err == nil {
 		var finalIPs []string
 		for _, fAddr := range finalAddrs {
@@ -176,7 +185,7 @@ func DeleteDummyInterface(ifaceName string, logger *logger.Logger) error {
 		logger.Debugf("Interface %s found, deleting it. Current state: UP=%v", ifaceName, link.Attrs().Flags&net.FlagUp != 0)
 
 		// First, remove all IP addresses
-		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		addrs, err := dummyAddresses(link)
 		if err == nil && len(addrs) > 0 {
 			logger.Debugf("Removing %d IP addresses from interface %s", len(addrs), ifaceName)
 			for _, addr := range addrs {
@@ -208,9 +217,38 @@ func DeleteDummyInterface(ifaceName string, logger *logger.Logger) error {
 		}
 	}
 
+	// A VRF stanza still naming the interface would make netplan reject the
+	// whole configuration once its 90-elchi-if-*.yaml is gone.
+	forgetVRFMember(ifaceName, logger)
+
 	// Step 2: Netplan file will be deleted separately by file cleanup process
 	// DO NOT apply netplan here - it causes network interruption during undeploy
 	// The interface is already removed from runtime via netlink
 
 	return nil
 }
+
+// dummyAddresses lists the IPv4 and IPv6 addresses of a dummy, leaving out
+// the fe80::/64 address the kernel assigns itself.
+func dummyAddresses(link netlink.Link) ([]netlink.Addr, error) {
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	var out []netlink.Addr
+	for _, addr := range addrs {
+		if !addr.IP.IsLinkLocalUnicast() {
+			out = append(out, addr)
+		}
+	}
+	return out, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,465 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	"github.com/CloudNativeWorks/elchi-client/pkg/template"
+	"google.golang.org/grpc"
+)
+
+const (
+	// FirewallTable is the nftables table the client owns ("inet elchi").
+	// Nothing outside it is ever touched.
+	FirewallTable    = "elchi"
+	FirewallUnit     = "elchi-firewall.service"
+	firewallRuleset  = "elchi.nft"
+	firewallPending  = "pending.nft"
+	firewallPrevious = "previous.nft"
+	firewallRules    = "rules.json"
+	// DefaultFirewallTestTimeout is how long controller connectivity is
+	// watched after a ruleset is loaded before it is kept.
+	DefaultFirewallTestTimeout = 20 // seconds
+)
+
+// FirewallDir holds the rendered rulesets and the structured rules; the boot
+// unit loads FirewallDir/elchi.nft.
+var FirewallDir = filepath.Join(models.ElchiLibPath, "firewall")
+
+// firewallUnitDir is where the boot unit is installed.
+var firewallUnitDir = filepath.Join(models.SystemdRootPath, "system")
+
+// nftCommand runs nft as root.
+var nftCommand = []string{"sudo", "nft"}
+
+// FirewallRule is one input rule. Empty fields match anything.
+type FirewallRule struct {
+	Comment  string `json:"comment,omitempty"`
+	Protocol string `json:"protocol"`
+	// Port and PortEnd select a port or an inclusive range.
+	Port    uint16 `json:"port,omitempty"`
+	PortEnd uint16 `json:"port_end,omitempty"`
+	// Sources are CIDRs or addresses, IPv4 and IPv6 mixed.
+	Sources   []string `json:"sources,omitempty"`
+	Interface string   `json:"interface,omitempty"`
+	// RateLimit drops new connections above a rate such as "100/second";
+	// Burst allows short spikes above it.
+	RateLimit string `json:"rate_limit,omitempty"`
+	Burst     int    `json:"burst,omitempty"`
+	// Action is "accept" (the default) or "drop".
+	Action string `json:"action,omitempty"`
+}
+
+// FirewallPort is a port closed by lockdown.
+type FirewallPort struct {
+	Protocol string `json:"protocol"`
+	Port     uint16 `json:"port"`
+}
+
+// FirewallConfig is the whole desired state of the inet elchi table.
+type FirewallConfig struct {
+	Rules []FirewallRule `json:"rules"`
+	// Lockdown ports are dropped unless one of Rules accepted the packet
+	// first, e.g. envoy admin ports reachable only from the control plane.
+	Lockdown []FirewallPort `json:"lockdown,omitempty"`
+	// Policy is the input chain policy: "accept" (the default; other tables
+	// still filter) or "drop".
+	Policy string `json:"policy,omitempty"`
+}
+
+// FirewallApplyOptions mirror NetplanConfig's safety settings.
+type FirewallApplyOptions struct {
+	PreserveControllerConnection bool   `json:"preserve_controller_connection"`
+	TestTimeoutSeconds           uint32 `json:"test_timeout_seconds,omitempty"`
+}
+
+// DefaultFirewallApplyOptions is what a request without options gets: like
+// typed interface changes, a ruleset that cuts the controller off is rolled
+// back.
+func DefaultFirewallApplyOptions() FirewallApplyOptions {
+	return FirewallApplyOptions{PreserveControllerConnection: true}
+}
+
+var (
+	rateLimitPattern = regexp.MustCompile(`^[1-9][0-9]*/(second|minute|hour|day)$`)
+	ifacePattern     = regexp.MustCompile(`^[a-zA-Z0-9_.:@-]{1,15}$`)
+	commentPattern   = regexp.MustCompile(`^[a-zA-Z0-9 _.:/@-]{0,128}$`)
+)
+
+// Validate checks every field that ends up in the ruleset.
+func (c FirewallConfig) Validate() error {
+	switch c.Policy {
+	case "", "accept", "drop":
+	default:
+		return fmt.Errorf("invalid policy %q: must be accept or drop", c.Policy)
+	}
+	for i, r := range c.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	for i, p := range c.Lockdown {
+		if p.Protocol != "tcp" && p.Protocol != "udp" {
+			return fmt.Errorf("lockdown %d: protocol must be tcp or udp", i+1)
+		}
+		if p.Port == 0 {
+			return fmt.Errorf("lockdown %d: port is required", i+1)
+		}
+	}
+	return nil
+}
+
+func (r FirewallRule) validate() error {
+	switch r.Protocol {
+	case "tcp", "udp":
+	case "", "icmp", "icmpv6":
+		if r.Port != 0 || r.PortEnd != 0 {
+			return fmt.Errorf("ports need protocol tcp or udp")
+		}
+	default:
+		return fmt.Errorf("invalid protocol %q", r.Protocol)
+	}
+	if r.PortEnd != 0 && r.PortEnd < r.Port {
+		return fmt.Errorf("port_end %d is below port %d", r.PortEnd, r.Port)
+	}
+	for _, s := range r.Sources {
+		if _, ok := parseSource(s); !ok {
+			return fmt.Errorf("invalid source %q", s)
+		}
+	}
+	if r.Interface != "" && !ifacePattern.MatchString(r.Interface) {
+		return fmt.Errorf("invalid interface %q", r.Interface)
+	}
+	if r.RateLimit != "" && !rateLimitPattern.MatchString(r.RateLimit) {
+		return fmt.Errorf("invalid rate_limit %q: want <n>/second|minute|hour|day", r.RateLimit)
+	}
+	if r.Burst < 0 || r.Burst > 0 && r.RateLimit == "" {
+		return fmt.Errorf("burst needs a rate_limit")
+	}
+	if !commentPattern.MatchString(r.Comment) {
+		return fmt.Errorf("comment may only hold letters, digits, spaces and _.:/@-")
+	}
+	switch r.Action {
+	case "", "accept", "drop":
+	default:
+		return fmt.Errorf("invalid action %q: must be accept or drop", r.Action)
+	}
+	return nil
+}
+
+// parseSource canonicalises an address or CIDR and reports its family.
+func parseSource(s string) (*net.IPNet, bool) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, false
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, true
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err == nil
+}
+
+// RenderFirewall renders cfg as an nft script that replaces the inet elchi
+// table in one transaction: declaring the table first makes the delete valid
+// when it does not exist yet.
+func RenderFirewall(cfg FirewallConfig) string {
+	policy := cfg.Policy
+	if policy == "" {
+		policy = "accept"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by elchi-client; changes are overwritten.\n")
+	fmt.Fprintf(&b, "table inet %s\ndelete table inet %s\n\n", FirewallTable, FirewallTable)
+	fmt.Fprintf(&b, "table inet %s {\n", FirewallTable)
+	fmt.Fprintf(&b, "\tchain input {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook input priority filter; policy %s;\n", policy)
+	fmt.Fprintf(&b, "\t\tct state established,related accept\n")
+	fmt.Fprintf(&b, "\t\tct state invalid drop\n")
+	fmt.Fprintf(&b, "\t\tiif \"lo\" accept\n")
+	if policy == "drop" {
+		// Neighbour discovery would otherwise break IPv6 on the host.
+		fmt.Fprintf(&b, "\t\ticmpv6 type { nd-neighbor-solicit, nd-neighbor-advert, nd-router-advert } accept\n")
+	}
+	for _, r := range cfg.Rules {
+		for _, line := range renderRule(r) {
+			fmt.Fprintf(&b, "\t\t%s\n", line)
+		}
+	}
+	for _, proto := range []string{"tcp", "udp"} {
+		var ports []string
+		for _, p := range cfg.Lockdown {
+			if p.Protocol == proto {
+				ports = append(ports, fmt.Sprint(p.Port))
+			}
+		}
+		if len(ports) > 0 {
+			fmt.Fprintf(&b, "\t\t%s dport { %s } counter drop comment \"lockdown\"\n", proto, strings.Join(ports, ", "))
+		}
+	}
+	fmt.Fprintf(&b, "\t}\n}\n")
+	return b.String()
+}
+
+// renderRule returns the nft statements of r: one per address family when
+// it has sources, plus the rate-limit drop ahead of each.
+func renderRule(r FirewallRule) []string {
+	var match []string
+	if r.Interface != "" {
+		match = append(match, fmt.Sprintf("iifname %q", r.Interface))
+	}
+	switch {
+	case r.Protocol == "icmp":
+		match = append(match, "meta l4proto icmp")
+	case r.Protocol == "icmpv6":
+		match = append(match, "meta l4proto icmpv6")
+	case r.Port != 0 && r.PortEnd > r.Port:
+		match = append(match, fmt.Sprintf("%s dport %d-%d", r.Protocol, r.Port, r.PortEnd))
+	case r.Port != 0:
+		match = append(match, fmt.Sprintf("%s dport %d", r.Protocol, r.Port))
+	case r.Protocol != "":
+		match = append(match, "meta l4proto "+r.Protocol)
+	}
+
+	var v4, v6 []string
+	for _, s := range r.Sources {
+		ipnet, _ := parseSource(s)
+		if ipnet.IP.To4() != nil {
+			v4 = append(v4, ipnet.String())
+		} else {
+			v6 = append(v6, ipnet.String())
+		}
+	}
+	var selectors [][]string
+	if len(v4)+len(v6) == 0 {
+		selectors = [][]string{match}
+	}
+	for _, fam := range []struct {
+		key   string
+		addrs []string
+	}{{"ip saddr", v4}, {"ip6 saddr", v6}} {
+		if len(fam.addrs) == 0 {
+			continue
+		}
+		sort.Strings(fam.addrs)
+		set := fam.addrs[0]
+		if len(fam.addrs) > 1 {
+			set = "{ " + strings.Join(fam.addrs, ", ") + " }"
+		}
+		selectors = append(selectors, append(append([]string{}, match...), fam.key+" "+set))
+	}
+
+	action := r.Action
+	if action == "" {
+		action = "accept"
+	}
+	comment := ""
+	if r.Comment != "" {
+		comment = fmt.Sprintf(" comment %q", r.Comment)
+	}
+	var out []string
+	for _, sel := range selectors {
+		prefix := strings.Join(sel, " ")
+		if prefix != "" {
+			prefix += " "
+		}
+		if r.RateLimit != "" {
+			limit := "limit rate over " + r.RateLimit
+			if r.Burst > 0 {
+				limit += fmt.Sprintf(" burst %d packets", r.Burst)
+			}
+			out = append(out, fmt.Sprintf("%sct state new %s counter drop%s", prefix, limit, comment))
+		}
+		out = append(out, fmt.Sprintf("%scounter %s%s", prefix, action, comment))
+	}
+	return out
+}
+
+// FirewallManager loads, reverts and persists the inet elchi table.
+type FirewallManager struct {
+	dir     string
+	logger  *logger.Logger
+	monitor *ConnectionMonitor
+	// connected reports whether the controller is still reachable after a
+	// load; it is the monitor's check outside tests.
+	connected func(ctx context.Context) bool
+}
+
+func NewFirewallManager(logger *logger.Logger) *FirewallManager {
+	fm := &FirewallManager{dir: FirewallDir, logger: logger, monitor: NewConnectionMonitor(logger)}
+	fm.connected = fm.monitor.MonitorConnectionDuringApply
+	return fm
+}
+
+// SetGRPCConnection sets the gRPC connection for ping testing
+func (fm *FirewallManager) SetGRPCConnection(conn *grpc.ClientConn, clientID string) {
+	fm.monitor.SetGRPCConnection(conn, clientID)
+}
+
+// Apply validates cfg, checks the rendered ruleset with nft -c and loads it
+// atomically. With PreserveControllerConnection the controller connection is
+// watched like during a netplan apply and the previous table is restored if
+// it is lost. Only a ruleset that stays is persisted for boot.
+func (fm *FirewallManager) Apply(cfg FirewallConfig, opts FirewallApplyOptions) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid firewall configuration: %w", err)
+	}
+	if err := os.MkdirAll(fm.dir, 0750); err != nil {
+		return fmt.Errorf("failed to create %s: %w", fm.dir, err)
+	}
+
+	pending := filepath.Join(fm.dir, firewallPending)
+	if err := os.WriteFile(pending, []byte(RenderFirewall(cfg)), 0640); err != nil {
+		return fmt.Errorf("failed to write ruleset: %w", err)
+	}
+	if out, err := runNft("-c", "-f", pending); err != nil {
+		return fmt.Errorf("ruleset rejected by nft: %w, output: %s", err, out)
+	}
+
+	previous, err := fm.snapshot()
+	if err != nil {
+		return err
+	}
+	if out, err := runNft("-f", pending); err != nil {
+		return fmt.Errorf("failed to load ruleset: %w, output: %s", err, out)
+	}
+	fm.logger.Infof("Loaded firewall table inet %s (%d rules, %d lockdown ports)", FirewallTable, len(cfg.Rules), len(cfg.Lockdown))
+
+	if opts.PreserveControllerConnection {
+		timeout := opts.TestTimeoutSeconds
+		if timeout == 0 {
+			timeout = DefaultFirewallTestTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+		ok := fm.connected(ctx)
+		cancel()
+		if !ok {
+			fm.logger.Warn("Controller connection lost after firewall change, restoring the previous ruleset")
+			if out, err := runNft("-f", previous); err != nil {
+				return fmt.Errorf("controller connection lost and rollback failed: %w, output: %s", err, out)
+			}
+			return fmt.Errorf("controller connection lost after applying the firewall, rolled back")
+		}
+	}
+
+	return fm.persist(cfg, pending)
+}
+
+// snapshot saves a script that restores the table as it is now (or removes
+// it when there is none) and returns its path.
+func (fm *FirewallManager) snapshot() (string, error) {
+	current, err := runNft("list", "table", "inet", FirewallTable)
+	if err != nil {
+		// No table yet: rolling back means removing the one we add.
+		current = ""
+	}
+	script := fmt.Sprintf("table inet %s\ndelete table inet %s\n%s", FirewallTable, FirewallTable, current)
+	path := filepath.Join(fm.dir, firewallPrevious)
+	if err := os.WriteFile(path, []byte(script), 0640); err != nil {
+		return "", fmt.Errorf("failed to save current ruleset: %w", err)
+	}
+	return path, nil
+}
+
+// persist makes the applied ruleset the boot ruleset and keeps its rules.
+func (fm *FirewallManager) persist(cfg FirewallConfig, pending string) error {
+	if err := os.Rename(pending, filepath.Join(fm.dir, firewallRuleset)); err != nil {
+		return fmt.Errorf("failed to persist ruleset: %w", err)
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(fm.dir, firewallRules), data, 0640); err != nil {
+		return fmt.Errorf("failed to persist rules: %w", err)
+	}
+	return fm.ensureBootUnit()
+}
+
+// Remove deletes the table and leaves a boot ruleset that only removes it.
+func (fm *FirewallManager) Remove() error {
+	if err := os.MkdirAll(fm.dir, 0750); err != nil {
+		return err
+	}
+	empty := fmt.Sprintf("table inet %s\ndelete table inet %s\n", FirewallTable, FirewallTable)
+	path := filepath.Join(fm.dir, firewallPending)
+	if err := os.WriteFile(path, []byte(empty), 0640); err != nil {
+		return err
+	}
+	if out, err := runNft("-f", path); err != nil {
+		return fmt.Errorf("failed to remove table inet %s: %w, output: %s", FirewallTable, err, out)
+	}
+	if err := os.Rename(path, filepath.Join(fm.dir, firewallRuleset)); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(fm.dir, firewallRules))
+	fm.logger.Infof("Removed firewall table inet %s", FirewallTable)
+	return nil
+}
+
+// Current returns the persisted rules and the live table as nft lists it.
+func (fm *FirewallManager) Current() (*FirewallConfig, string, error) {
+	cfg := &FirewallConfig{Rules: []FirewallRule{}}
+	data, err := os.ReadFile(filepath.Join(fm.dir, firewallRules))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, "", fmt.Errorf("corrupt %s: %w", firewallRules, err)
+		}
+	}
+	live, err := runNft("list", "table", "inet", FirewallTable)
+	if err != nil {
+		live = ""
+	}
+	return cfg, live, nil
+}
+
+// ensureBootUnit installs and enables the unit that loads the ruleset at boot.
+func (fm *FirewallManager) ensureBootUnit() error {
+	unitPath := filepath.Join(firewallUnitDir, FirewallUnit)
+	unit := fmt.Sprintf(template.FirewallUnitTemplate, filepath.Join(fm.dir, firewallRuleset))
+	if existing, err := os.ReadFile(unitPath); err == nil && string(existing) == unit {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sudo", "tee", unitPath)
+	cmd.Stdin = strings.NewReader(unit)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", unitPath, err)
+	}
+	if out, err := exec.CommandContext(ctx, "sudo", "systemctl", "daemon-reload").CombinedOutput(); err != nil {
+		return fmt.Errorf("daemon-reload failed: %w, output: %s", err, out)
+	}
+	if out, err := exec.CommandContext(ctx, "sudo", "systemctl", "enable", FirewallUnit).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to enable %s: %w, output: %s", FirewallUnit, err, out)
+	}
+	fm.logger.Infof("Installed %s to restore the firewall at boot", FirewallUnit)
+	return nil
+}
+
+func runNft(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, nftCommand[0], append(nftCommand[1:], args...)...)
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/template"
+)
+
+func TestFirewallValidate(t *testing.T) {
+	bad := map[string]FirewallRule{
+		"protocol":   {Protocol: "sctp"},
+		"port icmp":  {Protocol: "icmp", Port: 22},
+		"range":      {Protocol: "tcp", Port: 9000, PortEnd: 8000},
+		"source":     {Protocol: "tcp", Port: 22, Sources: []string{"10.0.0.0/33"}},
+		"interface":  {Protocol: "tcp", Port: 22, Interface: `eth0" accept`},
+		"rate":       {Protocol: "tcp", Port: 22, RateLimit: "10/fortnight"},
+		"burst only": {Protocol: "tcp", Port: 22, Burst: 5},
+		"comment":    {Protocol: "tcp", Port: 22, Comment: `x" drop`},
+		"action":     {Protocol: "tcp", Port: 22, Action: "reject"},
+	}
+	for name, r := range bad {
+		if err := (FirewallConfig{Rules: []FirewallRule{r}}).Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := (FirewallConfig{Policy: "reject"}).Validate(); err == nil {
+		t.Error("expected policy error")
+	}
+	if err := (FirewallConfig{Lockdown: []FirewallPort{{Protocol: "tcp"}}}).Validate(); err == nil {
+		t.Error("expected lockdown port error")
+	}
+	good := FirewallConfig{Rules: []FirewallRule{{Protocol: "tcp", Port: 443, Sources: []string{"10.0.0.1", "2001:db8::/32"}, RateLimit: "100/second", Burst: 20}}}
+	if err := good.Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+}
+
+func TestRenderFirewall(t *testing.T) {
+	got := RenderFirewall(FirewallConfig{
+		Rules: []FirewallRule{
+			{Comment: "listener https", Protocol: "tcp", Port: 443, Interface: "eth0", RateLimit: "100/second", Burst: 20},
+			{Protocol: "tcp", Port: 9901, PortEnd: 9910, Sources: []string{"10.0.0.7", "10.1.0.0/16", "2001:db8::1/64"}},
+			{Protocol: "icmp"},
+		},
+		Lockdown: []FirewallPort{{Protocol: "tcp", Port: 9901}, {Protocol: "tcp", Port: 9902}},
+	})
+	for _, want := range []string{
+		"table inet elchi\ndelete table inet elchi\n",
+		"type filter hook input priority filter; policy accept;",
+		`iifname "eth0" tcp dport 443 ct state new limit rate over 100/second burst 20 packets counter drop comment "listener https"`,
+		`iifname "eth0" tcp dport 443 counter accept comment "listener https"`,
+		"tcp dport 9901-9910 ip saddr { 10.0.0.7/32, 10.1.0.0/16 } counter accept",
+		"tcp dport 9901-9910 ip6 saddr 2001:db8::/64 counter accept",
+		"meta l4proto icmp counter accept",
+		`tcp dport { 9901, 9902 } counter drop comment "lockdown"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("ruleset lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "9901-9910 ip saddr") > strings.Index(got, `comment "lockdown"`) {
+		t.Error("lockdown must come after the allow rules")
+	}
+	if drop := RenderFirewall(FirewallConfig{Policy: "drop"}); !strings.Contains(drop, "nd-neighbor-solicit") {
+		t.Error("drop policy must keep neighbour discovery")
+	}
+}
+
+// fakeNft stands in for nft: -f loads a file as the live table, -c fails when
+// dir/reject exists and list prints the live table.
+func fakeNft(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "nft")
+	body := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %[1]s/calls
+case "$1" in
+  -c) [ -f %[1]s/reject ] && { echo "syntax error"; exit 1; }; exit 0 ;;
+  -f) cp "$2" %[1]s/live ;;
+  list) [ -f %[1]s/live ] || { echo "No such file or directory"; exit 1; }; cat %[1]s/live ;;
+esac
+`, dir)
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldCmd, oldUnit := nftCommand, firewallUnitDir
+	nftCommand = []string{script}
+	firewallUnitDir = t.TempDir()
+	t.Cleanup(func() { nftCommand, firewallUnitDir = oldCmd, oldUnit })
+	return dir
+}
+
+func testFirewallManager(t *testing.T, connected bool) *FirewallManager {
+	t.Helper()
+	logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"})
+	fm := &FirewallManager{dir: t.TempDir(), logger: logger.NewLogger("test")}
+	fm.connected = func(context.Context) bool { return connected }
+	// Pre-install the boot unit so Apply does not shell out to systemctl.
+	unit := fmt.Sprintf(template.FirewallUnitTemplate, filepath.Join(fm.dir, firewallRuleset))
+	if err := os.WriteFile(filepath.Join(firewallUnitDir, FirewallUnit), []byte(unit), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fm
+}
+
+func TestFirewallApplyPersists(t *testing.T) {
+	nft := fakeNft(t)
+	fm := testFirewallManager(t, true)
+
+	cfg := FirewallConfig{Rules: []FirewallRule{{Comment: "ssh", Protocol: "tcp", Port: 22}}}
+	if err := fm.Apply(cfg, FirewallApplyOptions{PreserveControllerConnection: true}); err != nil {
+		t.Fatal(err)
+	}
+	boot, err := os.ReadFile(filepath.Join(fm.dir, firewallRuleset))
+	if err != nil || string(boot) != RenderFirewall(cfg) {
+		t.Errorf("boot ruleset not persisted: %v", err)
+	}
+	got, live, err := fm.Current()
+	if err != nil || len(got.Rules) != 1 || got.Rules[0].Port != 22 || !strings.Contains(live, `comment "ssh"`) {
+		t.Errorf("Current() = %+v, %q, %v", got, live, err)
+	}
+	calls, _ := os.ReadFile(filepath.Join(nft, "calls"))
+	if !strings.HasPrefix(string(calls), "-c -f ") {
+		t.Errorf("ruleset not checked before loading:\n%s", calls)
+	}
+}
+
+func TestFirewallApplyRollsBack(t *testing.T) {
+	nft := fakeNft(t)
+	fm := testFirewallManager(t, true)
+	first := FirewallConfig{Rules: []FirewallRule{{Comment: "ssh", Protocol: "tcp", Port: 22}}}
+	if err := fm.Apply(first, FirewallApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fm.connected = func(context.Context) bool { return false }
+	second := FirewallConfig{Policy: "drop", Rules: []FirewallRule{{Comment: "https", Protocol: "tcp", Port: 443}}}
+	err := fm.Apply(second, FirewallApplyOptions{PreserveControllerConnection: true, TestTimeoutSeconds: 1})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("expected rollback, got %v", err)
+	}
+	live, _ := os.ReadFile(filepath.Join(nft, "live"))
+	if !strings.Contains(string(live), `comment "ssh"`) || strings.Contains(string(live), `comment "https"`) {
+		t.Errorf("previous ruleset not restored:\n%s", live)
+	}
+	boot, _ := os.ReadFile(filepath.Join(fm.dir, firewallRuleset))
+	if string(boot) != RenderFirewall(first) {
+		t.Error("a rolled back ruleset must not be persisted for boot")
+	}
+}
+
+func TestFirewallApplyRejected(t *testing.T) {
+	nft := fakeNft(t)
+	fm := testFirewallManager(t, true)
+	os.WriteFile(filepath.Join(nft, "reject"), nil, 0644)
+
+	if err := fm.Apply(FirewallConfig{}, FirewallApplyOptions{}); err == nil {
+		t.Fatal("expected nft -c to reject the ruleset")
+	}
+	if _, err := os.Stat(filepath.Join(nft, "live")); !os.IsNotExist(err) {
+		t.Error("a rejected ruleset must not be loaded")
+	}
+	if err := fm.Apply(FirewallConfig{Rules: []FirewallRule{{Protocol: "gre"}}}, FirewallApplyOptions{}); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
+
+func TestFirewallRemove(t *testing.T) {
+	nft := fakeNft(t)
+	fm := testFirewallManager(t, true)
+	if err := fm.Apply(FirewallConfig{Rules: []FirewallRule{{Protocol: "tcp", Port: 22}}}, FirewallApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	live, _ := os.ReadFile(filepath.Join(nft, "live"))
+	if strings.Contains(string(live), "dport") {
+		t.Errorf("table not emptied:\n%s", live)
+	}
+	if got, _, _ := fm.Current(); len(got.Rules) != 0 {
+		t.Errorf("rules kept after remove: %+v", got.Rules)
+	}
+}
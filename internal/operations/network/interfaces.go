@@ -0,0 +1,556 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v3"
+)
+
+// InterfaceNetplanPrefix names the netplan file of each typed interface:
+// 50-elchi-if-<name>.yaml. They sort before 90-elchi-if-* (deployment
+// dummies) and 99-elchi-* (raw YAML, routes, policies, VRFs).
+const InterfaceNetplanPrefix = "50-elchi-if-"
+
+// Interface kinds.
+const (
+	KindVLAN   = "vlan"
+	KindBond   = "bond"
+	KindBridge = "bridge"
+)
+
+// VirtualInterface is a VLAN, bond or bridge managed as its own netplan file.
+type VirtualInterface struct {
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Addresses []string `json:"addresses,omitempty"`
+	MTU       int      `json:"mtu,omitempty"`
+	DHCP4     bool     `json:"dhcp4,omitempty"`
+	DHCP6     bool     `json:"dhcp6,omitempty"`
+	// VLAN: the tag and the parent link.
+	ID   int    `json:"id,omitempty"`
+	Link string `json:"link,omitempty"`
+	// Bond and bridge members.
+	Interfaces []string          `json:"interfaces,omitempty"`
+	Bond       *BondParameters   `json:"bond,omitempty"`
+	Bridge     *BridgeParameters `json:"bridge,omitempty"`
+}
+
+type BondParameters struct {
+	Mode string `json:"mode"`
+	// LACPRate ("slow" or "fast") only applies to 802.3ad.
+	LACPRate string `json:"lacp_rate,omitempty"`
+	// TransmitHashPolicy applies to balance-xor, 802.3ad and balance-tlb.
+	TransmitHashPolicy string `json:"transmit_hash_policy,omitempty"`
+	MIIMonitorInterval int    `json:"mii_monitor_interval,omitempty"` // ms
+	Primary            string `json:"primary,omitempty"`
+}
+
+type BridgeParameters struct {
+	STP          bool `json:"stp"`
+	ForwardDelay int  `json:"forward_delay,omitempty"` // seconds
+}
+
+// InterfaceApplyOptions mirror NetplanConfig's safety settings. Typed
+// changes default to the try/rollback flow with connection monitoring.
+type InterfaceApplyOptions struct {
+	TestMode                     bool   `json:"test_mode"`
+	PreserveControllerConnection bool   `json:"preserve_controller_connection"`
+	TestTimeoutSeconds           uint32 `json:"test_timeout_seconds,omitempty"`
+}
+
+// DefaultInterfaceApplyOptions is what a request without options gets.
+func DefaultInterfaceApplyOptions() InterfaceApplyOptions {
+	return InterfaceApplyOptions{TestMode: true, PreserveControllerConnection: true}
+}
+
+// InterfaceStatus is the live netlink view of an interface.
+type InterfaceStatus struct {
+	Present    bool     `json:"present"`
+	Type       string   `json:"type,omitempty"`
+	State      string   `json:"state,omitempty"`
+	OperState  string   `json:"oper_state,omitempty"`
+	MTU        int      `json:"mtu,omitempty"`
+	MacAddress string   `json:"mac_address,omitempty"`
+	Addresses  []string `json:"addresses,omitempty"`
+	Members    []string `json:"members,omitempty"`
+	// VLAN
+	VLANID int    `json:"vlan_id,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	// Bond
+	BondMode           string `json:"bond_mode,omitempty"`
+	LACPRate           string `json:"lacp_rate,omitempty"`
+	TransmitHashPolicy string `json:"transmit_hash_policy,omitempty"`
+}
+
+// InterfaceReport pairs the persisted definition with the live state.
+type InterfaceReport struct {
+	Config VirtualInterface `json:"config"`
+	File   string           `json:"file"`
+	Live   InterfaceStatus  `json:"live"`
+}
+
+var (
+	bondModes = map[string]bool{
+		"balance-rr": true, "active-backup": true, "balance-xor": true, "broadcast": true,
+		"802.3ad": true, "balance-tlb": true, "balance-alb": true,
+	}
+	hashPolicies = map[string]bool{
+		"layer2": true, "layer3+4": true, "layer2+3": true, "encap2+3": true, "encap3+4": true,
+	}
+	hashPolicyModes = map[string]bool{"balance-xor": true, "802.3ad": true, "balance-tlb": true}
+)
+
+type InterfaceManager struct {
+	netplanPath string
+	logger      *logger.Logger
+	grpcConn    *grpc.ClientConn
+	clientID    string
+}
+
+func NewInterfaceManager(logger *logger.Logger) *InterfaceManager {
+	return &InterfaceManager{netplanPath: models.NetplanPath, logger: logger}
+}
+
+// SetGRPCConnection sets the gRPC connection for ping testing
+func (im *InterfaceManager) SetGRPCConnection(conn *grpc.ClientConn, clientID string) {
+	im.grpcConn = conn
+	im.clientID = clientID
+}
+
+func (im *InterfaceManager) fileName(name string) string {
+	return InterfaceNetplanPrefix + name + ".yaml"
+}
+
+func (im *InterfaceManager) netplan(name string) *NetplanManager {
+	nm := newNetplanFileManager(im.logger, im.fileName(name))
+	nm.netplanPath = im.netplanPath
+	nm.configPath = filepath.Join(im.netplanPath, im.fileName(name))
+	nm.backupPath = nm.configPath + NetplanBackupSuffix
+	if im.grpcConn != nil {
+		nm.SetGRPCConnection(im.grpcConn, im.clientID)
+	}
+	return nm
+}
+
+// Apply creates or updates iface: its netplan file is written and applied
+// with the NetplanConfig flow, which rolls the file back on failure.
+func (im *InterfaceManager) Apply(iface VirtualInterface, opts InterfaceApplyOptions) (*InterfaceReport, error) {
+	if err := validateVirtualInterface(iface); err != nil {
+		return nil, fmt.Errorf("invalid interface: %w", err)
+	}
+	if owner := im.definedElsewhere(iface.Name); owner != "" {
+		return nil, fmt.Errorf("%s is already defined in %s", iface.Name, owner)
+	}
+	content, err := renderInterfaceNetplan(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	// networkd cannot change the kind of a device or the mode of a bond in
+	// place; drop the old device so the apply recreates it.
+	im.removeIncompatibleLink(iface)
+
+	nm := im.netplan(iface.Name)
+	if err := nm.ApplyNetplanConfig(&client.NetplanConfig{
+		YamlContent:                  string(content),
+		TestMode:                     opts.TestMode,
+		TestTimeoutSeconds:           opts.TestTimeoutSeconds,
+		PreserveControllerConnection: opts.PreserveControllerConnection,
+	}); err != nil {
+		return nil, err
+	}
+	im.logger.Infof("Applied %s %s from %s", iface.Kind, iface.Name, nm.configPath)
+	return im.Get(iface.Name)
+}
+
+// Delete removes the interface's netplan file, applies, and deletes the
+// device if netplan left it behind. The file is restored if the apply fails
+// or the controller connection is lost.
+func (im *InterfaceManager) Delete(name string, opts InterfaceApplyOptions) error {
+	if !ifacePattern.MatchString(name) {
+		return fmt.Errorf("invalid interface name '%s'", name)
+	}
+	nm := im.netplan(name)
+	if _, err := os.Stat(nm.configPath); os.IsNotExist(err) {
+		return fmt.Errorf("%s is not managed as a typed interface", name)
+	}
+
+	if err := nm.createBackup(); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	if err := os.Remove(nm.configPath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", nm.configPath, err)
+	}
+	var err error
+	if opts.TestMode {
+		err = nm.applyWithTest(&client.NetplanConfig{
+			TestMode:                     true,
+			TestTimeoutSeconds:           opts.TestTimeoutSeconds,
+			PreserveControllerConnection: opts.PreserveControllerConnection,
+		})
+	} else {
+		err = nm.applyDirect()
+	}
+	if err != nil {
+		return err
+	}
+
+	// netplan apply does not delete virtual devices that left the config.
+	netlinkLock.Lock()
+	if link, err := netlink.LinkByName(name); err == nil {
+		if err := netlink.LinkDel(link); err != nil {
+			im.logger.Warnf("Failed to delete %s after removing its netplan file: %v", name, err)
+		}
+	}
+	netlinkLock.Unlock()
+	os.Remove(nm.backupPath)
+
+	im.logger.Infof("Deleted interface %s", name)
+	return nil
+}
+
+// Get returns the persisted definition of name with its live state.
+func (im *InterfaceManager) Get(name string) (*InterfaceReport, error) {
+	path := filepath.Join(im.netplanPath, im.fileName(name))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	iface, err := parseInterfaceNetplan(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &InterfaceReport{Config: iface, File: path, Live: interfaceStatus(iface.Name)}, nil
+}
+
+// List returns every typed interface with its live state.
+func (im *InterfaceManager) List() ([]InterfaceReport, error) {
+	paths, err := filepath.Glob(filepath.Join(im.netplanPath, InterfaceNetplanPrefix+"*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	reports := []InterfaceReport{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), InterfaceNetplanPrefix), ".yaml")
+		report, err := im.Get(name)
+		if err != nil {
+			im.logger.Warnf("Skipping %s: %v", path, err)
+			continue
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// definedElsewhere returns the netplan file other than the interface's own
+// that defines name, if any; netplan would silently merge the two.
+func (im *InterfaceManager) definedElsewhere(name string) string {
+	own := filepath.Join(im.netplanPath, im.fileName(name))
+	paths, _ := filepath.Glob(filepath.Join(im.netplanPath, "*.yaml"))
+	for _, path := range paths {
+		if path == own {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		devices, err := parseNetplanInterfaces(string(data))
+		if err != nil {
+			continue
+		}
+		if _, ok := devices[name]; ok {
+			return path
+		}
+	}
+	return ""
+}
+
+// removeIncompatibleLink deletes a live device whose kind or bond mode
+// differs from iface.
+func (im *InterfaceManager) removeIncompatibleLink(iface VirtualInterface) {
+	netlinkLock.Lock()
+	defer netlinkLock.Unlock()
+
+	link, err := netlink.LinkByName(iface.Name)
+	if err != nil {
+		return
+	}
+	reason := ""
+	switch l := link.(type) {
+	case *netlink.Bond:
+		if iface.Kind != KindBond {
+			reason = "kind changes"
+		} else if l.Mode.String() != iface.Bond.Mode {
+			reason = "bond mode changes from " + l.Mode.String()
+		}
+	case *netlink.Vlan:
+		if iface.Kind != KindVLAN {
+			reason = "kind changes"
+		} else if l.VlanId != iface.ID {
+			reason = fmt.Sprintf("VLAN id changes from %d", l.VlanId)
+		}
+	case *netlink.Bridge:
+		if iface.Kind != KindBridge {
+			reason = "kind changes"
+		}
+	default:
+		reason = "existing " + link.Type() + " device is replaced"
+	}
+	if reason == "" {
+		return
+	}
+	im.logger.Infof("Recreating %s: %s", iface.Name, reason)
+	if err := netlink.LinkDel(link); err != nil {
+		im.logger.Warnf("Failed to delete %s before recreating it: %v", iface.Name, err)
+	}
+}
+
+// validateVirtualInterface checks iface before anything is rendered.
+func validateVirtualInterface(iface VirtualInterface) error {
+	if !ifacePattern.MatchString(iface.Name) || strings.ContainsAny(iface.Name, ":@") {
+		return fmt.Errorf("invalid name '%s'", iface.Name)
+	}
+	if strings.HasPrefix(iface.Name, "elchi-if-") {
+		return fmt.Errorf("elchi-if-* names belong to deployment interfaces")
+	}
+	for _, addr := range iface.Addresses {
+		if _, ok := normalizeCIDR(addr); !ok {
+			return fmt.Errorf("invalid address '%s': want address/prefix", addr)
+		}
+	}
+	if iface.MTU != 0 && (iface.MTU < 68 || iface.MTU > 65535) {
+		return fmt.Errorf("invalid mtu %d", iface.MTU)
+	}
+	seen := make(map[string]bool)
+	for _, member := range iface.Interfaces {
+		if !ifacePattern.MatchString(member) || member == iface.Name || member == "lo" {
+			return fmt.Errorf("invalid member '%s'", member)
+		}
+		if seen[member] {
+			return fmt.Errorf("member %s listed twice", member)
+		}
+		seen[member] = true
+	}
+
+	switch iface.Kind {
+	case KindVLAN:
+		if iface.ID < 1 || iface.ID > 4094 {
+			return fmt.Errorf("vlan id must be 1-4094")
+		}
+		if iface.Link == "" || !ifacePattern.MatchString(iface.Link) || iface.Link == iface.Name {
+			return fmt.Errorf("vlan needs a parent link")
+		}
+		if len(iface.Interfaces) > 0 || iface.Bond != nil || iface.Bridge != nil {
+			return fmt.Errorf("vlan takes no members, bond or bridge parameters")
+		}
+	case KindBond:
+		if len(iface.Interfaces) == 0 {
+			return fmt.Errorf("bond needs at least one member")
+		}
+		b := iface.Bond
+		if b == nil || !bondModes[b.Mode] {
+			return fmt.Errorf("bond mode must be one of balance-rr, active-backup, balance-xor, broadcast, 802.3ad, balance-tlb, balance-alb")
+		}
+		if b.LACPRate != "" && (b.Mode != "802.3ad" || b.LACPRate != "slow" && b.LACPRate != "fast") {
+			return fmt.Errorf("lacp_rate is slow or fast and needs mode 802.3ad")
+		}
+		if b.TransmitHashPolicy != "" && (!hashPolicies[b.TransmitHashPolicy] || !hashPolicyModes[b.Mode]) {
+			return fmt.Errorf("transmit_hash_policy %q is not valid for mode %s", b.TransmitHashPolicy, b.Mode)
+		}
+		if b.MIIMonitorInterval < 0 {
+			return fmt.Errorf("mii_monitor_interval must not be negative")
+		}
+		if b.Primary != "" && !seen[b.Primary] {
+			return fmt.Errorf("primary %s is not a member", b.Primary)
+		}
+		if iface.Link != "" || iface.ID != 0 || iface.Bridge != nil {
+			return fmt.Errorf("bond takes no vlan or bridge parameters")
+		}
+	case KindBridge:
+		if iface.Bridge != nil && (iface.Bridge.ForwardDelay < 0 || iface.Bridge.ForwardDelay > 30) {
+			return fmt.Errorf("forward_delay must be 0-30 seconds")
+		}
+		if iface.Link != "" || iface.ID != 0 || iface.Bond != nil {
+			return fmt.Errorf("bridge takes no vlan or bond parameters")
+		}
+	default:
+		return fmt.Errorf("kind must be vlan, bond or bridge")
+	}
+	return nil
+}
+
+// Netplan rendering
+
+type netplanIfaceFile struct {
+	Network netplanIfaceNetwork `yaml:"network"`
+}
+
+type netplanIfaceNetwork struct {
+	Version  int                          `yaml:"version"`
+	Renderer string                       `yaml:"renderer"`
+	VLANs    map[string]netplanIfaceEntry `yaml:"vlans,omitempty"`
+	Bonds    map[string]netplanIfaceEntry `yaml:"bonds,omitempty"`
+	Bridges  map[string]netplanIfaceEntry `yaml:"bridges,omitempty"`
+}
+
+type netplanIfaceEntry struct {
+	ID         int                `yaml:"id,omitempty"`
+	Link       string             `yaml:"link,omitempty"`
+	Interfaces []string           `yaml:"interfaces,omitempty"`
+	Addresses  []string           `yaml:"addresses,omitempty"`
+	MTU        int                `yaml:"mtu,omitempty"`
+	DHCP4      bool               `yaml:"dhcp4,omitempty"`
+	DHCP6      bool               `yaml:"dhcp6,omitempty"`
+	Parameters *netplanIfaceParam `yaml:"parameters,omitempty"`
+}
+
+type netplanIfaceParam struct {
+	Mode               string `yaml:"mode,omitempty"`
+	LACPRate           string `yaml:"lacp-rate,omitempty"`
+	TransmitHashPolicy string `yaml:"transmit-hash-policy,omitempty"`
+	MIIMonitorInterval int    `yaml:"mii-monitor-interval,omitempty"`
+	Primary            string `yaml:"primary,omitempty"`
+	STP                *bool  `yaml:"stp,omitempty"`
+	ForwardDelay       *int   `yaml:"forward-delay,omitempty"`
+}
+
+// renderInterfaceNetplan renders the 50-elchi-if-<name>.yaml of iface.
+func renderInterfaceNetplan(iface VirtualInterface) ([]byte, error) {
+	entry := netplanIfaceEntry{
+		Addresses:  iface.Addresses,
+		MTU:        iface.MTU,
+		DHCP4:      iface.DHCP4,
+		DHCP6:      iface.DHCP6,
+		Interfaces: iface.Interfaces,
+	}
+	nw := netplanIfaceNetwork{Version: 2, Renderer: "networkd"}
+	switch iface.Kind {
+	case KindVLAN:
+		entry.ID, entry.Link = iface.ID, iface.Link
+		nw.VLANs = map[string]netplanIfaceEntry{iface.Name: entry}
+	case KindBond:
+		entry.Parameters = &netplanIfaceParam{
+			Mode:               iface.Bond.Mode,
+			LACPRate:           iface.Bond.LACPRate,
+			TransmitHashPolicy: iface.Bond.TransmitHashPolicy,
+			MIIMonitorInterval: iface.Bond.MIIMonitorInterval,
+			Primary:            iface.Bond.Primary,
+		}
+		nw.Bonds = map[string]netplanIfaceEntry{iface.Name: entry}
+	case KindBridge:
+		if iface.Bridge != nil {
+			stp, delay := iface.Bridge.STP, iface.Bridge.ForwardDelay
+			entry.Parameters = &netplanIfaceParam{STP: &stp, ForwardDelay: &delay}
+		}
+		nw.Bridges = map[string]netplanIfaceEntry{iface.Name: entry}
+	default:
+		return nil, fmt.Errorf("unknown kind %q", iface.Kind)
+	}
+	data, err := yaml.Marshal(netplanIfaceFile{Network: nw})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal interface config: %w", err)
+	}
+	return data, nil
+}
+
+// parseInterfaceNetplan reads back a file renderInterfaceNetplan wrote.
+func parseInterfaceNetplan(data []byte) (VirtualInterface, error) {
+	var file netplanIfaceFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return VirtualInterface{}, err
+	}
+	sections := []struct {
+		kind    string
+		entries map[string]netplanIfaceEntry
+	}{{KindVLAN, file.Network.VLANs}, {KindBond, file.Network.Bonds}, {KindBridge, file.Network.Bridges}}
+
+	var found []VirtualInterface
+	for _, section := range sections {
+		for name, e := range section.entries {
+			iface := VirtualInterface{
+				Name: name, Kind: section.kind,
+				Addresses: e.Addresses, MTU: e.MTU, DHCP4: e.DHCP4, DHCP6: e.DHCP6,
+				ID: e.ID, Link: e.Link, Interfaces: e.Interfaces,
+			}
+			p := e.Parameters
+			switch {
+			case section.kind == KindBond && p != nil:
+				iface.Bond = &BondParameters{
+					Mode: p.Mode, LACPRate: p.LACPRate, TransmitHashPolicy: p.TransmitHashPolicy,
+					MIIMonitorInterval: p.MIIMonitorInterval, Primary: p.Primary,
+				}
+			case section.kind == KindBridge && p != nil:
+				iface.Bridge = &BridgeParameters{}
+				if p.STP != nil {
+					iface.Bridge.STP = *p.STP
+				}
+				if p.ForwardDelay != nil {
+					iface.Bridge.ForwardDelay = *p.ForwardDelay
+				}
+			}
+			found = append(found, iface)
+		}
+	}
+	if len(found) != 1 {
+		return VirtualInterface{}, fmt.Errorf("expected one vlan, bond or bridge, found %d", len(found))
+	}
+	return found[0], nil
+}
+
+// interfaceStatus reads the live state of name from netlink.
+func interfaceStatus(name string) InterfaceStatus {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return InterfaceStatus{}
+	}
+	attrs := link.Attrs()
+	status := InterfaceStatus{
+		Present:    true,
+		Type:       link.Type(),
+		State:      "down",
+		OperState:  attrs.OperState.String(),
+		MTU:        attrs.MTU,
+		MacAddress: attrs.HardwareAddr.String(),
+	}
+	if attrs.Flags&net.FlagUp != 0 {
+		status.State = "up"
+	}
+	if addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL); err == nil {
+		for _, addr := range addrs {
+			status.Addresses = append(status.Addresses, addr.IPNet.String())
+		}
+	}
+	for _, member := range linkMembers(attrs.Index) {
+		status.Members = append(status.Members, member.Attrs().Name)
+	}
+	sort.Strings(status.Members)
+
+	switch l := link.(type) {
+	case *netlink.Vlan:
+		status.VLANID = l.VlanId
+		if parent, err := netlink.LinkByIndex(attrs.ParentIndex); err == nil {
+			status.Parent = parent.Attrs().Name
+		}
+	case *netlink.Bond:
+		status.BondMode = l.Mode.String()
+		if l.Mode == netlink.BOND_MODE_802_3AD {
+			status.LACPRate = l.LacpRate.String()
+		}
+		if hashPolicyModes[status.BondMode] {
+			status.TransmitHashPolicy = l.XmitHashPolicy.String()
+		}
+	}
+	return status
+}
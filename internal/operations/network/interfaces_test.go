@@ -0,0 +1,132 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+func testBond() VirtualInterface {
+	return VirtualInterface{
+		Name: "bond0", Kind: KindBond, Addresses: []string{"10.0.0.5/24"}, MTU: 9000,
+		Interfaces: []string{"eth1", "eth2"},
+		Bond:       &BondParameters{Mode: "802.3ad", LACPRate: "fast", TransmitHashPolicy: "layer3+4", MIIMonitorInterval: 100},
+	}
+}
+
+func TestValidateVirtualInterface(t *testing.T) {
+	valid := []VirtualInterface{
+		testBond(),
+		{Name: "vlan100", Kind: KindVLAN, ID: 100, Link: "bond0", Addresses: []string{"2001:db8::1/64"}},
+		{Name: "br0", Kind: KindBridge, Interfaces: []string{"eth3"}, Bridge: &BridgeParameters{STP: true, ForwardDelay: 4}},
+		{Name: "br1", Kind: KindBridge},
+	}
+	for _, iface := range valid {
+		if err := validateVirtualInterface(iface); err != nil {
+			t.Errorf("%s rejected: %v", iface.Name, err)
+		}
+	}
+
+	bad := map[string]func(*VirtualInterface){
+		"kind":            func(i *VirtualInterface) { i.Kind = "tunnel" },
+		"name":            func(i *VirtualInterface) { i.Name = "bond 0" },
+		"deployment name": func(i *VirtualInterface) { i.Name = "elchi-if-1" },
+		"address":         func(i *VirtualInterface) { i.Addresses = []string{"10.0.0.5"} },
+		"mtu":             func(i *VirtualInterface) { i.MTU = 20 },
+		"no members":      func(i *VirtualInterface) { i.Interfaces = nil },
+		"self member":     func(i *VirtualInterface) { i.Interfaces = []string{"bond0"} },
+		"duplicate":       func(i *VirtualInterface) { i.Interfaces = []string{"eth1", "eth1"} },
+		"mode":            func(i *VirtualInterface) { i.Bond.Mode = "lacp" },
+		"lacp mode":       func(i *VirtualInterface) { i.Bond.Mode = "active-backup"; i.Bond.TransmitHashPolicy = "" },
+		"lacp rate":       func(i *VirtualInterface) { i.Bond.LACPRate = "medium" },
+		"hash policy":     func(i *VirtualInterface) { i.Bond.TransmitHashPolicy = "layer4" },
+		"primary":         func(i *VirtualInterface) { i.Bond.Primary = "eth9" },
+		"vlan on bond":    func(i *VirtualInterface) { i.ID = 10 },
+		"no params":       func(i *VirtualInterface) { i.Bond = nil },
+	}
+	for name, mutate := range bad {
+		iface := testBond()
+		b := *iface.Bond
+		iface.Bond = &b
+		mutate(&iface)
+		if err := validateVirtualInterface(iface); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	for _, iface := range []VirtualInterface{
+		{Name: "vlan0", Kind: KindVLAN, ID: 0, Link: "eth0"},
+		{Name: "vlan5000", Kind: KindVLAN, ID: 5000, Link: "eth0"},
+		{Name: "vlan10", Kind: KindVLAN, ID: 10},
+		{Name: "br0", Kind: KindBridge, Bridge: &BridgeParameters{ForwardDelay: 60}},
+	} {
+		if err := validateVirtualInterface(iface); err == nil {
+			t.Errorf("%+v: expected an error", iface)
+		}
+	}
+}
+
+func TestInterfaceNetplanRoundTrip(t *testing.T) {
+	for _, iface := range []VirtualInterface{
+		testBond(),
+		{Name: "vlan100", Kind: KindVLAN, ID: 100, Link: "bond0", DHCP4: true},
+		{Name: "br0", Kind: KindBridge, Interfaces: []string{"eth3"}, Bridge: &BridgeParameters{STP: false}},
+	} {
+		data, err := renderInterfaceNetplan(iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseInterfaceNetplan(data)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", iface.Name, err, data)
+		}
+		if !reflect.DeepEqual(got, iface) {
+			t.Errorf("round trip of %s:\n got %+v\nwant %+v\n%s", iface.Name, got, iface, data)
+		}
+	}
+
+	data, _ := renderInterfaceNetplan(testBond())
+	for _, want := range []string{"bonds:", "mode: 802.3ad", "lacp-rate: fast", "transmit-hash-policy: layer3+4", "mii-monitor-interval: 100"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("bond netplan lacks %q:\n%s", want, data)
+		}
+	}
+	if _, err := parseInterfaceNetplan([]byte("network:\n  version: 2\n")); err == nil {
+		t.Error("a file without an interface must not parse")
+	}
+}
+
+func TestInterfaceManagerListAndConflicts(t *testing.T) {
+	logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"})
+	dir := t.TempDir()
+	im := &InterfaceManager{netplanPath: dir, logger: logger.NewLogger("test")}
+
+	os.WriteFile(filepath.Join(dir, NetplanConfigFile), []byte(currentNetplan), 0600)
+	bond, _ := renderInterfaceNetplan(testBond())
+	os.WriteFile(filepath.Join(dir, InterfaceNetplanPrefix+"bond0.yaml"), bond, 0600)
+
+	if owner := im.definedElsewhere("vlan100"); owner != filepath.Join(dir, NetplanConfigFile) {
+		t.Errorf("vlan100 defined in %q", owner)
+	}
+	if owner := im.definedElsewhere("bond0"); owner != "" {
+		t.Errorf("bond0's own file must not count as a conflict: %q", owner)
+	}
+	if _, err := im.Apply(VirtualInterface{Name: "vlan100", Kind: KindVLAN, ID: 100, Link: "eth0"}, InterfaceApplyOptions{}); err == nil ||
+		!strings.Contains(err.Error(), "already defined") {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+
+	list, err := im.List()
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List = %+v, %v", list, err)
+	}
+	if list[0].Config.Name != "bond0" || list[0].Config.Bond.Mode != "802.3ad" || list[0].Live.Present {
+		t.Errorf("bond0 report = %+v", list[0])
+	}
+	if _, err := im.Get("br9"); !os.IsNotExist(err) {
+		t.Errorf("Get(br9) = %v", err)
+	}
+}
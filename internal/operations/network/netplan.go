@@ -34,6 +34,15 @@ type NetplanManager struct {
 	monitor     *ConnectionMonitor
 	grpcConn    *grpc.ClientConn
 	clientID    string
+	// scratchValidate validates against the other netplan files too, for
+	// files that reference devices defined elsewhere (VLAN links, members).
+	scratchValidate bool
+	// noPrevious is set by createBackup when the file did not exist, so a
+	// rollback removes it instead of restoring a stale backup.
+	noPrevious bool
+	// restorePrevious replaces the single-file restore of rollback for
+	// changes that span several netplan files (snapshot restore).
+	restorePrevious func() error
 }
 
 func NewNetplanManager(logger *logger.Logger) *NetplanManager {
@@ -50,6 +59,16 @@ func NewNetplanManager(logger *logger.Logger) *NetplanManager {
 	}
 }
 
+// newNetplanFileManager manages one Elchi netplan file other than
+// NetplanConfigFile with the same backup, try and rollback flow.
+func newNetplanFileManager(logger *logger.Logger, file string) *NetplanManager {
+	nm := NewNetplanManager(logger)
+	nm.configPath = filepath.Join(nm.netplanPath, file)
+	nm.backupPath = nm.configPath + NetplanBackupSuffix
+	nm.scratchValidate = true
+	return nm
+}
+
 // SetGRPCConnection sets the gRPC connection for ping testing
 func (nm *NetplanManager) SetGRPCConnection(conn *grpc.ClientConn, clientID string) {
 	nm.grpcConn = conn
@@ -108,8 +127,10 @@ func (nm *NetplanManager) ApplyNetplanConfig(config *client.NetplanConfig) error
 // createBackup creates backup of current netplan configuration
 func (nm *NetplanManager) createBackup() error {
 	nm.logger.Debugf("Checking for existing config: %s", nm.configPath)
+	nm.noPrevious = false
 	if _, err := os.Stat(nm.configPath); os.IsNotExist(err) {
 		nm.logger.Debug("No existing config to backup")
+		nm.noPrevious = true
 		return nil
 	}
 
@@ -308,6 +329,29 @@ func (nm *NetplanManager) rollback() error {
 	nm.logger.Warn("Rolling back to previous netplan configuration")
 	nm.logger.Debugf("Checking for backup file: %s", nm.backupPath)
 
+	if nm.restorePrevious != nil {
+		if err := nm.restorePrevious(); err != nil {
+			return fmt.Errorf("failed to restore previous netplan files: %w", err)
+		}
+		if err := nm.executeNetplanApply(); err != nil {
+			return fmt.Errorf("rollback apply failed: %w", err)
+		}
+		nm.logger.Info("Configuration successfully rolled back")
+		return nil
+	}
+
+	if nm.noPrevious {
+		// The file was created by the change being rolled back.
+		if err := os.Remove(nm.configPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", nm.configPath, err)
+		}
+		if err := nm.executeNetplanApply(); err != nil {
+			return fmt.Errorf("rollback apply failed: %w", err)
+		}
+		nm.logger.Info("Configuration successfully rolled back")
+		return nil
+	}
+
 	if _, err := os.Stat(nm.backupPath); os.IsNotExist(err) {
 		nm.logger.Debug("No backup file found for rollback")
 		return fmt.Errorf("no backup file found for rollback")
@@ -377,6 +421,14 @@ func (nm *NetplanManager) ValidateConfig(yamlContent string) error {
 		return err
 	}
 
+	if nm.scratchValidate {
+		result, _ := nm.generateScratch(yamlContent)
+		if !result.OK {
+			return fmt.Errorf("config validation failed: %s", result.Output)
+		}
+		return nil
+	}
+
 	// Create temporary file for validation
 	nm.logger.Debug("Creating temporary file for config validation")
 	tmpFile, err := os.CreateTemp("", "netplan-validate-*.yaml")
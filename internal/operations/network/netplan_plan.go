@@ -0,0 +1,592 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/vishvananda/netlink"
+	"gopkg.in/yaml.v3"
+)
+
+// netplanCommand is the netplan binary the plan runs generate with.
+var netplanCommand = "netplan"
+
+// maxGeneratedFileBytes caps each rendered backend file returned in a plan.
+const maxGeneratedFileBytes = 64 << 10
+
+// NetplanPlan is what ApplyNetplanConfig would change, computed without
+// touching /etc/netplan.
+type NetplanPlan struct {
+	File    string `json:"file"`
+	Changed bool   `json:"changed"`
+	// Diff is a unified diff of the managed file, current to proposed.
+	Diff      string          `json:"diff"`
+	Generate  NetplanGenerate `json:"generate"`
+	Links     []LinkChange    `json:"links"`
+	Addresses []AddressChange `json:"addresses"`
+	Routes    []RouteChange   `json:"routes"`
+	Warnings  []string        `json:"warnings,omitempty"`
+}
+
+// NetplanGenerate is the outcome of `netplan generate` into a scratch root
+// holding the proposed file next to the other netplan files.
+type NetplanGenerate struct {
+	OK     bool   `json:"ok"`
+	Output string `json:"output,omitempty"`
+	// Files are the rendered backend files (systemd-networkd units, ...)
+	// keyed by their path under the root.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// Plan actions.
+const (
+	PlanCreate = "create"
+	PlanDelete = "delete"
+	PlanModify = "modify"
+	PlanAdd    = "add"
+	PlanRemove = "remove"
+)
+
+// LinkChange is a predicted change to a network device.
+type LinkChange struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// AddressChange is a predicted address added to or removed from a link.
+type AddressChange struct {
+	Interface string `json:"interface"`
+	Address   string `json:"address"`
+	Action    string `json:"action"`
+}
+
+// RouteChange is a predicted route change: a connected route that follows
+// from a link or address change, or a route netplan renders (from the route
+// files next to the managed one) that the kernel does not have yet.
+type RouteChange struct {
+	Interface   string `json:"interface"`
+	Destination string `json:"destination"`
+	Table       int    `json:"table,omitempty"`
+	Action      string `json:"action"`
+	Reason      string `json:"reason"`
+}
+
+// PlanNetplanConfig validates yamlContent like ApplyNetplanConfig and reports
+// the file diff, the rendered backend config and the predicted link, address
+// and route changes against the live netlink state.
+func (nm *NetplanManager) PlanNetplanConfig(yamlContent string) (*NetplanPlan, error) {
+	if yamlContent == "" {
+		return nil, fmt.Errorf("netplan YAML content is empty")
+	}
+	if err := nm.validateForbiddenConfigurations(yamlContent); err != nil {
+		return nil, fmt.Errorf("invalid netplan configuration: %w", err)
+	}
+	proposed, err := parseNetplanInterfaces(yamlContent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid netplan configuration: %w", err)
+	}
+
+	current, err := nm.GetCurrentConfig()
+	if err != nil {
+		return nil, err
+	}
+	plan := &NetplanPlan{
+		File:      nm.configPath,
+		Changed:   current != yamlContent,
+		Links:     []LinkChange{},
+		Addresses: []AddressChange{},
+		Routes:    []RouteChange{},
+	}
+	plan.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(current),
+		B:        difflib.SplitLines(yamlContent),
+		FromFile: nm.configPath,
+		ToFile:   nm.configPath + " (proposed)",
+		Context:  3,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff netplan config: %w", err)
+	}
+
+	var warnings []string
+	plan.Generate, warnings = nm.generateScratch(yamlContent)
+	plan.Warnings = append(plan.Warnings, warnings...)
+
+	previous, err := parseNetplanInterfaces(current)
+	if err != nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("current %s does not parse (%v); removals are not predicted", nm.configPath, err))
+		previous = nil
+	}
+	links, routes, err := liveNetworkState()
+	if err != nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("netlink state unavailable: %v", err))
+		return plan, nil
+	}
+	predictNetplanChanges(plan, proposed, previous, links, routes)
+	if plan.Generate.OK {
+		predictRenderedRoutes(plan, networkdRoutes(plan.Generate.Files), routes)
+	} else {
+		plan.Warnings = append(plan.Warnings, "netplan generate failed; only connected routes are predicted")
+	}
+	nm.logger.Debugf("Netplan plan: %d link, %d address, %d route changes",
+		len(plan.Links), len(plan.Addresses), len(plan.Routes))
+	return plan, nil
+}
+
+// generateScratch runs netplan generate with --root pointing at a scratch
+// copy of the netplan directory where the managed file is the proposed one.
+func (nm *NetplanManager) generateScratch(yamlContent string) (NetplanGenerate, []string) {
+	files, warnings := readNetplanFiles(nm.netplanPath, func(name string) bool {
+		return name != filepath.Base(nm.configPath)
+	})
+	files[filepath.Base(nm.configPath)] = []byte(yamlContent)
+	return generateNetplanRoot(files), warnings
+}
+
+// readNetplanFiles reads the *.yaml files of dir that keep accepts, keyed by
+// file name. Unreadable files are left out with a warning.
+func readNetplanFiles(dir string, keep func(name string) bool) (map[string][]byte, []string) {
+	var warnings []string
+	files := make(map[string][]byte)
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	for _, path := range paths {
+		if !keep(filepath.Base(path)) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s left out of netplan generate: %v", path, err))
+			continue
+		}
+		files[filepath.Base(path)] = data
+	}
+	return files, warnings
+}
+
+// generateNetplanRoot runs netplan generate over files (name to content) laid
+// out in a scratch root's etc/netplan.
+func generateNetplanRoot(files map[string][]byte) NetplanGenerate {
+	root, err := os.MkdirTemp("", "netplan-plan-*")
+	if err != nil {
+		return NetplanGenerate{Output: err.Error()}
+	}
+	defer os.RemoveAll(root)
+
+	confDir := filepath.Join(root, "etc", "netplan")
+	if err := os.MkdirAll(confDir, 0700); err != nil {
+		return NetplanGenerate{Output: err.Error()}
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(confDir, name), data, 0600); err != nil {
+			return NetplanGenerate{Output: err.Error()}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, netplanCommand, "generate", "--root", root).CombinedOutput()
+	result := NetplanGenerate{OK: err == nil, Output: strings.TrimSpace(string(output))}
+	if err != nil {
+		if result.Output == "" {
+			result.Output = err.Error()
+		}
+		return result
+	}
+
+	result.Files = make(map[string]string)
+	filepath.WalkDir(filepath.Join(root, "run"), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		if len(data) > maxGeneratedFileBytes {
+			data = append(data[:maxGeneratedFileBytes], "\n... truncated\n"...)
+		}
+		rel, _ := filepath.Rel(root, path)
+		result.Files["/"+rel] = string(data)
+		return nil
+	})
+	return result
+}
+
+// netplanIface is the part of a netplan device definition the plan compares.
+type netplanIface struct {
+	Name      string
+	Kind      string
+	Addresses []string
+	MTU       int
+	DHCP      bool
+	Members   []string
+}
+
+// virtualKinds are the netplan sections whose devices netplan creates.
+var virtualKinds = map[string]bool{
+	"vlans": true, "bonds": true, "bridges": true, "vrfs": true,
+	"tunnels": true, "dummy-devices": true, "virtual-ethernets": true,
+}
+
+// netplanSections maps netplan sections to the link kind reported in plans.
+var netplanSections = map[string]string{
+	"ethernets": "ethernet", "wifis": "wifi", "modems": "modem",
+	"vlans": "vlan", "bonds": "bond", "bridges": "bridge", "vrfs": "vrf",
+	"tunnels": "tunnel", "dummy-devices": "dummy", "virtual-ethernets": "veth",
+}
+
+type netplanDevice struct {
+	Addresses []yaml.Node `yaml:"addresses"`
+	MTU       int         `yaml:"mtu"`
+	DHCP4     bool        `yaml:"dhcp4"`
+	DHCP6     bool        `yaml:"dhcp6"`
+	SetName   string      `yaml:"set-name"`
+	// Interfaces are bond, bridge and vrf members.
+	Interfaces []string `yaml:"interfaces"`
+}
+
+// parseNetplanInterfaces returns the devices of a netplan document keyed by
+// their interface name (set-name when given). An empty document has none.
+func parseNetplanInterfaces(content string) (map[string]netplanIface, error) {
+	var doc struct {
+		Network map[string]yaml.Node `yaml:"network"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, err
+	}
+	out := make(map[string]netplanIface)
+	for section, node := range doc.Network {
+		kind, ok := netplanSections[section]
+		if !ok {
+			continue
+		}
+		var devices map[string]netplanDevice
+		if err := node.Decode(&devices); err != nil {
+			return nil, fmt.Errorf("network.%s: %w", section, err)
+		}
+		for id, dev := range devices {
+			iface := netplanIface{
+				Name:    id,
+				Kind:    kind,
+				MTU:     dev.MTU,
+				DHCP:    dev.DHCP4 || dev.DHCP6,
+				Members: dev.Interfaces,
+			}
+			if dev.SetName != "" {
+				iface.Name = dev.SetName
+			}
+			for _, a := range dev.Addresses {
+				// An address is a scalar or a one-key map carrying options.
+				addr := a.Value
+				if a.Kind == yaml.MappingNode && len(a.Content) > 0 {
+					addr = a.Content[0].Value
+				}
+				if norm, ok := normalizeCIDR(addr); ok {
+					iface.Addresses = append(iface.Addresses, norm)
+				}
+			}
+			out[iface.Name] = iface
+		}
+	}
+	return out, nil
+}
+
+// liveLink is the part of a kernel link the plan compares.
+type liveLink struct {
+	Kind      string
+	MTU       int
+	Master    string
+	Addresses []string
+}
+
+// liveRoute is a kernel route with its output interface.
+type liveRoute struct {
+	Interface   string
+	Destination string
+	Table       int
+}
+
+func liveNetworkState() (map[string]liveLink, []liveRoute, error) {
+	linkList, err := netlink.LinkList()
+	if err != nil {
+		return nil, nil, err
+	}
+	byIndex := make(map[int]string, len(linkList))
+	for _, l := range linkList {
+		byIndex[l.Attrs().Index] = l.Attrs().Name
+	}
+	links := make(map[string]liveLink, len(linkList))
+	for _, l := range linkList {
+		attrs := l.Attrs()
+		ll := liveLink{Kind: l.Type(), MTU: attrs.MTU, Master: byIndex[attrs.MasterIndex]}
+		addrs, _ := netlink.AddrList(l, netlink.FAMILY_ALL)
+		for _, a := range addrs {
+			if a.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ll.Addresses = append(ll.Addresses, a.IPNet.String())
+		}
+		links[attrs.Name] = ll
+	}
+
+	routeList, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: 0}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return links, nil, nil
+	}
+	var routes []liveRoute
+	for _, r := range routeList {
+		if r.Scope == netlink.SCOPE_HOST || r.Table == 255 || byIndex[r.LinkIndex] == "" {
+			continue
+		}
+		dst := "default"
+		if r.Dst != nil {
+			dst = r.Dst.String()
+		}
+		routes = append(routes, liveRoute{Interface: byIndex[r.LinkIndex], Destination: dst, Table: r.Table})
+	}
+	return links, routes, nil
+}
+
+// predictNetplanChanges fills plan with the changes applying proposed would
+// make to links, compared with the previously managed definitions.
+func predictNetplanChanges(plan *NetplanPlan, proposed, previous map[string]netplanIface, links map[string]liveLink, routes []liveRoute) {
+	for _, name := range sortedKeys(proposed) {
+		want := proposed[name]
+		have, exists := links[name]
+		if !exists {
+			if !virtualKinds[kindSection(want.Kind)] {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s %s does not exist on this host", want.Kind, name))
+				continue
+			}
+			plan.Links = append(plan.Links, LinkChange{Name: name, Kind: want.Kind, Action: PlanCreate})
+		} else if want.MTU > 0 && want.MTU != have.MTU {
+			plan.Links = append(plan.Links, LinkChange{Name: name, Kind: want.Kind, Action: PlanModify,
+				Detail: fmt.Sprintf("mtu %d -> %d", have.MTU, want.MTU)})
+		}
+		for _, member := range want.Members {
+			if l, ok := links[member]; !ok || l.Master != name {
+				plan.Links = append(plan.Links, LinkChange{Name: member, Kind: "member", Action: PlanModify,
+					Detail: "enslave to " + name})
+			}
+		}
+
+		wantAddrs := toSet(want.Addresses)
+		haveAddrs := toSet(have.Addresses)
+		for _, a := range want.Addresses {
+			if haveAddrs[a] {
+				continue
+			}
+			plan.Addresses = append(plan.Addresses, AddressChange{Interface: name, Address: a, Action: PlanAdd})
+			if dst, ok := connectedPrefix(a); ok && !hasRoute(routes, name, dst) && !coversPrefix(plan, name, dst) {
+				plan.Routes = append(plan.Routes, RouteChange{Interface: name, Destination: dst, Table: 254,
+					Action: PlanAdd, Reason: "connected route of " + a})
+			}
+		}
+		if want.DHCP {
+			// DHCP leases cannot be told apart from static addresses.
+			continue
+		}
+		for _, a := range have.Addresses {
+			if wantAddrs[a] {
+				continue
+			}
+			plan.Addresses = append(plan.Addresses, AddressChange{Interface: name, Address: a, Action: PlanRemove})
+			// The prefix route stays while another address keeps it.
+			if dst, ok := connectedPrefix(a); ok && hasRoute(routes, name, dst) && !prefixKept(want.Addresses, dst) {
+				plan.Routes = append(plan.Routes, RouteChange{Interface: name, Destination: dst, Table: 254,
+					Action: PlanRemove, Reason: "connected route of " + a})
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(previous) {
+		old := previous[name]
+		if _, kept := proposed[name]; kept {
+			continue
+		}
+		if _, exists := links[name]; !exists {
+			continue
+		}
+		if !virtualKinds[kindSection(old.Kind)] {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s %s is no longer managed; its addresses stay until it is reconfigured", old.Kind, name))
+			continue
+		}
+		plan.Links = append(plan.Links, LinkChange{Name: name, Kind: old.Kind, Action: PlanDelete})
+		for _, r := range routes {
+			if r.Interface == name {
+				plan.Routes = append(plan.Routes, RouteChange{Interface: name, Destination: r.Destination, Table: r.Table,
+					Action: PlanRemove, Reason: "link deleted"})
+			}
+		}
+	}
+}
+
+// networkdRoutes returns the [Route] sections of the rendered networkd files
+// with the interface their [Match] names.
+func networkdRoutes(files map[string]string) []liveRoute {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		if strings.HasSuffix(path, ".network") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var out []liveRoute
+	for _, path := range paths {
+		var iface, section string
+		var routes []liveRoute
+		for _, line := range strings.Split(files[path], "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+				section = strings.Trim(line, "[]")
+				if section == "Route" {
+					routes = append(routes, liveRoute{Destination: "default", Table: 254})
+				}
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			switch {
+			case section == "Match" && key == "Name" && iface == "":
+				if fields := strings.Fields(value); len(fields) > 0 {
+					iface = fields[0]
+				}
+			case section == "Route" && key == "Destination":
+				routes[len(routes)-1].Destination = planRouteDst(value)
+			case section == "Route" && key == "Table":
+				if table, err := strconv.Atoi(value); err == nil {
+					routes[len(routes)-1].Table = table
+				}
+			}
+		}
+		if iface == "" {
+			continue
+		}
+		for _, r := range routes {
+			r.Interface = iface
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// predictRenderedRoutes adds the rendered routes the kernel lacks; netplan
+// apply brings them up with their links.
+func predictRenderedRoutes(plan *NetplanPlan, rendered, routes []liveRoute) {
+	for _, want := range rendered {
+		present := false
+		for _, r := range routes {
+			if r.Interface == want.Interface && r.Table == want.Table && planRouteDst(r.Destination) == want.Destination {
+				present = true
+				break
+			}
+		}
+		if present || coversPrefix(plan, want.Interface, want.Destination) {
+			continue
+		}
+		plan.Routes = append(plan.Routes, RouteChange{Interface: want.Interface, Destination: want.Destination, Table: want.Table,
+			Action: PlanAdd, Reason: "route rendered by netplan generate"})
+	}
+}
+
+// planRouteDst spells a destination like liveNetworkState does.
+func planRouteDst(dst string) string {
+	switch p := canonicalPrefix(strings.TrimSpace(dst)); p {
+	case "0.0.0.0/0", "::/0":
+		return "default"
+	default:
+		return p
+	}
+}
+
+func kindSection(kind string) string {
+	for section, k := range netplanSections {
+		if k == kind {
+			return section
+		}
+	}
+	return ""
+}
+
+// normalizeCIDR canonicalises an address with prefix ("10.0.0.5/24").
+func normalizeCIDR(s string) (string, bool) {
+	ip, ipnet, err := net.ParseCIDR(strings.TrimSpace(s))
+	if err != nil {
+		return "", false
+	}
+	ones, _ := ipnet.Mask.Size()
+	return fmt.Sprintf("%s/%d", ip, ones), true
+}
+
+// connectedPrefix is the on-link route the kernel adds for an address; host
+// addresses (/32, /128) have none.
+func connectedPrefix(cidr string) (string, bool) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", false
+	}
+	ones, bits := ipnet.Mask.Size()
+	if ones == bits {
+		return "", false
+	}
+	return ipnet.String(), true
+}
+
+func hasRoute(routes []liveRoute, iface, dst string) bool {
+	for _, r := range routes {
+		if r.Interface == iface && r.Destination == dst {
+			return true
+		}
+	}
+	return false
+}
+
+// coversPrefix reports whether plan already adds the route to dst on iface.
+func coversPrefix(plan *NetplanPlan, iface, dst string) bool {
+	for _, r := range plan.Routes {
+		if r.Interface == iface && r.Destination == dst && r.Action == PlanAdd {
+			return true
+		}
+	}
+	return false
+}
+
+func prefixKept(addrs []string, dst string) bool {
+	for _, a := range addrs {
+		if p, ok := connectedPrefix(a); ok && p == dst {
+			return true
+		}
+	}
+	return false
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+func sortedKeys(m map[string]netplanIface) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,197 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+const currentNetplan = `network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    eth0:
+      addresses: [10.0.0.5/24, 10.0.0.6/24]
+  vlans:
+    vlan100:
+      id: 100
+      link: eth0
+      addresses: [192.168.100.2/24]
+`
+
+const proposedNetplan = `network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    eth0:
+      mtu: 9000
+      addresses:
+        - 10.0.0.5/24
+        - 10.0.1.5/24:
+            label: eth0:1
+  bonds:
+    bond0:
+      interfaces: [eth1, eth2]
+      addresses: [172.16.0.1/32]
+`
+
+func TestParseNetplanInterfaces(t *testing.T) {
+	got, err := parseNetplanInterfaces(proposedNetplan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth0 := got["eth0"]
+	if eth0.Kind != "ethernet" || eth0.MTU != 9000 || strings.Join(eth0.Addresses, ",") != "10.0.0.5/24,10.0.1.5/24" {
+		t.Errorf("eth0 = %+v", eth0)
+	}
+	if bond := got["bond0"]; bond.Kind != "bond" || strings.Join(bond.Members, ",") != "eth1,eth2" {
+		t.Errorf("bond0 = %+v", bond)
+	}
+	if empty, err := parseNetplanInterfaces(""); err != nil || len(empty) != 0 {
+		t.Errorf("empty document: %v, %v", empty, err)
+	}
+}
+
+func TestPredictNetplanChanges(t *testing.T) {
+	proposed, _ := parseNetplanInterfaces(proposedNetplan)
+	previous, _ := parseNetplanInterfaces(currentNetplan)
+	links := map[string]liveLink{
+		"eth0":    {Kind: "device", MTU: 1500, Addresses: []string{"10.0.0.5/24", "10.0.0.6/24"}},
+		"eth1":    {Kind: "device", MTU: 1500},
+		"eth2":    {Kind: "device", MTU: 1500, Master: "bond0"},
+		"vlan100": {Kind: "vlan", MTU: 1500, Addresses: []string{"192.168.100.2/24"}},
+	}
+	routes := []liveRoute{
+		{Interface: "eth0", Destination: "10.0.0.0/24", Table: 254},
+		{Interface: "vlan100", Destination: "192.168.100.0/24", Table: 254},
+		{Interface: "vlan100", Destination: "10.50.0.0/16", Table: 100},
+	}
+
+	plan := &NetplanPlan{}
+	predictNetplanChanges(plan, proposed, previous, links, routes)
+
+	var got []string
+	for _, l := range plan.Links {
+		got = append(got, "link "+l.Action+" "+l.Name+" "+l.Detail)
+	}
+	for _, a := range plan.Addresses {
+		got = append(got, "addr "+a.Action+" "+a.Address+" "+a.Interface)
+	}
+	for _, r := range plan.Routes {
+		got = append(got, "route "+r.Action+" "+r.Destination+" "+r.Interface)
+	}
+	want := []string{
+		"link create bond0 ",
+		"link modify eth1 enslave to bond0",
+		"link modify eth0 mtu 1500 -> 9000",
+		"link delete vlan100 ",
+		"addr add 172.16.0.1/32 bond0",
+		"addr add 10.0.1.5/24 eth0",
+		"addr remove 10.0.0.6/24 eth0",
+		// 10.0.0.0/24 stays: 10.0.0.5/24 keeps it after 10.0.0.6 goes.
+		"route add 10.0.1.0/24 eth0",
+		"route remove 192.168.100.0/24 vlan100",
+		"route remove 10.50.0.0/16 vlan100",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plan:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(plan.Warnings) != 0 {
+		t.Errorf("warnings = %v", plan.Warnings)
+	}
+}
+
+func TestPredictWarnsAboutMissingEthernet(t *testing.T) {
+	proposed, _ := parseNetplanInterfaces("network:\n  ethernets:\n    eth9:\n      addresses: [10.9.0.1/24]\n")
+	plan := &NetplanPlan{}
+	predictNetplanChanges(plan, proposed, nil, map[string]liveLink{}, nil)
+	if len(plan.Links) != 0 || len(plan.Warnings) != 1 || !strings.Contains(plan.Warnings[0], "eth9") {
+		t.Errorf("plan = %+v", plan)
+	}
+}
+
+func TestPredictRenderedRoutes(t *testing.T) {
+	files := map[string]string{
+		"/run/systemd/network/10-netplan-eth0.network": `[Match]
+Name=eth0
+
+[Network]
+Address=10.0.0.5/24
+
+[Route]
+Destination=10.20.0.0/16
+Gateway=10.0.0.1
+
+[Route]
+Gateway=10.0.0.1
+Table=100
+`,
+		"/run/systemd/network/10-netplan-eth1.network": "[Match]\nName=eth1\n\n[Route]\nDestination=2001:db8:1::/48\nGateway=fe80::1\n",
+		"/run/systemd/network/10-netplan-eth0.link":    "[Match]\nOriginalName=eth0\n",
+	}
+	rendered := networkdRoutes(files)
+	if len(rendered) != 3 || rendered[1].Destination != "default" || rendered[1].Table != 100 {
+		t.Fatalf("rendered = %+v", rendered)
+	}
+
+	plan := &NetplanPlan{}
+	predictRenderedRoutes(plan, rendered, []liveRoute{{Interface: "eth0", Destination: "10.20.0.0/16", Table: 254}})
+	var got []string
+	for _, r := range plan.Routes {
+		got = append(got, fmt.Sprintf("%s %s %s %d", r.Action, r.Destination, r.Interface, r.Table))
+	}
+	if want := "add default eth0 100,add 2001:db8:1::/48 eth1 254"; strings.Join(got, ",") != want {
+		t.Errorf("routes = %v, want %s", got, want)
+	}
+}
+
+func TestPlanNetplanConfig(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, NetplanConfigFile), []byte(currentNetplan), 0600)
+	os.WriteFile(filepath.Join(dir, "50-cloud-init.yaml"), []byte("network: {version: 2}\n"), 0600)
+
+	// A fake netplan that renders one unit per yaml file it finds.
+	fake := filepath.Join(t.TempDir(), "netplan")
+	os.WriteFile(fake, []byte(`#!/bin/sh
+root=$3
+mkdir -p "$root/run/systemd/network"
+for f in "$root"/etc/netplan/*.yaml; do
+  cp "$f" "$root/run/systemd/network/10-netplan-$(basename "$f" .yaml).network"
+done
+`), 0700)
+	oldCommand := netplanCommand
+	netplanCommand = fake
+	defer func() { netplanCommand = oldCommand }()
+
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatalf("logger init: %v", err)
+	}
+	nm := NewNetplanManager(logger.NewLogger("netplan-test"))
+	nm.netplanPath = dir
+	nm.configPath = filepath.Join(dir, NetplanConfigFile)
+
+	plan, err := nm.PlanNetplanConfig(proposedNetplan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Changed || !strings.Contains(plan.Diff, "+      mtu: 9000") || !strings.Contains(plan.Diff, "-  vlans:") {
+		t.Errorf("diff:\n%s", plan.Diff)
+	}
+	if !plan.Generate.OK || len(plan.Generate.Files) != 2 {
+		t.Errorf("generate = %+v", plan.Generate)
+	}
+	if got := plan.Generate.Files["/run/systemd/network/10-netplan-99-elchi-interfaces.network"]; got != proposedNetplan {
+		t.Errorf("generate did not see the proposed file: %q", got)
+	}
+	if data, _ := os.ReadFile(nm.configPath); string(data) != currentNetplan {
+		t.Error("plan modified the managed file")
+	}
+
+	if _, err := nm.PlanNetplanConfig("network:\n  ethernets:\n    eth0:\n      routes: []\n"); err == nil {
+		t.Error("plan accepted routes in netplan YAML")
+	}
+}
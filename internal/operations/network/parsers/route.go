@@ -14,6 +14,18 @@ type RouteInfo struct {
 	RoutingPolicies []*client.RoutingPolicy
 }
 
+// yamlUint reads a YAML number, which yaml.v3 decodes as int (or float64
+// for values written as 100.0).
+func yamlUint(v any) (uint32, bool) {
+	switch n := v.(type) {
+	case int:
+		return uint32(n), n >= 0
+	case float64:
+		return uint32(n), n >= 0
+	}
+	return 0, false
+}
+
 // Parses route configuration from a map
 func parseRoutes(ifaceMap map[string]any) []*client.Route {
 	var routes []*client.Route
@@ -27,15 +39,18 @@ func parseRoutes(ifaceMap map[string]any) []*client.Route {
 				if via, ok := rMap["via"].(string); ok {
 					route.Via = via
 				}
-				if table, ok := rMap["table"].(float64); ok {
-					route.Table = uint32(table)
+				if table, ok := yamlUint(rMap["table"]); ok {
+					route.Table = table
 				}
-				if metric, ok := rMap["metric"].(float64); ok {
-					route.Metric = uint32(metric)
+				if metric, ok := yamlUint(rMap["metric"]); ok {
+					route.Metric = metric
 				}
 				if scope, ok := rMap["scope"].(string); ok {
 					route.Scope = scope
 				}
+				if onlink, ok := rMap["on-link"].(bool); ok {
+					route.Onlink = onlink
+				}
 				routes = append(routes, route)
 			}
 		}
@@ -56,11 +71,11 @@ func parseRoutingPolicies(ifaceMap map[string]any) []*client.RoutingPolicy {
 				if to, ok := pMap["to"].(string); ok {
 					policy.To = to
 				}
-				if table, ok := pMap["table"].(float64); ok {
-					policy.Table = uint32(table)
+				if table, ok := yamlUint(pMap["table"]); ok {
+					policy.Table = table
 				}
-				if priority, ok := pMap["priority"].(float64); ok {
-					policy.Priority = uint32(priority)
+				if priority, ok := yamlUint(pMap["priority"]); ok {
+					policy.Priority = priority
 				}
 				policies = append(policies, policy)
 			}
@@ -91,6 +106,12 @@ func ParseNetplanRouteFile(path string) ([]*RouteInfo, error) {
 						Routes:          parseRoutes(ifaceMap),
 						RoutingPolicies: parseRoutingPolicies(ifaceMap),
 					}
+					for _, route := range info.Routes {
+						route.Interface = ifname
+					}
+					for _, policy := range info.RoutingPolicies {
+						policy.Interface = ifname
+					}
 					result = append(result, info)
 				}
 			}
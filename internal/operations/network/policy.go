@@ -54,6 +54,8 @@ func (pm *PolicyManager) PersistWarnings() []string {
 // ManagePolicies handles routing policy operations (add/delete/replace)
 func (pm *PolicyManager) ManagePolicies(operations []*client.RoutingPolicyOperation) error {
 	pm.logger.Info("Managing routing policy operations")
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
 
 	for _, op := range operations {
 		switch op.Action {
@@ -180,7 +182,11 @@ func (pm *PolicyManager) addRuntimePolicy(policy *client.RoutingPolicy) error {
 	rule := netlink.NewRule()
 	rule.Priority = int(policy.Priority)
 	rule.Table = int(policy.Table)
-	rule.Family = netlink.FAMILY_V4
+	family, err := policyFamily(policy)
+	if err != nil {
+		return err
+	}
+	rule.Family = family
 
 	// Parse source address
 	if policy.From != "" {
@@ -221,11 +227,11 @@ func (pm *PolicyManager) addRuntimePolicy(policy *client.RoutingPolicy) error {
 			rule.Priority, rule.Table, rule.Family)
 		if rule.Src != nil {
 			pm.logger.Debugf("Failed rule Src: %s (IP=%s, Mask=%s, Bits=%d)",
-				rule.Src.String(), rule.Src.IP.String(), rule.Src.Mask.String(), countBits(rule.Src.Mask))
+				rule.Src.String(), rule.Src.IP.String(), rule.Src.Mask.String(), maskBits(rule.Src.Mask))
 		}
 		if rule.Dst != nil {
 			pm.logger.Debugf("Failed rule Dst: %s (IP=%s, Mask=%s, Bits=%d)",
-				rule.Dst.String(), rule.Dst.IP.String(), rule.Dst.Mask.String(), countBits(rule.Dst.Mask))
+				rule.Dst.String(), rule.Dst.IP.String(), rule.Dst.Mask.String(), maskBits(rule.Dst.Mask))
 		}
 
 		// Try to understand the system error
@@ -247,7 +253,11 @@ func (pm *PolicyManager) deleteRuntimePolicy(policy *client.RoutingPolicy) error
 	rule := netlink.NewRule()
 	rule.Priority = int(policy.Priority)
 	rule.Table = int(policy.Table)
-	rule.Family = netlink.FAMILY_V4
+	family, err := policyFamily(policy)
+	if err != nil {
+		return err
+	}
+	rule.Family = family
 
 	// Parse source address
 	if policy.From != "" {
@@ -313,6 +323,10 @@ func (pm *PolicyManager) validatePolicy(policy *client.RoutingPolicy) error {
 		}
 	}
 
+	if _, err := policyFamily(policy); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -379,17 +393,33 @@ func (pm *PolicyManager) GetCurrentPolicies() ([]*client.RoutingPolicy, error) {
 	return policies, nil
 }
 
-// countBits counts the number of bits set in a mask
-func countBits(mask net.IPMask) int {
-	count := 0
-	for _, b := range mask {
-		for i := 0; i < 8; i++ {
-			if b&(1<<uint(7-i)) != 0 {
-				count++
-			}
+// maskBits returns the prefix length of a mask of either family.
+func maskBits(mask net.IPMask) int {
+	ones, _ := mask.Size()
+	return ones
+}
+
+// policyFamily returns the address family of a policy's selectors. A rule
+// matches one family; a policy with neither from nor to is an IPv4 rule.
+func policyFamily(policy *client.RoutingPolicy) (int, error) {
+	family := 0
+	for _, prefix := range []string{policy.From, policy.To} {
+		if prefix == "" {
+			continue
 		}
+		ip, _, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return 0, fmt.Errorf("invalid address %s: %w", prefix, err)
+		}
+		if family != 0 && ipFamily(ip) != family {
+			return 0, fmt.Errorf("from %s and to %s are different address families", policy.From, policy.To)
+		}
+		family = ipFamily(ip)
+	}
+	if family == 0 {
+		family = netlink.FAMILY_V4
 	}
-	return count
+	return family, nil
 }
 
 // PolicyManage handles SUB_POLICY_MANAGE command
@@ -541,8 +571,8 @@ func (pm *PolicyManager) addPolicyToPersistentConfig(policy *client.RoutingPolic
 	// Check if policy already exists (avoid duplicates)
 	pm.logger.Debugf("Checking for duplicate policy in %d existing policies", len(ifConfig.RoutingPolicy))
 	for _, existingPolicy := range ifConfig.RoutingPolicy {
-		if existingPolicy.From == netplanPolicy.From &&
-			existingPolicy.To == netplanPolicy.To &&
+		if canonicalPrefix(existingPolicy.From) == canonicalPrefix(netplanPolicy.From) &&
+			canonicalPrefix(existingPolicy.To) == canonicalPrefix(netplanPolicy.To) &&
 			existingPolicy.Table == netplanPolicy.Table &&
 			existingPolicy.Priority == netplanPolicy.Priority {
 			pm.logger.Debug("Policy already exists in netplan, skipping")
@@ -629,8 +659,8 @@ func (pm *PolicyManager) removePolicyFromPersistentConfig(policy *client.Routing
 	var filteredPolicies []NetplanPolicyEntry
 	removedCount := 0
 	for _, existingPolicy := range ifConfig.RoutingPolicy {
-		if existingPolicy.From == policy.From &&
-			existingPolicy.To == policy.To &&
+		if canonicalPrefix(existingPolicy.From) == canonicalPrefix(policy.From) &&
+			canonicalPrefix(existingPolicy.To) == canonicalPrefix(policy.To) &&
 			existingPolicy.Table == int(policy.Table) &&
 			existingPolicy.Priority == int(policy.Priority) {
 			pm.logger.Debug("Found matching policy to remove")
@@ -758,7 +788,8 @@ func (pm *PolicyManager) findInterfaceForPolicy(policy *client.RoutingPolicy, in
 	return interfaceMap[policyKey]
 }
 
-// createPolicyKey creates a unique key for a policy
+// createPolicyKey creates a unique key for a policy; prefixes are
+// canonicalised so netplan and netlink spellings of one rule agree
 func (pm *PolicyManager) createPolicyKey(from, to string, table, priority int) string {
-	return fmt.Sprintf("%s|%s|%d|%d", from, to, table, priority)
+	return fmt.Sprintf("%s|%s|%d|%d", canonicalPrefix(from), canonicalPrefix(to), table, priority)
 }
@@ -0,0 +1,132 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"github.com/vishvananda/netlink"
+)
+
+func TestClientRouteToNetlinkFamilies(t *testing.T) {
+	rm := newTestRouteManager(t)
+	tests := []struct {
+		name   string
+		route  *client.Route
+		family int
+		dst    string // "" when left to the gateway
+		gw     string
+	}{
+		{"v4 default via gateway", &client.Route{To: "0.0.0.0/0", Via: "10.0.0.1"}, netlink.FAMILY_V4, "", "10.0.0.1"},
+		{"v4 default without gateway", &client.Route{To: "default", Interface: "lo"}, netlink.FAMILY_V4, "0.0.0.0/0", ""},
+		{"v4 prefix", &client.Route{To: "10.1.0.0/16", Via: "10.0.0.1"}, netlink.FAMILY_V4, "10.1.0.0/16", "10.0.0.1"},
+		{"v6 default via gateway", &client.Route{To: "::/0", Via: "2001:db8::1"}, netlink.FAMILY_V6, "", "2001:db8::1"},
+		{"v6 default spelled default", &client.Route{To: "default", Via: "2001:db8::1"}, netlink.FAMILY_V6, "", "2001:db8::1"},
+		{"v6 default without gateway", &client.Route{To: "::/0", Interface: "lo"}, netlink.FAMILY_V6, "::/0", ""},
+		{"v6 prefix via link-local", &client.Route{To: "2001:db8:1::/48", Via: "fe80::1", Interface: "lo"}, netlink.FAMILY_V6, "2001:db8:1::/48", "fe80::1"},
+	}
+	for _, tt := range tests {
+		got, err := rm.clientRouteToNetlink(tt.route)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		dst := ""
+		if got.Dst != nil {
+			dst = got.Dst.String()
+		}
+		gw := ""
+		if got.Gw != nil {
+			gw = got.Gw.String()
+		}
+		if got.Family != tt.family || dst != tt.dst || gw != tt.gw {
+			t.Errorf("%s: family=%d dst=%q gw=%q, want family=%d dst=%q gw=%q", tt.name, got.Family, dst, gw, tt.family, tt.dst, tt.gw)
+		}
+	}
+}
+
+func TestClientRouteToNetlinkRejectsMixedAndUnscoped(t *testing.T) {
+	rm := newTestRouteManager(t)
+	rejected := map[string]*client.Route{
+		"link-local gateway": {To: "2001:db8:1::/48", Via: "fe80::1"},
+		"v4 via v6":          {To: "10.1.0.0/16", Via: "2001:db8::1"},
+		"v6 via v4":          {To: "2001:db8:1::/48", Via: "10.0.0.1"},
+		"v6 from v4 source":  {To: "2001:db8:1::/48", Via: "2001:db8::1", Source: "10.0.0.5"},
+	}
+	for name, route := range rejected {
+		if _, err := rm.clientRouteToNetlink(route); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPolicyFamily(t *testing.T) {
+	tests := []struct {
+		policy *client.RoutingPolicy
+		family int
+		err    bool
+	}{
+		{&client.RoutingPolicy{From: "10.0.0.0/24"}, netlink.FAMILY_V4, false},
+		{&client.RoutingPolicy{From: "2001:db8::/64", To: "2001:db8:1::/48"}, netlink.FAMILY_V6, false},
+		{&client.RoutingPolicy{To: "2001:db8:1::/48"}, netlink.FAMILY_V6, false},
+		{&client.RoutingPolicy{}, netlink.FAMILY_V4, false},
+		{&client.RoutingPolicy{From: "10.0.0.0/24", To: "2001:db8::/64"}, 0, true},
+		{&client.RoutingPolicy{From: "2001:db8::1"}, 0, true},
+	}
+	for _, tt := range tests {
+		family, err := policyFamily(tt.policy)
+		if (err != nil) != tt.err || (err == nil && family != tt.family) {
+			t.Errorf("policyFamily(from=%q to=%q) = %d, %v", tt.policy.From, tt.policy.To, family, err)
+		}
+	}
+
+	pm := NewPolicyManager(newTestRouteManager(t).logger)
+	err := pm.validatePolicy(&client.RoutingPolicy{From: "10.0.0.0/24", To: "2001:db8::/64", Table: 100, Priority: 100})
+	if err == nil || !strings.Contains(err.Error(), "different address families") {
+		t.Fatalf("mixed-family policy validated: %v", err)
+	}
+}
+
+func TestRoutePersistenceIPv6Spellings(t *testing.T) {
+	cfg := newRouteConfig()
+	upsertRouteEntry(cfg, "eth0", NetplanRouteEntry{To: "2001:db8:1::/48", Via: "2001:db8::1"})
+	if upsertRouteEntry(cfg, "eth0", NetplanRouteEntry{To: "2001:0db8:0001::/48", Via: "2001:db8:0:0::1"}) {
+		t.Fatal("the same IPv6 route spelled differently was persisted twice")
+	}
+
+	upsertRouteEntry(cfg, "eth0", NetplanRouteEntry{To: "default", Via: "fe80::1"})
+	upsertRouteEntry(cfg, "eth0", NetplanRouteEntry{To: "0.0.0.0/0", Via: "10.0.0.1"})
+	if !removeRoutesToDestination(cfg, "eth0", canonicalRouteDst("::/0", "fe80::2")) {
+		t.Fatal("IPv6 default spelled default not matched by ::/0")
+	}
+	routes := cfg.Network.Ethernets["eth0"].Routes
+	if len(routes) != 2 || routes[1].To != "0.0.0.0/0" {
+		t.Fatalf("removing the IPv6 default touched the IPv4 one: %+v", routes)
+	}
+}
+
+func TestDriftRouteKeyFamilies(t *testing.T) {
+	persisted := &client.Route{To: "default", Via: "fe80::1", Interface: "eth1", Table: 100}
+	live := &client.Route{To: "::/0", Via: "fe80::1", Interface: "eth1", Table: 100}
+	if driftRouteKey(persisted) != driftRouteKey(live) {
+		t.Fatalf("persisted %q and live %q keys differ", driftRouteKey(persisted), driftRouteKey(live))
+	}
+	other := &client.Route{To: "::/0", Via: "fe80::1", Interface: "eth2", Table: 100}
+	if driftRouteKey(live) == driftRouteKey(other) {
+		t.Fatal("link-local gateways on different links share a key")
+	}
+	v4 := &client.Route{To: "default", Via: "10.0.0.1", Table: 100}
+	if driftRouteKey(v4) == driftRouteKey(live) || !strings.Contains(driftRouteKey(v4), "0.0.0.0/0") {
+		t.Fatalf("IPv4 default key = %q", driftRouteKey(v4))
+	}
+
+	// Metric 0 is stored as 1024 for IPv6 only.
+	kernel := &client.Route{To: "::/0", Via: "fe80::1", Interface: "eth1", Table: 100, Metric: 1024}
+	if driftRouteKey(persisted) != driftRouteKey(kernel) {
+		t.Errorf("IPv6 metric 0 key %q, kernel key %q", driftRouteKey(persisted), driftRouteKey(kernel))
+	}
+	v4kernel := &client.Route{To: "0.0.0.0/0", Via: "10.0.0.1", Table: 100, Metric: 1024}
+	if driftRouteKey(v4) == driftRouteKey(v4kernel) {
+		t.Error("IPv4 metric 0 treated as 1024")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/CloudNativeWorks/elchi-client/pkg/models"
 	client "github.com/CloudNativeWorks/elchi-proto/client"
 	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/proto"
 	"gopkg.in/yaml.v3"
 )
 
@@ -47,19 +48,21 @@ func (rm *RouteManagerNew) PersistWarnings() []string {
 // ManageRoutes handles route operations (add/delete/replace)
 func (rm *RouteManagerNew) ManageRoutes(operations []*client.RouteOperation) error {
 	rm.logger.Info("Managing route operations")
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
 
 	for _, op := range operations {
 		switch op.Action {
 		case client.RouteOperation_ADD:
-			if err := rm.addRoute(op.Route); err != nil {
+			if err := rm.addRoute(protoRouteSpec(op.Route)); err != nil {
 				return fmt.Errorf("failed to add route: %w", err)
 			}
 		case client.RouteOperation_DELETE:
-			if err := rm.deleteRoute(op.Route); err != nil {
+			if err := rm.deleteRoute(protoRouteSpec(op.Route)); err != nil {
 				return fmt.Errorf("failed to delete route: %w", err)
 			}
 		case client.RouteOperation_REPLACE:
-			if err := rm.replaceRoute(op.Route); err != nil {
+			if err := rm.replaceRoute(protoRouteSpec(op.Route)); err != nil {
 				return fmt.Errorf("failed to replace route: %w", err)
 			}
 		}
@@ -68,11 +71,49 @@ func (rm *RouteManagerNew) ManageRoutes(operations []*client.RouteOperation) err
 	return nil
 }
 
+// protoRouteSpec wraps a SUB_ROUTE_MANAGE route. That command never tagged
+// routes with a protocol, so it is cleared (on a copy) and the kernel default
+// applies; protocols are set through RouteSpec on /elchi/network/routes.
+func protoRouteSpec(route *client.Route) RouteSpec {
+	if route == nil {
+		return RouteSpec{}
+	}
+	route = proto.Clone(route).(*client.Route)
+	route.Protocol = ""
+	return RouteSpec{Route: route}
+}
+
+// AddRouteSpec adds a route with its nexthops and attributes and persists it.
+func (rm *RouteManagerNew) AddRouteSpec(spec RouteSpec) error {
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
+	return rm.addRoute(spec)
+}
+
+// DeleteRouteSpec deletes a route and its persisted entries.
+func (rm *RouteManagerNew) DeleteRouteSpec(spec RouteSpec) error {
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
+	return rm.deleteRoute(spec)
+}
+
+// ReplaceRouteSpec replaces the route to the same destination, table and
+// metric and its persisted entries.
+func (rm *RouteManagerNew) ReplaceRouteSpec(spec RouteSpec) error {
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
+	return rm.replaceRoute(spec)
+}
+
 // addRoute adds a route to the routing table
-func (rm *RouteManagerNew) addRoute(route *client.Route) error {
-	rm.logger.Infof("Adding route: to=%s, via=%s, interface=%s", route.To, route.Via, route.Interface)
+func (rm *RouteManagerNew) addRoute(spec RouteSpec) error {
+	if spec.Route == nil {
+		return fmt.Errorf("route is required")
+	}
+	route := spec.Route
+	rm.logger.Infof("Adding route: to=%s, via=%s, interface=%s, nexthops=%d", route.To, route.Via, route.Interface, len(spec.Nexthops))
 
-	netlinkRoute, err := rm.clientRouteToNetlink(route)
+	netlinkRoute, err := rm.routeSpecToNetlink(spec)
 	if err != nil {
 		rm.logger.Debugf("Route conversion failed: %v", err)
 		return err
@@ -96,20 +137,35 @@ func (rm *RouteManagerNew) addRoute(route *client.Route) error {
 		rm.logger.Debugf("Route successfully added to netlink")
 	}
 
-	// Add to persistent netplan config
-	if err := rm.addRouteToPersistentConfig(route); err != nil {
-		// Runtime route was added successfully, so don't fail the operation, but
-		// record the divergence: this route will NOT survive a reboot.
-		rm.addPersistWarning("route to %s via %s applied to kernel but not persisted to netplan (will be lost on reboot): %v", route.To, route.Via, err)
-	} else {
-		rm.logger.Debugf("Route successfully persisted to netplan")
+	// Add to persistent netplan config, one entry per nexthop
+	for _, leg := range spec.legs() {
+		if err := rm.addRouteToPersistentConfig(leg, spec.netplanEntry(leg)); err != nil {
+			// Runtime route was added successfully, so don't fail the operation, but
+			// record the divergence: this route will NOT survive a reboot.
+			rm.addPersistWarning("route to %s via %s applied to kernel but not persisted to netplan (will be lost on reboot): %v", leg.To, leg.Via, err)
+		} else {
+			rm.logger.Debugf("Route successfully persisted to netplan")
+		}
 	}
+	rm.persistRouteAttributes(spec, false)
 
 	return nil
 }
 
+// persistRouteAttributes records (or with remove, forgets) what netplan
+// cannot hold for the route in RouteAttributesFile.
+func (rm *RouteManagerNew) persistRouteAttributes(spec RouteSpec, remove bool) {
+	if err := updateRouteAttributes(RouteAttributesFile, spec, remove); err != nil {
+		rm.addPersistWarning("nexthops and attributes of the route to %s not persisted (drift repair will not restore them): %v", spec.To, err)
+	}
+}
+
 // deleteRoute removes a route from the routing table
-func (rm *RouteManagerNew) deleteRoute(route *client.Route) error {
+func (rm *RouteManagerNew) deleteRoute(spec RouteSpec) error {
+	if spec.Route == nil {
+		return fmt.Errorf("route is required")
+	}
+	route := spec.Route
 	rm.logger.Infof("Deleting route: to=%s, via=%s, interface=%s", route.To, route.Via, route.Interface)
 	rm.logger.Debugf("Route details - To:%s, Protocol:%s", route.To, route.Protocol)
 
@@ -119,11 +175,14 @@ func (rm *RouteManagerNew) deleteRoute(route *client.Route) error {
 		return err
 	}
 
-	netlinkRoute, err := rm.clientRouteToNetlink(route)
+	netlinkRoute, err := rm.routeSpecToNetlink(spec)
 	if err != nil {
 		rm.logger.Debugf("Route conversion for delete failed: %v", err)
 		return err
 	}
+	// The kernel matches a non-zero protocol; the route may have been
+	// tagged differently from what the caller names.
+	netlinkRoute.Protocol = 0
 
 	rm.logger.Debugf("Deleting netlink route - Table:%d, LinkIndex:%d, Dst:%v, Gw:%v",
 		netlinkRoute.Table, netlinkRoute.LinkIndex, netlinkRoute.Dst, netlinkRoute.Gw)
@@ -136,19 +195,26 @@ func (rm *RouteManagerNew) deleteRoute(route *client.Route) error {
 	rm.logger.Debugf("Route successfully deleted from netlink")
 
 	// Remove from persistent config
-	if err := rm.removeRouteFromPersistentConfig(route); err != nil {
-		// Runtime route was removed, so don't fail the operation, but record the
-		// divergence: the route is still in netplan and will be re-created on reboot.
-		rm.addPersistWarning("route to %s via %s removed from kernel but not from netplan (will be re-created on reboot): %v", route.To, route.Via, err)
-	} else {
-		rm.logger.Debugf("Route successfully removed from netplan")
+	for _, leg := range spec.legs() {
+		if err := rm.removeRouteFromPersistentConfig(leg); err != nil {
+			// Runtime route was removed, so don't fail the operation, but record the
+			// divergence: the route is still in netplan and will be re-created on reboot.
+			rm.addPersistWarning("route to %s via %s removed from kernel but not from netplan (will be re-created on reboot): %v", leg.To, leg.Via, err)
+		} else {
+			rm.logger.Debugf("Route successfully removed from netplan")
+		}
 	}
+	rm.persistRouteAttributes(spec, true)
 
 	return nil
 }
 
 // replaceRoute replaces an existing route
-func (rm *RouteManagerNew) replaceRoute(route *client.Route) error {
+func (rm *RouteManagerNew) replaceRoute(spec RouteSpec) error {
+	if spec.Route == nil {
+		return fmt.Errorf("route is required")
+	}
+	route := spec.Route
 	rm.logger.Infof("Replacing route: to=%s, via=%s, interface=%s", route.To, route.Via, route.Interface)
 	rm.logger.Debugf("Route details - To:%s, Protocol:%s", route.To, route.Protocol)
 
@@ -158,7 +224,7 @@ func (rm *RouteManagerNew) replaceRoute(route *client.Route) error {
 		return err
 	}
 
-	netlinkRoute, err := rm.clientRouteToNetlink(route)
+	netlinkRoute, err := rm.routeSpecToNetlink(spec)
 	if err != nil {
 		return err
 	}
@@ -171,14 +237,32 @@ func (rm *RouteManagerNew) replaceRoute(route *client.Route) error {
 
 	// Persist the replacement so the netplan file matches the kernel; otherwise
 	// the old route survives in the file and is restored on reboot.
-	if err := rm.replaceRouteInPersistentConfig(route); err != nil {
-		// Runtime route was replaced successfully, so don't fail the operation, but
-		// record the divergence: the kernel and netplan now disagree and the stale
-		// route will be restored on reboot.
-		rm.addPersistWarning("route to %s via %s replaced in kernel but not persisted to netplan (kernel/netplan diverged, stale route restored on reboot): %v", route.To, route.Via, err)
-	} else {
-		rm.logger.Debugf("Replaced route successfully persisted to netplan")
+	// The replaced route may have had nexthops on other interfaces; their
+	// entries go too.
+	entries := make(map[string][]NetplanRouteEntry)
+	if old, err := readRouteAttributes(RouteAttributesFile); err == nil {
+		if prev, ok := old[spec.key()]; ok {
+			for _, leg := range prev.legs() {
+				if leg.Interface != "" {
+					entries[leg.Interface] = nil
+				}
+			}
+		}
 	}
+	for _, leg := range spec.legs() {
+		entries[leg.Interface] = append(entries[leg.Interface], spec.netplanEntry(leg))
+	}
+	for iface, ifEntries := range entries {
+		if err := rm.replaceRouteInPersistentConfig(iface, canonicalRouteDst(route.To, route.Via), ifEntries); err != nil {
+			// Runtime route was replaced successfully, so don't fail the operation, but
+			// record the divergence: the kernel and netplan now disagree and the stale
+			// route will be restored on reboot.
+			rm.addPersistWarning("route to %s replaced in kernel but not persisted to netplan for %s (kernel/netplan diverged, stale route restored on reboot): %v", route.To, iface, err)
+		} else {
+			rm.logger.Debugf("Replaced route successfully persisted to netplan")
+		}
+	}
+	rm.persistRouteAttributes(spec, false)
 
 	return nil
 }
@@ -186,14 +270,19 @@ func (rm *RouteManagerNew) replaceRoute(route *client.Route) error {
 // clientRouteToNetlink converts client.Route to netlink.Route
 func (rm *RouteManagerNew) clientRouteToNetlink(route *client.Route) (*netlink.Route, error) {
 	netlinkRoute := &netlink.Route{}
-
-	// Parse destination
-	if route.To != "" && route.To != "0.0.0.0/0" {
-		if _, dst, err := net.ParseCIDR(route.To); err != nil {
-			return nil, fmt.Errorf("invalid destination %s: %w", route.To, err)
-		} else {
-			netlinkRoute.Dst = dst
+	family := routeFamily(route)
+	netlinkRoute.Family = family
+
+	// Parse destination. A default route leaves it unset when the gateway
+	// gives netlink the family; without a gateway it needs 0.0.0.0/0 or ::/0.
+	if isDefaultDestination(route.To) {
+		if route.Via == "" {
+			netlinkRoute.Dst = defaultDestination(family)
 		}
+	} else if _, dst, err := net.ParseCIDR(route.To); err != nil {
+		return nil, fmt.Errorf("invalid destination %s: %w", route.To, err)
+	} else {
+		netlinkRoute.Dst = dst
 	}
 
 	// Parse gateway
@@ -202,6 +291,14 @@ func (rm *RouteManagerNew) clientRouteToNetlink(route *client.Route) (*netlink.R
 		if gw == nil {
 			return nil, fmt.Errorf("invalid gateway %s", route.Via)
 		}
+		if ipFamily(gw) != family {
+			return nil, fmt.Errorf("gateway %s and destination %s are different address families", route.Via, route.To)
+		}
+		// fe80::/10 gateways exist on every link; the kernel needs to be
+		// told which one.
+		if gw.IsLinkLocalUnicast() && route.Interface == "" {
+			return nil, fmt.Errorf("link-local gateway %s needs an interface", route.Via)
+		}
 		netlinkRoute.Gw = gw
 	}
 
@@ -211,6 +308,9 @@ func (rm *RouteManagerNew) clientRouteToNetlink(route *client.Route) (*netlink.R
 		if src == nil {
 			return nil, fmt.Errorf("invalid source %s", route.Source)
 		}
+		if ipFamily(src) != family {
+			return nil, fmt.Errorf("source %s and destination %s are different address families", route.Source, route.To)
+		}
 		netlinkRoute.Src = src
 	}
 
@@ -255,6 +355,47 @@ func (rm *RouteManagerNew) clientRouteToNetlink(route *client.Route) (*netlink.R
 	return netlinkRoute, nil
 }
 
+// isDefaultDestination reports whether to is empty, "default" or a /0.
+func isDefaultDestination(to string) bool {
+	if to == "" || to == "default" {
+		return true
+	}
+	_, dst, err := net.ParseCIDR(to)
+	if err != nil {
+		return false
+	}
+	ones, _ := dst.Mask.Size()
+	return ones == 0
+}
+
+// routeFamily returns the address family of a route: its destination's, or
+// for a default route its gateway's or source's, IPv4 when none tells.
+func routeFamily(route *client.Route) int {
+	if ip, _, err := net.ParseCIDR(route.To); err == nil {
+		return ipFamily(ip)
+	}
+	for _, addr := range []string{route.Via, route.Source} {
+		if ip := net.ParseIP(addr); ip != nil {
+			return ipFamily(ip)
+		}
+	}
+	return netlink.FAMILY_V4
+}
+
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+func defaultDestination(family int) *net.IPNet {
+	if family == netlink.FAMILY_V6 {
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+	}
+	return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+}
+
 // RouteManage handles SUB_ROUTE_MANAGE command
 func RouteManage(cmd *client.Command, logger *logger.Logger) *client.CommandResponse {
 	networkReq := cmd.GetNetwork()
@@ -318,7 +459,7 @@ func RouteList(cmd *client.Command, logger *logger.Logger) *client.CommandRespon
 		Result: &client.CommandResponse_Network{
 			Network: &client.ResponseNetwork{
 				Success:      true,
-				Message:      "Routes listed successfully",
+				Message:      "Routes listed successfully" + vrfSummary(logger),
 				NetworkState: networkState,
 			},
 		},
@@ -375,6 +516,9 @@ type NetplanRouteEntry struct {
 	Metric int    `yaml:"metric,omitempty"`
 	Scope  string `yaml:"scope,omitempty"`
 	Onlink bool   `yaml:"on-link,omitempty"`
+	MTU    int    `yaml:"mtu,omitempty"`
+	// InitCwnd is the initial congestion window (initcwnd).
+	InitCwnd int `yaml:"congestion-window,omitempty"`
 }
 
 // routeEntryFromClient builds a netplan route entry from a client route.
@@ -404,7 +548,7 @@ func upsertRouteEntry(config *NetplanRouteConfig, iface string, entry NetplanRou
 	}
 	ifConfig := config.Network.Ethernets[iface]
 	for _, existing := range ifConfig.Routes {
-		if existing.To == entry.To && existing.Via == entry.Via && existing.Onlink == entry.Onlink {
+		if sameRouteEntry(existing, entry.To, entry.Via) && existing.Onlink == entry.Onlink {
 			return false
 		}
 	}
@@ -413,10 +557,18 @@ func upsertRouteEntry(config *NetplanRouteConfig, iface string, entry NetplanRou
 	return true
 }
 
+// sameRouteEntry reports whether entry routes to the destination via the
+// gateway, whatever the spelling of either.
+func sameRouteEntry(entry NetplanRouteEntry, to, via string) bool {
+	return canonicalRouteDst(entry.To, entry.Via) == canonicalRouteDst(to, via) &&
+		canonicalAddr(entry.Via) == canonicalAddr(via)
+}
+
 // removeRoutesToDestination drops every route whose To matches `to` from the
 // interface. REPLACE keys on the destination (via/metric/etc may have changed),
 // so the stale persisted entry must be removed by destination, not by full
-// match. Returns true if anything was removed.
+// match. An IPv6 default must be passed as ::/0. Returns true if anything
+// was removed.
 func removeRoutesToDestination(config *NetplanRouteConfig, iface, to string) bool {
 	ifConfig, ok := config.Network.Ethernets[iface]
 	if !ok {
@@ -425,7 +577,7 @@ func removeRoutesToDestination(config *NetplanRouteConfig, iface, to string) boo
 	kept := make([]NetplanRouteEntry, 0, len(ifConfig.Routes))
 	removed := false
 	for _, r := range ifConfig.Routes {
-		if r.To == to {
+		if canonicalRouteDst(r.To, r.Via) == canonicalRouteDst(to, "") {
 			removed = true
 			continue
 		}
@@ -436,16 +588,17 @@ func removeRoutesToDestination(config *NetplanRouteConfig, iface, to string) boo
 	return removed
 }
 
-// replaceRouteInPersistentConfig updates the netplan file for a REPLACE: it
-// removes any persisted route to the same destination and adds the new one.
+// replaceRouteInPersistentConfig updates the netplan file of iface for a
+// REPLACE: it removes any persisted route to the destination `to` (canonical,
+// see canonicalRouteDst) and adds entries, one per nexthop on iface.
 // Without this the netplan file keeps the OLD route and networkd restores the
 // stale value on reboot/`netplan apply` (silent divergence from the kernel).
-func (rm *RouteManagerNew) replaceRouteInPersistentConfig(route *client.Route) error {
-	if route.Interface == "" {
+func (rm *RouteManagerNew) replaceRouteInPersistentConfig(iface, to string, entries []NetplanRouteEntry) error {
+	if iface == "" {
 		return fmt.Errorf("route must specify interface for netplan persistence")
 	}
 
-	routeFile := fmt.Sprintf("%s/99-elchi-route-%s.yaml", models.NetplanPath, route.Interface)
+	routeFile := fmt.Sprintf("%s/99-elchi-route-%s.yaml", models.NetplanPath, iface)
 
 	config := &NetplanRouteConfig{
 		Network: NetplanRouteNetwork{
@@ -460,8 +613,20 @@ func (rm *RouteManagerNew) replaceRouteInPersistentConfig(route *client.Route) e
 		}
 	}
 
-	removeRoutesToDestination(config, route.Interface, route.To)
-	upsertRouteEntry(config, route.Interface, routeEntryFromClient(route))
+	removeRoutesToDestination(config, iface, to)
+	for _, entry := range entries {
+		upsertRouteEntry(config, iface, entry)
+	}
+
+	if len(config.Network.Ethernets[iface].Routes) == 0 {
+		delete(config.Network.Ethernets, iface)
+		if len(config.Network.Ethernets) == 0 {
+			if err := os.Remove(routeFile); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove empty route config: %w", err)
+			}
+			return nil
+		}
+	}
 
 	data, err := yaml.Marshal(config)
 	if err != nil {
@@ -484,7 +649,7 @@ func (rm *RouteManagerNew) replaceRouteInPersistentConfig(route *client.Route) e
 }
 
 // addRouteToPersistentConfig adds route to netplan persistent configuration
-func (rm *RouteManagerNew) addRouteToPersistentConfig(route *client.Route) error {
+func (rm *RouteManagerNew) addRouteToPersistentConfig(route *client.Route, entry NetplanRouteEntry) error {
 	if route.Interface == "" {
 		return fmt.Errorf("route must specify interface for netplan persistence")
 	}
@@ -506,7 +671,7 @@ func (rm *RouteManagerNew) addRouteToPersistentConfig(route *client.Route) error
 	}
 
 	// Build the entry and add it (no-op if an identical entry already exists).
-	if !upsertRouteEntry(config, route.Interface, routeEntryFromClient(route)) {
+	if !upsertRouteEntry(config, route.Interface, entry) {
 		return nil // Route already exists
 	}
 
@@ -569,7 +734,7 @@ func (rm *RouteManagerNew) removeRouteFromPersistentConfig(route *client.Route)
 	var filteredRoutes []NetplanRouteEntry
 	for _, existingRoute := range ifConfig.Routes {
 		// Match core fields (to, via) - required
-		if existingRoute.To != route.To || existingRoute.Via != route.Via {
+		if !sameRouteEntry(existingRoute, route.To, route.Via) {
 			filteredRoutes = append(filteredRoutes, existingRoute)
 			continue
 		}
@@ -0,0 +1,389 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"github.com/vishvananda/netlink"
+)
+
+// RouteAttributesFile keeps the RouteSpec of every route with attributes
+// netplan cannot express (nexthop weights, advmss, protocol). netplan holds
+// one route per nexthop with its mtu and congestion-window, which networkd
+// restores at boot; the drift repair puts the rest back from this file.
+var RouteAttributesFile = filepath.Join(models.ElchiLibPath, "network", "route-attributes.json")
+
+// RouteNexthop is one gateway of a multipath route.
+type RouteNexthop struct {
+	Via       string `json:"via"`
+	Interface string `json:"interface,omitempty"`
+	// Weight is the share of flows the nexthop gets, 1-256 (0 means 1).
+	Weight int `json:"weight,omitempty"`
+}
+
+// RouteSpec is a client.Route plus what the proto has no field for. A
+// multipath route lists its gateways in Nexthops and leaves Via empty.
+// Protocol tags the route ("static", "boot", a name from the route list or
+// a number).
+type RouteSpec struct {
+	*client.Route
+	Nexthops []RouteNexthop `json:"nexthops,omitempty"`
+	MTU      int            `json:"mtu,omitempty"`
+	AdvMSS   int            `json:"advmss,omitempty"`
+	InitCwnd int            `json:"initcwnd,omitempty"`
+}
+
+// legs returns the route once per nexthop, the way netplan persists it and
+// client.Route reports it; a single-gateway route is its own leg.
+func (s RouteSpec) legs() []*client.Route {
+	if len(s.Nexthops) == 0 {
+		return []*client.Route{s.Route}
+	}
+	legs := make([]*client.Route, 0, len(s.Nexthops))
+	for _, nh := range s.Nexthops {
+		iface := nh.Interface
+		if iface == "" {
+			iface = s.Interface
+		}
+		legs = append(legs, &client.Route{
+			To:        s.To,
+			Via:       nh.Via,
+			Interface: iface,
+			Table:     s.Table,
+			Metric:    s.Metric,
+			Source:    s.Source,
+			Scope:     s.Scope,
+			IsDefault: s.IsDefault,
+			Protocol:  s.Protocol,
+			Onlink:    s.Onlink,
+		})
+	}
+	return legs
+}
+
+// netplanEntry is the netplan route of one leg; netplan has no key for the
+// weight, advmss or protocol.
+func (s RouteSpec) netplanEntry(leg *client.Route) NetplanRouteEntry {
+	entry := routeEntryFromClient(leg)
+	entry.MTU = s.MTU
+	entry.InitCwnd = s.InitCwnd
+	return entry
+}
+
+// hasAttributes reports whether netplan alone cannot restore the route.
+func (s RouteSpec) hasAttributes() bool {
+	return len(s.Nexthops) > 0 || s.AdvMSS != 0 || s.MTU != 0 || s.InitCwnd != 0 || routeProtocolTagged(s.Protocol)
+}
+
+// key identifies the kernel route: every leg of a multipath route shares it.
+func (s RouteSpec) key() string {
+	return routeSpecKey(s.legs()[0])
+}
+
+func routeSpecKey(route *client.Route) string {
+	table := route.Table
+	if table == 0 {
+		table = SystemTableMain
+	}
+	dst := canonicalRouteDst(route.To, route.Via)
+	return fmt.Sprintf("%d|%s|%d", table, dst, canonicalMetric(dst, route.Metric))
+}
+
+// validateRouteSpec checks what clientRouteToNetlink does not.
+func validateRouteSpec(s RouteSpec) error {
+	if s.Route == nil {
+		return fmt.Errorf("route is required")
+	}
+	if len(s.Nexthops) > 0 {
+		if s.Via != "" {
+			return fmt.Errorf("a multipath route takes its gateways from nexthops, not via")
+		}
+		if len(s.Nexthops) < 2 {
+			return fmt.Errorf("a multipath route needs at least two nexthops")
+		}
+		seen := make(map[string]bool)
+		for _, nh := range s.Nexthops {
+			if nh.Weight < 0 || nh.Weight > 256 {
+				return fmt.Errorf("nexthop %s: weight must be between 1 and 256", nh.Via)
+			}
+			key := canonicalAddr(nh.Via) + "%" + nh.Interface
+			if seen[key] {
+				return fmt.Errorf("nexthop %s listed twice", nh.Via)
+			}
+			seen[key] = true
+		}
+	}
+	if s.MTU != 0 && (s.MTU < 68 || s.MTU > 65535) {
+		return fmt.Errorf("mtu must be between 68 and 65535")
+	}
+	if s.AdvMSS < 0 || s.AdvMSS > 65535 {
+		return fmt.Errorf("advmss must be between 0 and 65535")
+	}
+	if s.InitCwnd < 0 || s.InitCwnd > 1024 {
+		return fmt.Errorf("initcwnd must be between 0 and 1024")
+	}
+	if _, err := routeProtocolNumber(s.Protocol); err != nil {
+		return err
+	}
+	return nil
+}
+
+// routeProtocolNumber parses the protocol a route is tagged with: a name as
+// getRouteProtocolName reports it, "unknown-<n>" or a number. "" and
+// "unspecified" are 0, the netlink default; protocols of routes Elchi must
+// not touch (see validateRouteDeletion) are refused.
+func routeProtocolNumber(name string) (int, error) {
+	switch name {
+	case "", "unspecified":
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "unknown-"))
+	if err != nil {
+		n = -1
+		for p := 1; p < 256; p++ {
+			if getRouteProtocolName(p) == name {
+				n = p
+				break
+			}
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("unknown route protocol %q", name)
+		}
+	}
+	if n < 0 || n > 255 {
+		return 0, fmt.Errorf("route protocol %d out of range 0-255", n)
+	}
+	switch getRouteProtocolName(n) {
+	case "bgp", "ospf", "isis", "zebra", "bird", "kernel", "redirect", "dhcp", "ra":
+		return 0, fmt.Errorf("route protocol %s belongs to routes Elchi does not manage", getRouteProtocolName(n))
+	}
+	return n, nil
+}
+
+// routeProtocolTagged reports whether protocol asks for more than the
+// kernel default for added routes.
+func routeProtocolTagged(protocol string) bool {
+	n, err := routeProtocolNumber(protocol)
+	return err == nil && n != 0 && n != 3 // 3 = boot, the netlink default
+}
+
+// routeSpecToNetlink converts a RouteSpec: clientRouteToNetlink for the
+// first leg, then the nexthops and attributes.
+func (rm *RouteManagerNew) routeSpecToNetlink(s RouteSpec) (*netlink.Route, error) {
+	if err := validateRouteSpec(s); err != nil {
+		return nil, err
+	}
+	legs := s.legs()
+	nlRoute, err := rm.clientRouteToNetlink(legs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.Nexthops) > 0 {
+		if nlRoute.Dst == nil {
+			// Without a route-level gateway netlink needs the destination.
+			nlRoute.Dst = defaultDestination(nlRoute.Family)
+		}
+		nlRoute.Gw = nil
+		nlRoute.LinkIndex = 0
+		nlRoute.Flags = 0
+		for i, leg := range legs {
+			legRoute, err := rm.clientRouteToNetlink(leg)
+			if err != nil {
+				return nil, fmt.Errorf("nexthop %s: %w", leg.Via, err)
+			}
+			if legRoute.Family != nlRoute.Family {
+				return nil, fmt.Errorf("nexthop %s is not in the family of %s", leg.Via, s.To)
+			}
+			weight := s.Nexthops[i].Weight
+			if weight == 0 {
+				weight = 1
+			}
+			nlRoute.MultiPath = append(nlRoute.MultiPath, &netlink.NexthopInfo{
+				LinkIndex: legRoute.LinkIndex,
+				Gw:        legRoute.Gw,
+				Hops:      weight - 1,
+				Flags:     legRoute.Flags,
+			})
+		}
+	}
+
+	nlRoute.MTU = s.MTU
+	nlRoute.AdvMSS = s.AdvMSS
+	nlRoute.InitCwnd = s.InitCwnd
+	protocol, _ := routeProtocolNumber(s.Protocol)
+	nlRoute.Protocol = netlink.RouteProtocol(protocol)
+	return nlRoute, nil
+}
+
+// routeSpecFromNetlink is the reverse of routeSpecToNetlink; linkName maps an
+// interface index to its name.
+func routeSpecFromNetlink(route netlink.Route, linkName func(int) string) RouteSpec {
+	clientRoute := &client.Route{
+		Table:    uint32(route.Table),
+		Metric:   uint32(route.Priority),
+		Onlink:   route.Flags&int(netlink.FLAG_ONLINK) != 0,
+		Protocol: getRouteProtocolName(int(route.Protocol)),
+	}
+
+	// Set destination
+	if route.Dst != nil {
+		clientRoute.To = route.Dst.String()
+		ones, _ := route.Dst.Mask.Size()
+		clientRoute.IsDefault = ones == 0
+	} else {
+		clientRoute.To = defaultDestination(route.Family).String()
+		clientRoute.IsDefault = true
+	}
+
+	// Set gateway
+	if route.Gw != nil {
+		clientRoute.Via = route.Gw.String()
+	}
+
+	// Set source
+	if route.Src != nil {
+		clientRoute.Source = route.Src.String()
+	}
+
+	// Set interface
+	if route.LinkIndex > 0 {
+		clientRoute.Interface = linkName(route.LinkIndex)
+	}
+
+	// Set scope
+	switch route.Scope {
+	case netlink.SCOPE_UNIVERSE:
+		clientRoute.Scope = "global"
+	case netlink.SCOPE_SITE:
+		clientRoute.Scope = "site"
+	case netlink.SCOPE_LINK:
+		clientRoute.Scope = "link"
+	case netlink.SCOPE_HOST:
+		clientRoute.Scope = "host"
+	default:
+		clientRoute.Scope = "global"
+	}
+
+	spec := RouteSpec{Route: clientRoute, MTU: route.MTU, AdvMSS: route.AdvMSS, InitCwnd: route.InitCwnd}
+	for _, nh := range route.MultiPath {
+		via := ""
+		if nh.Gw != nil {
+			via = nh.Gw.String()
+		}
+		spec.Nexthops = append(spec.Nexthops, RouteNexthop{Via: via, Interface: linkName(nh.LinkIndex), Weight: nh.Hops + 1})
+		if nh.Flags&int(netlink.FLAG_ONLINK) != 0 {
+			clientRoute.Onlink = true
+		}
+	}
+	return spec
+}
+
+// sameRouteAttributes reports whether the live route carries what the
+// persisted spec asks for. Nexthops are compared as a set.
+func sameRouteAttributes(want, live RouteSpec) bool {
+	if want.MTU != live.MTU || want.AdvMSS != live.AdvMSS || want.InitCwnd != live.InitCwnd {
+		return false
+	}
+	if routeProtocolTagged(want.Protocol) {
+		wantProto, _ := routeProtocolNumber(want.Protocol)
+		liveProto, _ := routeProtocolNumber(live.Protocol)
+		if wantProto != liveProto {
+			return false
+		}
+	}
+	return nexthopSet(want) == nexthopSet(live)
+}
+
+func nexthopSet(s RouteSpec) string {
+	var hops []string
+	for _, nh := range s.Nexthops {
+		weight := nh.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		iface := nh.Interface
+		if iface == "" {
+			iface = s.Interface
+		}
+		hops = append(hops, fmt.Sprintf("%s%%%s*%d", canonicalAddr(nh.Via), iface, weight))
+	}
+	sort.Strings(hops)
+	return strings.Join(hops, ",")
+}
+
+// readRouteAttributes loads RouteAttributesFile, keyed by RouteSpec.key. A
+// missing file is an empty set.
+func readRouteAttributes(path string) (map[string]RouteSpec, error) {
+	specs := make(map[string]RouteSpec)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return specs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []RouteSpec
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, s := range list {
+		if s.Route != nil {
+			specs[s.key()] = s
+		}
+	}
+	return specs, nil
+}
+
+// updateRouteAttributes stores s under its key when it has attributes and
+// drops the key otherwise (remove drops it either way).
+func updateRouteAttributes(path string, s RouteSpec, remove bool) error {
+	specs, err := readRouteAttributes(path)
+	if err != nil {
+		return err
+	}
+	_, had := specs[s.key()]
+	if remove || !s.hasAttributes() {
+		if !had {
+			return nil
+		}
+		delete(specs, s.key())
+	} else {
+		specs[s.key()] = s
+	}
+
+	list := make([]RouteSpec, 0, len(specs))
+	for _, spec := range specs {
+		list = append(list, spec)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// linkNameByIndex names an interface index, "" when it is gone.
+func linkNameByIndex(index int) string {
+	if link, err := netlink.LinkByIndex(index); err == nil {
+		return link.Attrs().Name
+	}
+	return ""
+}
+
+// ListRouteSpecs returns the routes of all tables with their nexthops and
+// attributes.
+func ListRouteSpecs() ([]RouteSpec, error) {
+	return getCurrentRouteSpecs()
+}
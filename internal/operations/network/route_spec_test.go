@@ -0,0 +1,202 @@
+package network
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"github.com/vishvananda/netlink"
+	"gopkg.in/yaml.v3"
+)
+
+func ecmpDefault() RouteSpec {
+	return RouteSpec{
+		Route: &client.Route{To: "0.0.0.0/0", Interface: "lo", Metric: 10, Protocol: "static"},
+		Nexthops: []RouteNexthop{
+			{Via: "10.0.0.1", Weight: 3},
+			{Via: "10.0.1.1"},
+		},
+		MTU:      1400,
+		AdvMSS:   1360,
+		InitCwnd: 10,
+	}
+}
+
+func TestRouteSpecToNetlinkMultipath(t *testing.T) {
+	rm := newTestRouteManager(t)
+	got, err := rm.routeSpecToNetlink(ecmpDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Gw != nil || got.LinkIndex != 0 || got.Dst.String() != "0.0.0.0/0" {
+		t.Fatalf("route-level gateway or link left on a multipath route: %+v", got)
+	}
+	if len(got.MultiPath) != 2 || got.MultiPath[0].Hops != 2 || got.MultiPath[1].Hops != 0 ||
+		got.MultiPath[0].Gw.String() != "10.0.0.1" || got.MultiPath[1].LinkIndex == 0 {
+		t.Fatalf("nexthops = %+v %+v", got.MultiPath[0], got.MultiPath[1])
+	}
+	if got.MTU != 1400 || got.AdvMSS != 1360 || got.InitCwnd != 10 || got.Protocol != 4 || got.Priority != 10 {
+		t.Fatalf("attributes = mtu %d advmss %d initcwnd %d protocol %d metric %d", got.MTU, got.AdvMSS, got.InitCwnd, got.Protocol, got.Priority)
+	}
+}
+
+func TestRouteSpecRejected(t *testing.T) {
+	rm := newTestRouteManager(t)
+	route := func() *client.Route { return &client.Route{To: "0.0.0.0/0", Interface: "lo"} }
+	rejected := map[string]RouteSpec{
+		"single nexthop":   {Route: route(), Nexthops: []RouteNexthop{{Via: "10.0.0.1"}}},
+		"via and hops":     {Route: &client.Route{To: "0.0.0.0/0", Via: "10.0.0.1"}, Nexthops: []RouteNexthop{{Via: "10.0.0.2"}, {Via: "10.0.0.3"}}},
+		"weight":           {Route: route(), Nexthops: []RouteNexthop{{Via: "10.0.0.1", Weight: 300}, {Via: "10.0.0.2"}}},
+		"duplicate hop":    {Route: route(), Nexthops: []RouteNexthop{{Via: "10.0.0.1"}, {Via: "10.0.0.1"}}},
+		"mixed families":   {Route: route(), Nexthops: []RouteNexthop{{Via: "10.0.0.1"}, {Via: "2001:db8::1"}}},
+		"mtu":              {Route: &client.Route{To: "10.1.0.0/16", Via: "10.0.0.1"}, MTU: 20},
+		"bgp protocol":     {Route: &client.Route{To: "10.1.0.0/16", Via: "10.0.0.1", Protocol: "bgp"}},
+		"unknown protocol": {Route: &client.Route{To: "10.1.0.0/16", Via: "10.0.0.1", Protocol: "nope"}},
+	}
+	for name, spec := range rejected {
+		if _, err := rm.routeSpecToNetlink(spec); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRouteProtocolNumber(t *testing.T) {
+	for name, want := range map[string]int{"": 0, "unspecified": 0, "boot": 3, "static": 4, "unknown-200": 200, "201": 201} {
+		if got, err := routeProtocolNumber(name); err != nil || got != want {
+			t.Errorf("routeProtocolNumber(%q) = %d, %v; want %d", name, got, err, want)
+		}
+	}
+	if routeProtocolTagged("boot") || !routeProtocolTagged("static") {
+		t.Fatal("only protocols other than the netlink default count as tagged")
+	}
+}
+
+func TestProtoRouteSpecIgnoresProtocol(t *testing.T) {
+	route := &client.Route{To: "10.1.0.0/16", Via: "10.0.0.1", Protocol: "kernel"}
+	spec := protoRouteSpec(route)
+	if spec.Protocol != "" || spec.hasAttributes() {
+		t.Fatalf("spec = %+v", spec.Route)
+	}
+	if route.Protocol != "kernel" {
+		t.Error("the request's route was modified")
+	}
+	if _, err := newTestRouteManager(t).routeSpecToNetlink(spec); err != nil {
+		t.Errorf("route reported with protocol kernel refused: %v", err)
+	}
+}
+
+func TestRouteSpecFromNetlinkRoundTrip(t *testing.T) {
+	names := map[int]string{2: "eth0", 3: "eth1"}
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	spec := routeSpecFromNetlink(netlink.Route{
+		Family:   netlink.FAMILY_V4,
+		Dst:      dst,
+		Table:    SystemTableMain,
+		Priority: 10,
+		Protocol: 4,
+		MTU:      1400,
+		AdvMSS:   1360,
+		InitCwnd: 10,
+		MultiPath: []*netlink.NexthopInfo{
+			{LinkIndex: 2, Gw: net.ParseIP("10.0.0.1"), Hops: 2},
+			{LinkIndex: 3, Gw: net.ParseIP("10.0.1.1")},
+		},
+	}, func(i int) string { return names[i] })
+
+	if spec.To != "0.0.0.0/0" || !spec.IsDefault || spec.Protocol != "static" || spec.Via != "" {
+		t.Fatalf("route = %+v", spec.Route)
+	}
+	legs := spec.legs()
+	if len(legs) != 2 || legs[0].Via != "10.0.0.1" || legs[0].Interface != "eth0" || legs[1].Interface != "eth1" || legs[1].Metric != 10 {
+		t.Fatalf("legs = %+v", legs)
+	}
+
+	want := RouteSpec{
+		Route:    &client.Route{To: "default", Metric: 10, Protocol: "static"},
+		Nexthops: []RouteNexthop{{Via: "10.0.1.1", Interface: "eth1"}, {Via: "10.0.0.1", Interface: "eth0", Weight: 3}},
+		MTU:      1400, AdvMSS: 1360, InitCwnd: 10,
+	}
+	if want.key() != spec.key() || !sameRouteAttributes(want, spec) {
+		t.Fatalf("persisted %q does not match live %q", want.key(), spec.key())
+	}
+	want.Nexthops[1].Weight = 1
+	if sameRouteAttributes(want, spec) {
+		t.Fatal("weight drift not detected")
+	}
+}
+
+func TestCompareRouteAttributes(t *testing.T) {
+	spec := ecmpDefault()
+	spec.Interface = "eth0"
+	persisted := map[string]RouteSpec{spec.key(): spec}
+
+	// networkd restored only the first netplan leg.
+	live := RouteSpec{Route: &client.Route{To: "0.0.0.0/0", Via: "10.0.0.1", Interface: "eth0", Table: SystemTableMain, Metric: 10, Protocol: "static"},
+		MTU: 1400, InitCwnd: 10}
+	if got := compareRouteAttributes(persisted, []RouteSpec{live}); len(got) != 1 {
+		t.Fatalf("single leg not reported: %+v", got)
+	}
+	if got := compareRouteAttributes(persisted, nil); len(got) != 1 {
+		t.Fatalf("missing route not reported: %+v", got)
+	}
+
+	live.Via = ""
+	live.AdvMSS = 1360
+	live.Nexthops = []RouteNexthop{{Via: "10.0.1.1", Interface: "eth0", Weight: 1}, {Via: "10.0.0.1", Interface: "eth0", Weight: 3}}
+	if got := compareRouteAttributes(persisted, []RouteSpec{live}); len(got) != 0 {
+		t.Fatalf("matching route reported: %+v", got)
+	}
+
+	// An IPv6 spec without a metric lives at the kernel's 1024.
+	v6 := RouteSpec{Route: &client.Route{To: "2001:db8:1::/48", Via: "2001:db8::1", Interface: "eth0"}, MTU: 1400}
+	liveV6 := RouteSpec{Route: &client.Route{To: "2001:db8:1::/48", Via: "2001:db8::1", Interface: "eth0", Table: SystemTableMain, Metric: 1024}, MTU: 1400}
+	if got := compareRouteAttributes(map[string]RouteSpec{v6.key(): v6}, []RouteSpec{liveV6}); len(got) != 0 {
+		t.Fatalf("IPv6 spec without a metric reported: %+v", got)
+	}
+}
+
+func TestRouteAttributesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network", "route-attributes.json")
+	spec := ecmpDefault()
+	if err := updateRouteAttributes(path, spec, false); err != nil {
+		t.Fatal(err)
+	}
+	plain := RouteSpec{Route: &client.Route{To: "10.1.0.0/16", Via: "10.0.0.1", Interface: "eth0"}}
+	if err := updateRouteAttributes(path, plain, false); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := readRouteAttributes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := specs["254|0.0.0.0/0|10"]
+	if len(specs) != 1 || !ok || len(got.Nexthops) != 2 || got.Nexthops[0].Weight != 3 || got.AdvMSS != 1360 {
+		t.Fatalf("stored specs = %+v", specs)
+	}
+
+	if err := updateRouteAttributes(path, spec, true); err != nil {
+		t.Fatal(err)
+	}
+	if specs, _ := readRouteAttributes(path); len(specs) != 0 {
+		t.Fatalf("removed spec still stored: %+v", specs)
+	}
+}
+
+func TestRouteSpecNetplanEntries(t *testing.T) {
+	spec := ecmpDefault()
+	cfg := newRouteConfig()
+	for _, leg := range spec.legs() {
+		upsertRouteEntry(cfg, leg.Interface, spec.netplanEntry(leg))
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if strings.Count(out, "mtu: 1400") != 2 || strings.Count(out, "congestion-window: 10") != 2 ||
+		!strings.Contains(out, "via: 10.0.0.1") || !strings.Contains(out, "via: 10.0.1.1") {
+		t.Fatalf("netplan routes:\n%s", out)
+	}
+}
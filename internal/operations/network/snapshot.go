@@ -0,0 +1,715 @@
+package network
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/grpc"
+)
+
+// SnapshotDir holds the network snapshots, one <id>.tar.gz each.
+var SnapshotDir = filepath.Join(models.ElchiLibPath, "network-snapshots")
+
+const (
+	// SnapshotFormatVersion is the archive layout version written into each
+	// manifest; restore refuses archives from a newer layout.
+	SnapshotFormatVersion = 1
+	DefaultSnapshotKeep   = 10
+
+	snapshotSuffix       = ".tar.gz"
+	snapshotManifestFile = "manifest.json"
+	snapshotNetplanDir   = "netplan"
+	snapshotTablesFile   = "rt_tables.conf"
+	maxSnapshotBytes     = 16 << 20
+)
+
+// snapshotNetplanGlobs are the Elchi-managed netplan files: typed interfaces
+// (50-elchi-if-*), deployment dummies (90-elchi-if-*) and the managed config,
+// route, policy and VRF files (99-elchi-*). Legacy route files
+// (models.NetplanRoutePrefix) are left out: the client may not write them.
+var snapshotNetplanGlobs = []string{models.NetplanIfPrefix + "*.yaml", "90-elchi-if-*.yaml", "99-elchi-*.yaml"}
+
+// deploymentNetplanPrefix names the dummy files deploy and undeploy own.
+// Restore never removes one of them, as that would pull the address from
+// under a running envoy.
+const deploymentNetplanPrefix = "90-elchi-if-"
+
+var snapshotIDPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{3}Z$`)
+
+// snapshotMu serializes snapshot creation, restore and pruning.
+var snapshotMu sync.Mutex
+
+// ErrSnapshotNotFound is returned for an unknown snapshot ID.
+var ErrSnapshotNotFound = errors.New("network snapshot not found")
+
+// NetworkSnapshot is the manifest of one snapshot archive.
+type NetworkSnapshot struct {
+	Version       int                              `json:"version"`
+	ID            string                           `json:"id"`
+	Label         string                           `json:"label,omitempty"`
+	CreatedAt     time.Time                        `json:"created_at"`
+	NetplanFiles  []string                         `json:"netplan_files"`
+	RoutingTables []*client.RoutingTableDefinition `json:"routing_tables"`
+	// Rules and Routes are the live state: Elchi-priority rules and the
+	// routes of Elchi-managed tables.
+	Rules    []*client.RoutingPolicy `json:"rules"`
+	Routes   []*client.Route         `json:"routes"`
+	Dummies  []SnapshotDummy         `json:"dummies"`
+	Warnings []string                `json:"warnings,omitempty"`
+	// Size is the archive size; it is filled in when listing.
+	Size int64 `json:"size,omitempty"`
+}
+
+// SnapshotDummy is a live elchi-if-* dummy interface.
+type SnapshotDummy struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// SnapshotRestoreOptions control the netplan try of a restore. A restore
+// always runs in test mode.
+type SnapshotRestoreOptions struct {
+	PreserveControllerConnection bool   `json:"preserve_controller_connection"`
+	TestTimeoutSeconds           uint32 `json:"test_timeout_seconds,omitempty"`
+}
+
+// DefaultSnapshotRestoreOptions keeps the controller connection monitored.
+func DefaultSnapshotRestoreOptions() SnapshotRestoreOptions {
+	return SnapshotRestoreOptions{PreserveControllerConnection: true}
+}
+
+// SnapshotRestoreResult reports what a restore changed.
+type SnapshotRestoreResult struct {
+	Snapshot string `json:"snapshot"`
+	// PreRestore is the snapshot taken automatically before the restore.
+	PreRestore string   `json:"pre_restore"`
+	Written    []string `json:"written"`
+	Removed    []string `json:"removed"`
+	// Kept are deployment dummy files created after the snapshot.
+	Kept     []string `json:"kept,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type SnapshotManager struct {
+	dir             string
+	netplanPath     string
+	tablePath       string
+	kernelTablePath string
+	logger          *logger.Logger
+	grpcConn        *grpc.ClientConn
+	clientID        string
+}
+
+func NewSnapshotManager(logger *logger.Logger) *SnapshotManager {
+	return &SnapshotManager{
+		dir:             SnapshotDir,
+		netplanPath:     models.NetplanPath,
+		tablePath:       ElchiTableFile,
+		kernelTablePath: KernelTableLink,
+		logger:          logger,
+	}
+}
+
+// SetGRPCConnection sets the connection monitored while a restore is tried.
+func (sm *SnapshotManager) SetGRPCConnection(conn *grpc.ClientConn, clientID string) {
+	sm.grpcConn = conn
+	sm.clientID = clientID
+}
+
+// Create archives the Elchi-managed netplan files, the routing table
+// definitions and the live rules, routes and dummy interfaces.
+func (sm *SnapshotManager) Create(label string) (*NetworkSnapshot, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	return sm.create(label)
+}
+
+func (sm *SnapshotManager) create(label string) (*NetworkSnapshot, error) {
+	snap := &NetworkSnapshot{
+		Version:   SnapshotFormatVersion,
+		Label:     label,
+		CreatedAt: time.Now().UTC(),
+	}
+	snap.ID = snap.CreatedAt.Format("20060102T150405.000Z")
+
+	files, err := sm.readElchiNetplanFiles()
+	if err != nil {
+		return nil, err
+	}
+	for name := range files {
+		snap.NetplanFiles = append(snap.NetplanFiles, name)
+	}
+	sort.Strings(snap.NetplanFiles)
+
+	tables, err := sm.readTablesFile()
+	if err != nil {
+		return nil, err
+	}
+	snap.RoutingTables = parseTableDefinitions(tables)
+
+	sm.collectLiveState(snap)
+
+	if err := os.MkdirAll(sm.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", sm.dir, err)
+	}
+	path := sm.archivePath(snap.ID)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("snapshot %s already exists", snap.ID)
+	}
+	if err := writeSnapshotArchive(path, snap, files, tables); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil {
+		snap.Size = info.Size()
+	}
+	sm.logger.Infof("Network snapshot %s created with %d netplan files, %d rules, %d routes",
+		snap.ID, len(snap.NetplanFiles), len(snap.Rules), len(snap.Routes))
+	return snap, nil
+}
+
+// collectLiveState records the kernel side. Failures are kept as warnings;
+// the files alone are still a usable snapshot.
+func (sm *SnapshotManager) collectLiveState(snap *NetworkSnapshot) {
+	rules, err := NewPolicyManager(sm.logger).GetCurrentPolicies()
+	if err != nil {
+		snap.Warnings = append(snap.Warnings, fmt.Sprintf("rules not captured: %v", err))
+	}
+	snap.Rules = rules
+
+	routes, err := getCurrentRoutes()
+	if err != nil {
+		snap.Warnings = append(snap.Warnings, fmt.Sprintf("routes not captured: %v", err))
+	}
+	for _, route := range routes {
+		if isElchiManagedTableID(int(route.Table)) {
+			snap.Routes = append(snap.Routes, route)
+		}
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		snap.Warnings = append(snap.Warnings, fmt.Sprintf("dummy interfaces not captured: %v", err))
+	}
+	for _, link := range links {
+		if link.Type() != "dummy" || !strings.HasPrefix(link.Attrs().Name, "elchi-if-") {
+			continue
+		}
+		dummy := SnapshotDummy{Name: link.Attrs().Name}
+		if addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL); err == nil {
+			for _, addr := range addrs {
+				dummy.Addresses = append(dummy.Addresses, addr.IP.String())
+			}
+		}
+		snap.Dummies = append(snap.Dummies, dummy)
+	}
+}
+
+// readElchiNetplanFiles reads every Elchi-managed netplan file. Unlike a
+// plan, a snapshot missing one would delete it on restore, so an
+// unreadable file is an error.
+func (sm *SnapshotManager) readElchiNetplanFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, glob := range snapshotNetplanGlobs {
+		paths, err := filepath.Glob(filepath.Join(sm.netplanPath, glob))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			files[filepath.Base(path)] = data
+		}
+	}
+	return files, nil
+}
+
+// readTablesFile returns the table definitions the kernel reads, or nil
+// when Elchi has none.
+func (sm *SnapshotManager) readTablesFile() ([]byte, error) {
+	for _, path := range []string{sm.kernelTablePath, sm.tablePath} {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return nil, nil
+}
+
+func isElchiNetplanFile(name string) bool {
+	for _, glob := range snapshotNetplanGlobs {
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (sm *SnapshotManager) archivePath(id string) string {
+	return filepath.Join(sm.dir, id+snapshotSuffix)
+}
+
+func writeSnapshotArchive(path string, snap *NetworkSnapshot, files map[string][]byte, tables []byte) error {
+	manifest, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: snap.CreatedAt, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(snapshotManifestFile, manifest); err != nil {
+		return err
+	}
+	for _, name := range snap.NetplanFiles {
+		if err := add(snapshotNetplanDir+"/"+name, files[name]); err != nil {
+			return err
+		}
+	}
+	if tables != nil {
+		if err := add(snapshotTablesFile, tables); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// readSnapshotManifest reads only the manifest of the archive at path.
+func readSnapshotManifest(path string) (*NetworkSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("snapshot %s has no manifest", path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+		}
+		if hdr.Name != snapshotManifestFile {
+			continue
+		}
+		var snap NetworkSnapshot
+		if err := json.NewDecoder(io.LimitReader(tr, maxSnapshotBytes)).Decode(&snap); err != nil {
+			return nil, fmt.Errorf("invalid manifest in %s: %w", path, err)
+		}
+		if info, err := f.Stat(); err == nil {
+			snap.Size = info.Size()
+		}
+		return &snap, nil
+	}
+}
+
+// List returns the stored snapshots, newest first.
+func (sm *SnapshotManager) List() ([]NetworkSnapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(sm.dir, "*"+snapshotSuffix))
+	if err != nil {
+		return nil, err
+	}
+	snaps := []NetworkSnapshot{}
+	for _, path := range paths {
+		snap, err := readSnapshotManifest(path)
+		if err != nil {
+			sm.logger.Warnf("Skipping network snapshot: %v", err)
+			continue
+		}
+		snaps = append(snaps, *snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID > snaps[j].ID })
+	return snaps, nil
+}
+
+// Get returns the manifest of snapshot id.
+func (sm *SnapshotManager) Get(id string) (*NetworkSnapshot, error) {
+	if !snapshotIDPattern.MatchString(id) {
+		return nil, ErrSnapshotNotFound
+	}
+	snap, err := readSnapshotManifest(sm.archivePath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrSnapshotNotFound
+	}
+	return snap, err
+}
+
+// Delete removes snapshot id.
+func (sm *SnapshotManager) Delete(id string) error {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	if !snapshotIDPattern.MatchString(id) {
+		return ErrSnapshotNotFound
+	}
+	err := os.Remove(sm.archivePath(id))
+	if os.IsNotExist(err) {
+		return ErrSnapshotNotFound
+	}
+	return err
+}
+
+// Prune keeps the newest keep snapshots and returns the IDs it removed.
+func (sm *SnapshotManager) Prune(keep int) ([]string, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	if keep < 1 {
+		return nil, fmt.Errorf("keep must be at least 1")
+	}
+	paths, err := filepath.Glob(filepath.Join(sm.dir, "*"+snapshotSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, path := range paths {
+		if id := strings.TrimSuffix(filepath.Base(path), snapshotSuffix); snapshotIDPattern.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	var removed []string
+	for _, id := range ids[min(keep, len(ids)):] {
+		if err := os.Remove(sm.archivePath(id)); err != nil {
+			return removed, fmt.Errorf("failed to prune snapshot %s: %w", id, err)
+		}
+		removed = append(removed, id)
+	}
+	if len(removed) > 0 {
+		sm.logger.Infof("Pruned %d network snapshots", len(removed))
+	}
+	return removed, nil
+}
+
+// extract unpacks snapshot id into a temporary directory the caller removes.
+func (sm *SnapshotManager) extract(id string) (*NetworkSnapshot, string, error) {
+	snap, err := sm.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if snap.Version > SnapshotFormatVersion {
+		return nil, "", fmt.Errorf("snapshot %s has format %d, this client reads up to %d", id, snap.Version, SnapshotFormatVersion)
+	}
+	root, err := os.MkdirTemp("", "elchi-snapshot-*")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := common.ExtractTarball(sm.archivePath(id), root, maxSnapshotBytes); err != nil {
+		os.RemoveAll(root)
+		return nil, "", fmt.Errorf("failed to extract snapshot %s: %w", id, err)
+	}
+	return snap, root, nil
+}
+
+// planSnapshotRestore splits the change from the current Elchi netplan files
+// to the snapshot ones into files to write and files to remove. Deployment
+// dummy files the snapshot lacks are kept.
+func planSnapshotRestore(current, snapshot map[string][]byte) (write, remove, kept []string) {
+	for name, data := range snapshot {
+		if old, ok := current[name]; !ok || !bytes.Equal(old, data) {
+			write = append(write, name)
+		}
+	}
+	for name := range current {
+		if _, ok := snapshot[name]; ok {
+			continue
+		}
+		if strings.HasPrefix(name, deploymentNetplanPrefix) {
+			kept = append(kept, name)
+		} else {
+			remove = append(remove, name)
+		}
+	}
+	sort.Strings(write)
+	sort.Strings(remove)
+	sort.Strings(kept)
+	return write, remove, kept
+}
+
+// snapshotNetplanFiles reads the netplan files of the snapshot extracted to
+// root. Legacy route files, which older snapshots archived, are skipped with
+// a warning: the sudoers rules do not let the client write them.
+func snapshotNetplanFiles(snap *NetworkSnapshot, root string) (map[string][]byte, []string, error) {
+	restored := make(map[string][]byte)
+	var warnings []string
+	for _, name := range snap.NetplanFiles {
+		if filepath.Base(name) == name && strings.HasPrefix(name, models.NetplanRoutePrefix) {
+			warnings = append(warnings, fmt.Sprintf("legacy route file %s in the snapshot was not restored", name))
+			continue
+		}
+		if !isElchiNetplanFile(name) || filepath.Base(name) != name {
+			return nil, nil, fmt.Errorf("snapshot %s lists unexpected netplan file %q", snap.ID, name)
+		}
+		data, err := os.ReadFile(filepath.Join(root, snapshotNetplanDir, name))
+		if err != nil {
+			return nil, nil, fmt.Errorf("snapshot %s is incomplete: %w", snap.ID, err)
+		}
+		restored[name] = data
+	}
+	return restored, warnings, nil
+}
+
+// Restore brings the Elchi-managed network configuration back to snapshot
+// id. A snapshot of the current state is taken first. The netplan files are
+// validated with netplan generate, written and tried with the connection
+// monitor; a failed try restores the previous files and tables. Rules,
+// routes and dummies of the snapshot are then re-added to the kernel; live
+// ones that are not in the snapshot are left in place and reported.
+func (sm *SnapshotManager) Restore(id string, opts SnapshotRestoreOptions) (*SnapshotRestoreResult, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	snap, root, err := sm.extract(id)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+
+	result := &SnapshotRestoreResult{Snapshot: id}
+	restored, warnings, err := snapshotNetplanFiles(snap, root)
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = append(result.Warnings, warnings...)
+	current, err := sm.readElchiNetplanFiles()
+	if err != nil {
+		return nil, err
+	}
+	result.Written, result.Removed, result.Kept = planSnapshotRestore(current, restored)
+	for _, name := range result.Kept {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("%s is not in the snapshot and was kept for its deployment", name))
+	}
+
+	// Validate the resulting netplan directory before anything changes.
+	proposed, warnings := readNetplanFiles(sm.netplanPath, func(name string) bool { return !isElchiNetplanFile(name) })
+	result.Warnings = append(result.Warnings, warnings...)
+	for _, name := range result.Kept {
+		proposed[name] = current[name]
+	}
+	for name, data := range restored {
+		proposed[name] = data
+	}
+	if gen := generateNetplanRoot(proposed); !gen.OK {
+		return result, fmt.Errorf("snapshot %s fails netplan generate: %s", id, gen.Output)
+	}
+
+	pre, err := sm.create("pre-restore " + id)
+	if err != nil {
+		return result, fmt.Errorf("failed to snapshot the current state: %w", err)
+	}
+	result.PreRestore = pre.ID
+
+	tm := &TableManager{logger: sm.logger, tablePath: sm.tablePath}
+	previousTables, err := tm.readTableDefinitions()
+	if err != nil {
+		return result, fmt.Errorf("failed to read routing tables: %w", err)
+	}
+	snapshotTables := []*client.RoutingTableDefinition{}
+	if data, err := os.ReadFile(filepath.Join(root, snapshotTablesFile)); err == nil {
+		snapshotTables = parseTableDefinitions(data)
+	}
+	if err := tm.writeTableDefinitions(snapshotTables); err != nil {
+		return result, fmt.Errorf("failed to restore routing tables: %w", err)
+	}
+
+	if len(result.Written)+len(result.Removed) > 0 {
+		if err := sm.tryNetplanFiles(restored, current, result, opts); err != nil {
+			if werr := tm.writeTableDefinitions(previousTables); werr != nil {
+				sm.logger.Errorf("Failed to restore previous routing tables: %v", werr)
+			}
+			return result, err
+		}
+	}
+
+	result.Warnings = append(result.Warnings, sm.restoreLiveState(snap)...)
+	sm.logger.Infof("Network snapshot %s restored (%d files written, %d removed)", id, len(result.Written), len(result.Removed))
+	return result, nil
+}
+
+// tryNetplanFiles writes the planned files and applies them through the
+// netplan try path; a failed try puts current back.
+func (sm *SnapshotManager) tryNetplanFiles(restored, current map[string][]byte, result *SnapshotRestoreResult, opts SnapshotRestoreOptions) error {
+	touched := append(append([]string{}, result.Written...), result.Removed...)
+	rolledBack := false
+	restorePrevious := func() error {
+		rolledBack = true
+		for _, name := range touched {
+			if data, ok := current[name]; ok {
+				if err := writeNetplanFile(filepath.Join(sm.netplanPath, name), data); err != nil {
+					return err
+				}
+			} else if err := os.Remove(filepath.Join(sm.netplanPath, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, name := range result.Written {
+		if err := writeNetplanFile(filepath.Join(sm.netplanPath, name), restored[name]); err != nil {
+			restorePrevious()
+			return err
+		}
+	}
+	for _, name := range result.Removed {
+		if err := os.Remove(filepath.Join(sm.netplanPath, name)); err != nil && !os.IsNotExist(err) {
+			restorePrevious()
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+
+	nm := NewNetplanManager(sm.logger)
+	nm.netplanPath = sm.netplanPath
+	nm.restorePrevious = restorePrevious
+	if sm.grpcConn != nil {
+		nm.SetGRPCConnection(sm.grpcConn, sm.clientID)
+	}
+	err := nm.applyWithTest(&client.NetplanConfig{
+		TestMode:                     true,
+		PreserveControllerConnection: opts.PreserveControllerConnection,
+		TestTimeoutSeconds:           opts.TestTimeoutSeconds,
+	})
+	if err == nil {
+		return nil
+	}
+	if !rolledBack {
+		if rbErr := nm.rollback(); rbErr != nil {
+			return fmt.Errorf("restore failed: %w (rollback failed: %v)", err, rbErr)
+		}
+	}
+	return fmt.Errorf("restore rolled back: %w", err)
+}
+
+// writeNetplanFile writes a root-owned netplan file.
+func writeNetplanFile(path string, data []byte) error {
+	cmd := exec.Command("sudo", "tee", path)
+	cmd.Stdin = bytes.NewReader(data)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to write %s via sudo tee: %w", path, err)
+	}
+	if err := exec.Command("sudo", "chmod", "0600", path).Run(); err != nil {
+		return fmt.Errorf("failed to set permissions for %s: %w", path, err)
+	}
+	return nil
+}
+
+// restoreLiveState re-adds the snapshot's rules, routes and dummies and
+// returns warnings for what could not be restored or was not in it.
+func (sm *SnapshotManager) restoreLiveState(snap *NetworkSnapshot) []string {
+	var warnings []string
+	routeStateLock.Lock()
+	defer routeStateLock.Unlock()
+
+	pm := NewPolicyManager(sm.logger)
+	wanted := make(map[string]bool)
+	for _, rule := range snap.Rules {
+		wanted[pm.createPolicyKey(rule.From, rule.To, int(rule.Table), int(rule.Priority))] = true
+		if err := pm.addRuntimePolicy(rule); err != nil {
+			warnings = append(warnings, fmt.Sprintf("rule priority %d to table %d not restored: %v", rule.Priority, rule.Table, err))
+		}
+	}
+	if live, err := pm.GetCurrentPolicies(); err == nil {
+		for _, rule := range live {
+			if !wanted[pm.createPolicyKey(rule.From, rule.To, int(rule.Table), int(rule.Priority))] {
+				warnings = append(warnings, fmt.Sprintf("rule priority %d to table %d is not in the snapshot and was left in place", rule.Priority, rule.Table))
+			}
+		}
+	}
+
+	rm := NewRouteManagerNew(sm.logger)
+	wanted = make(map[string]bool)
+	for _, route := range snap.Routes {
+		wanted[snapshotRouteKey(route)] = true
+		nlRoute, err := rm.clientRouteToNetlink(route)
+		if err == nil {
+			err = netlink.RouteReplace(nlRoute)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("route to %s in table %d not restored: %v", route.To, route.Table, err))
+		}
+	}
+	if live, err := getCurrentRoutes(); err == nil {
+		for _, route := range live {
+			if isElchiManagedTableID(int(route.Table)) && !wanted[snapshotRouteKey(route)] {
+				warnings = append(warnings, fmt.Sprintf("route to %s in table %d is not in the snapshot and was left in place", route.To, route.Table))
+			}
+		}
+	}
+
+	// netplan apply recreates the dummies from their files; this covers the
+	// ones networkd has not brought back yet.
+	for _, dummy := range snap.Dummies {
+		if _, err := netlink.LinkByName(dummy.Name); err == nil {
+			continue
+		}
+		var addresses []string
+		for _, a := range dummy.Addresses {
+			if ip := net.ParseIP(a); ip != nil && !ip.IsLinkLocalUnicast() {
+				addresses = append(addresses, a)
+			}
+		}
+		if len(addresses) == 0 {
+			warnings = append(warnings, fmt.Sprintf("dummy %s not restored: no address in the snapshot", dummy.Name))
+			continue
+		}
+		if err := SetupDummyInterfaceWithNetlink(dummy.Name, strings.Join(addresses, ","), sm.logger); err != nil {
+			warnings = append(warnings, fmt.Sprintf("dummy %s not restored: %v", dummy.Name, err))
+		}
+	}
+	return warnings
+}
+
+func snapshotRouteKey(route *client.Route) string {
+	return fmt.Sprintf("%d|%s|%s|%s|%d", route.Table, route.To, route.Via, route.Interface, route.Metric)
+}
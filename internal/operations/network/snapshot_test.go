@@ -0,0 +1,187 @@
+package network
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+func testSnapshotManager(t *testing.T) *SnapshotManager {
+	t.Helper()
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatalf("logger init: %v", err)
+	}
+	netplanDir := t.TempDir()
+	tableDir := t.TempDir()
+	return &SnapshotManager{
+		dir:             filepath.Join(t.TempDir(), "snapshots"),
+		netplanPath:     netplanDir,
+		tablePath:       filepath.Join(tableDir, "rt_tables.conf"),
+		kernelTablePath: filepath.Join(tableDir, "elchi.conf"),
+		logger:          logger.NewLogger("snapshot-test"),
+	}
+}
+
+func TestPlanSnapshotRestore(t *testing.T) {
+	current := map[string][]byte{
+		"99-elchi-interfaces.yaml":  []byte("a"),
+		"99-elchi-route-eth0.yaml":  []byte("old"),
+		"50-elchi-if-bond0.yaml":    []byte("bond"),
+		"90-elchi-if-8080.yaml":     []byte("dummy"),
+		"99-elchi-policy-eth0.yaml": []byte("p"),
+		"90-elchi-if-9090.yaml":     []byte("kept"),
+	}
+	snapshot := map[string][]byte{
+		"99-elchi-interfaces.yaml": []byte("a"),
+		"99-elchi-route-eth0.yaml": []byte("new"),
+		"90-elchi-if-8080.yaml":    []byte("dummy"),
+		"99-elchi-vrf-blue.yaml":   []byte("vrf"),
+	}
+	write, remove, kept := planSnapshotRestore(current, snapshot)
+	if want := []string{"99-elchi-route-eth0.yaml", "99-elchi-vrf-blue.yaml"}; !reflect.DeepEqual(write, want) {
+		t.Errorf("write = %v, want %v", write, want)
+	}
+	if want := []string{"50-elchi-if-bond0.yaml", "99-elchi-policy-eth0.yaml"}; !reflect.DeepEqual(remove, want) {
+		t.Errorf("remove = %v, want %v", remove, want)
+	}
+	if want := []string{"90-elchi-if-9090.yaml"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept = %v, want %v", kept, want)
+	}
+}
+
+func TestSnapshotCreateListPrune(t *testing.T) {
+	sm := testSnapshotManager(t)
+	os.WriteFile(filepath.Join(sm.netplanPath, NetplanConfigFile), []byte(currentNetplan), 0600)
+	os.WriteFile(filepath.Join(sm.netplanPath, "90-elchi-if-8080.yaml"), []byte("network: {version: 2}\n"), 0600)
+	os.WriteFile(filepath.Join(sm.netplanPath, "50-cloud-init.yaml"), []byte("network: {version: 2}\n"), 0600)
+	os.WriteFile(sm.tablePath, []byte("# Elchi-managed routing tables\n100\tblue\n"), 0644)
+
+	snap, err := sm.Create("before upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"90-elchi-if-8080.yaml", NetplanConfigFile}; !reflect.DeepEqual(snap.NetplanFiles, want) {
+		t.Errorf("netplan files = %v, want %v", snap.NetplanFiles, want)
+	}
+	if len(snap.RoutingTables) != 1 || snap.RoutingTables[0].Name != "blue" {
+		t.Errorf("routing tables = %v", snap.RoutingTables)
+	}
+
+	got, err := sm.Get(snap.ID)
+	if err != nil || got.Label != "before upgrade" || got.Version != SnapshotFormatVersion || got.Size == 0 {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	_, root, err := sm.extract(snap.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if data, _ := os.ReadFile(filepath.Join(root, snapshotNetplanDir, NetplanConfigFile)); string(data) != currentNetplan {
+		t.Errorf("archived %s = %q", NetplanConfigFile, data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, snapshotTablesFile)); len(data) == 0 {
+		t.Error("routing tables not archived")
+	}
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(2 * time.Millisecond)
+		if _, err := sm.Create(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := sm.List()
+	if err != nil || len(list) != 3 {
+		t.Fatalf("List = %d snapshots, %v", len(list), err)
+	}
+	if list[2].ID != snap.ID {
+		t.Errorf("oldest listed last: got %s, want %s", list[2].ID, snap.ID)
+	}
+
+	removed, err := sm.Prune(2)
+	if err != nil || !reflect.DeepEqual(removed, []string{snap.ID}) {
+		t.Errorf("Prune = %v, %v", removed, err)
+	}
+	if _, err := sm.Get(snap.ID); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("pruned snapshot still readable: %v", err)
+	}
+	if err := sm.Delete(list[0].ID); err != nil {
+		t.Error(err)
+	}
+	if _, err := sm.Prune(0); err == nil {
+		t.Error("Prune(0) accepted")
+	}
+	if _, err := sm.Get("../../etc/passwd"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Get of a path = %v", err)
+	}
+}
+
+func TestSnapshotRestoreRejectsInvalidNetplan(t *testing.T) {
+	sm := testSnapshotManager(t)
+	os.WriteFile(filepath.Join(sm.netplanPath, NetplanConfigFile), []byte(currentNetplan), 0600)
+	snap, err := sm.Create("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(sm.netplanPath, NetplanConfigFile), []byte(proposedNetplan), 0600)
+
+	oldCommand := netplanCommand
+	netplanCommand = "false"
+	defer func() { netplanCommand = oldCommand }()
+
+	if _, err := sm.Restore(snap.ID, DefaultSnapshotRestoreOptions()); err == nil {
+		t.Fatal("restore went ahead although netplan generate failed")
+	}
+	if data, _ := os.ReadFile(filepath.Join(sm.netplanPath, NetplanConfigFile)); string(data) != proposedNetplan {
+		t.Error("a rejected restore modified the netplan files")
+	}
+	if list, _ := sm.List(); len(list) != 1 {
+		t.Errorf("a rejected restore took a pre-restore snapshot: %d snapshots", len(list))
+	}
+	if _, err := sm.Restore("20200101T000000.000Z", DefaultSnapshotRestoreOptions()); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("restore of an unknown snapshot = %v", err)
+	}
+}
+
+func TestSnapshotSkipsLegacyRouteFiles(t *testing.T) {
+	sm := testSnapshotManager(t)
+	legacy := models.NetplanRoutePrefix + "eth0.yaml"
+	os.WriteFile(filepath.Join(sm.netplanPath, NetplanConfigFile), []byte(currentNetplan), 0600)
+	os.WriteFile(filepath.Join(sm.netplanPath, legacy), []byte("network: {version: 2}\n"), 0600)
+
+	snap, err := sm.Create("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{NetplanConfigFile}; !reflect.DeepEqual(snap.NetplanFiles, want) {
+		t.Errorf("netplan files = %v, want %v", snap.NetplanFiles, want)
+	}
+
+	// A snapshot taken before legacy files were excluded still lists one.
+	old := &NetworkSnapshot{Version: SnapshotFormatVersion, ID: "20200101T000000.000Z", NetplanFiles: []string{legacy, NetplanConfigFile}}
+	files := map[string][]byte{legacy: []byte("changed"), NetplanConfigFile: []byte(currentNetplan)}
+	if err := writeSnapshotArchive(sm.archivePath(old.ID), old, files, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, root, err := sm.extract(old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	restored, warnings, err := snapshotNetplanFiles(old, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restored[legacy]; ok || len(warnings) != 1 {
+		t.Errorf("legacy file restored: files %v, warnings %v", restored, warnings)
+	}
+	current, _ := sm.readElchiNetplanFiles()
+	if write, remove, _ := planSnapshotRestore(current, restored); len(write)+len(remove) != 0 {
+		t.Errorf("restore would write %v and remove %v", write, remove)
+	}
+}
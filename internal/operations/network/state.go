@@ -86,7 +86,7 @@ func GetNetworkState(cmd *client.Command, logger *logger.Logger) *client.Command
 		Result: &client.CommandResponse_Network{
 			Network: &client.ResponseNetwork{
 				Success:      true,
-				Message:      "Network state retrieved successfully",
+				Message:      "Network state retrieved successfully" + vrfSummary(logger),
 				NetworkState: networkState,
 			},
 		},
@@ -140,9 +140,24 @@ func getCurrentInterfaceStates() ([]*client.InterfaceState, error) {
 	return interfaces, nil
 }
 
-// getCurrentRoutes returns current routes from all routing tables
+// getCurrentRoutes returns current routes from all routing tables. A
+// multipath route is listed once per nexthop.
 func getCurrentRoutes() ([]*client.Route, error) {
+	specs, err := getCurrentRouteSpecs()
+	if err != nil {
+		return nil, err
+	}
 	var clientRoutes []*client.Route
+	for _, spec := range specs {
+		clientRoutes = append(clientRoutes, spec.legs()...)
+	}
+	return clientRoutes, nil
+}
+
+// getCurrentRouteSpecs returns current routes from all routing tables with
+// their nexthops and attributes.
+func getCurrentRouteSpecs() ([]RouteSpec, error) {
+	var specs []RouteSpec
 
 	// Get all routing tables first
 	tableManager := NewTableManager(logger.NewLogger("network"))
@@ -155,6 +170,18 @@ func getCurrentRoutes() ([]*client.Route, error) {
 		}
 	}
 
+	// VRF tables are normally Elchi-managed already; a VRF bound to any other
+	// table still gets its routes listed.
+	for _, table := range vrfTables() {
+		known := false
+		for _, t := range tables {
+			known = known || t.Id == table.Id
+		}
+		if !known {
+			tables = append(tables, table)
+		}
+	}
+
 	// Query each table separately
 	for _, table := range tables {
 		// Skip reserved tables that don't contain user routes
@@ -172,59 +199,11 @@ func getCurrentRoutes() ([]*client.Route, error) {
 			if route.Scope == netlink.SCOPE_HOST {
 				continue
 			}
-
-			clientRoute := &client.Route{
-				Table:  uint32(route.Table),
-				Metric: uint32(route.Priority),
-			}
-
-			// Set destination
-			if route.Dst != nil {
-				clientRoute.To = route.Dst.String()
-			} else {
-				clientRoute.To = "0.0.0.0/0"
-				clientRoute.IsDefault = true
-			}
-
-			// Set gateway
-			if route.Gw != nil {
-				clientRoute.Via = route.Gw.String()
-			}
-
-			// Set source
-			if route.Src != nil {
-				clientRoute.Source = route.Src.String()
-			}
-
-			// Set interface
-			if route.LinkIndex > 0 {
-				if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
-					clientRoute.Interface = link.Attrs().Name
-				}
-			}
-
-			// Set scope
-			switch route.Scope {
-			case netlink.SCOPE_UNIVERSE:
-				clientRoute.Scope = "global"
-			case netlink.SCOPE_SITE:
-				clientRoute.Scope = "site"
-			case netlink.SCOPE_LINK:
-				clientRoute.Scope = "link"
-			case netlink.SCOPE_HOST:
-				clientRoute.Scope = "host"
-			default:
-				clientRoute.Scope = "global"
-			}
-
-			// Set protocol
-			clientRoute.Protocol = getRouteProtocolName(int(route.Protocol))
-
-			clientRoutes = append(clientRoutes, clientRoute)
+			specs = append(specs, routeSpecFromNetlink(route, linkNameByIndex))
 		}
 	}
 
-	return clientRoutes, nil
+	return specs, nil
 }
 
 // getRouteProtocolName converts netlink protocol number to human-readable name
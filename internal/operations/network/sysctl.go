@@ -0,0 +1,405 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// SysctlConfPath is the sysctl.d entry systemd-sysctl loads at boot. The
+// installer links it to SysctlDir/90-elchi.conf, which the client writes
+// (like rt_tables.d/elchi.conf), and lets the client apply it with
+// "sudo sysctl -p SysctlConfPath" only.
+var SysctlConfPath = "/etc/sysctl.d/90-elchi.conf"
+
+// SysctlDir holds the conf file and the desired profiles and values it is
+// rendered from.
+var SysctlDir = filepath.Join(models.ElchiLibPath, "sysctl")
+
+const (
+	sysctlConfig = "config.json"
+	sysctlConf   = "90-elchi.conf"
+	sysctlHeader = "# Managed by elchi-client. Manual changes are overwritten.\n"
+)
+
+// sysctlAllowedPrefixes bound the explicit keys: network settings only, so
+// a config can never point kernel.modprobe or kernel.core_pattern at a
+// program.
+var sysctlAllowedPrefixes = []string{"net.core.", "net.ipv4.", "net.ipv6."}
+
+// SysctlProfiles are the named settings a SysctlConfig can pick. Later
+// profiles and explicit values override earlier ones.
+var SysctlProfiles = map[string]map[string]string{
+	// edge: connection-heavy envoy listeners and upstream pools.
+	"edge": {
+		"net.core.somaxconn":           "65535",
+		"net.core.netdev_max_backlog":  "16384",
+		"net.ipv4.tcp_max_syn_backlog": "65535",
+		"net.ipv4.ip_local_port_range": "1024 65535",
+		"net.ipv4.tcp_tw_reuse":        "1",
+		"net.ipv4.tcp_fin_timeout":     "15",
+	},
+	// policy-routing: loose reverse-path filtering, so replies routed by a
+	// source rule through another uplink are not dropped.
+	"policy-routing": {
+		"net.ipv4.conf.all.rp_filter":     "2",
+		"net.ipv4.conf.default.rp_filter": "2",
+	},
+	// nonlocal-bind: listeners on addresses not (yet) on the host, e.g.
+	// before the elchi-if-* dummy is up.
+	"nonlocal-bind": {
+		"net.ipv4.ip_nonlocal_bind": "1",
+		"net.ipv6.ip_nonlocal_bind": "1",
+	},
+}
+
+var (
+	sysctlKeyPattern   = regexp.MustCompile(`^[a-z0-9_]+(\.[a-zA-Z0-9_-]+)+$`)
+	sysctlValuePattern = regexp.MustCompile(`^[a-zA-Z0-9 ._:-]{1,128}$`)
+)
+
+// SysctlConfig is the desired state: profiles applied in order, then Values.
+type SysctlConfig struct {
+	Profiles []string          `json:"profiles,omitempty"`
+	Values   map[string]string `json:"values,omitempty"`
+}
+
+// SysctlValue is one key with where its desired value comes from.
+type SysctlValue struct {
+	Key     string `json:"key"`
+	Desired string `json:"desired"`
+	Current string `json:"current"`
+	// Source is the profile that set the value, or "explicit".
+	Source string `json:"source"`
+	InSync bool   `json:"in_sync"`
+}
+
+// SysctlReport compares the desired values with the kernel and the conf
+// file.
+type SysctlReport struct {
+	Config *SysctlConfig `json:"config"`
+	Values []SysctlValue `json:"values"`
+	// ConfInSync is false when the conf file is missing or edited, or not
+	// linked from SysctlConfPath.
+	ConfInSync bool `json:"conf_in_sync"`
+	// Repaired lists the values (and conf file) written because they
+	// differed; RepairErrors the ones that could not be.
+	Repaired     []string `json:"repaired,omitempty"`
+	RepairErrors []string `json:"repair_errors,omitempty"`
+}
+
+// InSync reports whether the kernel and the conf file match the config.
+func (r *SysctlReport) InSync() bool {
+	if !r.ConfInSync {
+		return false
+	}
+	for _, v := range r.Values {
+		if !v.InSync {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the profile names and every key and value that end up in
+// the conf file.
+func (c SysctlConfig) Validate() error {
+	for _, name := range c.Profiles {
+		if _, ok := SysctlProfiles[name]; !ok {
+			return fmt.Errorf("unknown sysctl profile %q", name)
+		}
+	}
+	for key, value := range c.Values {
+		if !sysctlKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid sysctl key %q", key)
+		}
+		if !sysctlKeyAllowed(key) {
+			return fmt.Errorf("sysctl key %q is not managed: only %s keys are", key, strings.Join(sysctlAllowedPrefixes, "*, ")+"*")
+		}
+		// Whitespace, newlines included, is folded before rendering.
+		if !sysctlValuePattern.MatchString(normalizeSysctlValue(value)) {
+			return fmt.Errorf("invalid value %q for %s", value, key)
+		}
+	}
+	return nil
+}
+
+func sysctlKeyAllowed(key string) bool {
+	for _, prefix := range sysctlAllowedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the desired value and its source for every key.
+func (c SysctlConfig) resolve() map[string]SysctlValue {
+	values := make(map[string]SysctlValue)
+	for _, name := range c.Profiles {
+		for key, value := range SysctlProfiles[name] {
+			values[key] = SysctlValue{Key: key, Desired: value, Source: name}
+		}
+	}
+	for key, value := range c.Values {
+		values[key] = SysctlValue{Key: key, Desired: normalizeSysctlValue(value), Source: "explicit"}
+	}
+	return values
+}
+
+// RenderSysctl returns the conf file for cfg, keys sorted.
+func RenderSysctl(cfg SysctlConfig) string {
+	values := cfg.resolve()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(sysctlHeader)
+	if len(cfg.Profiles) > 0 {
+		b.WriteString("# Profiles: " + strings.Join(cfg.Profiles, ", ") + "\n")
+	}
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", key, values[key].Desired)
+	}
+	return b.String()
+}
+
+// normalizeSysctlValue folds the tabs /proc/sys uses between fields (e.g.
+// ip_local_port_range) into single spaces.
+func normalizeSysctlValue(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// SysctlManager applies, reports and repairs the managed kernel settings.
+type SysctlManager struct {
+	dir      string
+	linkPath string
+	procDir  string
+	logger   *logger.Logger
+	// load applies the linked conf file; sudo sysctl -p outside tests.
+	load func(path string) error
+}
+
+func NewSysctlManager(logger *logger.Logger) *SysctlManager {
+	return &SysctlManager{
+		dir:      SysctlDir,
+		linkPath: SysctlConfPath,
+		procDir:  "/proc/sys",
+		logger:   logger,
+		load:     runSysctlLoad,
+	}
+}
+
+// Apply validates cfg, checks every key exists, writes the conf file, sets
+// the values live and keeps cfg for Status and Repair. Keys dropped from the
+// previous config keep their live value until the next boot.
+func (sm *SysctlManager) Apply(cfg SysctlConfig) (*SysctlReport, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sysctl configuration: %w", err)
+	}
+	for key := range cfg.resolve() {
+		if _, err := sm.current(key); err != nil {
+			return nil, fmt.Errorf("sysctl %s is not available on this host: %w", key, err)
+		}
+	}
+	if err := sm.linked(); err != nil {
+		return nil, err
+	}
+
+	if err := sm.writeConf(RenderSysctl(cfg)); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(sm.dir, sysctlConfig), data, 0640); err != nil {
+		return nil, fmt.Errorf("failed to persist sysctl config: %w", err)
+	}
+
+	report := sm.report(&cfg)
+	sm.setDrifted(report)
+	sm.logger.Infof("Applied sysctl config (%d profiles, %d keys)", len(cfg.Profiles), len(report.Values))
+	if len(report.RepairErrors) > 0 {
+		return report, fmt.Errorf("could not set %s", strings.Join(report.RepairErrors, "; "))
+	}
+	return report, nil
+}
+
+// Status reports the current against the desired values. It returns nil
+// when no config was ever applied.
+func (sm *SysctlManager) Status() (*SysctlReport, error) {
+	cfg, err := sm.config()
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+	return sm.report(cfg), nil
+}
+
+// Repair rewrites a missing or edited conf file and sets the drifted values
+// again. With no applied config it does nothing.
+func (sm *SysctlManager) Repair() (*SysctlReport, error) {
+	cfg, err := sm.config()
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+	report := sm.report(cfg)
+	if err := sm.linked(); err != nil {
+		// sysctl -p is only allowed on the link; nothing can be set.
+		report.RepairErrors = append(report.RepairErrors, err.Error())
+		return report, nil
+	}
+	if !report.ConfInSync {
+		if err := sm.writeConf(RenderSysctl(*cfg)); err != nil {
+			report.RepairErrors = append(report.RepairErrors, err.Error())
+		} else {
+			report.ConfInSync = true
+			report.Repaired = append(report.Repaired, sm.linkPath)
+		}
+	}
+	sm.setDrifted(report)
+	return report, nil
+}
+
+// Remove empties the conf file, keeping the link valid, and drops the kept
+// config. Live values stay until the next boot.
+func (sm *SysctlManager) Remove() error {
+	if err := sm.writeConf(sysctlHeader); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(sm.dir, sysctlConfig)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sm.logger.Infof("Removed the managed settings from %s", sm.linkPath)
+	return nil
+}
+
+// setDrifted loads the conf file when a value is not in sync and updates
+// the report from what the kernel then holds.
+func (sm *SysctlManager) setDrifted(report *SysctlReport) {
+	drifted := false
+	for _, v := range report.Values {
+		drifted = drifted || !v.InSync
+	}
+	if !drifted {
+		return
+	}
+	if err := sm.load(sm.linkPath); err != nil {
+		// sysctl -p goes on past a bad key; the values below tell which.
+		sm.logger.Warnf("sysctl -p %s: %v", sm.linkPath, err)
+	}
+	for i, v := range report.Values {
+		if v.InSync {
+			continue
+		}
+		if current, err := sm.current(v.Key); err == nil {
+			report.Values[i].Current = current
+			report.Values[i].InSync = current == v.Desired
+		}
+		if !report.Values[i].InSync {
+			// Some keys round or clamp what is written (e.g. somaxconn
+			// on old kernels); say so instead of retrying every tick.
+			report.RepairErrors = append(report.RepairErrors, fmt.Sprintf("%s: kernel kept %q instead of %q", v.Key, report.Values[i].Current, v.Desired))
+			continue
+		}
+		report.Repaired = append(report.Repaired, fmt.Sprintf("%s = %s (was %s)", v.Key, v.Desired, v.Current))
+	}
+}
+
+func (sm *SysctlManager) report(cfg *SysctlConfig) *SysctlReport {
+	report := &SysctlReport{Config: cfg, Values: []SysctlValue{}}
+	for _, v := range cfg.resolve() {
+		current, err := sm.current(v.Key)
+		if err != nil {
+			current = ""
+		}
+		v.Current = current
+		v.InSync = err == nil && current == v.Desired
+		report.Values = append(report.Values, v)
+	}
+	sort.Slice(report.Values, func(i, j int) bool { return report.Values[i].Key < report.Values[j].Key })
+
+	existing, err := os.ReadFile(filepath.Join(sm.dir, sysctlConf))
+	report.ConfInSync = err == nil && string(existing) == RenderSysctl(*cfg) && sm.linked() == nil
+	return report
+}
+
+// linked checks the installer's link from linkPath to the conf file.
+func (sm *SysctlManager) linked() error {
+	// Readlink rather than EvalSymlinks: a deleted conf file leaves the
+	// link dangling, and Repair must still be able to rewrite it.
+	target, err := os.Readlink(sm.linkPath)
+	want, _ := filepath.Abs(filepath.Join(sm.dir, sysctlConf))
+	if err != nil || filepath.Clean(target) != want {
+		return fmt.Errorf("%s is not linked to %s; re-run the installer", sm.linkPath, want)
+	}
+	return nil
+}
+
+// writeConf replaces the conf file; the link keeps pointing at it.
+func (sm *SysctlManager) writeConf(content string) error {
+	if err := os.MkdirAll(sm.dir, 0750); err != nil {
+		return fmt.Errorf("failed to create %s: %w", sm.dir, err)
+	}
+	path := filepath.Join(sm.dir, sysctlConf)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// current reads a live value from /proc/sys.
+func (sm *SysctlManager) current(key string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(sm.procDir, strings.ReplaceAll(key, ".", "/")))
+	if err != nil {
+		return "", err
+	}
+	return normalizeSysctlValue(string(data)), nil
+}
+
+// config returns the kept config, nil when there is none. It is validated
+// again: the file is re-applied as root on every reconcile tick.
+func (sm *SysctlManager) config() (*SysctlConfig, error) {
+	data, err := os.ReadFile(filepath.Join(sm.dir, sysctlConfig))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := &SysctlConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("corrupt %s: %w", sysctlConfig, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("refusing %s: %w", sysctlConfig, err)
+	}
+	return cfg, nil
+}
+
+func runSysctlLoad(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sudo", "sysctl", "-p", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w, output: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
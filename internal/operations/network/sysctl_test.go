@@ -0,0 +1,169 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestSysctlManager fakes /proc/sys and the sysctl.d link in a temp dir;
+// load applies the conf file the way sysctl -p would, storing values with
+// tabs between fields like the kernel.
+func newTestSysctlManager(t *testing.T, live map[string]string) *SysctlManager {
+	rm := newTestRouteManager(t)
+	dir := t.TempDir()
+	sm := &SysctlManager{
+		dir:      filepath.Join(dir, "sysctl"),
+		linkPath: filepath.Join(dir, "90-elchi.conf"),
+		procDir:  filepath.Join(dir, "proc"),
+		logger:   rm.logger,
+	}
+	set := func(key, value string) error {
+		path := filepath.Join(sm.procDir, strings.ReplaceAll(key, ".", "/"))
+		return os.WriteFile(path, []byte(strings.ReplaceAll(value, " ", "\t")+"\n"), 0644)
+	}
+	sm.load = func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if key, value, ok := strings.Cut(line, " = "); ok && !strings.HasPrefix(line, "#") {
+				if err := set(key, value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for key, value := range live {
+		path := filepath.Join(sm.procDir, strings.ReplaceAll(key, ".", "/"))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(sm.dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(sm.dir, sysctlConf), sm.linkPath); err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+func TestSysctlConfigResolveAndRender(t *testing.T) {
+	cfg := SysctlConfig{
+		Profiles: []string{"edge", "policy-routing"},
+		Values:   map[string]string{"net.core.somaxconn": "4096", "net.ipv4.ip_local_port_range": "2000\t60000"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	values := cfg.resolve()
+	if v := values["net.core.somaxconn"]; v.Desired != "4096" || v.Source != "explicit" {
+		t.Fatalf("explicit value did not override the profile: %+v", v)
+	}
+	if v := values["net.ipv4.conf.all.rp_filter"]; v.Desired != "2" || v.Source != "policy-routing" {
+		t.Fatalf("rp_filter = %+v", v)
+	}
+
+	conf := RenderSysctl(cfg)
+	if !strings.Contains(conf, "net.ipv4.ip_local_port_range = 2000 60000\n") ||
+		strings.Index(conf, "net.core.netdev_max_backlog") > strings.Index(conf, "net.core.somaxconn") {
+		t.Fatalf("conf:\n%s", conf)
+	}
+
+	for name, bad := range map[string]SysctlConfig{
+		"profile":  {Profiles: []string{"fast"}},
+		"key":      {Values: map[string]string{"../etc/passwd": "1"}},
+		"value":    {Values: map[string]string{"net.core.somaxconn": "1\nkernel.panic = 1"}},
+		"no value": {Values: map[string]string{"net.core.somaxconn": ""}},
+		"modprobe": {Values: map[string]string{"kernel.modprobe": "/var/lib/elchi/x"}},
+		"core":     {Values: map[string]string{"kernel.core_pattern": "|/var/lib/elchi/x"}},
+		"path":     {Values: map[string]string{"net.ipv4.tcp_congestion_control": "/tmp/x"}},
+		"vm":       {Values: map[string]string{"vm.overcommit_memory": "1"}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSysctlApplyStatusRepair(t *testing.T) {
+	sm := newTestSysctlManager(t, map[string]string{
+		"net.ipv4.ip_nonlocal_bind": "0",
+		"net.ipv6.ip_nonlocal_bind": "0",
+		"net.core.somaxconn":        "4096",
+	})
+
+	if report, err := sm.Status(); err != nil || report != nil {
+		t.Fatalf("status before any apply = %+v, %v", report, err)
+	}
+	if report, err := sm.Repair(); err != nil || report != nil {
+		t.Fatalf("repair before any apply = %+v, %v", report, err)
+	}
+	if _, err := sm.Apply(SysctlConfig{Values: map[string]string{"net.ipv4.tcp_nope": "1"}}); err == nil {
+		t.Fatal("unknown key applied")
+	}
+
+	cfg := SysctlConfig{Profiles: []string{"nonlocal-bind"}, Values: map[string]string{"net.core.somaxconn": "4096"}}
+	report, err := sm.Apply(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.InSync() || len(report.Repaired) != 2 {
+		t.Fatalf("apply report = %+v", report)
+	}
+
+	// Drift: a manual sysctl -w and a deleted conf file.
+	if err := os.WriteFile(filepath.Join(sm.procDir, "net/ipv4/ip_nonlocal_bind"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(sm.dir, sysctlConf))
+	status, err := sm.Status()
+	if err != nil || status.InSync() || status.ConfInSync {
+		t.Fatalf("drift not reported: %+v, %v", status, err)
+	}
+	if v := status.Values[1]; v.Key != "net.ipv4.ip_nonlocal_bind" || v.Current != "0" || v.Desired != "1" || v.InSync {
+		t.Fatalf("drifted value = %+v", v)
+	}
+
+	repaired, err := sm.Repair()
+	if err != nil || !repaired.InSync() || len(repaired.Repaired) != 2 {
+		t.Fatalf("repair = %+v, %v", repaired, err)
+	}
+	if status, _ := sm.Status(); !status.InSync() {
+		t.Fatalf("still drifted after repair: %+v", status)
+	}
+
+	if err := sm.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if report, _ := sm.Status(); report != nil {
+		t.Fatalf("config kept after remove: %+v", report)
+	}
+	if data, err := os.ReadFile(sm.linkPath); err != nil || string(data) != sysctlHeader {
+		t.Fatalf("link after remove = %q, %v", data, err)
+	}
+}
+
+func TestSysctlRefusesUnlinkedAndTamperedConfig(t *testing.T) {
+	sm := newTestSysctlManager(t, map[string]string{"net.core.somaxconn": "4096"})
+	os.Remove(sm.linkPath)
+	if _, err := sm.Apply(SysctlConfig{Values: map[string]string{"net.core.somaxconn": "8192"}}); err == nil || !strings.Contains(err.Error(), "re-run the installer") {
+		t.Fatalf("apply without the link = %v", err)
+	}
+
+	// config.json is re-applied as root by the reconcile loop.
+	tampered := `{"values":{"kernel.modprobe":"/var/lib/elchi/x"}}`
+	if err := os.WriteFile(filepath.Join(sm.dir, sysctlConfig), []byte(tampered), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.Repair(); err == nil || !strings.Contains(err.Error(), "not managed") {
+		t.Fatalf("tampered config repaired: %v", err)
+	}
+}
@@ -163,7 +163,12 @@ func (tm *TableManager) readTableDefinitions() ([]*client.RoutingTableDefinition
 	if err != nil {
 		return nil, err
 	}
+	return parseTableDefinitions(data), nil
+}
 
+// parseTableDefinitions parses rt_tables content, skipping comments and
+// malformed lines.
+func parseTableDefinitions(data []byte) []*client.RoutingTableDefinition {
 	var tables []*client.RoutingTableDefinition
 	lines := strings.Split(string(data), "\n")
 
@@ -189,7 +194,7 @@ func (tm *TableManager) readTableDefinitions() ([]*client.RoutingTableDefinition
 		})
 	}
 
-	return tables, nil
+	return tables
 }
 
 // isValidTableName checks if table name is valid (alphanumeric + underscore/hyphen)
@@ -285,6 +290,10 @@ func (tm *TableManager) deleteTable(table *client.RoutingTableDefinition) error
 		return nil // Not an error, idempotent operation
 	}
 
+	if vrf := vrfUsingTable(int(table.Id)); vrf != "" {
+		return fmt.Errorf("table %d is bound to VRF %s; delete the VRF first", table.Id, vrf)
+	}
+
 	// Flush the kernel routes in this table and any rules that point at it.
 	// Removing only the name->id definition leaves those routes/rules orphaned
 	// in the kernel (and the id can later be reused with a different name while
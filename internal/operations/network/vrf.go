@@ -0,0 +1,488 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"github.com/vishvananda/netlink"
+	"gopkg.in/yaml.v3"
+)
+
+// VRFNetplanPrefix names the netplan file each VRF is persisted in:
+// 99-elchi-vrf-<name>.yaml with a single `vrfs:` stanza.
+const VRFNetplanPrefix = "99-elchi-vrf-"
+
+// VRF is a VRF device bound to an Elchi-managed routing table and the
+// interfaces enslaved to it.
+type VRF struct {
+	Name       string   `json:"name"`
+	Table      uint32   `json:"table"`
+	Interfaces []string `json:"interfaces"`
+	// State is the runtime link state ("up", "down") or "missing" when the
+	// VRF is only persisted; it is not part of the desired configuration.
+	State string `json:"state,omitempty"`
+}
+
+type VRFManager struct {
+	netplanPath string
+	tablePath   string
+	logger      *logger.Logger
+	// persistWarnings collects cases where the VRF was changed in the kernel
+	// but its netplan file could not be updated, as for routes and policies.
+	persistWarnings []string
+}
+
+func NewVRFManager(logger *logger.Logger) *VRFManager {
+	return &VRFManager{
+		netplanPath: models.NetplanPath,
+		tablePath:   ElchiTableFile,
+		logger:      logger,
+	}
+}
+
+// addPersistWarning records (and logs) a kernel-applied-but-not-persisted VRF change.
+func (vm *VRFManager) addPersistWarning(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	vm.logger.Warnf("%s", msg)
+	vm.persistWarnings = append(vm.persistWarnings, msg)
+}
+
+// PersistWarnings returns the netplan-persistence warnings accumulated by the
+// last ApplyVRF or DeleteVRF call.
+func (vm *VRFManager) PersistWarnings() []string {
+	return vm.persistWarnings
+}
+
+// ApplyVRF creates the VRF or updates it in place: its members are set to
+// exactly v.Interfaces. The table of an existing VRF cannot be changed by the
+// kernel; delete and recreate it instead.
+func (vm *VRFManager) ApplyVRF(v VRF) error {
+	if err := vm.validateVRF(v); err != nil {
+		return err
+	}
+
+	netlinkLock.Lock()
+	err := vm.applyRuntimeVRF(v)
+	netlinkLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := vm.writeVRFFile(v); err != nil {
+		vm.addPersistWarning("VRF %s applied to kernel but not persisted to netplan (will be lost on reboot): %v", v.Name, err)
+	}
+	vm.logger.Infof("VRF %s bound to table %d with interfaces %v", v.Name, v.Table, v.Interfaces)
+	return nil
+}
+
+// DeleteVRF releases every member of the VRF, deletes the device and its
+// netplan file. Routes stay in the table, which is still Elchi-managed.
+func (vm *VRFManager) DeleteVRF(name string) error {
+	if !isValidVRFName(name) {
+		return fmt.Errorf("invalid VRF name '%s'", name)
+	}
+
+	netlinkLock.Lock()
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		if _, ok := link.(*netlink.Vrf); !ok {
+			netlinkLock.Unlock()
+			return fmt.Errorf("%s is a %s device, not a VRF", name, link.Type())
+		}
+		for _, member := range linkMembers(link.Attrs().Index) {
+			if err := netlink.LinkSetNoMaster(member); err != nil {
+				vm.logger.Warnf("Failed to release %s from VRF %s: %v", member.Attrs().Name, name, err)
+			}
+		}
+		if err := netlink.LinkDel(link); err != nil {
+			netlinkLock.Unlock()
+			return fmt.Errorf("failed to delete VRF %s: %w", name, err)
+		}
+	} else if !strings.Contains(err.Error(), "not found") {
+		netlinkLock.Unlock()
+		return fmt.Errorf("error checking VRF %s: %w", name, err)
+	}
+	netlinkLock.Unlock()
+
+	path := vm.vrfFile(name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		vm.addPersistWarning("VRF %s deleted from kernel but %s could not be removed (will be re-created on reboot): %v", name, path, err)
+	}
+	vm.logger.Infof("Deleted VRF %s", name)
+	return nil
+}
+
+// ListVRFs returns the VRF devices in the kernel, plus persisted VRFs that
+// are not (yet) present at runtime with State "missing".
+func (vm *VRFManager) ListVRFs() ([]VRF, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var vrfs []VRF
+	for _, link := range links {
+		dev, ok := link.(*netlink.Vrf)
+		if !ok {
+			continue
+		}
+		v := VRF{Name: dev.Name, Table: dev.Table, Interfaces: []string{}, State: "down"}
+		if dev.Flags&net.FlagUp != 0 {
+			v.State = "up"
+		}
+		for _, member := range links {
+			if member.Attrs().MasterIndex == dev.Index {
+				v.Interfaces = append(v.Interfaces, member.Attrs().Name)
+			}
+		}
+		sort.Strings(v.Interfaces)
+		seen[v.Name] = true
+		vrfs = append(vrfs, v)
+	}
+
+	persisted, err := readPersistedVRFs(vm.netplanPath)
+	if err != nil {
+		vm.logger.Warnf("Failed to read persisted VRFs: %v", err)
+	}
+	for _, v := range persisted {
+		if !seen[v.Name] {
+			v.State = "missing"
+			vrfs = append(vrfs, v)
+		}
+	}
+
+	sort.Slice(vrfs, func(i, j int) bool { return vrfs[i].Name < vrfs[j].Name })
+	return vrfs, nil
+}
+
+// validateVRF checks the name, that the table is an Elchi-managed table
+// defined in rt_tables and not bound to another VRF, and the member names.
+func (vm *VRFManager) validateVRF(v VRF) error {
+	if !isValidVRFName(v.Name) {
+		return fmt.Errorf("invalid VRF name '%s': 1-15 letters, numbers, underscore or hyphen, not starting with elchi-if-", v.Name)
+	}
+	if !isElchiManagedTableID(int(v.Table)) {
+		return fmt.Errorf("table ID %d outside Elchi management range (%d-%d) or reserved system table", v.Table, MinTableID, MaxTableID)
+	}
+
+	tm := &TableManager{logger: vm.logger, tablePath: vm.tablePath}
+	tables, err := tm.readTableDefinitions()
+	if err != nil {
+		return fmt.Errorf("failed to read table definitions: %w", err)
+	}
+	defined := false
+	for _, t := range tables {
+		if t.Id == v.Table {
+			defined = true
+			break
+		}
+	}
+	if !defined {
+		return fmt.Errorf("table %d is not defined; add it with SUB_TABLE_MANAGE first", v.Table)
+	}
+	if other := vrfUsingTable(int(v.Table)); other != "" && other != v.Name {
+		return fmt.Errorf("table %d is already bound to VRF %s", v.Table, other)
+	}
+
+	seen := make(map[string]bool)
+	for _, iface := range v.Interfaces {
+		if !ifacePattern.MatchString(iface) || iface == "lo" || iface == v.Name {
+			return fmt.Errorf("invalid VRF member '%s'", iface)
+		}
+		if seen[iface] {
+			return fmt.Errorf("interface %s listed twice", iface)
+		}
+		seen[iface] = true
+	}
+	return nil
+}
+
+// applyRuntimeVRF creates or reuses the VRF device and reconciles its members.
+// Callers hold netlinkLock.
+func (vm *VRFManager) applyRuntimeVRF(v VRF) error {
+	link, err := netlink.LinkByName(v.Name)
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			return fmt.Errorf("error checking VRF %s: %w", v.Name, err)
+		}
+		if err := netlink.LinkAdd(&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: v.Name}, Table: v.Table}); err != nil {
+			return fmt.Errorf("failed to create VRF %s: %w", v.Name, err)
+		}
+		if link, err = netlink.LinkByName(v.Name); err != nil {
+			return fmt.Errorf("failed to get newly created VRF %s: %w", v.Name, err)
+		}
+		vm.logger.Debugf("Created VRF %s for table %d", v.Name, v.Table)
+	}
+
+	dev, ok := link.(*netlink.Vrf)
+	if !ok {
+		return fmt.Errorf("%s already exists as a %s device", v.Name, link.Type())
+	}
+	if dev.Table != v.Table {
+		return fmt.Errorf("VRF %s is bound to table %d; delete it to rebind it to table %d", v.Name, dev.Table, v.Table)
+	}
+	if err := netlink.LinkSetUp(dev); err != nil {
+		return fmt.Errorf("failed to set VRF %s up: %w", v.Name, err)
+	}
+
+	// Resolve every member first so a typo fails before anything moves.
+	desired := make(map[string]netlink.Link)
+	for _, iface := range v.Interfaces {
+		member, err := netlink.LinkByName(iface)
+		if err != nil {
+			return fmt.Errorf("interface %s: %w", iface, err)
+		}
+		if _, isVRF := member.(*netlink.Vrf); isVRF {
+			return fmt.Errorf("interface %s is itself a VRF", iface)
+		}
+		desired[iface] = member
+	}
+
+	for _, member := range linkMembers(dev.Index) {
+		if _, keep := desired[member.Attrs().Name]; keep {
+			continue
+		}
+		if err := netlink.LinkSetNoMaster(member); err != nil {
+			return fmt.Errorf("failed to release %s from VRF %s: %w", member.Attrs().Name, v.Name, err)
+		}
+		vm.logger.Debugf("Released %s from VRF %s", member.Attrs().Name, v.Name)
+	}
+	for name, member := range desired {
+		if member.Attrs().MasterIndex == dev.Index {
+			continue
+		}
+		if err := netlink.LinkSetMasterByIndex(member, dev.Index); err != nil {
+			return fmt.Errorf("failed to enslave %s to VRF %s: %w", name, v.Name, err)
+		}
+		vm.logger.Debugf("Enslaved %s to VRF %s", name, v.Name)
+	}
+	return nil
+}
+
+// vrfTables returns the tables VRF devices are bound to, named after the VRF.
+func vrfTables() []*client.RoutingTableDefinition {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil
+	}
+	var tables []*client.RoutingTableDefinition
+	for _, link := range links {
+		if dev, ok := link.(*netlink.Vrf); ok {
+			tables = append(tables, &client.RoutingTableDefinition{Id: dev.Table, Name: dev.Name})
+		}
+	}
+	return tables
+}
+
+// vrfSummary describes the VRFs for ResponseNetwork.Message, since
+// NetworkState has no VRF field: " (VRFs: blue table 100 [eth1 elchi-if-9901])".
+// It is empty when there are none.
+func vrfSummary(logger *logger.Logger) string {
+	vrfs, err := NewVRFManager(logger).ListVRFs()
+	if err != nil || len(vrfs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(vrfs))
+	for _, v := range vrfs {
+		part := fmt.Sprintf("%s table %d [%s]", v.Name, v.Table, strings.Join(v.Interfaces, " "))
+		if v.State != "up" {
+			part += " " + v.State
+		}
+		parts = append(parts, part)
+	}
+	return " (VRFs: " + strings.Join(parts, "; ") + ")"
+}
+
+// linkMembers returns the links enslaved to the device with the given index.
+func linkMembers(index int) []netlink.Link {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil
+	}
+	var members []netlink.Link
+	for _, link := range links {
+		if link.Attrs().MasterIndex == index {
+			members = append(members, link)
+		}
+	}
+	return members
+}
+
+// vrfUsingTable returns the name of the VRF device bound to table, if any.
+func vrfUsingTable(table int) string {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return ""
+	}
+	for _, link := range links {
+		if dev, ok := link.(*netlink.Vrf); ok && int(dev.Table) == table {
+			return dev.Name
+		}
+	}
+	return ""
+}
+
+// isValidVRFName accepts interface-name-sized table-style names. elchi-if-*
+// belongs to deployment dummies.
+func isValidVRFName(name string) bool {
+	return len(name) <= 15 && isValidTableName(name) && !strings.HasPrefix(name, "elchi-if-")
+}
+
+// Netplan persistence
+
+type netplanVRFConfig struct {
+	Network netplanVRFNetwork `yaml:"network"`
+}
+
+type netplanVRFNetwork struct {
+	Version  int                        `yaml:"version"`
+	Renderer string                     `yaml:"renderer"`
+	VRFs     map[string]netplanVRFEntry `yaml:"vrfs"`
+}
+
+type netplanVRFEntry struct {
+	Table      uint32   `yaml:"table"`
+	Interfaces []string `yaml:"interfaces,omitempty"`
+}
+
+func (vm *VRFManager) vrfFile(name string) string {
+	return filepath.Join(vm.netplanPath, VRFNetplanPrefix+name+".yaml")
+}
+
+// renderVRFNetplan renders the netplan file of v. Members must be defined in
+// some netplan file too; elchi-if-* dummies are, in their 90-elchi-if-*.yaml.
+func renderVRFNetplan(v VRF) ([]byte, error) {
+	members := append([]string(nil), v.Interfaces...)
+	sort.Strings(members)
+	return yaml.Marshal(netplanVRFConfig{Network: netplanVRFNetwork{
+		Version:  2,
+		Renderer: "networkd",
+		VRFs:     map[string]netplanVRFEntry{v.Name: {Table: v.Table, Interfaces: members}},
+	}})
+}
+
+func (vm *VRFManager) writeVRFFile(v VRF) error {
+	data, err := renderVRFNetplan(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal VRF config: %w", err)
+	}
+	path := vm.vrfFile(v.Name)
+
+	// Use tee with sudo to write file directly as root (bypass ownership issues)
+	cmd := exec.Command("sudo", "tee", path)
+	cmd.Stdin = strings.NewReader(string(data))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to write VRF config via sudo tee: %w", err)
+	}
+	if err := exec.Command("sudo", "chmod", "0600", path).Run(); err != nil {
+		vm.logger.Warnf("Failed to set permissions for %s: %v", path, err)
+	}
+	vm.logger.Debugf("VRF %s persisted to %s", v.Name, path)
+	return nil
+}
+
+// readPersistedVRFs parses every 99-elchi-vrf-*.yaml in dir.
+func readPersistedVRFs(dir string) ([]VRF, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, VRFNetplanPrefix+"*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var vrfs []VRF
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return vrfs, err
+		}
+		var cfg netplanVRFConfig
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return vrfs, fmt.Errorf("%s: %w", path, err)
+		}
+		for name, entry := range cfg.Network.VRFs {
+			members := entry.Interfaces
+			if members == nil {
+				members = []string{}
+			}
+			vrfs = append(vrfs, VRF{Name: name, Table: entry.Table, Interfaces: members})
+		}
+	}
+	sort.Slice(vrfs, func(i, j int) bool { return vrfs[i].Name < vrfs[j].Name })
+	return vrfs, nil
+}
+
+// persistedVRFOf returns the persisted VRF that lists iface as a member.
+func persistedVRFOf(dir, iface string) (VRF, bool) {
+	vrfs, _ := readPersistedVRFs(dir)
+	for _, v := range vrfs {
+		for _, member := range v.Interfaces {
+			if member == iface {
+				return v, true
+			}
+		}
+	}
+	return VRF{}, false
+}
+
+// rejoinVRF enslaves a freshly (re)created interface to the VRF its netplan
+// file names, so a redeployed elchi-if-* dummy lands back in its VRF without
+// waiting for a reboot. Callers hold netlinkLock.
+func rejoinVRF(link netlink.Link, logger *logger.Logger) {
+	v, ok := persistedVRFOf(models.NetplanPath, link.Attrs().Name)
+	if !ok {
+		return
+	}
+	dev, err := netlink.LinkByName(v.Name)
+	if err != nil {
+		logger.Warnf("Interface %s belongs to VRF %s, which does not exist", link.Attrs().Name, v.Name)
+		return
+	}
+	if link.Attrs().MasterIndex == dev.Attrs().Index {
+		return
+	}
+	if err := netlink.LinkSetMasterByIndex(link, dev.Attrs().Index); err != nil {
+		logger.Warnf("Failed to enslave %s to VRF %s: %v", link.Attrs().Name, v.Name, err)
+		return
+	}
+	logger.Debugf("Interface %s rejoined VRF %s", link.Attrs().Name, v.Name)
+}
+
+// forgetVRFMember drops iface from every persisted VRF. netplan refuses a
+// `vrfs:` stanza naming an interface no file defines, so a deleted dummy must
+// not stay listed.
+func forgetVRFMember(iface string, logger *logger.Logger) {
+	vm := NewVRFManager(logger)
+	vrfs, err := readPersistedVRFs(vm.netplanPath)
+	if err != nil {
+		logger.Warnf("Failed to read persisted VRFs: %v", err)
+	}
+	for _, v := range vrfs {
+		kept := withoutMember(v.Interfaces, iface)
+		if len(kept) == len(v.Interfaces) {
+			continue
+		}
+		v.Interfaces = kept
+		if err := vm.writeVRFFile(v); err != nil {
+			logger.Warnf("Failed to remove %s from persisted VRF %s: %v", iface, v.Name, err)
+			continue
+		}
+		logger.Infof("Removed %s from persisted VRF %s", iface, v.Name)
+	}
+}
+
+func withoutMember(members []string, iface string) []string {
+	kept := make([]string, 0, len(members))
+	for _, m := range members {
+		if m != iface {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,85 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+func TestIsValidVRFName(t *testing.T) {
+	for _, name := range []string{"blue", "cust-a_1", "vrf-123456789ab"} {
+		if !isValidVRFName(name) {
+			t.Errorf("%q should be valid", name)
+		}
+	}
+	for _, name := range []string{"", "has space", "vrf-1234567890ab", "elchi-if-9901", "a/b"} {
+		if isValidVRFName(name) {
+			t.Errorf("%q should be rejected", name)
+		}
+	}
+}
+
+func TestRenderAndReadVRFNetplan(t *testing.T) {
+	data, err := renderVRFNetplan(VRF{Name: "blue", Table: 100, Interfaces: []string{"elchi-if-9901", "eth1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"vrfs:", "blue:", "table: 100", "- elchi-if-9901", "- eth1", "renderer: networkd"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("netplan lacks %q:\n%s", want, data)
+		}
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, VRFNetplanPrefix+"blue.yaml"), data, 0600)
+	os.WriteFile(filepath.Join(dir, "99-elchi-route-eth1.yaml"), []byte("network: {}\n"), 0600)
+	red, _ := renderVRFNetplan(VRF{Name: "red", Table: 101})
+	os.WriteFile(filepath.Join(dir, VRFNetplanPrefix+"red.yaml"), red, 0600)
+
+	vrfs, err := readPersistedVRFs(dir)
+	if err != nil || len(vrfs) != 2 {
+		t.Fatalf("readPersistedVRFs = %+v, %v", vrfs, err)
+	}
+	if vrfs[0].Name != "blue" || vrfs[0].Table != 100 || strings.Join(vrfs[0].Interfaces, ",") != "elchi-if-9901,eth1" {
+		t.Errorf("blue = %+v", vrfs[0])
+	}
+	if vrfs[1].Name != "red" || len(vrfs[1].Interfaces) != 0 {
+		t.Errorf("red = %+v", vrfs[1])
+	}
+	if v, ok := persistedVRFOf(dir, "elchi-if-9901"); !ok || v.Name != "blue" {
+		t.Errorf("persistedVRFOf = %+v, %v", v, ok)
+	}
+	if _, ok := persistedVRFOf(dir, "eth9"); ok {
+		t.Error("eth9 is in no VRF")
+	}
+	if got := withoutMember([]string{"elchi-if-9901", "eth1"}, "elchi-if-9901"); strings.Join(got, ",") != "eth1" {
+		t.Errorf("withoutMember = %v", got)
+	}
+}
+
+func TestValidateVRF(t *testing.T) {
+	logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"})
+	tables := filepath.Join(t.TempDir(), "rt_tables.conf")
+	os.WriteFile(tables, []byte("100\tcust_a\n"), 0644)
+	vm := &VRFManager{tablePath: tables, logger: logger.NewLogger("test")}
+
+	if err := vm.validateVRF(VRF{Name: "blue", Table: 100, Interfaces: []string{"eth1"}}); err != nil {
+		t.Errorf("valid VRF rejected: %v", err)
+	}
+	bad := map[string]VRF{
+		"undefined table": {Name: "blue", Table: 101},
+		"system table":    {Name: "blue", Table: 254},
+		"dummy name":      {Name: "elchi-if-1", Table: 100},
+		"duplicate":       {Name: "blue", Table: 100, Interfaces: []string{"eth1", "eth1"}},
+		"loopback":        {Name: "blue", Table: 100, Interfaces: []string{"lo"}},
+		"self":            {Name: "blue", Table: 100, Interfaces: []string{"blue"}},
+	}
+	for name, v := range bad {
+		if err := vm.validateVRF(v); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
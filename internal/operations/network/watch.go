@@ -0,0 +1,502 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// Kinds of NetEvent.
+const (
+	NetEventLink    = "link"
+	NetEventAddress = "address"
+	NetEventRoute   = "route"
+	NetEventRule    = "rule"
+)
+
+// NetEvent is a significant kernel network change: a link, address, route or
+// rule touching an Elchi-managed object, an uplink or a default route.
+type NetEvent struct {
+	At   time.Time `json:"at"`
+	Kind string    `json:"kind"`
+	// Action is added, removed, up, down or changed; for a debounced event
+	// it is the last one seen.
+	Action    string `json:"action"`
+	Object    string `json:"object"`
+	Interface string `json:"interface,omitempty"`
+	Table     int    `json:"table,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	// Count is the number of kernel updates folded into the event.
+	Count int `json:"count"`
+}
+
+func (e NetEvent) key() string {
+	return e.Kind + "|" + e.Object
+}
+
+// NetEventWatcher subscribes to netlink link, address, route and rule
+// updates and hands the significant ones, debounced, to an emit callback.
+type NetEventWatcher struct {
+	logger   *logger.Logger
+	debounce time.Duration
+}
+
+func NewNetEventWatcher(logger *logger.Logger, debounce time.Duration) *NetEventWatcher {
+	return &NetEventWatcher{logger: logger, debounce: debounce}
+}
+
+// Run watches until ctx is cancelled or a subscription fails; the caller
+// restarts it after an error. Updates pending in the debounce window when it
+// returns are dropped.
+func (w *NetEventWatcher) Run(ctx context.Context, emit func(NetEvent)) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	errCh := make(chan error, 1)
+	onError := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+
+	links := make(chan netlink.LinkUpdate, 64)
+	addrs := make(chan netlink.AddrUpdate, 64)
+	routes := make(chan netlink.RouteUpdate, 64)
+	rules := make(chan ruleUpdate, 64)
+	if err := netlink.LinkSubscribeWithOptions(links, done, netlink.LinkSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return fmt.Errorf("subscribe to link updates: %w", err)
+	}
+	if err := netlink.AddrSubscribeWithOptions(addrs, done, netlink.AddrSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return fmt.Errorf("subscribe to address updates: %w", err)
+	}
+	if err := netlink.RouteSubscribeWithOptions(routes, done, netlink.RouteSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return fmt.Errorf("subscribe to route updates: %w", err)
+	}
+	if err := ruleSubscribe(rules, done, onError); err != nil {
+		return fmt.Errorf("subscribe to rule updates: %w", err)
+	}
+
+	// Prime after subscribing so nothing between the two is missed; what
+	// already exists is not reported.
+	state := newNetWatchState(models.NetplanPath)
+	if err := state.prime(); err != nil {
+		return err
+	}
+
+	deb := newNetEventDebouncer(w.debounce)
+	tick := w.debounce / 2
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	add := func(ev NetEvent, ok bool) {
+		if !ok {
+			return
+		}
+		ev.At = time.Now().UTC()
+		deb.add(ev)
+		if w.debounce <= 0 {
+			for _, ev := range deb.due(ev.At) {
+				emit(ev)
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errCh:
+			return err
+		case u, ok := <-links:
+			if !ok {
+				return fmt.Errorf("link subscription closed")
+			}
+			add(state.linkEvent(u))
+		case u, ok := <-addrs:
+			if !ok {
+				return fmt.Errorf("address subscription closed")
+			}
+			add(state.addrEvent(u))
+		case u, ok := <-routes:
+			if !ok {
+				return fmt.Errorf("route subscription closed")
+			}
+			add(state.routeEvent(u))
+		case u, ok := <-rules:
+			if !ok {
+				return fmt.Errorf("rule subscription closed")
+			}
+			add(state.ruleEvent(u))
+		case now := <-ticker.C:
+			for _, ev := range deb.due(now.UTC()) {
+				emit(ev)
+			}
+		}
+	}
+}
+
+// netWatchState is what the watcher knows of the links, needed to name the
+// interface of an address or route update and to spot link transitions.
+type netWatchState struct {
+	netplanPath string
+	links       map[int]watchedLink
+	// uplinks carry a default route in the main table.
+	uplinks map[string]bool
+}
+
+type watchedLink struct {
+	name   string
+	kind   string
+	up     bool
+	mtu    int
+	master int
+}
+
+func newNetWatchState(netplanPath string) *netWatchState {
+	return &netWatchState{netplanPath: netplanPath, links: make(map[int]watchedLink), uplinks: make(map[string]bool)}
+}
+
+func (s *netWatchState) prime() error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+	for _, link := range links {
+		s.links[link.Attrs().Index] = watchedLinkOf(link)
+	}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: SystemTableMain}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %w", err)
+	}
+	for _, route := range routes {
+		if isDefaultDst(route.Dst) {
+			if l, ok := s.links[route.LinkIndex]; ok {
+				s.uplinks[l.name] = true
+			}
+		}
+	}
+	return nil
+}
+
+func watchedLinkOf(link netlink.Link) watchedLink {
+	attrs := link.Attrs()
+	return watchedLink{
+		name: attrs.Name,
+		kind: link.Type(),
+		// Dummies and some virtual links never leave OperUnknown.
+		up:     attrs.Flags&net.FlagUp != 0 && (attrs.OperState == netlink.OperUp || attrs.OperState == netlink.OperUnknown),
+		mtu:    attrs.MTU,
+		master: attrs.MasterIndex,
+	}
+}
+
+// significantLink reports whether changes on name are worth reporting:
+// deployment dummies, the typed interfaces and VRFs Elchi persists, and the
+// uplinks.
+func (s *netWatchState) significantLink(name, kind string) bool {
+	if name == "" {
+		return false
+	}
+	if strings.HasPrefix(name, "elchi-if-") || kind == "vrf" || s.uplinks[name] {
+		return true
+	}
+	for _, file := range []string{InterfaceNetplanPrefix + name + ".yaml", VRFNetplanPrefix + name + ".yaml"} {
+		if _, err := os.Stat(filepath.Join(s.netplanPath, file)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *netWatchState) linkName(index int) string {
+	return s.links[index].name
+}
+
+func (s *netWatchState) linkEvent(u netlink.LinkUpdate) (NetEvent, bool) {
+	index := u.Attrs().Index
+	prev, known := s.links[index]
+	ev := NetEvent{Kind: NetEventLink, Object: u.Attrs().Name, Interface: u.Attrs().Name, Count: 1}
+
+	if u.Header.Type == syscall.RTM_DELLINK {
+		delete(s.links, index)
+		ev.Action = "removed"
+		return ev, s.significantLink(ev.Object, prev.kind)
+	}
+
+	cur := watchedLinkOf(u.Link)
+	s.links[index] = cur
+	switch {
+	case !known:
+		ev.Action = "added"
+		ev.Detail = cur.kind
+	case prev.up != cur.up:
+		ev.Action = "down"
+		if cur.up {
+			ev.Action = "up"
+		}
+	case prev.name != cur.name:
+		ev.Action = "changed"
+		ev.Detail = fmt.Sprintf("renamed from %s", prev.name)
+	case prev.mtu != cur.mtu:
+		ev.Action = "changed"
+		ev.Detail = fmt.Sprintf("mtu %d -> %d", prev.mtu, cur.mtu)
+	case prev.master != cur.master:
+		ev.Action = "changed"
+		ev.Detail = "master " + s.masterName(prev.master) + " -> " + s.masterName(cur.master)
+	default:
+		// Flag and statistics updates that change nothing we track.
+		return ev, false
+	}
+	return ev, s.significantLink(cur.name, cur.kind)
+}
+
+func (s *netWatchState) masterName(index int) string {
+	if index == 0 {
+		return "none"
+	}
+	if name := s.linkName(index); name != "" {
+		return name
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+func (s *netWatchState) addrEvent(u netlink.AddrUpdate) (NetEvent, bool) {
+	link := s.links[u.LinkIndex]
+	ev := NetEvent{Kind: NetEventAddress, Object: u.LinkAddress.String(), Interface: link.name, Action: "removed", Count: 1}
+	if u.NewAddr {
+		ev.Action = "added"
+	}
+	return ev, s.significantLink(link.name, link.kind)
+}
+
+func (s *netWatchState) routeEvent(u netlink.RouteUpdate) (NetEvent, bool) {
+	table := u.Table
+	if table == 0 {
+		table = SystemTableMain
+	}
+	isDefault := isDefaultDst(u.Dst)
+	ev := NetEvent{
+		Kind:      NetEventRoute,
+		Object:    fmt.Sprintf("%s table %d", routeDstString(u.Dst, u.Family), table),
+		Interface: s.linkName(u.LinkIndex),
+		Table:     table,
+		Action:    "removed",
+		Count:     1,
+	}
+	if u.Type == syscall.RTM_NEWROUTE {
+		ev.Action = "added"
+	}
+	ev.Detail = routeDetail(u.Route, ev.Interface)
+
+	if isDefault && table == SystemTableMain && ev.Interface != "" {
+		if ev.Action == "added" {
+			s.uplinks[ev.Interface] = true
+		} else {
+			delete(s.uplinks, ev.Interface)
+		}
+	}
+	return ev, isDefault || isElchiManagedTableID(table)
+}
+
+func routeDetail(route netlink.Route, iface string) string {
+	var parts []string
+	if route.Gw != nil {
+		parts = append(parts, "via "+route.Gw.String())
+	}
+	if iface != "" {
+		parts = append(parts, "dev "+iface)
+	}
+	if route.Priority != 0 {
+		parts = append(parts, fmt.Sprintf("metric %d", route.Priority))
+	}
+	return strings.Join(parts, " ")
+}
+
+func isDefaultDst(dst *net.IPNet) bool {
+	if dst == nil {
+		return true
+	}
+	ones, _ := dst.Mask.Size()
+	return ones == 0
+}
+
+func routeDstString(dst *net.IPNet, family int) string {
+	if dst != nil {
+		return dst.String()
+	}
+	if family == netlink.FAMILY_V6 {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
+
+// ruleUpdate is a decoded RTM_NEWRULE/RTM_DELRULE; the netlink library has
+// no rule subscription.
+type ruleUpdate struct {
+	Type     uint16
+	Family   int
+	Priority int
+	Table    int
+	Src      *net.IPNet
+	Dst      *net.IPNet
+}
+
+func (s *netWatchState) ruleEvent(u ruleUpdate) (NetEvent, bool) {
+	object := fmt.Sprintf("priority %d", u.Priority)
+	if u.Src != nil {
+		object += " from " + u.Src.String()
+	}
+	if u.Dst != nil {
+		object += " to " + u.Dst.String()
+	}
+	ev := NetEvent{
+		Kind:   NetEventRule,
+		Object: object,
+		Table:  u.Table,
+		Action: "removed",
+		Detail: fmt.Sprintf("lookup %d", u.Table),
+		Count:  1,
+	}
+	if u.Type == syscall.RTM_NEWRULE {
+		ev.Action = "added"
+	}
+	elchiPriority := u.Priority >= MinPolicyPriority && u.Priority <= MaxPolicyPriority
+	return ev, elchiPriority || isElchiManagedTableID(u.Table)
+}
+
+// ruleSubscribe streams IPv4 and IPv6 rule updates into ch until done is
+// closed, the way the netlink library's own subscriptions do.
+func ruleSubscribe(ch chan<- ruleUpdate, done <-chan struct{}, onError func(error)) error {
+	s, err := nl.Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_IPV4_RULE, syscall.RTNLGRP_IPV6_RULE)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-done
+		s.Close()
+	}()
+	go func() {
+		defer close(ch)
+		for {
+			msgs, _, err := s.Receive()
+			if err != nil {
+				onError(fmt.Errorf("receive rule updates: %w", err))
+				return
+			}
+			for _, m := range msgs {
+				if m.Header.Type != syscall.RTM_NEWRULE && m.Header.Type != syscall.RTM_DELRULE {
+					continue
+				}
+				u, err := parseRuleMessage(m.Header.Type, m.Data)
+				if err != nil {
+					continue
+				}
+				select {
+				case ch <- u:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// parseRuleMessage decodes the fib_rule_hdr (laid out like rtmsg) and the
+// FRA_* attributes the watcher reports on.
+func parseRuleMessage(msgType uint16, data []byte) (ruleUpdate, error) {
+	if len(data) < syscall.SizeofRtMsg {
+		return ruleUpdate{}, fmt.Errorf("short rule message")
+	}
+	hdr := nl.DeserializeRtMsg(data)
+	u := ruleUpdate{Type: msgType, Family: int(hdr.Family), Table: int(hdr.Table)}
+	attrs, err := nl.ParseRouteAttr(data[syscall.SizeofRtMsg:])
+	if err != nil {
+		return ruleUpdate{}, err
+	}
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case nl.FRA_PRIORITY:
+			if len(attr.Value) >= 4 {
+				u.Priority = int(native.Uint32(attr.Value))
+			}
+		case nl.FRA_TABLE:
+			if len(attr.Value) >= 4 {
+				u.Table = int(native.Uint32(attr.Value))
+			}
+		case nl.FRA_SRC:
+			u.Src = ruleNet(attr.Value, hdr.Src_len)
+		case nl.FRA_DST:
+			u.Dst = ruleNet(attr.Value, hdr.Dst_len)
+		}
+	}
+	return u, nil
+}
+
+func ruleNet(addr []byte, ones uint8) *net.IPNet {
+	ip := net.IP(addr)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(int(ones), 8*len(addr))}
+}
+
+// netEventDebouncer folds the updates of one object that arrive within the
+// window into a single event, so a flapping link or a netplan apply does not
+// flood the control plane. An event is released a window after its first
+// update: a link that keeps flapping is reported once per window, with the
+// number of updates in Count.
+type netEventDebouncer struct {
+	window  time.Duration
+	pending map[string]*pendingNetEvent
+}
+
+type pendingNetEvent struct {
+	first time.Time
+	event NetEvent
+}
+
+func newNetEventDebouncer(window time.Duration) *netEventDebouncer {
+	return &netEventDebouncer{window: window, pending: make(map[string]*pendingNetEvent)}
+}
+
+func (d *netEventDebouncer) add(ev NetEvent) {
+	p, ok := d.pending[ev.key()]
+	if !ok {
+		d.pending[ev.key()] = &pendingNetEvent{first: ev.At, event: ev}
+		return
+	}
+	count := p.event.Count + ev.Count
+	p.event = ev
+	p.event.Count = count
+}
+
+// due removes and returns the events whose window has passed, oldest first.
+func (d *netEventDebouncer) due(now time.Time) []NetEvent {
+	var ready []*pendingNetEvent
+	for key, p := range d.pending {
+		if now.Sub(p.first) >= d.window {
+			ready = append(ready, p)
+			delete(d.pending, key)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].first.Before(ready[j].first) })
+	events := make([]NetEvent, len(ready))
+	for i, p := range ready {
+		events[i] = p.event
+	}
+	return events
+}
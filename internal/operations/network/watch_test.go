@@ -0,0 +1,168 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+func TestNetEventDebouncer(t *testing.T) {
+	d := newNetEventDebouncer(2 * time.Second)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	d.add(NetEvent{At: start, Kind: NetEventLink, Object: "elchi-if-9901", Action: "down", Count: 1})
+	d.add(NetEvent{At: start.Add(500 * time.Millisecond), Kind: NetEventLink, Object: "elchi-if-9901", Action: "up", Count: 1})
+	d.add(NetEvent{At: start.Add(time.Second), Kind: NetEventRoute, Object: "0.0.0.0/0 table 254", Action: "removed", Count: 1})
+
+	if got := d.due(start.Add(time.Second)); len(got) != 0 {
+		t.Fatalf("events released inside the window: %+v", got)
+	}
+	got := d.due(start.Add(2 * time.Second))
+	if len(got) != 1 || got[0].Object != "elchi-if-9901" || got[0].Action != "up" || got[0].Count != 2 {
+		t.Fatalf("link flap not folded into one event: %+v", got)
+	}
+	got = d.due(start.Add(3 * time.Second))
+	if len(got) != 1 || got[0].Kind != NetEventRoute {
+		t.Fatalf("route event not released after its own window: %+v", got)
+	}
+	if got := d.due(start.Add(time.Hour)); len(got) != 0 {
+		t.Fatalf("released events kept pending: %+v", got)
+	}
+}
+
+func TestNetWatchLinkEvents(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, InterfaceNetplanPrefix+"bond0.yaml"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := newNetWatchState(dir)
+	s.links[2] = watchedLink{name: "eth0", kind: "device", up: true, mtu: 1500}
+	s.uplinks["eth0"] = true
+	s.links[3] = watchedLink{name: "eth1", kind: "device", up: true, mtu: 1500}
+
+	link := func(index int, name string, up bool, mtu int) netlink.LinkUpdate {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Index, attrs.Name, attrs.MTU = index, name, mtu
+		if up {
+			attrs.Flags = net.FlagUp
+			attrs.OperState = netlink.OperUp
+		}
+		u := netlink.LinkUpdate{Link: &netlink.Device{LinkAttrs: attrs}}
+		u.Header.Type = syscall.RTM_NEWLINK
+		return u
+	}
+
+	if ev, ok := s.linkEvent(link(2, "eth0", false, 1500)); !ok || ev.Action != "down" {
+		t.Fatalf("uplink down = %+v, %v", ev, ok)
+	}
+	if _, ok := s.linkEvent(link(2, "eth0", false, 1500)); ok {
+		t.Fatal("update without a tracked change reported")
+	}
+	if _, ok := s.linkEvent(link(3, "eth1", false, 1500)); ok {
+		t.Fatal("unmanaged link reported")
+	}
+	if ev, ok := s.linkEvent(link(7, "elchi-if-9901", true, 1500)); !ok || ev.Action != "added" {
+		t.Fatalf("new dummy = %+v, %v", ev, ok)
+	}
+	if ev, ok := s.linkEvent(link(8, "bond0", true, 1500)); !ok || ev.Action != "added" {
+		t.Fatalf("persisted bond = %+v, %v", ev, ok)
+	}
+	if ev, ok := s.linkEvent(link(8, "bond0", true, 9000)); !ok || ev.Action != "changed" || ev.Detail != "mtu 1500 -> 9000" {
+		t.Fatalf("bond mtu change = %+v, %v", ev, ok)
+	}
+
+	del := link(7, "elchi-if-9901", false, 1500)
+	del.Header.Type = syscall.RTM_DELLINK
+	if ev, ok := s.linkEvent(del); !ok || ev.Action != "removed" {
+		t.Fatalf("dummy removal = %+v, %v", ev, ok)
+	}
+	if _, known := s.links[7]; known {
+		t.Fatal("removed link still tracked")
+	}
+
+	addr := netlink.AddrUpdate{LinkIndex: 8, NewAddr: true, LinkAddress: net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)}}
+	if ev, ok := s.addrEvent(addr); !ok || ev.Interface != "bond0" || ev.Object != "10.0.0.1/24" || ev.Action != "added" {
+		t.Fatalf("address on bond = %+v, %v", ev, ok)
+	}
+	addr.LinkIndex = 3
+	if _, ok := s.addrEvent(addr); ok {
+		t.Fatal("address on unmanaged link reported")
+	}
+}
+
+func TestNetWatchRouteEvents(t *testing.T) {
+	s := newNetWatchState(t.TempDir())
+	s.links[3] = watchedLink{name: "eth1", kind: "device"}
+
+	_, dst, _ := net.ParseCIDR("192.168.10.0/24")
+	tests := []struct {
+		name   string
+		update netlink.RouteUpdate
+		want   bool
+	}{
+		{"main default", netlink.RouteUpdate{Type: syscall.RTM_NEWROUTE, Route: netlink.Route{LinkIndex: 3, Gw: net.ParseIP("10.0.0.254"), Table: SystemTableMain}}, true},
+		{"managed table", netlink.RouteUpdate{Type: syscall.RTM_NEWROUTE, Route: netlink.Route{LinkIndex: 3, Dst: dst, Table: 100}}, true},
+		{"main prefix", netlink.RouteUpdate{Type: syscall.RTM_NEWROUTE, Route: netlink.Route{LinkIndex: 3, Dst: dst, Table: SystemTableMain}}, false},
+		{"local table", netlink.RouteUpdate{Type: syscall.RTM_NEWROUTE, Route: netlink.Route{LinkIndex: 3, Dst: dst, Table: SystemTableLocal}}, false},
+	}
+	for _, tt := range tests {
+		if _, ok := s.routeEvent(tt.update); ok != tt.want {
+			t.Errorf("%s: significant = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+	if !s.uplinks["eth1"] {
+		t.Fatal("default route did not mark eth1 as an uplink")
+	}
+
+	ev, _ := s.routeEvent(netlink.RouteUpdate{Type: syscall.RTM_DELROUTE, Route: netlink.Route{LinkIndex: 3, Gw: net.ParseIP("10.0.0.254"), Table: SystemTableMain}})
+	if ev.Object != "0.0.0.0/0 table 254" || ev.Action != "removed" || ev.Detail != "via 10.0.0.254 dev eth1" {
+		t.Fatalf("default route removal = %+v", ev)
+	}
+	if s.uplinks["eth1"] {
+		t.Fatal("eth1 still an uplink after its default route was removed")
+	}
+}
+
+func TestNetWatchRuleEvents(t *testing.T) {
+	// fib_rule_hdr: family, dst_len, src_len, tos, table, 3 reserved/action bytes, flags.
+	data := make([]byte, syscall.SizeofRtMsg)
+	data[0], data[2], data[4] = syscall.AF_INET, 24, 252
+	attr := func(typ int, value []byte) {
+		buf := make([]byte, 4, 4+len(value))
+		nl.NativeEndian().PutUint16(buf[0:2], uint16(4+len(value)))
+		nl.NativeEndian().PutUint16(buf[2:4], uint16(typ))
+		data = append(data, append(buf, value...)...)
+	}
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.NativeEndian.PutUint32(b, v)
+		return b
+	}
+	attr(nl.FRA_PRIORITY, u32(150))
+	attr(nl.FRA_TABLE, u32(300))
+	attr(nl.FRA_SRC, net.ParseIP("10.1.2.0").To4())
+
+	u, err := parseRuleMessage(syscall.RTM_NEWRULE, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Priority != 150 || u.Table != 300 || u.Src.String() != "10.1.2.0/24" || u.Dst != nil {
+		t.Fatalf("parsed rule = %+v", u)
+	}
+
+	s := newNetWatchState(t.TempDir())
+	ev, ok := s.ruleEvent(u)
+	if !ok || ev.Object != "priority 150 from 10.1.2.0/24" || ev.Action != "added" {
+		t.Fatalf("elchi rule = %+v, %v", ev, ok)
+	}
+	if _, ok := s.ruleEvent(ruleUpdate{Type: syscall.RTM_NEWRULE, Priority: 32766, Table: SystemTableMain}); ok {
+		t.Fatal("main lookup rule reported")
+	}
+}
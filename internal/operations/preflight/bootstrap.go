@@ -0,0 +1,119 @@
+// Package preflight checks a deployment against what is already bound on the
+// host before anything is written: the ports in its bootstrap against
+// listening sockets and other elchi bootstraps, and its downstream address
+// against the other elchi interfaces.
+package preflight
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Bind is one address a bootstrap makes envoy listen on.
+type Bind struct {
+	// Source names the bootstrap element, e.g. `listener "web"` or "admin".
+	Source   string
+	Protocol string // "tcp" or "udp"
+	Address  string
+	Port     uint32
+}
+
+func (b Bind) String() string {
+	return fmt.Sprintf("%s %s %s", b.Source, b.Protocol, net.JoinHostPort(b.Address, fmt.Sprint(b.Port)))
+}
+
+type socketAddress struct {
+	Address   string `yaml:"address"`
+	PortValue uint32 `yaml:"port_value"`
+	Protocol  string `yaml:"protocol"`
+}
+
+type address struct {
+	SocketAddress *socketAddress `yaml:"socket_address"`
+}
+
+type bootstrap struct {
+	Admin struct {
+		Address address `yaml:"address"`
+	} `yaml:"admin"`
+	StaticResources struct {
+		Listeners []struct {
+			Name                string  `yaml:"name"`
+			Address             address `yaml:"address"`
+			AdditionalAddresses []struct {
+				Address address `yaml:"address"`
+			} `yaml:"additional_addresses"`
+		} `yaml:"listeners"`
+	} `yaml:"static_resources"`
+}
+
+// BootstrapBinds returns the socket addresses of a bootstrap's admin and
+// static listeners. It reads both the JSON the control plane sends and the
+// YAML written to disk. Pipes and port 0 are skipped; listeners delivered over
+// LDS are not known until envoy runs.
+func BootstrapBinds(data []byte) ([]Bind, error) {
+	var b bootstrap
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse bootstrap: %w", err)
+	}
+
+	var binds []Bind
+	add := func(source string, a address) {
+		sa := a.SocketAddress
+		if sa == nil || sa.PortValue == 0 {
+			return
+		}
+		proto := "tcp"
+		if strings.EqualFold(sa.Protocol, "UDP") {
+			proto = "udp"
+		}
+		binds = append(binds, Bind{Source: source, Protocol: proto, Address: sa.Address, Port: sa.PortValue})
+	}
+
+	add("admin", b.Admin.Address)
+	for _, l := range b.StaticResources.Listeners {
+		source := fmt.Sprintf("listener %q", l.Name)
+		add(source, l.Address)
+		for _, extra := range l.AdditionalAddresses {
+			add(source, extra.Address)
+		}
+	}
+	return binds, nil
+}
+
+// overlaps reports whether two binds on the same port would collide: equal
+// addresses, or a wildcard covering the other. "::" is dual-stack and covers
+// IPv4 too.
+func overlaps(a, b string) bool {
+	ipA, ipB := parseIP(a), parseIP(b)
+	if ipA == nil || ipB == nil {
+		// Hostnames are resolved by envoy; only an exact match is certain.
+		return a == b
+	}
+	if ipA.Equal(ipB) {
+		return true
+	}
+	return covers(ipA, ipB) || covers(ipB, ipA)
+}
+
+func covers(wildcard, ip net.IP) bool {
+	if !wildcard.IsUnspecified() {
+		return false
+	}
+	if wildcard.To4() == nil {
+		return true
+	}
+	return ip.To4() != nil
+}
+
+// parseIP treats an empty address as a wildcard, the safe side for a conflict
+// check.
+func parseIP(s string) net.IP {
+	if s == "" {
+		return net.IPv6unspecified
+	}
+	return net.ParseIP(s)
+}
@@ -0,0 +1,183 @@
+package preflight
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	"github.com/vishvananda/netlink"
+)
+
+// elchiIfPrefix names the per-deployment dummy interfaces.
+const elchiIfPrefix = "elchi-if-"
+
+// Request is the part of a deployment the preflight looks at.
+type Request struct {
+	// Service is "<name>-<port>"; its own bootstrap is not a conflict.
+	Service           string
+	Port              uint32
+	Bootstrap         []byte
+	DownstreamAddress string
+}
+
+// Conflict is one thing the deployment would collide with.
+type Conflict struct {
+	What string
+	With string
+}
+
+// ConflictError lists every conflict found, so one failed deploy shows the
+// whole picture.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	parts := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		parts[i] = c.What + " conflicts with " + c.With
+	}
+	return fmt.Sprintf("%d port/address conflict(s): %s", len(e.Conflicts), strings.Join(parts, "; "))
+}
+
+// host is what is already bound on the machine.
+type host struct {
+	sockets []Socket
+	// bootstraps maps other deployments to the binds of their bootstrap.
+	bootstraps map[string][]Bind
+	// interfaces maps elchi-if-* interfaces to their addresses.
+	interfaces map[string][]*net.IPNet
+}
+
+// Check returns a *ConflictError when the deployment's admin or static
+// listener addresses are already taken by a listening socket or another elchi
+// bootstrap, or when its downstream address overlaps an address on another
+// elchi-if-* interface.
+func Check(req Request) error {
+	binds, err := BootstrapBinds(req.Bootstrap)
+	if err != nil {
+		return err
+	}
+	sockets, err := ListeningSockets()
+	if err != nil {
+		return fmt.Errorf("failed to read listening sockets: %w", err)
+	}
+	interfaces, err := elchiInterfaces()
+	if err != nil {
+		return fmt.Errorf("failed to list elchi interfaces: %w", err)
+	}
+	h := &host{
+		sockets:    sockets,
+		bootstraps: otherBootstraps(models.BootstrapsPath, req.Service),
+		interfaces: interfaces,
+	}
+	if conflicts := h.conflicts(req, binds); len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+func (h *host) conflicts(req Request, binds []Bind) []Conflict {
+	services := make([]string, 0, len(h.bootstraps))
+	for svc := range h.bootstraps {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+
+	var out []Conflict
+	for _, b := range binds {
+		for _, s := range h.sockets {
+			if s.Protocol != b.Protocol || s.Port != b.Port || !overlaps(b.Address, s.Address) {
+				continue
+			}
+			with := fmt.Sprintf("listening socket %s", net.JoinHostPort(s.Address, fmt.Sprint(s.Port)))
+			if s.Process != "" {
+				with += " of " + s.Process
+			}
+			out = append(out, Conflict{What: b.String(), With: with})
+		}
+
+		for _, svc := range services {
+			for _, other := range h.bootstraps[svc] {
+				if other.Protocol == b.Protocol && other.Port == b.Port && overlaps(b.Address, other.Address) {
+					out = append(out, Conflict{What: b.String(), With: fmt.Sprintf("deployment %s (%s)", svc, other)})
+				}
+			}
+		}
+	}
+
+	// A dual-stack downstream address is "<ipv4>,<ipv6>".
+	for _, address := range strings.Split(req.DownstreamAddress, ",") {
+		ip := net.ParseIP(strings.TrimSpace(address))
+		if ip == nil {
+			continue
+		}
+		own := fmt.Sprintf("%s%d", elchiIfPrefix, req.Port)
+		names := make([]string, 0, len(h.interfaces))
+		for name := range h.interfaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if name == own {
+				continue
+			}
+			for _, addr := range h.interfaces[name] {
+				if addr.Contains(ip) {
+					out = append(out, Conflict{
+						What: "downstream address " + ip.String(),
+						With: fmt.Sprintf("%s on %s", addr, name),
+					})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// otherBootstraps parses every bootstrap in dir except service's own.
+// Unreadable files are skipped: they cannot be running either.
+func otherBootstraps(dir, service string) map[string][]Bind {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	out := make(map[string][]Bind, len(matches))
+	for _, m := range matches {
+		svc := strings.TrimSuffix(filepath.Base(m), ".yaml")
+		if svc == service {
+			continue
+		}
+		data, err := os.ReadFile(m)
+		if err != nil {
+			continue
+		}
+		if binds, err := BootstrapBinds(data); err == nil && len(binds) > 0 {
+			out[svc] = binds
+		}
+	}
+	return out
+}
+
+func elchiInterfaces() (map[string][]*net.IPNet, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]*net.IPNet)
+	for _, link := range links {
+		name := link.Attrs().Name
+		if !strings.HasPrefix(name, elchiIfPrefix) {
+			continue
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %w", name, err)
+		}
+		for _, a := range addrs {
+			out[name] = append(out[name], a.IPNet)
+		}
+	}
+	return out, nil
+}
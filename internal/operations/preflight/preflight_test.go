@@ -0,0 +1,164 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const bootstrapJSON = `{
+  "admin": {"address": {"socket_address": {"address": "127.0.0.1", "port_value": 9901}}},
+  "static_resources": {
+    "listeners": [
+      {"name": "web", "address": {"socket_address": {"address": "10.0.0.5", "port_value": 443}},
+       "additional_addresses": [{"address": {"socket_address": {"address": "::", "port_value": 8443}}}]},
+      {"name": "dns", "address": {"socket_address": {"address": "0.0.0.0", "port_value": 53, "protocol": "UDP"}}},
+      {"name": "pipe", "address": {"pipe": {"path": "/run/envoy.sock"}}}
+    ]
+  }
+}`
+
+func TestBootstrapBinds(t *testing.T) {
+	binds, err := BootstrapBinds([]byte(bootstrapJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"admin tcp 127.0.0.1:9901",
+		`listener "web" tcp 10.0.0.5:443`,
+		`listener "web" tcp [::]:8443`,
+		`listener "dns" udp 0.0.0.0:53`,
+	}
+	if len(binds) != len(want) {
+		t.Fatalf("binds = %v, want %v", binds, want)
+	}
+	for i, b := range binds {
+		if b.String() != want[i] {
+			t.Errorf("bind %d = %s, want %s", i, b, want[i])
+		}
+	}
+
+	// The YAML written to disk parses the same way.
+	yamlBinds, err := BootstrapBinds([]byte("admin:\n  address:\n    socket_address:\n      address: 127.0.0.1\n      port_value: 9901\n"))
+	if err != nil || len(yamlBinds) != 1 || yamlBinds[0].Port != 9901 {
+		t.Errorf("yaml binds = %v, %v", yamlBinds, err)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"10.0.0.5", "10.0.0.5", true},
+		{"10.0.0.5", "10.0.0.6", false},
+		{"0.0.0.0", "10.0.0.5", true},
+		{"10.0.0.5", "0.0.0.0", true},
+		{"::", "10.0.0.5", true},
+		{"0.0.0.0", "2001:db8::1", false},
+		{"2001:db8::1", "2001:db8::1", true},
+		{"", "10.0.0.5", true},
+		{"envoy.local", "envoy.local", true},
+		{"envoy.local", "10.0.0.5", false},
+	}
+	for _, c := range cases {
+		if got := overlaps(c.a, c.b); got != c.want {
+			t.Errorf("overlaps(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestParseProcNet(t *testing.T) {
+	proc := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(proc, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:26AD 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 111 1 0000000000000000 100 0 0 10 0
+   1: 0500000A:01BB 0600000A:D431 01 00000000:00000000 00:00000000 00000000  1000        0 112 1 0000000000000000 100 0 0 10 0
+`
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:20FB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 113 1 0000000000000000 100 0 0 10 0
+`
+	udp := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  1: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 114 2 0000000000000000 0
+  2: 0500000A:C350 08080808:0035 01 00000000:00000000 00:00000000 00000000     0        0 115 2 0000000000000000 0
+`
+	for name, content := range map[string]string{"tcp": tcp, "tcp6": tcp6, "udp": udp} {
+		if err := os.WriteFile(filepath.Join(proc, "net", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sockets, err := listeningSockets(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sockets {
+		got = append(got, s.Protocol+" "+net.JoinHostPort(s.Address, fmt.Sprint(s.Port)))
+	}
+	want := "tcp 127.0.0.1:9901,tcp [::]:8443,udp 0.0.0.0:53"
+	if strings.Join(got, ",") != want {
+		t.Errorf("sockets = %v, want %s", got, want)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The service's own bootstrap (a redeploy) is not a conflict.
+	write("edge-9901.yaml", bootstrapJSON)
+	write("api-9902.yaml", `{"static_resources": {"listeners": [
+	  {"name": "api", "address": {"socket_address": {"address": "0.0.0.0", "port_value": 443}}}]}}`)
+	write("other-9903.yaml", `{"admin": {"address": {"socket_address": {"address": "127.0.0.1", "port_value": 9903}}}}`)
+
+	_, taken, _ := net.ParseCIDR("10.0.0.5/32")
+	_, own, _ := net.ParseCIDR("10.0.0.7/32")
+	h := &host{
+		sockets: []Socket{
+			{Protocol: "tcp", Address: "127.0.0.1", Port: 9901, Process: "nginx[42]"},
+			{Protocol: "udp", Address: "0.0.0.0", Port: 8443},
+		},
+		bootstraps: otherBootstraps(dir, "edge-9901"),
+		interfaces: map[string][]*net.IPNet{
+			"elchi-if-9902": {taken},
+			"elchi-if-9901": {own},
+		},
+	}
+	binds, _ := BootstrapBinds([]byte(bootstrapJSON))
+	conflicts := h.conflicts(Request{Service: "edge-9901", Port: 9901, DownstreamAddress: "10.0.0.5"}, binds)
+
+	want := []Conflict{
+		{What: "admin tcp 127.0.0.1:9901", With: "listening socket 127.0.0.1:9901 of nginx[42]"},
+		{What: `listener "web" tcp 10.0.0.5:443`, With: `deployment api-9902 (listener "api" tcp 0.0.0.0:443)`},
+		{What: "downstream address 10.0.0.5", With: "10.0.0.5/32 on elchi-if-9902"},
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("conflicts = %+v, want %+v", conflicts, want)
+	}
+	for i := range want {
+		if conflicts[i] != want[i] {
+			t.Errorf("conflict %d = %+v, want %+v", i, conflicts[i], want[i])
+		}
+	}
+
+	var err error = &ConflictError{Conflicts: conflicts}
+	var ce *ConflictError
+	if !errors.As(err, &ce) || !strings.HasPrefix(err.Error(), "3 port/address conflict(s): admin tcp 127.0.0.1:9901 conflicts with") {
+		t.Errorf("error = %v", err)
+	}
+
+	if got := h.conflicts(Request{Service: "edge-9901", Port: 9901, DownstreamAddress: "10.0.0.7"}, nil); len(got) != 0 {
+		t.Errorf("own interface reported as a conflict: %+v", got)
+	}
+}
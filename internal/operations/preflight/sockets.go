@@ -0,0 +1,154 @@
+package preflight
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// Socket is a listening socket from /proc/net.
+type Socket struct {
+	Protocol string
+	Address  string
+	Port     uint32
+	Inode    string
+	// Process is "<comm>[<pid>]" when the owner could be found; sockets of
+	// other users' processes are only visible to root.
+	Process string
+}
+
+// tcpListen is the TCP_LISTEN state in /proc/net/tcp*.
+const tcpListen = "0A"
+
+var procNetFiles = []struct {
+	name     string
+	protocol string
+}{
+	{"tcp", "tcp"}, {"tcp6", "tcp"}, {"udp", "udp"}, {"udp6", "udp"},
+}
+
+// ListeningSockets returns the host's listening TCP sockets and bound,
+// unconnected UDP sockets.
+func ListeningSockets() ([]Socket, error) {
+	return listeningSockets(models.ProcPath)
+}
+
+func listeningSockets(proc string) ([]Socket, error) {
+	var sockets []Socket
+	for _, f := range procNetFiles {
+		found, err := parseProcNet(filepath.Join(proc, "net", f.name), f.protocol)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// No IPv6 on this host.
+				continue
+			}
+			return nil, err
+		}
+		sockets = append(sockets, found...)
+	}
+	resolveOwners(proc, sockets)
+	return sockets, nil
+}
+
+func parseProcNet(path, protocol string) ([]Socket, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sockets []Socket
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if protocol == "tcp" && fields[3] != tcpListen {
+			continue
+		}
+		if protocol == "udp" && !zeroAddress(fields[2]) {
+			// A connected UDP socket is a client, not a listener.
+			continue
+		}
+		ip, port, err := parseHexAddress(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		sockets = append(sockets, Socket{Protocol: protocol, Address: ip.String(), Port: port, Inode: fields[9]})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseHexAddress decodes "0100007F:0050". The address is stored as 32-bit
+// words in host byte order, the port in network order.
+func parseHexAddress(s string) (net.IP, uint32, error) {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("malformed socket address %q", s)
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("malformed socket address %q", s)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed socket port %q", s)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return ip, uint32(port), nil
+}
+
+func zeroAddress(s string) bool {
+	return strings.Trim(s, "0:") == ""
+}
+
+// resolveOwners fills in Socket.Process by matching socket inodes against the
+// fds in /proc/<pid>/fd. Best effort: unreadable processes are skipped.
+func resolveOwners(proc string, sockets []Socket) {
+	if len(sockets) == 0 {
+		return
+	}
+	byInode := make(map[string][]int, len(sockets))
+	for i, s := range sockets {
+		if s.Inode != "0" {
+			byInode[s.Inode] = append(byInode[s.Inode], i)
+		}
+	}
+
+	fdDirs, _ := filepath.Glob(filepath.Join(proc, "[0-9]*", "fd"))
+	for _, fdDir := range fdDirs {
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		pidDir := filepath.Dir(fdDir)
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			idx, ok := byInode[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]
+			if !ok {
+				continue
+			}
+			comm, _ := os.ReadFile(filepath.Join(pidDir, "comm"))
+			owner := fmt.Sprintf("%s[%s]", strings.TrimSpace(string(comm)), filepath.Base(pidDir))
+			for _, i := range idx {
+				sockets[i].Process = owner
+			}
+		}
+	}
+}
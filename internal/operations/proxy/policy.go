@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxResponseBytes caps a forwarded admin response body. It stays below
+// gRPC's default 4 MiB receive limit on the control plane side, leaving room for
+// headers and the command envelope.
+const DefaultMaxResponseBytes = 3 << 20
+
+// DefaultAllow is the admin surface the control plane may reach when the config
+// does not override it: read-only inspection plus log level and counter reset.
+// Endpoints that stop, drain or reconfigure envoy (/quitquitquit,
+// /drain_listeners, /healthcheck/fail, /runtime_modify, the profilers) are
+// deliberately absent.
+var DefaultAllow = []string{
+	"GET /certs",
+	"GET /clusters",
+	"GET /config_dump",
+	"GET /contention",
+	"GET /heap_dump",
+	"GET /hot_restart_version",
+	"GET /init_dump",
+	"GET /listeners",
+	"GET /memory",
+	"GET /ready",
+	"GET /runtime",
+	"GET /server_info",
+	"GET /stats*",
+	"POST /logging",
+	"POST /reset_counters",
+}
+
+// DefaultVirtualAllow is the client-answered /elchi/* surface the control
+// plane may reach when the config does not override it: reports only. The
+// POST paths change the host (firewall, certs, VRFs, interfaces, snapshots,
+// drift repair, routes, sysctl) and must be allowed explicitly.
+var DefaultVirtualAllow = []string{
+	"GET /elchi/*",
+}
+
+// Policy decides which forwarded admin requests and client-answered virtual
+// paths are allowed and how much of a response is returned.
+type Policy struct {
+	rules            []rule
+	virtualRules     []rule
+	maxResponseBytes int64
+}
+
+// rule is one "METHOD /path" allowlist entry; a trailing "*" makes it a prefix.
+type rule struct {
+	method string
+	path   string
+	prefix bool
+}
+
+// NewPolicy parses allow entries ("GET /stats", "GET /stats*") for envoy and
+// virtualAllow entries ("POST /elchi/firewall") for the client's own paths.
+// An empty allow list selects DefaultAllow, an empty virtualAllow list
+// DefaultVirtualAllow; maxResponseBytes <= 0 selects DefaultMaxResponseBytes.
+func NewPolicy(allow, virtualAllow []string, maxResponseBytes int64) (*Policy, error) {
+	if len(allow) == 0 {
+		allow = DefaultAllow
+	}
+	if len(virtualAllow) == 0 {
+		virtualAllow = DefaultVirtualAllow
+	}
+	if maxResponseBytes <= 0 {
+		maxResponseBytes = DefaultMaxResponseBytes
+	}
+
+	rules, err := parseRules(allow)
+	if err != nil {
+		return nil, err
+	}
+	virtualRules, err := parseRules(virtualAllow)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{rules: rules, virtualRules: virtualRules, maxResponseBytes: maxResponseBytes}, nil
+}
+
+func parseRules(entries []string) ([]rule, error) {
+	var rules []rule
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid admin allow entry %q, want \"METHOD /path\"", entry)
+		}
+		r := rule{method: strings.ToUpper(fields[0]), path: fields[1]}
+		if r.method != "GET" && r.method != "POST" {
+			return nil, fmt.Errorf("invalid admin allow entry %q: method must be GET or POST", entry)
+		}
+		if strings.HasSuffix(r.path, "*") {
+			r.prefix = true
+			r.path = strings.TrimSuffix(r.path, "*")
+		}
+		if !strings.HasPrefix(r.path, "/") {
+			return nil, fmt.Errorf("invalid admin allow entry %q: path must start with /", entry)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// MaxResponseBytes returns the forwarded response body cap.
+func (p *Policy) MaxResponseBytes() int64 {
+	return p.maxResponseBytes
+}
+
+// Allowed reports whether method may be sent to adminPath (without query). Paths
+// that are not in canonical form are rejected outright so "/stats/../quitquitquit"
+// cannot ride on a prefix rule.
+func (p *Policy) Allowed(method, adminPath string) error {
+	if !matchRules(p.rules, method, adminPath) {
+		return fmt.Errorf("%s %s is not in the envoy admin allowlist", method, adminPath)
+	}
+	return nil
+}
+
+// VirtualAllowed is Allowed for the paths the client answers itself.
+func (p *Policy) VirtualAllowed(method, virtualPath string) error {
+	if !matchRules(p.virtualRules, method, virtualPath) {
+		return fmt.Errorf("%s %s is not in the virtual path allowlist", method, virtualPath)
+	}
+	return nil
+}
+
+func matchRules(rules []rule, method, adminPath string) bool {
+	if !strings.HasPrefix(adminPath, "/") || path.Clean(adminPath) != adminPath {
+		return false
+	}
+	for _, r := range rules {
+		if r.method != method {
+			continue
+		}
+		if adminPath == r.path || (r.prefix && strings.HasPrefix(adminPath, r.path)) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	policyMu      sync.RWMutex
+	currentPolicy = mustDefaultPolicy()
+)
+
+// SetPolicy installs the process-wide forwarding policy (from the client config
+// at startup).
+func SetPolicy(p *Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	currentPolicy = p
+}
+
+// CurrentPolicy returns the process-wide forwarding policy.
+func CurrentPolicy() *Policy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return currentPolicy
+}
+
+func mustDefaultPolicy() *Policy {
+	p, err := NewPolicy(nil, nil, 0)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
@@ -2,36 +2,207 @@ package proxy
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
 	client "github.com/CloudNativeWorks/elchi-proto/client"
+	"gopkg.in/yaml.v3"
 )
 
 var defaultHTTPClient = &http.Client{
 	Timeout: 30 * time.Second,
 }
 
+// bootstrapsDir is where the admin unix socket of a deployment is looked up.
+var bootstrapsDir = models.BootstrapsPath
+
+// Reserved query parameters. They control Forward and are stripped before the
+// request reaches envoy.
+const (
+	// QueryOffset selects chunked mode: the response carries the body from this
+	// byte offset, up to the size cap, without a truncation marker.
+	QueryOffset = "elchi_offset"
+	// QueryEncoding=gzip returns the body gzip-compressed and base64-encoded.
+	QueryEncoding = "elchi_encoding"
+)
+
+// Response headers Forward adds to describe the returned body.
+const (
+	HeaderTotalBytes = "X-Elchi-Total-Bytes"
+	HeaderOffset     = "X-Elchi-Offset"
+	HeaderNextOffset = "X-Elchi-Next-Offset"
+	HeaderTruncated  = "X-Elchi-Truncated"
+	HeaderBodySHA256 = "X-Elchi-Body-Sha256"
+	HeaderEncoding   = "X-Elchi-Encoding"
+)
+
+// Do sends req to the deployment's admin endpoint and returns the full
+// response. It is for the client's own, trusted use (version GC, stats); requests
+// that originate from the control plane go through Forward.
 func Do(ctx context.Context, req *client.RequestEnvoyAdmin) (*client.ResponseEnvoyAdmin, error) {
-	u := fmt.Sprintf("http://127.0.0.1:%d%s", req.Port, req.Path)
+	resp, err := open(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.ResponseEnvoyAdmin{
+		StatusCode: int32(resp.StatusCode),
+		Body:       string(respBody),
+		Headers:    flattenHeaders(resp.Header),
+	}, nil
+}
+
+// Forward relays a control-plane admin request. The method and path must pass
+// the current Policy, and the body is streamed: at most MaxResponseBytes are
+// kept in memory while the rest is only counted and hashed. A truncated body is
+// marked in the X-Elchi-* headers (and, outside chunked mode, with a trailing
+// note); QueryOffset fetches the following chunks.
+func Forward(ctx context.Context, req *client.RequestEnvoyAdmin) (*client.ResponseEnvoyAdmin, error) {
+	return forward(ctx, req, CurrentPolicy().MaxResponseBytes())
+}
+
+// ForwardLimited is Forward with a smaller body cap, for callers that pack
+// several responses into one reply.
+func ForwardLimited(ctx context.Context, req *client.RequestEnvoyAdmin, maxBytes int64) (*client.ResponseEnvoyAdmin, error) {
+	return forward(ctx, req, min(maxBytes, CurrentPolicy().MaxResponseBytes()))
+}
+
+func forward(ctx context.Context, req *client.RequestEnvoyAdmin, limit int64) (*client.ResponseEnvoyAdmin, error) {
+	adminPath, _, _ := strings.Cut(req.Path, "?")
+	if err := CurrentPolicy().Allowed(req.Method.String(), adminPath); err != nil {
+		return nil, err
+	}
+
+	queries := make(map[string]string, len(req.Queries))
+	var offset int64
+	chunked, gzipped := false, false
+	for k, v := range req.Queries {
+		switch k {
+		case QueryOffset:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", QueryOffset, v)
+			}
+			offset, chunked = n, true
+		case QueryEncoding:
+			if v != "gzip" {
+				return nil, fmt.Errorf("unsupported %s %q", QueryEncoding, v)
+			}
+			gzipped = true
+		default:
+			queries[k] = v
+		}
+	}
+
+	upstream := &client.RequestEnvoyAdmin{
+		Name:    req.Name,
+		Port:    req.Port,
+		Method:  req.Method,
+		Path:    req.Path,
+		Queries: queries,
+		Body:    req.Body,
+	}
+	resp, err := open(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Everything read is hashed so chunks of a body that changed between
+	// requests (a config push mid-download) can be detected.
+	hasher := sha256.New()
+	body := io.TeeReader(resp.Body, hasher)
+
+	skipped, err := io.CopyN(io.Discard, body, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var kept bytes.Buffer
+	if _, err := io.CopyN(&kept, body, limit); err != nil && err != io.EOF {
+		return nil, err
+	}
+	rest, err := io.Copy(io.Discard, body)
+	if err != nil {
+		return nil, err
+	}
+
+	total := skipped + int64(kept.Len()) + rest
+	headers := flattenHeaders(resp.Header)
+	headers[HeaderTotalBytes] = strconv.FormatInt(total, 10)
+	headers[HeaderBodySHA256] = hex.EncodeToString(hasher.Sum(nil))
+	if chunked {
+		headers[HeaderOffset] = strconv.FormatInt(skipped, 10)
+	}
+	if rest > 0 {
+		next := skipped + int64(kept.Len())
+		headers[HeaderTruncated] = "true"
+		headers[HeaderNextOffset] = strconv.FormatInt(next, 10)
+		if !chunked {
+			fmt.Fprintf(&kept, "\n[elchi-client: response truncated, %d of %d bytes shown; request %s=%d for the rest]\n",
+				next, total, QueryOffset, next)
+		}
+	}
+
+	out := kept.String()
+	if gzipped {
+		if out, err = gzipBase64(kept.Bytes()); err != nil {
+			return nil, err
+		}
+		headers[HeaderEncoding] = "gzip+base64"
+		delete(headers, "Content-Length")
+	}
 
+	return &client.ResponseEnvoyAdmin{
+		StatusCode: int32(resp.StatusCode),
+		Body:       out,
+		Headers:    headers,
+	}, nil
+}
+
+// open sends req to the admin endpoint: the unix socket named in the
+// deployment's bootstrap when it has one, 127.0.0.1:<port> otherwise.
+func open(ctx context.Context, req *client.RequestEnvoyAdmin) (*http.Response, error) {
+	httpClient, host := defaultHTTPClient, fmt.Sprintf("127.0.0.1:%d", req.Port)
+	if socket := adminSocket(req.Port); socket != "" {
+		httpClient, host = unixHTTPClient(socket), "envoy-admin"
+	}
+
+	u := "http://" + host + req.Path
 	if len(req.Queries) > 0 {
 		params := url.Values{}
 		for k, v := range req.Queries {
 			params.Set(k, v)
 		}
-		u += "?" + params.Encode()
+		sep := "?"
+		if strings.Contains(req.Path, "?") {
+			sep = "&"
+		}
+		u += sep + params.Encode()
 	}
 
 	var bodyReader io.Reader
 	if req.Body != "" {
 		bodyReader = bytes.NewReader([]byte(req.Body))
-	} else {
-		bodyReader = nil
 	}
 
 	httpReq, err := http.NewRequestWithContext(ctx, req.Method.String(), u, bodyReader)
@@ -40,27 +211,67 @@ func Do(ctx context.Context, req *client.RequestEnvoyAdmin) (*client.ResponseEnv
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := defaultHTTPClient.Do(httpReq)
-	if err != nil {
-		return nil, err
+	return httpClient.Do(httpReq)
+}
+
+// adminSocket returns the admin pipe path from the bootstrap of the deployment
+// on port, or "" when the admin listens on TCP (or no bootstrap is found).
+func adminSocket(port uint32) string {
+	matches, _ := filepath.Glob(filepath.Join(bootstrapsDir, fmt.Sprintf("*-%d.yaml", port)))
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			continue
+		}
+		var bootstrap struct {
+			Admin struct {
+				Address struct {
+					Pipe struct {
+						Path string `yaml:"path"`
+					} `yaml:"pipe"`
+				} `yaml:"address"`
+			} `yaml:"admin"`
+		}
+		if yaml.Unmarshal(data, &bootstrap) == nil && bootstrap.Admin.Address.Pipe.Path != "" {
+			return bootstrap.Admin.Address.Pipe.Path
+		}
 	}
-	defer resp.Body.Close()
+	return ""
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+func unixHTTPClient(socket string) *http.Client {
+	return &http.Client{
+		Timeout: defaultHTTPClient.Timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+			DisableKeepAlives: true,
+		},
 	}
+}
 
-	headers := make(map[string]string)
-	for k, v := range resp.Header {
+// flattenHeaders keeps every value of multi-value headers, joined the way HTTP
+// folds repeated fields into one.
+func flattenHeaders(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for k, v := range h {
 		if len(v) > 0 {
-			headers[k] = v[0]
+			headers[k] = strings.Join(v, ", ")
 		}
 	}
+	return headers
+}
 
-	return &client.ResponseEnvoyAdmin{
-		StatusCode: int32(resp.StatusCode),
-		Body:       string(respBody),
-		Headers:    headers,
-	}, nil
+func gzipBase64(b []byte) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+func adminServer(t *testing.T, h http.HandlerFunc) uint32 {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return uint32(port)
+}
+
+func withPolicy(t *testing.T, allow []string, maxBytes int64) {
+	t.Helper()
+	p, err := NewPolicy(allow, nil, maxBytes)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	prev := CurrentPolicy()
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(prev) })
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	p, _ := NewPolicy(nil, nil, 0)
+	for _, ok := range [][2]string{{"GET", "/config_dump"}, {"GET", "/stats/prometheus"}, {"POST", "/logging"}} {
+		if err := p.Allowed(ok[0], ok[1]); err != nil {
+			t.Errorf("%s %s rejected: %v", ok[0], ok[1], err)
+		}
+	}
+	for _, bad := range [][2]string{
+		{"POST", "/quitquitquit"},
+		{"POST", "/config_dump"},
+		{"GET", "/stats/../quitquitquit"},
+		{"GET", "stats"},
+	} {
+		if err := p.Allowed(bad[0], bad[1]); err == nil {
+			t.Errorf("%s %s allowed", bad[0], bad[1])
+		}
+	}
+
+	if _, err := NewPolicy([]string{"DELETE /x"}, nil, 0); err == nil {
+		t.Error("unsupported method accepted in allowlist")
+	}
+}
+
+func TestPolicyVirtualAllowed(t *testing.T) {
+	p, _ := NewPolicy(nil, nil, 0)
+	if err := p.VirtualAllowed("GET", "/elchi/firewall"); err != nil {
+		t.Errorf("default policy rejected a report: %v", err)
+	}
+	for _, bad := range [][2]string{{"POST", "/elchi/firewall"}, {"POST", "/elchi/sysctl/delete"}, {"GET", "/elchi/../stats"}} {
+		if err := p.VirtualAllowed(bad[0], bad[1]); err == nil {
+			t.Errorf("%s %s allowed by default", bad[0], bad[1])
+		}
+	}
+
+	p, _ = NewPolicy(nil, []string{"GET /elchi/*", "POST /elchi/sysctl"}, 0)
+	if err := p.VirtualAllowed("POST", "/elchi/sysctl"); err != nil {
+		t.Errorf("explicit POST rejected: %v", err)
+	}
+	if err := p.VirtualAllowed("POST", "/elchi/sysctl/delete"); err == nil {
+		t.Error("exact rule matched a longer path")
+	}
+	if err := p.Allowed("POST", "/elchi/sysctl"); err == nil {
+		t.Error("virtual rule leaked into the envoy allowlist")
+	}
+}
+
+func TestForwardRejectsDisallowedPath(t *testing.T) {
+	called := false
+	port := adminServer(t, func(http.ResponseWriter, *http.Request) { called = true })
+	withPolicy(t, nil, 0)
+
+	_, err := Forward(context.Background(), &client.RequestEnvoyAdmin{Port: port, Method: client.HttpMethod_POST, Path: "/quitquitquit"})
+	if err == nil || called {
+		t.Fatalf("POST /quitquitquit forwarded (err=%v, reached envoy=%v)", err, called)
+	}
+}
+
+func TestForwardTruncatesAndChunks(t *testing.T) {
+	body := strings.Repeat("x", 100) + strings.Repeat("y", 50)
+	var gotQuery url.Values
+	port := adminServer(t, func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		w.Header().Add("Vary", "a")
+		w.Header().Add("Vary", "b")
+		_, _ = io.WriteString(w, body)
+	})
+	withPolicy(t, nil, 100)
+
+	resp, err := Forward(context.Background(), &client.RequestEnvoyAdmin{Port: port, Method: client.HttpMethod_GET, Path: "/config_dump"})
+	if err != nil {
+		t.Fatalf("Forward: %v", err)
+	}
+	if !strings.HasPrefix(resp.Body, strings.Repeat("x", 100)+"\n[elchi-client: response truncated") {
+		t.Errorf("truncated body lacks marker: %q", resp.Body)
+	}
+	if resp.Headers[HeaderTruncated] != "true" || resp.Headers[HeaderTotalBytes] != "150" || resp.Headers[HeaderNextOffset] != "100" {
+		t.Errorf("truncation headers = %v", resp.Headers)
+	}
+	if resp.Headers["Vary"] != "a, b" {
+		t.Errorf("multi-value header = %q, want both values", resp.Headers["Vary"])
+	}
+
+	chunk, err := Forward(context.Background(), &client.RequestEnvoyAdmin{
+		Port: port, Method: client.HttpMethod_GET, Path: "/config_dump",
+		Queries: map[string]string{QueryOffset: "100", "include_eds": "true"},
+	})
+	if err != nil {
+		t.Fatalf("Forward chunk: %v", err)
+	}
+	if chunk.Body != strings.Repeat("y", 50) || chunk.Headers[HeaderTruncated] != "" {
+		t.Errorf("second chunk = %q (headers %v)", chunk.Body, chunk.Headers)
+	}
+	if gotQuery.Get(QueryOffset) != "" || gotQuery.Get("include_eds") != "true" {
+		t.Errorf("envoy saw query %v, want reserved params stripped and others kept", gotQuery)
+	}
+	if chunk.Headers[HeaderBodySHA256] != resp.Headers[HeaderBodySHA256] {
+		t.Error("chunks of an unchanged body report different digests")
+	}
+}
+
+func TestForwardGzip(t *testing.T) {
+	body := strings.Repeat("config ", 1000)
+	port := adminServer(t, func(w http.ResponseWriter, _ *http.Request) { _, _ = io.WriteString(w, body) })
+	withPolicy(t, nil, 0)
+
+	resp, err := Forward(context.Background(), &client.RequestEnvoyAdmin{
+		Port: port, Method: client.HttpMethod_GET, Path: "/config_dump",
+		Queries: map[string]string{QueryEncoding: "gzip"},
+	})
+	if err != nil {
+		t.Fatalf("Forward: %v", err)
+	}
+	if resp.Headers[HeaderEncoding] != "gzip+base64" {
+		t.Fatalf("encoding header = %q", resp.Headers[HeaderEncoding])
+	}
+	raw, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(zr)
+	if string(got) != body {
+		t.Error("decompressed body differs")
+	}
+}
+
+func TestDoOverUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "admin.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "unix:"+r.URL.Path)
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { srv.Close() })
+
+	bootstrap := "admin:\n  address:\n    pipe:\n      path: " + socket + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "web-9901.yaml"), []byte(bootstrap), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prev := bootstrapsDir
+	bootstrapsDir = dir
+	t.Cleanup(func() { bootstrapsDir = prev })
+
+	resp, err := Do(context.Background(), &client.RequestEnvoyAdmin{Port: 9901, Method: client.HttpMethod_GET, Path: "/ready"})
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if resp.Body != "unix:/ready" {
+		t.Errorf("body = %q, want the unix socket server's reply", resp.Body)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
 	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
 	"github.com/CloudNativeWorks/elchi-client/pkg/models"
 	"github.com/CloudNativeWorks/elchi-proto/client"
@@ -166,8 +167,9 @@ func syncInto(ctx context.Context, root string, cfg *client.ShieldConfig, log *l
 
 // prepareFile validates f's content and writes it to tmp (with mode), without
 // touching the live destination. Inline content is hash-checked when a sha256 is
-// given; downloads REQUIRE a sha256 (the fetch is otherwise unverified) and are
-// bounded by downloadTimeout/maxDownloadBytes.
+// given; downloads REQUIRE a sha256 (the fetch is otherwise unverified), must carry
+// a signature accepted by the artifact signing policy, and are bounded by
+// downloadTimeout/maxDownloadBytes.
 func prepareFile(ctx context.Context, f *client.ShieldFile, tmp, want string, mode os.FileMode) error {
 	switch src := f.GetSource().(type) {
 	case *client.ShieldFile_Inline:
@@ -198,6 +200,12 @@ func prepareFile(ctx context.Context, f *client.ShieldFile, tmp, want string, mo
 		if got != want {
 			return fmt.Errorf("sha256 mismatch (download): got %s want %s", got, want)
 		}
+		// The sha256 comes from the same control plane as the URL; the detached
+		// signature (".sig" on the URL path) is checked against the locally
+		// pinned keys.
+		if err := verifyDownloadSignature(ctx, src.Download.GetUrl(), want); err != nil {
+			return err
+		}
 		return os.Chmod(tmp, mode)
 
 	default:
@@ -350,6 +358,20 @@ func downloadTo(ctx context.Context, url, dst string) error {
 	return nil
 }
 
+func verifyDownloadSignature(ctx context.Context, url, digest string) error {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	signature, err := common.FetchDetachedSignature(ctx, http.DefaultClient, url)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", url, err)
+	}
+	if _, err := common.CurrentSignaturePolicy().Verify(digest, signature); err != nil {
+		return fmt.Errorf("download %s: %w", url, err)
+	}
+	return nil
+}
+
 // fileMode parses an octal mode string (e.g. "0640"), masking to permission bits so
 // a stray setuid/setgid/sticky or out-of-range value can't be applied. Empty or
 // unparseable input falls back to defaultFileMode (0600).
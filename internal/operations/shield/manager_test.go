@@ -2,7 +2,9 @@ package shield
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +12,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
 	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
 	"github.com/CloudNativeWorks/elchi-proto/client"
 )
@@ -241,9 +244,36 @@ func TestModeMasking(t *testing.T) {
 	}
 }
 
+// trustTestKey pins a fresh signing key for the test and returns a signer for
+// artifact digests.
+func trustTestKey(t *testing.T, allowUnsigned bool) func(body []byte) string {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := common.NewSignaturePolicy([]string{base64.StdEncoding.EncodeToString(pub)}, allowUnsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := common.CurrentSignaturePolicy()
+	common.SetSignaturePolicy(policy)
+	t.Cleanup(func() { common.SetSignaturePolicy(prev) })
+
+	return func(body []byte) string {
+		digest := sha256.Sum256(body)
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest[:]))
+	}
+}
+
 func TestDownloadSuccess(t *testing.T) {
+	sign := trustTestKey(t, false)
 	body := []byte("GeoIP-mmdb-bytes")
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Country.mmdb.sig" {
+			_, _ = w.Write([]byte(sign(body)))
+			return
+		}
 		_, _ = w.Write(body)
 	}))
 	defer srv.Close()
@@ -251,7 +281,7 @@ func TestDownloadSuccess(t *testing.T) {
 	root := t.TempDir()
 	cfg := &client.ShieldConfig{Files: []*client.ShieldFile{{
 		Path:   "geo/Country.mmdb",
-		Source: &client.ShieldFile_Download{Download: &client.ShieldDownload{Url: srv.URL}},
+		Source: &client.ShieldFile_Download{Download: &client.ShieldDownload{Url: srv.URL + "/Country.mmdb"}},
 		Sha256: sum(body),
 		Mode:   "0644",
 	}}}
@@ -265,6 +295,38 @@ func TestDownloadSuccess(t *testing.T) {
 	assertNoTempFiles(t, root)
 }
 
+func TestDownloadUnsignedRejected(t *testing.T) {
+	trustTestKey(t, false)
+	body := []byte("unsigned")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/x.mmdb.sig" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	cfg := &client.ShieldConfig{Files: []*client.ShieldFile{{
+		Path:   "geo/x.mmdb",
+		Source: &client.ShieldFile_Download{Download: &client.ShieldDownload{Url: srv.URL + "/x.mmdb"}},
+		Sha256: sum(body),
+	}}}
+	if _, err := syncInto(context.Background(), root, cfg, testLogger()); err == nil {
+		t.Fatal("unsigned download must be rejected unless allow_unsigned is set")
+	}
+	if _, err := os.Stat(filepath.Join(root, "geo", "x.mmdb")); !os.IsNotExist(err) {
+		t.Fatal("an unsigned download must not be committed")
+	}
+	assertNoTempFiles(t, root)
+
+	trustTestKey(t, true)
+	if _, err := syncInto(context.Background(), root, cfg, testLogger()); err != nil {
+		t.Fatalf("unsigned download with allow_unsigned: %v", err)
+	}
+}
+
 func TestDownloadRequiresSha(t *testing.T) {
 	root := t.TempDir()
 	cfg := &client.ShieldConfig{Files: []*client.ShieldFile{{
@@ -0,0 +1,207 @@
+// Package stats merges and summarizes the Prometheus stats of the envoy
+// deployments on a host. Scraping is the caller's job; this package only works
+// on the /stats/prometheus text.
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Labels added to every series so merged output stays attributable.
+const (
+	ListenerLabel = "elchi_listener"
+	PortLabel     = "elchi_port"
+)
+
+// Source is one deployment's scraped /stats/prometheus body.
+type Source struct {
+	Listener string
+	Port     uint32
+	Text     []byte
+}
+
+// Sample is one parsed series value.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// family collects the lines of one metric family across all sources, so the
+// merged exposition has each # HELP / # TYPE exactly once, as the format requires.
+type family struct {
+	help, typ string
+	samples   []string
+}
+
+// Merge combines the sources into one exposition, tagging every sample with
+// the listener name and port it came from.
+func Merge(sources []Source) []byte {
+	families := make(map[string]*family)
+	var order []string
+	get := func(name string) *family {
+		f, ok := families[name]
+		if !ok {
+			f = &family{}
+			families[name] = f
+			order = append(order, name)
+		}
+		return f
+	}
+
+	for _, src := range sources {
+		extra := fmt.Sprintf(`%s="%s",%s="%d"`, ListenerLabel, escapeLabel(src.Listener), PortLabel, src.Port)
+		current := ""
+		scanner := bufio.NewScanner(bytes.NewReader(src.Text))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case line == "":
+				continue
+			case strings.HasPrefix(line, "# HELP "), strings.HasPrefix(line, "# TYPE "):
+				fields := strings.SplitN(line, " ", 4)
+				if len(fields) < 3 {
+					continue
+				}
+				current = fields[2]
+				f := get(current)
+				if fields[1] == "HELP" && f.help == "" {
+					f.help = line
+				} else if fields[1] == "TYPE" && f.typ == "" {
+					f.typ = line
+				}
+			case strings.HasPrefix(line, "#"):
+				continue
+			default:
+				name := sampleName(line)
+				if current == "" || !strings.HasPrefix(name, current) {
+					current = name
+				}
+				get(current).samples = append(get(current).samples, withLabels(line, name, extra))
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	for _, name := range order {
+		f := families[name]
+		if f.help != "" {
+			out.WriteString(f.help + "\n")
+		}
+		if f.typ != "" {
+			out.WriteString(f.typ + "\n")
+		}
+		for _, s := range f.samples {
+			out.WriteString(s + "\n")
+		}
+	}
+	return out.Bytes()
+}
+
+// ParseSamples parses the sample lines of an exposition. Malformed lines are
+// skipped: a stat envoy renders oddly must not hide the rest.
+func ParseSamples(text []byte) []Sample {
+	var out []Sample
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if s, ok := parseSample(line); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func parseSample(line string) (Sample, bool) {
+	name := sampleName(line)
+	rest := line[len(name):]
+	labels := map[string]string{}
+	if strings.HasPrefix(rest, "{") {
+		end, ok := parseLabels(rest[1:], labels)
+		if !ok {
+			return Sample{}, false
+		}
+		rest = rest[1+end:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return Sample{}, false
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	return Sample{Name: name, Labels: labels, Value: v}, true
+}
+
+// parseLabels reads `a="x",b="y"}` into labels and returns the index just
+// past the closing brace.
+func parseLabels(s string, labels map[string]string) (int, bool) {
+	i := 0
+	for i < len(s) {
+		if s[i] == '}' {
+			return i + 1, true
+		}
+		if s[i] == ',' || s[i] == ' ' {
+			i++
+			continue
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return 0, false
+		}
+		key := s[i : i+eq]
+		i += eq + 2
+		var val strings.Builder
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					val.WriteByte('\n')
+				default:
+					val.WriteByte(s[i])
+				}
+				continue
+			}
+			val.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return 0, false
+		}
+		labels[key] = val.String()
+		i++ // closing quote
+	}
+	return 0, false
+}
+
+func sampleName(line string) string {
+	if i := strings.IndexAny(line, "{ \t"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+func withLabels(line, name, extra string) string {
+	rest := line[len(name):]
+	if strings.HasPrefix(rest, "{}") {
+		return name + "{" + extra + "}" + rest[2:]
+	}
+	if strings.HasPrefix(rest, "{") {
+		return name + "{" + extra + "," + rest[1:]
+	}
+	return name + "{" + extra + "}" + rest
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule is a threshold on one stat, written "<metric> <op> <value>", e.g.
+// "envoy_server_state == 0" or "envoy_cluster_upstream_cx_connect_fail < 10".
+// The metric's value is the sum over all of its label sets; a metric that is
+// absent counts as 0.
+type Rule struct {
+	Metric string
+	Op     string
+	Value  float64
+}
+
+var ruleOps = map[string]func(a, b float64) bool{
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+// ParseRule parses a "<metric> <op> <value>" rule.
+func ParseRule(s string) (Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return Rule{}, fmt.Errorf("invalid stats rule %q, want \"<metric> <op> <value>\"", s)
+	}
+	if _, ok := ruleOps[fields[1]]; !ok {
+		return Rule{}, fmt.Errorf("invalid stats rule %q: unknown operator %q", s, fields[1])
+	}
+	v, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid stats rule %q: %w", s, err)
+	}
+	return Rule{Metric: fields[0], Op: fields[1], Value: v}, nil
+}
+
+// Check returns an error describing the violation when samples break the rule.
+func (r Rule) Check(samples []Sample) error {
+	var sum float64
+	for _, s := range samples {
+		if s.Name == r.Metric {
+			sum += s.Value
+		}
+	}
+	if !ruleOps[r.Op](sum, r.Value) {
+		return fmt.Errorf("%s is %g, want %s %g", r.Metric, sum, r.Op, r.Value)
+	}
+	return nil
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s %s %g", r.Metric, r.Op, r.Value)
+}
@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const envoyStats = `# TYPE envoy_listener_downstream_cx_active gauge
+envoy_listener_downstream_cx_active{envoy_listener_address="0.0.0.0_80"} 7
+# TYPE envoy_http_downstream_rq_total counter
+envoy_http_downstream_rq_total{envoy_http_conn_manager_prefix="ingress"} 1000
+envoy_http_downstream_rq_total{envoy_http_conn_manager_prefix="admin"} 50
+# TYPE envoy_http_downstream_rq_xx counter
+envoy_http_downstream_rq_xx{envoy_response_code_class="2",envoy_http_conn_manager_prefix="ingress"} 990
+envoy_http_downstream_rq_xx{envoy_response_code_class="5",envoy_http_conn_manager_prefix="ingress"} 10
+# TYPE envoy_cluster_membership_healthy gauge
+envoy_cluster_membership_healthy{envoy_cluster_name="api"} 2
+envoy_cluster_membership_healthy{envoy_cluster_name="db"} 0
+# TYPE envoy_cluster_membership_total gauge
+envoy_cluster_membership_total{envoy_cluster_name="api"} 3
+envoy_cluster_membership_total{envoy_cluster_name="db"} 1
+# TYPE envoy_server_uptime gauge
+envoy_server_uptime 42
+`
+
+func TestMergeTagsSeriesAndDedupesType(t *testing.T) {
+	out := string(Merge([]Source{
+		{Listener: "web", Port: 80, Text: []byte(envoyStats)},
+		{Listener: "api", Port: 8080, Text: []byte(envoyStats)},
+	}))
+
+	if n := strings.Count(out, "# TYPE envoy_server_uptime gauge"); n != 1 {
+		t.Errorf("TYPE line appears %d times, want 1", n)
+	}
+	for _, want := range []string{
+		`envoy_server_uptime{elchi_listener="web",elchi_port="80"} 42`,
+		`envoy_server_uptime{elchi_listener="api",elchi_port="8080"} 42`,
+		`envoy_cluster_membership_total{elchi_listener="web",elchi_port="80",envoy_cluster_name="db"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("merged output lacks %q", want)
+		}
+	}
+
+	// Samples of one family must stay contiguous after their TYPE line.
+	typeIdx := strings.Index(out, "# TYPE envoy_server_uptime")
+	if !strings.Contains(out[typeIdx:], `envoy_server_uptime{elchi_listener="api"`) {
+		t.Error("second source's samples are not grouped under the family")
+	}
+}
+
+func TestParseSamplesLabels(t *testing.T) {
+	samples := ParseSamples([]byte(`x{a="q\"uo,te}",b="2"} 1.5` + "\nbroken{a=} 1\ny 3\n"))
+	if len(samples) != 2 {
+		t.Fatalf("parsed %d samples, want 2 (malformed line skipped): %+v", len(samples), samples)
+	}
+	if samples[0].Labels["a"] != `q"uo,te}` || samples[0].Labels["b"] != "2" || samples[0].Value != 1.5 {
+		t.Errorf("sample = %+v", samples[0])
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	t0 := time.Now()
+	s, c := Summarize(ParseSamples([]byte(envoyStats)), nil, t0)
+	if s.ActiveConnections != 7 || s.RequestsTotal != 1000 || s.Responses5xxTotal != 10 {
+		t.Errorf("summary = %+v (admin traffic must be excluded)", s)
+	}
+	if s.UpstreamHealthy != 2 || s.UpstreamTotal != 4 || len(s.UnhealthyClusters) != 1 || s.UnhealthyClusters[0] != "db" {
+		t.Errorf("upstream health = %+v", s)
+	}
+	if s.RPS != 0 {
+		t.Errorf("first scrape RPS = %v, want 0", s.RPS)
+	}
+
+	next := strings.Replace(envoyStats, `"ingress"} 1000`, `"ingress"} 1100`, 1)
+	s, _ = Summarize(ParseSamples([]byte(next)), &c, t0.Add(10*time.Second))
+	if s.RPS != 10 {
+		t.Errorf("RPS = %v, want 10", s.RPS)
+	}
+
+	// A restart resets counters: no negative rate.
+	reset := strings.Replace(envoyStats, `"ingress"} 1000`, `"ingress"} 5`, 1)
+	s, _ = Summarize(ParseSamples([]byte(reset)), &c, t0.Add(10*time.Second))
+	if s.RPS != 0 {
+		t.Errorf("RPS after counter reset = %v, want 0", s.RPS)
+	}
+}
+
+func TestRules(t *testing.T) {
+	samples := ParseSamples([]byte(envoyStats))
+
+	r, err := ParseRule("envoy_cluster_membership_healthy >= 2")
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	if err := r.Check(samples); err != nil {
+		t.Errorf("rule summed over clusters should pass: %v", err)
+	}
+
+	r, _ = ParseRule("envoy_server_uptime < 10")
+	if err := r.Check(samples); err == nil {
+		t.Error("violated rule passed")
+	}
+
+	r, _ = ParseRule("envoy_missing_stat == 0")
+	if err := r.Check(samples); err != nil {
+		t.Errorf("absent metric should count as 0: %v", err)
+	}
+
+	for _, bad := range []string{"", "x >", "x => 1", "x < one"} {
+		if _, err := ParseRule(bad); err == nil {
+			t.Errorf("ParseRule(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// Counters are the cumulative values a Summary's rates are derived from. They
+// are kept between scrapes; envoy counters reset on a full restart, which shows
+// up as a decrease and yields a zero rate for that interval.
+type Counters struct {
+	Requests   float64
+	Responses5 float64
+	At         time.Time
+}
+
+// Summary is the compact per-listener health view sent to the control plane.
+type Summary struct {
+	ActiveConnections float64  `json:"active_connections"`
+	RequestsTotal     float64  `json:"requests_total"`
+	Responses5xxTotal float64  `json:"responses_5xx_total"`
+	RPS               float64  `json:"rps"`
+	Errors5xxPerSec   float64  `json:"errors_5xx_per_sec"`
+	UpstreamHealthy   float64  `json:"upstream_healthy"`
+	UpstreamTotal     float64  `json:"upstream_total"`
+	UnhealthyClusters []string `json:"unhealthy_clusters,omitempty"`
+}
+
+// Summarize builds a Summary from one deployment's samples. Admin listener and
+// admin connection manager stats are excluded so operator traffic does not
+// count as load. prev, when non-nil, supplies the previous counters for rates.
+func Summarize(samples []Sample, prev *Counters, now time.Time) (Summary, Counters) {
+	var s Summary
+	healthy := map[string]float64{}
+	total := map[string]float64{}
+
+	for _, smp := range samples {
+		switch smp.Name {
+		case "envoy_listener_downstream_cx_active":
+			s.ActiveConnections += smp.Value
+		case "envoy_http_downstream_rq_total":
+			if smp.Labels["envoy_http_conn_manager_prefix"] != "admin" {
+				s.RequestsTotal += smp.Value
+			}
+		case "envoy_http_downstream_rq_xx":
+			if smp.Labels["envoy_http_conn_manager_prefix"] != "admin" && smp.Labels["envoy_response_code_class"] == "5" {
+				s.Responses5xxTotal += smp.Value
+			}
+		case "envoy_cluster_membership_healthy":
+			healthy[smp.Labels["envoy_cluster_name"]] += smp.Value
+		case "envoy_cluster_membership_total":
+			total[smp.Labels["envoy_cluster_name"]] += smp.Value
+		}
+	}
+
+	for cluster, n := range total {
+		s.UpstreamTotal += n
+		s.UpstreamHealthy += healthy[cluster]
+		if n > 0 && healthy[cluster] == 0 {
+			s.UnhealthyClusters = append(s.UnhealthyClusters, cluster)
+		}
+	}
+	sort.Strings(s.UnhealthyClusters)
+
+	cur := Counters{Requests: s.RequestsTotal, Responses5: s.Responses5xxTotal, At: now}
+	if prev != nil {
+		if dt := now.Sub(prev.At).Seconds(); dt > 0 {
+			s.RPS = rate(prev.Requests, cur.Requests, dt)
+			s.Errors5xxPerSec = rate(prev.Responses5, cur.Responses5, dt)
+		}
+	}
+	return s, cur
+}
+
+// Add accumulates o into s (host totals).
+func (s *Summary) Add(o Summary) {
+	s.ActiveConnections += o.ActiveConnections
+	s.RequestsTotal += o.RequestsTotal
+	s.Responses5xxTotal += o.Responses5xxTotal
+	s.RPS += o.RPS
+	s.Errors5xxPerSec += o.Errors5xxPerSec
+	s.UpstreamHealthy += o.UpstreamHealthy
+	s.UpstreamTotal += o.UpstreamTotal
+}
+
+func rate(prev, cur, seconds float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return (cur - prev) / seconds
+}
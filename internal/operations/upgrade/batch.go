@@ -0,0 +1,272 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// Per-service outcomes of a batch upgrade.
+const (
+	BatchUpgraded       = "upgraded"
+	BatchFailed         = "failed"
+	BatchNotAttempted   = "not_attempted"
+	BatchRolledBack     = "rolled_back"
+	BatchRollbackFailed = "rollback_failed"
+)
+
+// BatchOptions describe a host-wide upgrade.
+type BatchOptions struct {
+	FromVersion string
+	ToVersion   string
+	// Selector is a glob on the service name ("<name>-<port>"); empty matches
+	// every service on FromVersion.
+	Selector string
+	// Concurrency is how many services are upgraded at once (at least 1).
+	Concurrency int
+	// RollbackOnFailure downgrades the services already upgraded when one fails.
+	RollbackOnFailure bool
+	Graceful          bool
+}
+
+// BatchResult is the outcome for one service.
+type BatchResult struct {
+	Service string `json:"service"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Restart string `json:"restart,omitempty"`
+}
+
+var (
+	batchDefaultsMu sync.RWMutex
+	batchDefaults   = BatchOptions{Concurrency: 1}
+)
+
+// BatchName is the UPGRADE_LISTENER name (with port 0) that asks for a
+// host-wide upgrade. It may carry the batch options as a query:
+// "*?selector=web-*&concurrency=4&rollback=true".
+const BatchName = "*"
+
+// ParseBatchName returns the batch options of a BatchName request on top of
+// the defaults, and false for any other name.
+func ParseBatchName(name string) (BatchOptions, bool, error) {
+	opts := CurrentBatchDefaults()
+	head, query, _ := strings.Cut(name, "?")
+	if head != BatchName {
+		return opts, false, nil
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return opts, true, fmt.Errorf("invalid batch options %q: %w", query, err)
+	}
+	for key := range values {
+		switch key {
+		case "selector", "concurrency", "rollback":
+		default:
+			return opts, true, fmt.Errorf("unknown batch option %q", key)
+		}
+	}
+	opts.Selector = values.Get("selector")
+	if _, err := path.Match(opts.Selector, ""); err != nil {
+		return opts, true, fmt.Errorf("invalid selector %q: %w", opts.Selector, err)
+	}
+	if v := values.Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, true, fmt.Errorf("invalid concurrency %q: must be at least 1", v)
+		}
+		opts.Concurrency = n
+	}
+	if v := values.Get("rollback"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, true, fmt.Errorf("invalid rollback %q", v)
+		}
+		opts.RollbackOnFailure = b
+	}
+	return opts, true, nil
+}
+
+// SetBatchDefaults sets the Concurrency and RollbackOnFailure used for batch
+// upgrades that do not set them.
+func SetBatchDefaults(concurrency int, rollbackOnFailure bool) {
+	batchDefaultsMu.Lock()
+	defer batchDefaultsMu.Unlock()
+	batchDefaults = BatchOptions{Concurrency: max(concurrency, 1), RollbackOnFailure: rollbackOnFailure}
+}
+
+// CurrentBatchDefaults returns the options set by SetBatchDefaults; only
+// Concurrency and RollbackOnFailure are filled in.
+func CurrentBatchDefaults() BatchOptions {
+	batchDefaultsMu.RLock()
+	defer batchDefaultsMu.RUnlock()
+	return batchDefaults
+}
+
+// upgradeListener is UpgradeListener; tests replace it.
+var upgradeListener = UpgradeListener
+
+// DiscoverServices returns the envoy services whose unit runs fromVersion and
+// whose name matches selector, sorted by name.
+func DiscoverServices(fromVersion, selector string) ([]string, error) {
+	return discoverServices(models.SystemdPath, fromVersion, selector)
+}
+
+func discoverServices(dir, fromVersion, selector string) ([]string, error) {
+	if selector != "" {
+		if _, err := path.Match(selector, ""); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", selector, err)
+		}
+	}
+	units, err := filepath.Glob(filepath.Join(dir, "*.service"))
+	if err != nil {
+		return nil, err
+	}
+
+	binary := envoyBinaryPath(fromVersion)
+	var services []string
+	for _, unit := range units {
+		name := strings.TrimSuffix(filepath.Base(unit), ".service")
+		if selector != "" {
+			if ok, _ := path.Match(selector, name); !ok {
+				continue
+			}
+		}
+		content, err := os.ReadFile(unit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", unit, err)
+		}
+		if strings.Contains(string(content), binary) {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// UpgradeListeners upgrades every service matching opts, at most
+// opts.Concurrency at a time, with the configured strategy (in place or
+// blue/green). The first failure stops new upgrades; those already running
+// finish. With RollbackOnFailure the services that did upgrade are then moved
+// back to FromVersion, newest first. Every discovered service gets a result.
+func UpgradeListeners(ctx context.Context, opts BatchOptions, logger *logger.Logger, runner *cmdrunner.CommandsRunner) ([]BatchResult, error) {
+	return upgradeListeners(ctx, models.SystemdPath, opts, logger, runner)
+}
+
+func upgradeListeners(ctx context.Context, dir string, opts BatchOptions, logger *logger.Logger, runner *cmdrunner.CommandsRunner) ([]BatchResult, error) {
+	if opts.FromVersion == "" || opts.ToVersion == "" || opts.FromVersion == opts.ToVersion {
+		return nil, fmt.Errorf("batch upgrade needs two different versions, got %q -> %q", opts.FromVersion, opts.ToVersion)
+	}
+	services, err := discoverServices(dir, opts.FromVersion, opts.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, nil
+	}
+	concurrency := max(opts.Concurrency, 1)
+	logger.Infof("Upgrading %d services from %s to %s (concurrency %d)", len(services), opts.FromVersion, opts.ToVersion, concurrency)
+
+	results := make([]BatchResult, len(services))
+	for i, svc := range services {
+		results[i] = BatchResult{Service: svc, Status: BatchNotAttempted}
+	}
+
+	var (
+		failed    atomic.Bool
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		completed []int // upgraded services in completion order
+	)
+	sem := make(chan struct{}, concurrency)
+	for i, svc := range services {
+		sem <- struct{}{}
+		if failed.Load() || ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res, err := upgradeListener(ctx, svc, opts.FromVersion, opts.ToVersion, opts.Graceful, logger, runner)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				results[i].Status = BatchFailed
+				results[i].Error = err.Error()
+				failed.Store(true)
+				return
+			}
+			results[i].Status = BatchUpgraded
+			results[i].Restart = res.RestartStatus
+			completed = append(completed, i)
+		}()
+	}
+	wg.Wait()
+
+	if !failed.Load() {
+		if err := ctx.Err(); err != nil {
+			return results, fmt.Errorf("batch upgrade interrupted: %w", err)
+		}
+		return results, nil
+	}
+
+	failure := firstFailure(results)
+	if opts.RollbackOnFailure {
+		// The batch was asked to leave the host on one version; rolling back
+		// must not be skipped because the command's own context ended.
+		rbCtx := context.WithoutCancel(ctx)
+		for j := len(completed) - 1; j >= 0; j-- {
+			r := &results[completed[j]]
+			logger.Infof("Rolling %s back to %s", r.Service, opts.FromVersion)
+			if _, err := upgradeListener(rbCtx, r.Service, opts.ToVersion, opts.FromVersion, opts.Graceful, logger, runner); err != nil {
+				r.Status = BatchRollbackFailed
+				r.Error = err.Error()
+				continue
+			}
+			r.Status = BatchRolledBack
+		}
+	}
+	return results, failure
+}
+
+func firstFailure(results []BatchResult) error {
+	for _, r := range results {
+		if r.Status == BatchFailed {
+			return fmt.Errorf("upgrade of %s failed: %s", r.Service, r.Error)
+		}
+	}
+	return nil
+}
+
+// SummarizeBatch counts results per status, e.g. "2 upgraded, 1 failed".
+func SummarizeBatch(results []BatchResult) string {
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	var parts []string
+	for _, status := range []string{BatchUpgraded, BatchFailed, BatchRolledBack, BatchRollbackFailed, BatchNotAttempted} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	if len(parts) == 0 {
+		return "no matching services"
+	}
+	return strings.Join(parts, ", ")
+}
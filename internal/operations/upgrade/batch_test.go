@@ -0,0 +1,173 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+func writeUnits(t *testing.T, units map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, version := range units {
+		content := fmt.Sprintf("ExecStart=/usr/bin/elchi-client hotrestart \"%s -c x.yaml\"\n", envoyBinaryPath(version))
+		if err := os.WriteFile(filepath.Join(dir, name+".service"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "sshd.service"), []byte("ExecStart=/usr/sbin/sshd\n"), 0644)
+	return dir
+}
+
+// fakeUpgrades replaces upgradeListener, failing the services in fail, and
+// records every call as "service from->to".
+func fakeUpgrades(t *testing.T, fail map[string]bool) *[]string {
+	t.Helper()
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatalf("logger init: %v", err)
+	}
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	orig := upgradeListener
+	t.Cleanup(func() { upgradeListener = orig })
+	upgradeListener = func(_ context.Context, svc, from, to string, _ bool, _ *logger.Logger, _ *cmdrunner.CommandsRunner) (*UpgradeResult, error) {
+		mu.Lock()
+		calls = append(calls, fmt.Sprintf("%s %s->%s", svc, from, to))
+		mu.Unlock()
+		if fail[svc] && from == "v1" {
+			return nil, fmt.Errorf("boom")
+		}
+		return &UpgradeResult{RestartStatus: "restarted"}, nil
+	}
+	return &calls
+}
+
+func TestDiscoverServices(t *testing.T) {
+	dir := writeUnits(t, map[string]string{"web-80": "v1", "web-443": "v1", "api-8080": "v1", "old-81": "v0"})
+
+	got, err := discoverServices(dir, "v1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"api-8080", "web-443", "web-80"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+
+	got, _ = discoverServices(dir, "v1", "web-*")
+	if want := []string{"web-443", "web-80"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selected services = %v, want %v", got, want)
+	}
+
+	if _, err := discoverServices(dir, "v1", "[web"); err == nil {
+		t.Error("malformed selector accepted")
+	}
+}
+
+func TestUpgradeListenersStopsAndRollsBack(t *testing.T) {
+	dir := writeUnits(t, map[string]string{"a-1": "v1", "b-2": "v1", "c-3": "v1", "d-4": "v1"})
+	calls := fakeUpgrades(t, map[string]bool{"c-3": true})
+
+	results, err := upgradeListeners(context.Background(), dir, BatchOptions{
+		FromVersion: "v1", ToVersion: "v2", Concurrency: 1, RollbackOnFailure: true,
+	}, logger.NewLogger("test"), nil)
+	if err == nil {
+		t.Fatal("batch with a failing service succeeded")
+	}
+
+	want := []string{"a-1 v1->v2", "b-2 v1->v2", "c-3 v1->v2", "b-2 v2->v1", "a-1 v2->v1"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %v, want %v", *calls, want)
+	}
+	statuses := map[string]string{}
+	for _, r := range results {
+		statuses[r.Service] = r.Status
+	}
+	wantStatus := map[string]string{"a-1": BatchRolledBack, "b-2": BatchRolledBack, "c-3": BatchFailed, "d-4": BatchNotAttempted}
+	if !reflect.DeepEqual(statuses, wantStatus) {
+		t.Errorf("statuses = %v, want %v", statuses, wantStatus)
+	}
+	if got := SummarizeBatch(results); got != "1 failed, 2 rolled_back, 1 not_attempted" {
+		t.Errorf("summary = %q", got)
+	}
+}
+
+func TestUpgradeListenersWithoutRollback(t *testing.T) {
+	dir := writeUnits(t, map[string]string{"a-1": "v1", "b-2": "v1"})
+	calls := fakeUpgrades(t, map[string]bool{"b-2": true})
+
+	results, err := upgradeListeners(context.Background(), dir, BatchOptions{FromVersion: "v1", ToVersion: "v2"}, logger.NewLogger("test"), nil)
+	if err == nil {
+		t.Fatal("batch with a failing service succeeded")
+	}
+	if len(*calls) != 2 || results[0].Status != BatchUpgraded {
+		t.Errorf("calls = %v, results = %+v; upgraded services must stay upgraded", *calls, results)
+	}
+}
+
+func TestUpgradeListenersBoundsConcurrency(t *testing.T) {
+	units := map[string]string{}
+	for i := range 8 {
+		units[fmt.Sprintf("svc-%d", i)] = "v1"
+	}
+	dir := writeUnits(t, units)
+	fakeUpgrades(t, nil)
+
+	var running, peak atomic.Int32
+	inner := upgradeListener
+	upgradeListener = func(ctx context.Context, svc, from, to string, g bool, l *logger.Logger, r *cmdrunner.CommandsRunner) (*UpgradeResult, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return inner(ctx, svc, from, to, g, l, r)
+	}
+
+	results, err := upgradeListeners(context.Background(), dir, BatchOptions{FromVersion: "v1", ToVersion: "v2", Concurrency: 3}, logger.NewLogger("test"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p > 3 || p < 2 {
+		t.Errorf("peak concurrency = %d, want 2..3", p)
+	}
+	if got := SummarizeBatch(results); got != "8 upgraded" {
+		t.Errorf("summary = %q", got)
+	}
+}
+
+func TestParseBatchName(t *testing.T) {
+	t.Cleanup(func() { SetBatchDefaults(1, false) })
+	SetBatchDefaults(2, true)
+
+	if _, ok, err := ParseBatchName("web"); ok || err != nil {
+		t.Fatalf("plain name parsed as a batch: %v, %v", ok, err)
+	}
+	opts, ok, err := ParseBatchName(BatchName)
+	if !ok || err != nil || opts.Selector != "" || opts.Concurrency != 2 || !opts.RollbackOnFailure {
+		t.Fatalf("bare batch name = %+v, %v, %v", opts, ok, err)
+	}
+	opts, _, err = ParseBatchName("*?selector=web-*&concurrency=4&rollback=false")
+	if err != nil || opts.Selector != "web-*" || opts.Concurrency != 4 || opts.RollbackOnFailure {
+		t.Fatalf("batch options = %+v, %v", opts, err)
+	}
+	for _, bad := range []string{"*?concurrency=0", "*?rollback=maybe", "*?selector=[", "*?parallel=2"} {
+		if _, ok, err := ParseBatchName(bad); !ok || err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/hotrestart"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/proxy"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/stats"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/systemd"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// Blue/green defaults, used for zero fields of BlueGreenOptions.
+const (
+	DefaultSoak                = 60 * time.Second
+	DefaultCheckInterval       = 5 * time.Second
+	DefaultReadyTimeout        = 30 * time.Second
+	DefaultMax5xxRatioIncrease = 0.01
+)
+
+// min5xxRatioRequests is the number of soak requests below which the 5xx ratio
+// is not judged: two errors out of three requests says nothing about a build.
+const min5xxRatioRequests = 20
+
+// BlueGreenOptions configure blue/green upgrades.
+type BlueGreenOptions struct {
+	// Soak is how long the new epoch must stay healthy before the upgrade is
+	// kept.
+	Soak time.Duration
+	// CheckInterval is the time between health checks during the soak.
+	CheckInterval time.Duration
+	// ReadyTimeout bounds how long the new epoch may take to start and answer
+	// /ready.
+	ReadyTimeout time.Duration
+	// Max5xxRatioIncrease is how far the share of 5xx responses during the
+	// soak may exceed the old epoch's share.
+	Max5xxRatioIncrease float64
+	// Rules are extra stats thresholds checked on every soak tick.
+	Rules []stats.Rule
+}
+
+var (
+	blueGreenMu     sync.RWMutex
+	blueGreen       = withDefaults(BlueGreenOptions{})
+	blueGreenAlways bool
+)
+
+// SetBlueGreen sets the blue/green options. With always every UpgradeListener
+// call upgrades blue/green (upgrade.strategy: blue_green); otherwise only the
+// requests that ask for a graceful upgrade do. Zero durations take the
+// defaults.
+func SetBlueGreen(opts BlueGreenOptions, always bool) {
+	blueGreenMu.Lock()
+	defer blueGreenMu.Unlock()
+	blueGreen = withDefaults(opts)
+	blueGreenAlways = always
+}
+
+// CurrentBlueGreen returns the blue/green options and whether every upgrade
+// uses them.
+func CurrentBlueGreen() (BlueGreenOptions, bool) {
+	blueGreenMu.RLock()
+	defer blueGreenMu.RUnlock()
+	return blueGreen, blueGreenAlways
+}
+
+func withDefaults(o BlueGreenOptions) BlueGreenOptions {
+	if o.Soak <= 0 {
+		o.Soak = DefaultSoak
+	}
+	if o.CheckInterval <= 0 {
+		o.CheckInterval = DefaultCheckInterval
+	}
+	if o.ReadyTimeout <= 0 {
+		o.ReadyTimeout = DefaultReadyTimeout
+	}
+	return o
+}
+
+// errNeedsNativeSupervisor is returned, before anything changes, for units
+// still running under hotrestarter.py.
+var errNeedsNativeSupervisor = errors.New("blue/green upgrades need the native hot restarter (client.hot_restarter: native)")
+
+var baseIDPattern = regexp.MustCompile(`--base-id[ =](\d+)`)
+
+// upgradeBlueGreen brings toVersion up as a new hot-restart epoch next to the
+// running one instead of restarting the unit:
+//
+//  1. the target binary validates the rewritten bootstrap and must report the
+//     same --hot-restart-version as the running binary;
+//  2. the unit and bootstrap are rewritten (so a later restart keeps the new
+//     version) and the new binary is staged for the supervisor's next epoch,
+//     with --drain-time-s and --parent-shutdown-time-s stretched past the
+//     soak (see soakCommand);
+//  3. `systemctl reload` starts the epoch; envoy hands the listeners over, so
+//     new connections go to the new epoch, while the old epoch keeps serving
+//     its established connections and stays alive for the whole soak;
+//  4. the new epoch must answer /ready within ReadyTimeout and then stay ready,
+//     within the 5xx budget and inside every rule for the Soak window, and must
+//     not leave a cluster without healthy hosts that had them before;
+//  5. only then is the unit's own command (and drain times) staged for the
+//     next reload.
+//
+// Envoy cannot hand the listeners back to a parent epoch, so a failure
+// restores the files and hot-restarts the old binary in as a further epoch;
+// the listeners are not dropped either way.
+func upgradeBlueGreen(
+	ctx context.Context,
+	serviceName string,
+	fromVersion string,
+	toVersion string,
+	opts BlueGreenOptions,
+	logger *logger.Logger,
+	runner *cmdrunner.CommandsRunner,
+) (*UpgradeResult, error) {
+	serviceFile := serviceName + ".service"
+	systemdPath := filepath.Join(models.SystemdPath, serviceFile)
+	bootstrapPath := filepath.Join(models.ElchiLibPath, "bootstraps", serviceName+".yaml")
+	targetBinary := envoyBinaryPath(toVersion)
+
+	origService, err := os.ReadFile(systemdPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read systemd service file: %w", err)
+	}
+	origBootstrap, err := os.ReadFile(bootstrapPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap file: %w", err)
+	}
+
+	// The Python wrapper always re-executes the command it was started with,
+	// so only the native supervisor can run a different binary as a new epoch.
+	if !strings.Contains(string(origService), " hotrestart ") {
+		return nil, fmt.Errorf("%s still runs hotrestarter.py: %w", serviceName, errNeedsNativeSupervisor)
+	}
+	m := baseIDPattern.FindStringSubmatch(string(origService))
+	if m == nil {
+		return nil, fmt.Errorf("no --base-id in %s", systemdPath)
+	}
+	baseID := m[1]
+	port, err := strconv.ParseUint(baseID, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --base-id %q in %s: %w", baseID, systemdPath, err)
+	}
+
+	status, err := hotrestart.ReadStatus(baseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hot-restart status of %s: %w", serviceName, err)
+	}
+	if status.State != hotrestart.StateRunning || len(status.Children) == 0 {
+		return nil, fmt.Errorf("%s is not running (supervisor state %q)", serviceName, status.State)
+	}
+	oldCommand := status.Command
+	newCommand, err := commandForVersion(oldCommand, fromVersion, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkHotRestartCompatible(ctx, oldCommand[0], targetBinary, runner); err != nil {
+		return nil, err
+	}
+	newBootstrap := bootstrapForVersion(string(origBootstrap), fromVersion, toVersion)
+	if err := validateBootstrap(ctx, targetBinary, bootstrapPath, newBootstrap, runner); err != nil {
+		return nil, err
+	}
+
+	baselineSamples, err := scrapeSamples(ctx, uint32(port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats of the running epoch: %w", err)
+	}
+	baseline, _ := stats.Summarize(baselineSamples, nil, time.Now())
+
+	// revert puts the old binary back. Like the in-place rollback it runs on a
+	// fresh context so a cancelled command cannot leave the new build serving.
+	revert := func(reason error) error {
+		logger.Errorf("Blue/green upgrade of %s failed (%v); reverting to version %s", serviceName, reason, fromVersion)
+		rbCtx, cancel := context.WithTimeout(context.Background(), opts.ReadyTimeout+60*time.Second)
+		defer cancel()
+
+		if werr := os.WriteFile(systemdPath, origService, 0644); werr != nil {
+			logger.Errorf("revert: failed to restore systemd unit %s: %v", systemdPath, werr)
+		}
+		if werr := os.WriteFile(bootstrapPath, origBootstrap, 0644); werr != nil {
+			logger.Errorf("revert: failed to restore bootstrap %s: %v", bootstrapPath, werr)
+		}
+		if rerr := ReloadSystemdDaemon(rbCtx, runner, logger); rerr != nil {
+			logger.Errorf("revert: daemon-reload failed: %v", rerr)
+		}
+		os.Remove(hotrestart.NextCommandPath(baseID))
+
+		if st, serr := hotrestart.ReadStatus(baseID); serr == nil && st.State == hotrestart.StateRunning {
+			rerr := hotRestartTo(rbCtx, serviceFile, baseID, oldCommand, st.CurrentEpoch, opts.ReadyTimeout, runner)
+			if rerr == nil {
+				logger.Infof("Hot-restarted %s back onto version %s", serviceName, fromVersion)
+				return reason
+			}
+			logger.Errorf("revert: hot restart onto %s failed: %v; restarting the unit", fromVersion, rerr)
+		}
+		if _, rerr := systemd.ServiceControl(rbCtx, serviceFile, client.SubCommandType_SUB_RESTART, logger, runner); rerr != nil {
+			logger.Errorf("revert: restart onto old version failed: %v", rerr)
+		}
+		return reason
+	}
+
+	result := &UpgradeResult{}
+	if err := os.WriteFile(systemdPath, []byte(replaceEnvoyVersionPath(string(origService), fromVersion, toVersion)), 0644); err != nil {
+		return nil, revert(fmt.Errorf("failed to write updated systemd service file: %w", err))
+	}
+	result.SystemdServiceUpdated = systemdPath
+	if err := os.WriteFile(bootstrapPath, []byte(newBootstrap), 0644); err != nil {
+		return nil, revert(fmt.Errorf("failed to write updated bootstrap file: %w", err))
+	}
+	result.BootstrapFileUpdated = bootstrapPath
+	if err := ReloadSystemdDaemon(ctx, runner, logger); err != nil {
+		return nil, revert(err)
+	}
+
+	logger.Infof("Starting %s as a new epoch of %s", toVersion, serviceName)
+	if err := hotRestartTo(ctx, serviceFile, baseID, soakCommand(newCommand, opts), status.CurrentEpoch, opts.ReadyTimeout, runner); err != nil {
+		return nil, revert(err)
+	}
+	epoch := status.CurrentEpoch + 1
+	if st, err := hotrestart.ReadStatus(baseID); err == nil {
+		epoch = st.CurrentEpoch
+	}
+
+	logger.Infof("Epoch %d of %s is ready; soaking for %s", epoch, serviceName, opts.Soak)
+	if err := soak(ctx, uint32(port), baseID, epoch, baseline, opts); err != nil {
+		return nil, revert(err)
+	}
+
+	// The soak drain times would otherwise stay with every later epoch.
+	if err := hotrestart.StageNextCommand(baseID, newCommand); err != nil {
+		logger.Warnf("Failed to stage the unit's command for the next reload of %s: %v", serviceName, err)
+	}
+
+	result.RestartStatus = fmt.Sprintf("hot-restarted to epoch %d and healthy for %s", epoch, opts.Soak)
+	result.ServiceActive = true
+	return result, nil
+}
+
+// soakCommand returns command with drain times that keep the old epoch alive
+// until the soak is over: its established connections drain gradually over
+// ReadyTimeout plus Soak instead of the unit's few seconds, and it is shut
+// down only after that.
+func soakCommand(command []string, opts BlueGreenOptions) []string {
+	drain := int((opts.ReadyTimeout + opts.Soak + time.Second - 1) / time.Second)
+	out := setFlag(command, "--drain-time-s", strconv.Itoa(drain))
+	return setFlag(out, "--parent-shutdown-time-s", strconv.Itoa(drain+int(opts.CheckInterval/time.Second)+10))
+}
+
+// setFlag returns args with flag set to value, replacing "flag value" or
+// "flag=value" or appending it.
+func setFlag(args []string, flag, value string) []string {
+	out := make([]string, 0, len(args)+2)
+	set := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == flag && i+1 < len(args):
+			out = append(out, flag, value)
+			set = true
+			i++
+		case strings.HasPrefix(args[i], flag+"="):
+			out = append(out, flag+"="+value)
+			set = true
+		default:
+			out = append(out, args[i])
+		}
+	}
+	if !set {
+		out = append(out, flag, value)
+	}
+	return out
+}
+
+// hotRestartTo stages command for the supervisor, triggers a reload and waits
+// until an epoch newer than afterEpoch runs it and answers /ready.
+func hotRestartTo(ctx context.Context, serviceFile, baseID string, command []string, afterEpoch int, timeout time.Duration, runner *cmdrunner.CommandsRunner) error {
+	if err := hotrestart.StageNextCommand(baseID, command); err != nil {
+		return fmt.Errorf("failed to stage the next epoch: %w", err)
+	}
+	if err := runner.RunWithS(ctx, "systemctl", "reload", serviceFile); err != nil {
+		return fmt.Errorf("failed to reload %s: %w", serviceFile, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	port, _ := strconv.ParseUint(baseID, 10, 32)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	started := false
+	for {
+		st, err := hotrestart.ReadStatus(baseID)
+		switch {
+		case err != nil:
+		case st.State != hotrestart.StateRunning:
+			return fmt.Errorf("hot-restart supervisor of %s stopped", serviceFile)
+		case st.CurrentEpoch > afterEpoch && slices.Equal(st.Command, command) && epochAlive(st, st.CurrentEpoch):
+			started = true
+			if adminReady(ctx, uint32(port)) == nil {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if started {
+				return fmt.Errorf("new epoch of %s did not become ready within %s", serviceFile, timeout)
+			}
+			return fmt.Errorf("new epoch of %s did not start within %s", serviceFile, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// soak watches the new epoch for opts.Soak and returns the first problem.
+func soak(ctx context.Context, port uint32, baseID string, epoch int, baseline stats.Summary, opts BlueGreenOptions) error {
+	startSamples, err := scrapeSamples(ctx, port)
+	if err != nil {
+		return fmt.Errorf("failed to read stats of the new epoch: %w", err)
+	}
+	start, _ := stats.Summarize(startSamples, nil, time.Now())
+
+	deadline := time.NewTimer(opts.Soak)
+	defer deadline.Stop()
+	ticker := time.NewTicker(opts.CheckInterval)
+	defer ticker.Stop()
+
+	var cur stats.Summary
+	check := func() error {
+		st, err := hotrestart.ReadStatus(baseID)
+		if err != nil {
+			return fmt.Errorf("failed to read hot-restart status: %w", err)
+		}
+		if st.State != hotrestart.StateRunning || !epochAlive(st, epoch) {
+			return fmt.Errorf("epoch %d exited during the soak", epoch)
+		}
+		if err := adminReady(ctx, port); err != nil {
+			return err
+		}
+		samples, err := scrapeSamples(ctx, port)
+		if err != nil {
+			return fmt.Errorf("failed to read stats: %w", err)
+		}
+		for _, r := range opts.Rules {
+			if err := r.Check(samples); err != nil {
+				return fmt.Errorf("stats rule failed: %w", err)
+			}
+		}
+		cur, _ = stats.Summarize(samples, nil, time.Now())
+		return check5xxRatio(baseline, start, cur, opts.Max5xxRatioIncrease)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := check(); err != nil {
+				return err
+			}
+		case <-deadline.C:
+			if err := check(); err != nil {
+				return err
+			}
+			// Active health checks start over in a new epoch, so upstream health
+			// is only compared once the soak has given them time to converge.
+			if lost := newlyUnhealthy(baseline, cur); len(lost) > 0 {
+				return fmt.Errorf("clusters without healthy hosts after the upgrade: %s", strings.Join(lost, ", "))
+			}
+			return nil
+		}
+	}
+}
+
+// commandForVersion rewrites the binary paths of the supervisor's command line
+// to toVersion. The running command must actually use fromVersion: otherwise
+// the request does not describe this deployment.
+func commandForVersion(command []string, fromVersion, toVersion string) ([]string, error) {
+	if len(command) == 0 || command[0] != envoyBinaryPath(fromVersion) {
+		return nil, fmt.Errorf("running envoy command %v is not version %s", command, fromVersion)
+	}
+	out := make([]string, len(command))
+	for i, arg := range command {
+		out[i] = replaceEnvoyVersionPath(arg, fromVersion, toVersion)
+	}
+	return out, nil
+}
+
+// checkHotRestartCompatible refuses binaries that cannot hot restart into each
+// other: envoy would fail to attach to the old epoch's shared memory and the
+// supervisor would take the whole group down.
+func checkHotRestartCompatible(ctx context.Context, oldBinary, newBinary string, runner *cmdrunner.CommandsRunner) error {
+	oldVersion, err := runner.RunAndTrimmedOutput(ctx, oldBinary, "--hot-restart-version")
+	if err != nil {
+		return fmt.Errorf("failed to read hot-restart version of %s: %w", oldBinary, err)
+	}
+	newVersion, err := runner.RunAndTrimmedOutput(ctx, newBinary, "--hot-restart-version")
+	if err != nil {
+		return fmt.Errorf("failed to read hot-restart version of %s: %w", newBinary, err)
+	}
+	if oldVersion != newVersion {
+		return fmt.Errorf("hot-restart version %q of %s differs from %q of the running binary; use an in-place upgrade", newVersion, newBinary, oldVersion)
+	}
+	return nil
+}
+
+// validateBootstrap runs the target binary in validate mode against the
+// bootstrap it will be started with, before anything on disk changes.
+func validateBootstrap(ctx context.Context, binary, bootstrapPath, content string, runner *cmdrunner.CommandsRunner) error {
+	tmp, err := os.CreateTemp(filepath.Dir(bootstrapPath), ".validate-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to stage bootstrap for validation: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to stage bootstrap for validation: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to stage bootstrap for validation: %w", err)
+	}
+	if _, err := runner.RunWithOutput(ctx, binary, "-c", tmp.Name(), "--mode", "validate"); err != nil {
+		return fmt.Errorf("bootstrap does not validate with %s: %w", binary, err)
+	}
+	return nil
+}
+
+// check5xxRatio fails when the share of 5xx responses among the requests served
+// since start is more than maxIncrease above the share before the upgrade.
+func check5xxRatio(baseline, start, cur stats.Summary, maxIncrease float64) error {
+	requests := cur.RequestsTotal - start.RequestsTotal
+	errors5xx := cur.Responses5xxTotal - start.Responses5xxTotal
+	if requests < 0 || errors5xx < 0 {
+		// Counters were reset; everything counted is from the new epoch.
+		requests, errors5xx = cur.RequestsTotal, cur.Responses5xxTotal
+	}
+	if requests < min5xxRatioRequests {
+		return nil
+	}
+	before := 0.0
+	if baseline.RequestsTotal > 0 {
+		before = baseline.Responses5xxTotal / baseline.RequestsTotal
+	}
+	if got := errors5xx / requests; got > before+maxIncrease {
+		return fmt.Errorf("5xx ratio %.4f since cutover exceeds %.4f before the upgrade by more than %.4f", got, before, maxIncrease)
+	}
+	return nil
+}
+
+// newlyUnhealthy returns the clusters with no healthy host in cur that had one
+// in baseline.
+func newlyUnhealthy(baseline, cur stats.Summary) []string {
+	var out []string
+	for _, c := range cur.UnhealthyClusters {
+		if !slices.Contains(baseline.UnhealthyClusters, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func epochAlive(st *hotrestart.Status, epoch int) bool {
+	for _, c := range st.Children {
+		if c.Epoch == epoch {
+			return true
+		}
+	}
+	return false
+}
+
+func adminReady(ctx context.Context, port uint32) error {
+	resp, err := proxy.Do(ctx, &client.RequestEnvoyAdmin{Port: port, Method: client.HttpMethod_GET, Path: "/ready"})
+	if err != nil {
+		return fmt.Errorf("admin /ready failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin /ready returned %d: %s", resp.StatusCode, strings.TrimSpace(resp.Body))
+	}
+	return nil
+}
+
+func scrapeSamples(ctx context.Context, port uint32) ([]stats.Sample, error) {
+	resp, err := proxy.Do(ctx, &client.RequestEnvoyAdmin{Port: port, Method: client.HttpMethod_GET, Path: "/stats/prometheus"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return stats.ParseSamples([]byte(resp.Body)), nil
+}
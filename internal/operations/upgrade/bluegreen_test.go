@@ -0,0 +1,97 @@
+package upgrade
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/stats"
+)
+
+func TestCommandForVersion(t *testing.T) {
+	cmd := []string{"/var/lib/elchi/envoys/v1.34.0/envoy", "-c", "/var/lib/elchi/bootstraps/web-80.yaml", "--base-id", "80"}
+	got, err := commandForVersion(cmd, "v1.34.0", "v1.35.0")
+	if err != nil {
+		t.Fatalf("commandForVersion: %v", err)
+	}
+	want := []string{"/var/lib/elchi/envoys/v1.35.0/envoy", "-c", "/var/lib/elchi/bootstraps/web-80.yaml", "--base-id", "80"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %v, want %v", got, want)
+	}
+	if cmd[0] != "/var/lib/elchi/envoys/v1.34.0/envoy" {
+		t.Error("input command was modified")
+	}
+
+	if _, err := commandForVersion(cmd, "v1.33.0", "v1.35.0"); err == nil {
+		t.Error("a command running another version was accepted")
+	}
+}
+
+func TestBootstrapForVersion(t *testing.T) {
+	in := "node:\n  metadata:\n    envoy-version:\n      value: v1.34.0\n"
+	if got := bootstrapForVersion(in, "v1.34.0", "v1.35.0"); got != "node:\n  metadata:\n    envoy-version:\n      value: v1.35.0\n" {
+		t.Errorf("bootstrap = %q", got)
+	}
+}
+
+func TestCheck5xxRatio(t *testing.T) {
+	baseline := stats.Summary{RequestsTotal: 10000, Responses5xxTotal: 100} // 1%
+	start := stats.Summary{RequestsTotal: 10000, Responses5xxTotal: 100}
+
+	tests := []struct {
+		name    string
+		cur     stats.Summary
+		wantErr bool
+	}{
+		{"same ratio", stats.Summary{RequestsTotal: 11000, Responses5xxTotal: 110}, false},
+		{"within budget", stats.Summary{RequestsTotal: 11000, Responses5xxTotal: 119}, false},
+		{"above budget", stats.Summary{RequestsTotal: 11000, Responses5xxTotal: 150}, true},
+		{"too few requests to judge", stats.Summary{RequestsTotal: 10005, Responses5xxTotal: 105}, false},
+		{"counter reset", stats.Summary{RequestsTotal: 100, Responses5xxTotal: 50}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := check5xxRatio(baseline, start, tt.cur, 0.01)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check5xxRatio err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewlyUnhealthy(t *testing.T) {
+	baseline := stats.Summary{UnhealthyClusters: []string{"legacy"}}
+	cur := stats.Summary{UnhealthyClusters: []string{"api", "legacy"}}
+	if got := newlyUnhealthy(baseline, cur); !reflect.DeepEqual(got, []string{"api"}) {
+		t.Errorf("newlyUnhealthy = %v, want [api]", got)
+	}
+}
+
+func TestSetBlueGreenDefaults(t *testing.T) {
+	t.Cleanup(func() { SetBlueGreen(BlueGreenOptions{}, false) })
+
+	SetBlueGreen(BlueGreenOptions{}, true)
+	got, always := CurrentBlueGreen()
+	if !always || got.Soak != DefaultSoak || got.CheckInterval != DefaultCheckInterval || got.ReadyTimeout != DefaultReadyTimeout {
+		t.Fatalf("options = %+v, %v, want defaults filled in", got, always)
+	}
+	SetBlueGreen(BlueGreenOptions{Soak: time.Minute}, false)
+	if got, always := CurrentBlueGreen(); always || got.Soak != time.Minute {
+		t.Errorf("options = %+v, %v, want graceful-only blue/green", got, always)
+	}
+}
+
+func TestSoakCommand(t *testing.T) {
+	command := []string{"/var/lib/elchi/envoys/v1.30.0/envoy", "-c", "web-80.yaml", "--drain-time-s", "10", "--parent-shutdown-time-s=20"}
+	got := soakCommand(command, BlueGreenOptions{Soak: time.Minute, ReadyTimeout: 30 * time.Second, CheckInterval: 5 * time.Second})
+	want := []string{"/var/lib/elchi/envoys/v1.30.0/envoy", "-c", "web-80.yaml", "--drain-time-s", "90", "--parent-shutdown-time-s=105"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("soakCommand = %v, want %v", got, want)
+	}
+	if command[4] != "10" {
+		t.Error("soakCommand modified the running command")
+	}
+	if got := soakCommand(command[:3], BlueGreenOptions{Soak: time.Second}); len(got) != 7 || got[3] != "--drain-time-s" || got[5] != "--parent-shutdown-time-s" {
+		t.Errorf("flags not appended: %v", got)
+	}
+}
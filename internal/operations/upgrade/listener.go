@@ -2,6 +2,7 @@ package upgrade
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -26,6 +27,13 @@ func replaceEnvoyVersionPath(content, fromVersion, toVersion string) string {
 	return strings.ReplaceAll(content, envoyBinaryPath(fromVersion), envoyBinaryPath(toVersion))
 }
 
+// bootstrapForVersion rewrites the envoy binary paths and the envoy-version
+// metadata of a bootstrap from fromVersion to toVersion.
+func bootstrapForVersion(content, fromVersion, toVersion string) string {
+	content = replaceEnvoyVersionPath(content, fromVersion, toVersion)
+	return strings.ReplaceAll(content, fmt.Sprintf("value: %s", fromVersion), fmt.Sprintf("value: %s", toVersion))
+}
+
 // UpgradeResult contains the result of an upgrade operation
 type UpgradeResult struct {
 	SystemdServiceUpdated string
@@ -77,13 +85,7 @@ func UpdateBootstrapVersion(serviceName, fromVersion, toVersion string, logger *
 		return "", fmt.Errorf("failed to read bootstrap file: %w", err)
 	}
 
-	// Update version paths in bootstrap file
-	updatedContent := replaceEnvoyVersionPath(string(currentContent), fromVersion, toVersion)
-
-	// Update envoy-version metadata
-	oldVersionMetadata := fmt.Sprintf("value: %s", fromVersion)
-	newVersionMetadata := fmt.Sprintf("value: %s", toVersion)
-	updatedContent = strings.ReplaceAll(updatedContent, oldVersionMetadata, newVersionMetadata)
+	updatedContent := bootstrapForVersion(string(currentContent), fromVersion, toVersion)
 
 	// Write updated bootstrap file
 	if err := os.WriteFile(bootstrapPath, []byte(updatedContent), 0644); err != nil {
@@ -105,9 +107,10 @@ func ReloadSystemdDaemon(ctx context.Context, runner *cmdrunner.CommandsRunner,
 
 // RestartService restarts the service so the new binary takes over.
 //
-// A binary upgrade ALWAYS requires a full restart — there is no in-process
-// "graceful"/hot path here (the new binary cannot take over the running
-// process). The graceful flag is kept for API compatibility, but we no longer
+// An in-place binary upgrade ALWAYS requires a full restart — there is no
+// in-process "graceful"/hot path here (the new binary cannot take over the
+// running process; blue/green upgrades, see upgradeBlueGreen, start it as a new
+// hot-restart epoch instead, and graceful requests go there). We no longer
 // report a "graceful restart" that never happened: the previous code ran an
 // identical SUB_RESTART in both branches yet told the control plane "graceful
 // restart completed", masking the connection drop.
@@ -162,6 +165,21 @@ func UpgradeListener(
 		return nil, fmt.Errorf("target envoy binary for version %s not found at %s: %w", toVersion, targetBinary, err)
 	}
 
+	// A graceful request picks blue/green for this upgrade; with
+	// upgrade.strategy: blue_green every upgrade is. A graceful request for a
+	// unit that cannot hot restart onto another binary is restarted in place,
+	// as before blue/green existed, and says so in RestartStatus.
+	opts, always := CurrentBlueGreen()
+	fallback := ""
+	if always || graceful {
+		result, err := upgradeBlueGreen(ctx, serviceName, fromVersion, toVersion, opts, logger, runner)
+		if always || !errors.Is(err, errNeedsNativeSupervisor) {
+			return result, err
+		}
+		logger.Warnf("Graceful upgrade of %s falls back to a restart: %v", serviceName, err)
+		fallback = fmt.Sprintf(" (graceful upgrade unavailable: %v)", err)
+	}
+
 	// Capture the current unit + bootstrap bytes so we can roll back if any later
 	// step fails. Without this, a failed daemon-reload/restart/verify left the
 	// unit and bootstrap pointing at the (possibly broken) new version.
@@ -238,7 +256,7 @@ func UpgradeListener(
 	if err != nil {
 		return nil, rollback(err)
 	}
-	result.RestartStatus = restartStatus
+	result.RestartStatus = restartStatus + fallback
 
 	// 6. Verify service is active
 	if err := VerifyServiceActive(ctx, serviceName, runner); err != nil {
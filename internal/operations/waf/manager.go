@@ -38,8 +38,46 @@ func (m *Manager) GetDownloadedVersions() ([]string, error) {
 	return common.GetDownloadedVersions(DefaultBaseDir, "coraza.wasm", m.logger)
 }
 
-// SetVersion downloads and installs a specific WAF version
+// PruneUnused deletes downloaded WAF versions that are not in inUse and not
+// retained by policy. The caller owns the in-use set: only it knows which
+// units, bootstraps and running processes reference a version.
+func (m *Manager) PruneUnused(inUse map[string]bool, policy common.GCPolicy, dryRun bool) (*common.GCResult, error) {
+	versions, err := m.GetDownloadedVersions()
+	if err != nil {
+		return nil, err
+	}
+	return common.PruneVersions(DefaultBaseDir, versions, inUse, policy, dryRun, m.logger)
+}
+
+// InstallableVersions returns the WAF versions the configured artifact index
+// offers for this host's architecture
+func (m *Manager) InstallableVersions(ctx context.Context) ([]string, error) {
+	return m.downloader.InstallableVersions(ctx)
+}
+
+// IsInstalled reports whether a WAF version is already present locally
+func (m *Manager) IsInstalled(version string) bool {
+	_, err := os.Stat(filepath.Join(DefaultBaseDir, version, "coraza.wasm"))
+	return err == nil
+}
+
+// installs shares one install per version between concurrent SetVersion
+// calls. It is package level because managers are created per request.
+var installs common.FlightGroup
+
+// SetVersion downloads and installs a specific WAF version. Concurrent calls for the same
+// version share a single download.
 func (m *Manager) SetVersion(ctx context.Context, version string, forceDownload bool) (string, error) {
+	path, err, shared := installs.Do(version, func() (string, error) {
+		return m.setVersion(ctx, version, forceDownload)
+	})
+	if shared {
+		m.logger.WithField("version", version).Info("Joined in-flight install of the same version")
+	}
+	return path, err
+}
+
+func (m *Manager) setVersion(ctx context.Context, version string, forceDownload bool) (string, error) {
 	m.logger.WithFields(logrus.Fields{
 		"version": version,
 		"force":   forceDownload,
@@ -10,7 +10,6 @@ type ArchiveResponse struct {
 // Constants
 const (
 	DefaultBaseDir  = "/var/lib/elchi/waf"
-	ArchiveURL      = "https://archive.elchi.io/index.json"
 	DefaultArch     = "wasm-amd64"
 	DownloadTimeout = 300 // 5 minutes
 )
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/envoy"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/waf"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+// maxImportBytes caps the unpacked size of an offline artifact bundle.
+const maxImportBytes = 4 << 30 // 4 GiB
+
+// ImportedArtifact is one version handled by ImportArtifacts.
+type ImportedArtifact struct {
+	Component string `json:"component"` // "envoy" or "waf"
+	Version   string `json:"version"`
+	Path      string `json:"path,omitempty"`
+	Skipped   bool   `json:"skipped,omitempty"` // already installed, not forced
+	Error     string `json:"error,omitempty"`
+}
+
+// versionInstaller is the part of the envoy and waf managers an import needs.
+type versionInstaller interface {
+	InstallableVersions(ctx context.Context) ([]string, error)
+	IsInstalled(version string) bool
+	SetVersion(ctx context.Context, version string, forceDownload bool) (string, error)
+}
+
+// ImportArtifacts installs the envoy and WAF versions contained in an offline
+// bundle: a tar or tar.gz with an index.json at its root (same schema as the
+// public archive index) whose download_url entries are paths relative to it.
+// Detached signatures sit next to each binary as "<path>.sig".
+//
+// The bundle is unpacked and temporarily used as a file:// artifact source, so
+// the regular SetVersion path does the install: checksum and signature checks,
+// permissions and the usual version directories. A later SetVersion for the
+// same version then succeeds without network access.
+func ImportArtifacts(ctx context.Context, tarball string, force bool, log *logger.Logger) ([]ImportedArtifact, error) {
+	dir, err := os.MkdirTemp("", "elchi-artifacts-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := common.ExtractTarball(tarball, dir, maxImportBytes); err != nil {
+		return nil, fmt.Errorf("failed to unpack %s: %w", tarball, err)
+	}
+	indexPath := filepath.Join(dir, "index.json")
+	if _, err := os.Stat(indexPath); err != nil {
+		return nil, fmt.Errorf("bundle has no index.json at its root")
+	}
+
+	source, err := common.NewArtifactSource("file://"+indexPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	prev := common.CurrentArtifactSource()
+	common.SetArtifactSource(source)
+	defer common.SetArtifactSource(prev)
+
+	var results []ImportedArtifact
+	var errs []string
+	for _, c := range []struct {
+		name      string
+		installer versionInstaller
+	}{
+		{"envoy", envoy.NewManager()},
+		{"waf", waf.NewManager()},
+	} {
+		versions, err := c.installer.InstallableVersions(ctx)
+		if err != nil {
+			return results, fmt.Errorf("failed to read bundle index: %w", err)
+		}
+		for _, v := range versions {
+			res := ImportedArtifact{Component: c.name, Version: v}
+			if !force && c.installer.IsInstalled(v) {
+				res.Skipped = true
+				results = append(results, res)
+				continue
+			}
+			path, err := c.installer.SetVersion(ctx, v, true)
+			if err != nil {
+				res.Error = err.Error()
+				errs = append(errs, fmt.Sprintf("%s %s: %v", c.name, v, err))
+			} else {
+				res.Path = path
+			}
+			results = append(results, res)
+		}
+	}
+
+	log.WithFields(logger.Fields{
+		"bundle":  tarball,
+		"handled": len(results),
+		"failed":  len(errs),
+	}).Info("Artifact import completed")
+
+	if len(errs) > 0 {
+		return results, fmt.Errorf("artifact import: %s", strings.Join(errs, "; "))
+	}
+	return results, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/configdump"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/files"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/systemd"
 	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
@@ -18,7 +19,9 @@ func (s *Services) UpdateBootstrapService(ctx context.Context, cmd *client.Comma
 
 	fileName := fmt.Sprintf("%s-%d", bootstrapReq.GetName(), bootstrapReq.GetPort())
 
-	_, err := files.WriteBootstrapFile(fileName, bootstrapReq.GetBootstrap())
+	rec := configdump.Begin(ctx, fileName, bootstrapReq.GetPort(), configdump.SourceUpdateBootstrap)
+
+	bootstrapPath, err := files.WriteBootstrapFile(fileName, bootstrapReq.GetBootstrap())
 	if err != nil {
 		return helper.NewErrorResponse(cmd, err.Error())
 	}
@@ -28,6 +31,8 @@ func (s *Services) UpdateBootstrapService(ctx context.Context, cmd *client.Comma
 		return helper.NewErrorResponse(cmd, err.Error())
 	}
 
+	recordConfigChange(ctx, rec, bootstrapPath, s.logger)
+
 	return &client.CommandResponse{
 		Identity:  cmd.Identity,
 		CommandId: cmd.CommandId,
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/certs"
+	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+// certRemindInterval is how often a certificate that stays at warning or
+// critical is reported again.
+const certRemindInterval = 24 * time.Hour
+
+// CertWatchOptions tune the certificate expiry scanner.
+type CertWatchOptions struct {
+	Interval   time.Duration
+	Thresholds certs.Thresholds
+	Sources    certs.Sources
+}
+
+// CertWatcher periodically scans every certificate the host uses and raises
+// an event when one nears expiry.
+type CertWatcher struct {
+	logger *logger.Logger
+	opts   CertWatchOptions
+
+	// reported is the last level reported per certificate (path and
+	// fingerprint, so a rotation counts as a new certificate) and when.
+	reported map[string]certReport
+}
+
+type certReport struct {
+	level string
+	at    time.Time
+}
+
+var (
+	latestCertsMu sync.RWMutex
+	latestCerts   *certs.Report
+)
+
+// NewCertWatcher builds an idle watcher; Start runs it.
+func NewCertWatcher(log *logger.Logger, opts CertWatchOptions) *CertWatcher {
+	return &CertWatcher{logger: log, opts: opts, reported: make(map[string]certReport)}
+}
+
+// Start scans every opts.Interval until ctx is cancelled.
+func (w *CertWatcher) Start(ctx context.Context) {
+	defer helper.RecoverPanic(w.logger, "cert-watcher")
+
+	w.logger.Infof("Certificate expiry scanner started (warning at %d days, critical at %d)",
+		w.opts.Thresholds.WarningDays, w.opts.Thresholds.CriticalDays)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		w.scanOnce(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *CertWatcher) scanOnce(ctx context.Context, now time.Time) *certs.Report {
+	report := certs.Scan(ctx, w.opts.Sources, w.opts.Thresholds, now)
+	latestCertsMu.Lock()
+	latestCerts = &report
+	latestCertsMu.Unlock()
+
+	seen := make(map[string]bool, len(report.Certificates))
+	for _, c := range report.Certificates {
+		key := c.Path + "|" + c.SHA256
+		seen[key] = true
+		last, ok := w.reported[key]
+		if c.Level == certs.LevelOK {
+			if ok && last.level != certs.LevelOK {
+				w.logger.Infof("Certificate %s (%s) is no longer near expiry", c.Path, c.Source)
+			}
+			w.reported[key] = certReport{level: c.Level, at: now}
+			continue
+		}
+		if ok && last.level == c.Level && now.Sub(last.at) < certRemindInterval {
+			continue
+		}
+		w.report(c)
+		w.reported[key] = certReport{level: c.Level, at: now}
+	}
+	for key := range w.reported {
+		if !seen[key] {
+			delete(w.reported, key)
+		}
+	}
+	return &report
+}
+
+// report emits the structured expiry event; the fields are what the log
+// pipeline indexes on.
+func (w *CertWatcher) report(c certs.Checked) {
+	fields := logger.Fields{
+		"event":  "certificate_expiry",
+		"level":  c.Level,
+		"path":   c.Path,
+		"source": c.Source,
+	}
+	if c.Level == certs.LevelError {
+		fields["error"] = c.Error
+		w.logger.WithFields(fields).Warn(fmt.Sprintf("Certificate %s (%s) could not be checked: %s", c.Path, c.Source, c.Error))
+		return
+	}
+	fields["subject"] = c.Subject
+	fields["sha256"] = c.SHA256
+	fields["not_after"] = c.NotAfter
+	fields["days_left"] = c.DaysLeft
+
+	msg := fmt.Sprintf("Certificate %s (%s) expires in %d days", c.Path, c.Source, c.DaysLeft)
+	if c.DaysLeft < 0 {
+		msg = fmt.Sprintf("Certificate %s (%s) expired %d days ago", c.Path, c.Source, -c.DaysLeft)
+	}
+	if c.Level == certs.LevelCritical {
+		w.logger.WithFields(fields).Error(msg)
+	} else {
+		w.logger.WithFields(fields).Warn(msg)
+	}
+}
+
+// latestCertReport returns the last scan, or nil while the scanner is off or
+// has not run yet.
+func latestCertReport() *certs.Report {
+	latestCertsMu.RLock()
+	defer latestCertsMu.RUnlock()
+	return latestCerts
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/certs"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+func writeTestCert(t *testing.T, path string, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertWatcherReportsTransitions(t *testing.T) {
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatalf("logger init: %v", err)
+	}
+	oldDir := certs.Dir
+	certs.Dir = t.TempDir()
+	defer func() { certs.Dir = oldDir }()
+
+	shieldDir := t.TempDir()
+	path := filepath.Join(shieldDir, "upstream.crt")
+	now := time.Now()
+	writeTestCert(t, path, now.Add(10*24*time.Hour))
+
+	w := NewCertWatcher(logger.NewLogger("cert-watch-test"), CertWatchOptions{
+		Thresholds: certs.Thresholds{WarningDays: 30, CriticalDays: 7},
+		Sources:    certs.Sources{ShieldDir: shieldDir},
+	})
+	report := w.scanOnce(context.Background(), now)
+	if len(report.Certificates) != 1 || report.Certificates[0].Level != certs.LevelWarning {
+		t.Fatalf("report = %+v", report)
+	}
+	if latestCertReport() != report {
+		t.Error("the latest report is not published for the stats summary")
+	}
+	key := path + "|" + report.Certificates[0].SHA256
+	first := w.reported[key]
+	if first.level != certs.LevelWarning {
+		t.Fatalf("reported = %+v", w.reported)
+	}
+
+	// Same level within a day: not reported again.
+	w.scanOnce(context.Background(), now.Add(time.Hour))
+	if w.reported[key].at != first.at {
+		t.Error("a warning was reported again within the reminder interval")
+	}
+	// A day later it is.
+	w.scanOnce(context.Background(), now.Add(certRemindInterval))
+	if !w.reported[key].at.After(first.at) {
+		t.Error("a standing warning was not reported again after the reminder interval")
+	}
+
+	// Rotation: the old certificate is forgotten, the new one is fine.
+	writeTestCert(t, path, now.Add(365*24*time.Hour))
+	report = w.scanOnce(context.Background(), now.Add(certRemindInterval+time.Hour))
+	if report.Counts[certs.LevelOK] != 1 || len(w.reported) != 1 {
+		t.Errorf("after rotation: counts %v, reported %v", report.Counts, w.reported)
+	}
+}
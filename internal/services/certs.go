@@ -0,0 +1,82 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/certs"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// CertsPath is the virtual admin path the control plane sends as a PROXY
+// command to manage TLS bundles. GET lists every managed and
+// bootstrap-referenced certificate; GET "/elchi/certs/<name>" returns one
+// bundle with the sds_config to reference it; POST "/elchi/certs/<name>"
+// with a {"cert","key","ca"} PEM body installs or rotates it and POST
+// "/elchi/certs/<name>/delete" removes it.
+const CertsPath = "/elchi/certs"
+
+type certBundleRequest struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+}
+
+// certsResponse answers CertsPath.
+func certsResponse(req *client.RequestEnvoyAdmin, log *logger.Logger) (int32, any) {
+	rest := strings.Trim(strings.TrimPrefix(req.GetPath(), CertsPath), "/")
+	name, action, _ := strings.Cut(rest, "/")
+	post := req.GetMethod() == client.HttpMethod_POST
+
+	switch {
+	case name == "" && !post:
+		list := certs.Inventory(time.Now())
+		if list == nil {
+			list = []certs.Info{}
+		}
+		return 200, list
+	case name != "" && action == "" && !post:
+		return certBundle(name)
+	case name != "" && action == "" && post:
+		var body certBundleRequest
+		if err := json.Unmarshal([]byte(req.GetBody()), &body); err != nil {
+			return 400, map[string]string{"error": "invalid body: " + err.Error()}
+		}
+		info, err := certs.Install(name, certs.Bundle{Cert: []byte(body.Cert), Key: []byte(body.Key), CA: []byte(body.CA)})
+		if err != nil {
+			return 400, map[string]string{"error": err.Error()}
+		}
+		log.WithFields(logger.Fields{
+			"event":     "certificate_installed",
+			"bundle":    name,
+			"subject":   info.Subject,
+			"sha256":    info.SHA256,
+			"not_after": info.NotAfter,
+		}).Info("Installed certificate bundle " + name)
+		return certBundle(name)
+	case name != "" && action == "delete" && post:
+		if err := certs.Remove(name); err != nil {
+			if err == certs.ErrNotFound {
+				return 404, map[string]string{"error": err.Error()}
+			}
+			return 409, map[string]string{"error": err.Error()}
+		}
+		log.WithFields(logger.Fields{"event": "certificate_removed", "bundle": name}).Info("Removed certificate bundle " + name)
+		return 200, map[string]string{"removed": name}
+	}
+	return 404, map[string]string{"error": "unknown certificate request"}
+}
+
+func certBundle(name string) (int32, any) {
+	infos := certs.Bundled(name, time.Now())
+	if infos == nil {
+		return 404, map[string]string{"error": certs.ErrNotFound.Error()}
+	}
+	return 200, map[string]any{
+		"name":         name,
+		"certificates": infos,
+		"sds_config":   certs.SDSConfig(name),
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/configdump"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+// ConfigDiffPath is the virtual admin path the control plane sends as a PROXY
+// command to list the bootstrap history of the deployment on the request's
+// port; "/elchi/config-diff/<id>" (or ".../latest") returns one entry with
+// its config dump diff. The deploy and bootstrap responses have no field for
+// the diff, so this is how it is read back.
+const ConfigDiffPath = "/elchi/config-diff"
+
+// recordConfigChange finishes rec after a bootstrap change and logs the
+// outcome. Failures are only logged: the change itself already succeeded.
+func recordConfigChange(ctx context.Context, rec *configdump.Recorder, bootstrapPath string, log *logger.Logger) {
+	entry, err := rec.Finish(ctx, bootstrapPath)
+	if err != nil {
+		log.Warnf("Failed to store bootstrap history: %v", err)
+	}
+	if entry == nil {
+		return
+	}
+	fields := logger.Fields{
+		"event":      "envoy_config_change",
+		"service":    entry.Service,
+		"source":     entry.Source,
+		"history_id": entry.ID,
+		"summary":    entry.Summary,
+		"settled":    entry.Settled,
+	}
+	if entry.Error != "" {
+		fields["error"] = entry.Error
+	}
+	log.WithFields(fields).Info("Envoy config of " + entry.Service + " changed: " + entry.Summary)
+}
+
+// configDiffResponse answers ConfigDiffPath for the deployment on port.
+func configDiffResponse(path string, port uint32) (int32, any) {
+	deployments, err := listDeployments()
+	if err != nil {
+		return 500, map[string]string{"error": err.Error()}
+	}
+	service := ""
+	for _, d := range deployments {
+		if d.Port == port {
+			service = d.Filename
+		}
+	}
+	if service == "" {
+		return 404, map[string]string{"error": "no deployment on this port"}
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(path, ConfigDiffPath), "/")
+	if id == "" {
+		list, err := configdump.ListEntries(service)
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		if list == nil {
+			list = []configdump.Entry{}
+		}
+		return 200, list
+	}
+	entry, err := configdump.GetEntry(service, id)
+	if err != nil {
+		if err == configdump.ErrNotFound {
+			return 404, map[string]string{"error": err.Error()}
+		}
+		return 500, map[string]string{"error": err.Error()}
+	}
+	return 200, entry
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/crashes"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/hotrestart"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/journal"
+	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+)
+
+// CrashesPath is the virtual admin path the control plane sends as a PROXY
+// command to list crash captures; "/elchi/crashes/<id>" returns one with its
+// log tail. Like StatsSummaryPath it never reaches an envoy.
+const CrashesPath = "/elchi/crashes"
+
+// logTailMaxBytes bounds how much of a system log is read for its tail.
+const logTailMaxBytes = 4 << 20
+
+// CrashWatchOptions tune the crash-loop watcher.
+type CrashWatchOptions struct {
+	Interval   time.Duration
+	Window     time.Duration
+	Threshold  int
+	LogLines   int
+	MaxEntries int
+	MaxBytes   int64
+}
+
+// CrashWatcher polls the restart count of every envoy unit and captures
+// forensics when one is crash looping.
+type CrashWatcher struct {
+	logger   *logger.Logger
+	runner   *cmdrunner.CommandsRunner
+	opts     CrashWatchOptions
+	store    *crashes.Store
+	detector *crashes.Detector
+}
+
+// NewCrashWatcher builds a watcher storing captures in crashes.DefaultDir.
+func NewCrashWatcher(log *logger.Logger, opts CrashWatchOptions) *CrashWatcher {
+	return &CrashWatcher{
+		logger:   log,
+		runner:   cmdrunner.NewCommandsRunner(),
+		opts:     opts,
+		store:    crashes.NewStore(crashes.DefaultDir, opts.MaxEntries, opts.MaxBytes),
+		detector: crashes.NewDetector(opts.Window, opts.Threshold),
+	}
+}
+
+// Start polls every opts.Interval until ctx is cancelled.
+func (w *CrashWatcher) Start(ctx context.Context) {
+	defer helper.RecoverPanic(w.logger, "crash-watcher")
+
+	w.logger.Infof("Crash-loop watcher started (%d restarts within %s)", w.opts.Threshold, w.opts.Window)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		w.checkOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *CrashWatcher) checkOnce(ctx context.Context) {
+	deployments, err := listDeployments()
+	if err != nil {
+		w.logger.Warnf("Crash watcher: failed to list deployments: %v", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(deployments))
+	for _, d := range deployments {
+		seen[d.Filename] = true
+		args := []string{"show"}
+		for _, p := range crashes.UnitStateProperties {
+			args = append(args, "-p", p)
+		}
+		out, err := w.runner.RunWithOutput(ctx, "systemctl", append(args, d.Filename+".service")...)
+		if err != nil {
+			continue
+		}
+		state := crashes.ParseUnitState(string(out))
+
+		now := time.Now()
+		looping, inWindow := w.detector.Observe(d.Filename, state.NRestarts, now)
+		if !looping {
+			continue
+		}
+		rec, err := w.capture(d, state, inWindow, now)
+		if err != nil {
+			w.logger.Errorf("Failed to capture crash loop of %s: %v", d.Filename, err)
+			continue
+		}
+		w.report(rec)
+	}
+	w.detector.Forget(seen)
+}
+
+func (w *CrashWatcher) capture(d deployment, state crashes.UnitState, inWindow int, now time.Time) (*crashes.Record, error) {
+	rec := &crashes.Record{
+		ID:               crashes.NewID(d.Filename, now),
+		Service:          d.Filename,
+		DetectedAt:       now,
+		Restarts:         state.NRestarts,
+		RestartsInWindow: inWindow,
+		Window:           w.opts.Window.String(),
+		Result:           state.Result,
+		ExitCode:         state.ExitCode,
+		Signal:           state.Signal,
+	}
+
+	// The unit's main process is the hot restarter; the native one also
+	// records how the envoy child itself ended.
+	since := now.Add(-w.opts.Window)
+	pids := make(map[int]bool)
+	if st, err := hotrestart.ReadStatus(strconv.FormatUint(uint64(d.Port), 10)); err == nil && st.LastExit != nil &&
+		now.Sub(st.LastExit.At) <= w.opts.Window {
+		code := st.LastExit.Code
+		rec.EnvoyExitCode = &code
+		rec.EnvoySignal = st.LastExit.Signal
+		pids[st.LastExit.PID] = true
+	}
+	// Only a core dumped by this unit's processes is attached.
+	if dumped, err := journal.CoredumpPIDs(d.Filename+".service", since); err != nil {
+		w.logger.Debugf("Crash capture of %s: no coredump journal entries: %v", d.Filename, err)
+	} else {
+		for pid := range dumped {
+			pids[pid] = true
+		}
+	}
+
+	rec.LogSource = "/var/log/elchi/" + d.Filename + "_system.log"
+	tail, err := crashes.TailLines(rec.LogSource, w.opts.LogLines, logTailMaxBytes)
+	if err != nil {
+		w.logger.Warnf("Crash capture of %s: no system log: %v", d.Filename, err)
+	} else {
+		rec.LogLines = strings.Count(string(tail), "\n")
+	}
+
+	core, owned := crashes.FindCore(since, pids)
+	if core == "" {
+		rec.CoreNote = "no core file of this service written within the window"
+	}
+	if err := w.store.Save(rec, tail, core, owned); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// report emits the structured crash event; the fields are what the log
+// pipeline indexes on.
+func (w *CrashWatcher) report(rec *crashes.Record) {
+	fields := logger.Fields{
+		"event":              "envoy_crash_loop",
+		"crash_id":           rec.ID,
+		"service":            rec.Service,
+		"restarts":           rec.Restarts,
+		"restarts_in_window": rec.RestartsInWindow,
+		"window":             rec.Window,
+		"result":             rec.Result,
+		"exit_code":          rec.ExitCode,
+		"signal":             rec.Signal,
+		"core":               rec.Core,
+	}
+	if rec.EnvoyExitCode != nil {
+		fields["envoy_exit_code"] = *rec.EnvoyExitCode
+		fields["envoy_signal"] = rec.EnvoySignal
+	}
+	w.logger.WithFields(fields).Error(fmt.Sprintf("Envoy service %s is crash looping (%d restarts within %s)", rec.Service, rec.RestartsInWindow, rec.Window))
+}
+
+// crashesResponse answers CrashesPath and CrashesPath/<id> from the store.
+func crashesResponse(path string) (int32, any) {
+	store := crashes.NewStore(crashes.DefaultDir, 0, 0)
+	id := strings.TrimPrefix(strings.TrimPrefix(path, CrashesPath), "/")
+	if id == "" {
+		list, err := store.List()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		if list == nil {
+			list = []crashes.Record{}
+		}
+		return 200, list
+	}
+
+	rec, tail, err := store.Get(id)
+	if err != nil {
+		if err == crashes.ErrNotFound {
+			return 404, map[string]string{"error": err.Error()}
+		}
+		return 500, map[string]string{"error": err.Error()}
+	}
+	return 200, struct {
+		*crashes.Record
+		LogTail string `json:"log_tail"`
+	}{rec, string(tail)}
+}
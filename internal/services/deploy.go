@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,6 +13,7 @@ import (
 	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/files"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/preflight"
 	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
 	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
 	"github.com/CloudNativeWorks/elchi-client/pkg/models"
@@ -147,6 +149,24 @@ func validateDeploymentPrerequisites(ctx context.Context, deployReq *client.Requ
 		}
 	}
 
+	// The port map above only knows deployments made since this client
+	// started; check the bootstrap's addresses against what is actually bound
+	// on the host, every elchi bootstrap on disk and the other interfaces.
+	if err := preflight.Check(preflight.Request{
+		Service:           fmt.Sprintf("%s-%d", deployReq.GetName(), deployReq.GetPort()),
+		Port:              deployReq.GetPort(),
+		Bootstrap:         deployReq.GetBootstrap(),
+		DownstreamAddress: deployReq.GetDownstreamAddress(),
+	}); err != nil {
+		var conflicts *preflight.ConflictError
+		if errors.As(err, &conflicts) {
+			for _, c := range conflicts.Conflicts {
+				log.Warnf("Deploy preflight: %s conflicts with %s", c.What, c.With)
+			}
+		}
+		return err
+	}
+
 	return nil
 }
 
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/CloudNativeWorks/elchi-client/internal/cmdrunner"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/configdump"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
 	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
 	"github.com/CloudNativeWorks/elchi-client/pkg/models"
@@ -189,36 +190,36 @@ func checkInterfaceChanged(ifaceName, downstreamAddress string, logger *logger.L
 		return true, fmt.Errorf("error checking interface: %w", err)
 	}
 
-	// Get expected IP
-	ipv4CIDR, err := tools.GetIPv4CIDR(downstreamAddress)
+	// Get expected IPs
+	cidrs, err := tools.GetHostCIDRs(downstreamAddress)
 	if err != nil {
 		return true, fmt.Errorf("invalid IP address format: %w", err)
 	}
 
-	// Parse expected IP
-	expectedIP := strings.Split(ipv4CIDR, "/")[0]
-
-	// Check current IPs on interface
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	// Check current IPs on interface; the kernel's own fe80:: address does
+	// not count
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return true, fmt.Errorf("failed to list addresses: %w", err)
 	}
-
-	// Check if expected IP exists
-	hasCorrectIP := false
+	current := make(map[string]bool)
 	for _, addr := range addrs {
-		if addr.IP.String() == expectedIP {
-			hasCorrectIP = true
+		if !addr.IP.IsLinkLocalUnicast() {
+			current[addr.IP.String()] = true
 		}
 	}
 
-	if !hasCorrectIP {
-		logger.Debugf("Interface %s IP changed or missing: expected %s", ifaceName, expectedIP)
-		return true, nil
+	// Check if every expected IP exists
+	for _, cidr := range cidrs {
+		expectedIP := strings.Split(cidr, "/")[0]
+		if !current[expectedIP] {
+			logger.Debugf("Interface %s IP changed or missing: expected %s", ifaceName, expectedIP)
+			return true, nil
+		}
 	}
 
 	// Check if there are extra IPs that shouldn't be there
-	if len(addrs) > 1 {
+	if len(current) > len(cidrs) {
 		logger.Debugf("Interface %s has extra IPs, needs cleanup", ifaceName)
 		return true, nil
 	}
@@ -239,7 +240,7 @@ func checkServiceChanged(existingPath string, deployReq *client.RequestDeploy, f
 	}
 
 	// Generate expected content
-	expectedContent := fmt.Sprintf(template.SystemdTemplate,
+	expectedContent := fmt.Sprintf(template.UnitTemplate(),
 		deployReq.GetName(),    // Description (%s)
 		deployReq.GetVersion(), // ExecStartPre envoy path (%s)
 		filename,               // ExecStartPre bootstrap (%s)
@@ -267,6 +268,12 @@ func ApplyDeploymentUpdates(ctx context.Context, deployReq *client.RequestDeploy
 
 	needsSystemdReload := false
 
+	// Snapshot the running config so the restart below can be diffed.
+	var rec *configdump.Recorder
+	if checkResult.ServiceNeedsRestart && !checkResult.BinaryMissing {
+		rec = configdump.Begin(ctx, filename, deployReq.GetPort(), configdump.SourceDeployUpdate)
+	}
+
 	// Update bootstrap file if changed
 	if checkResult.BootstrapChanged {
 		logger.Infof("Updating bootstrap file for %s", filename)
@@ -302,7 +309,7 @@ func ApplyDeploymentUpdates(ctx context.Context, deployReq *client.RequestDeploy
 	if checkResult.ServiceChanged {
 		logger.Infof("Updating service file for %s", serviceName)
 		servicePath := filepath.Join(models.SystemdPath, serviceName)
-		content := fmt.Sprintf(template.SystemdTemplate,
+		content := fmt.Sprintf(template.UnitTemplate(),
 			deployReq.GetName(),    // Description (%s)
 			deployReq.GetVersion(), // ExecStartPre envoy path (%s)
 			filename,               // ExecStartPre bootstrap (%s)
@@ -340,6 +347,7 @@ func ApplyDeploymentUpdates(ctx context.Context, deployReq *client.RequestDeploy
 			return fmt.Errorf("failed to restart service: %w", err)
 		}
 		logger.Infof("Service restarted successfully: %s", serviceName)
+		recordConfigChange(ctx, rec, filepath.Join(models.BootstrapsPath, filename+".yaml"), logger)
 	}
 
 	return nil
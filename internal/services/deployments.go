@@ -0,0 +1,55 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+)
+
+// deployment is one envoy deployment on this host as seen on disk. Every
+// deployment has a bootstrap named <name>-<port>.yaml; the port is both the
+// admin port and the --base-id, and Filename is also the unit name.
+type deployment struct {
+	Filename string
+	Port     uint32
+}
+
+// listDeployments enumerates deployments from the bootstraps directory. The
+// in-memory activeDeployments map only knows what this process deployed since
+// it started, so anything that must see every deployment reads the disk.
+func listDeployments() ([]deployment, error) {
+	entries, err := os.ReadDir(models.BootstrapsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var out []deployment
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".yaml") {
+			continue
+		}
+		if d, ok := parseDeploymentFilename(strings.TrimSuffix(e.Name(), ".yaml")); ok {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// parseDeploymentFilename splits "<name>-<port>" on its LAST dash (names may
+// contain dashes themselves).
+func parseDeploymentFilename(filename string) (deployment, bool) {
+	idx := strings.LastIndex(filename, "-")
+	if idx <= 0 || idx == len(filename)-1 {
+		return deployment{}, false
+	}
+	port, err := strconv.ParseUint(filename[idx+1:], 10, 32)
+	if err != nil || port == 0 {
+		return deployment{}, false
+	}
+	return deployment{Filename: filename, Port: uint32(port)}, true
+}
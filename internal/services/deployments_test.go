@@ -0,0 +1,25 @@
+package services
+
+import "testing"
+
+func TestParseDeploymentFilename(t *testing.T) {
+	cases := []struct {
+		in   string
+		want deployment
+		ok   bool
+	}{
+		{"web-80", deployment{Filename: "web-80", Port: 80}, true},
+		{"my-edge-proxy-10443", deployment{Filename: "my-edge-proxy-10443", Port: 10443}, true},
+		{"noport", deployment{}, false},
+		{"web-", deployment{}, false},
+		{"-80", deployment{}, false},
+		{"web-http", deployment{}, false},
+		{"web-0", deployment{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parseDeploymentFilename(c.in)
+		if ok != c.ok || got != c.want {
+			t.Errorf("parseDeploymentFilename(%q) = %+v, %v; want %+v, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
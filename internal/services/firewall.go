@@ -0,0 +1,75 @@
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// FirewallPath is the virtual admin path the control plane sends as a PROXY
+// command to manage the host firewall (nftables table inet elchi). GET
+// returns the persisted rules and the live table; POST with a
+// FirewallConfig body plus the apply options replaces the table, and POST
+// "/elchi/firewall/delete" removes it.
+const FirewallPath = "/elchi/firewall"
+
+// firewallRequest is the POST body. LockdownAdmin adds the admin port of
+// every envoy deployment on the host to the lockdown list, so rules only
+// need to accept the control plane's sources.
+type firewallRequest struct {
+	network.FirewallConfig
+	network.FirewallApplyOptions
+	LockdownAdmin bool `json:"lockdown_admin"`
+}
+
+// firewallResponse answers FirewallPath.
+func (s *Services) firewallResponse(req *client.RequestEnvoyAdmin) (int32, any) {
+	fm := network.NewFirewallManager(s.logger)
+	post := req.GetMethod() == client.HttpMethod_POST
+
+	switch {
+	case req.GetPath() == FirewallPath && !post:
+		cfg, live, err := fm.Current()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, map[string]any{"config": cfg, "ruleset": live}
+	case req.GetPath() == FirewallPath+"/delete" && post:
+		if err := fm.Remove(); err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		s.logger.WithFields(logger.Fields{"event": "firewall_removed"}).Info("Removed host firewall")
+		return 200, map[string]string{"removed": "inet " + network.FirewallTable}
+	case req.GetPath() == FirewallPath && post:
+		body := firewallRequest{FirewallApplyOptions: network.DefaultFirewallApplyOptions()}
+		if err := json.Unmarshal([]byte(req.GetBody()), &body); err != nil {
+			return 400, map[string]string{"error": "invalid body: " + err.Error()}
+		}
+		cfg := body.FirewallConfig
+		if body.LockdownAdmin {
+			deployments, err := listDeployments()
+			if err != nil {
+				return 500, map[string]string{"error": err.Error()}
+			}
+			for _, d := range deployments {
+				cfg.Lockdown = append(cfg.Lockdown, network.FirewallPort{Protocol: "tcp", Port: uint16(d.Port)})
+			}
+		}
+		if s.grpcClient != nil {
+			fm.SetGRPCConnection(s.grpcClient.GetConnection(), s.grpcClient.GetClientID())
+		}
+		if err := fm.Apply(cfg, body.FirewallApplyOptions); err != nil {
+			s.logger.WithFields(logger.Fields{"event": "firewall_apply_failed", "error": err.Error()}).Error("Firewall apply failed")
+			return 400, map[string]string{"error": err.Error()}
+		}
+		s.logger.WithFields(logger.Fields{
+			"event":    "firewall_applied",
+			"rules":    len(cfg.Rules),
+			"lockdown": len(cfg.Lockdown),
+		}).Info("Applied host firewall")
+		return 200, map[string]any{"config": cfg, "ruleset": network.RenderFirewall(cfg)}
+	}
+	return 404, map[string]string{"error": "unknown firewall request"}
+}
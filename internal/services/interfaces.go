@@ -0,0 +1,78 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// InterfacesPath is the virtual admin path the control plane sends as a PROXY
+// command to manage VLANs, bonds and bridges without hand-written netplan
+// YAML. GET lists them with their live netlink state; GET
+// "/elchi/interfaces/<name>" returns one; POST "/elchi/interfaces/<name>"
+// with a VirtualInterface body (plus the apply options) creates or updates
+// it, and POST "/elchi/interfaces/<name>/delete" removes it.
+const InterfacesPath = "/elchi/interfaces"
+
+type interfaceRequest struct {
+	network.VirtualInterface
+	network.InterfaceApplyOptions
+}
+
+// interfacesResponse answers InterfacesPath.
+func (s *Services) interfacesResponse(req *client.RequestEnvoyAdmin) (int32, any) {
+	rest := strings.Trim(strings.TrimPrefix(req.GetPath(), InterfacesPath), "/")
+	name, action, _ := strings.Cut(rest, "/")
+	post := req.GetMethod() == client.HttpMethod_POST
+
+	im := network.NewInterfaceManager(s.logger)
+	if s.grpcClient != nil {
+		im.SetGRPCConnection(s.grpcClient.GetConnection(), s.grpcClient.GetClientID())
+	}
+	// Both POSTs take the options; unset ones keep the safe defaults.
+	body := interfaceRequest{InterfaceApplyOptions: network.DefaultInterfaceApplyOptions()}
+	if post && req.GetBody() != "" {
+		if err := json.Unmarshal([]byte(req.GetBody()), &body); err != nil {
+			return 400, map[string]string{"error": "invalid body: " + err.Error()}
+		}
+	}
+
+	switch {
+	case name == "" && !post:
+		list, err := im.List()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, list
+	case name != "" && action == "" && !post:
+		report, err := im.Get(name)
+		if os.IsNotExist(err) {
+			return 404, map[string]string{"error": name + " is not managed as a typed interface"}
+		}
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, report
+	case name != "" && action == "" && post:
+		body.Name = name
+		report, err := im.Apply(body.VirtualInterface, body.InterfaceApplyOptions)
+		if err != nil {
+			s.logger.WithFields(logger.Fields{"event": "interface_apply_failed", "interface": name, "error": err.Error()}).Error("Interface apply failed")
+			return 400, map[string]string{"error": err.Error()}
+		}
+		s.logger.WithFields(logger.Fields{"event": "interface_applied", "interface": name, "kind": body.Kind}).Info("Applied interface " + name)
+		return 200, report
+	case name != "" && action == "delete" && post:
+		if err := im.Delete(name, body.InterfaceApplyOptions); err != nil {
+			s.logger.WithFields(logger.Fields{"event": "interface_delete_failed", "interface": name, "error": err.Error()}).Error("Interface delete failed")
+			return 400, map[string]string{"error": err.Error()}
+		}
+		s.logger.WithFields(logger.Fields{"event": "interface_deleted", "interface": name}).Info("Deleted interface " + name)
+		return 200, map[string]string{"removed": name}
+	}
+	return 404, map[string]string{"error": "unknown interface request"}
+}
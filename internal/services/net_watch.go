@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// NetEventsPath is the virtual admin path the control plane sends as a
+// PROXY command for the recent significant network changes, oldest first.
+const NetEventsPath = "/elchi/network/events"
+
+// netWatchRetry is the pause before resubscribing after a netlink
+// subscription failed.
+const netWatchRetry = 5 * time.Second
+
+// NetWatchOptions tune the netlink change watcher.
+type NetWatchOptions struct {
+	Debounce   time.Duration
+	BufferSize int
+}
+
+// NetWatcher reports significant link, address, route and rule changes as
+// events and keeps the recent ones for NetEventsPath. Nothing is pushed: the
+// command stream only carries responses to the control plane's commands, so
+// it reads the events with a PROXY command to NetEventsPath or from the
+// CLIENT_LOGS journal.
+type NetWatcher struct {
+	logger *logger.Logger
+	opts   NetWatchOptions
+}
+
+var (
+	netEventsMu sync.RWMutex
+	netEvents   *netEventRing
+)
+
+// NewNetWatcher builds an idle watcher; Start runs it.
+func NewNetWatcher(log *logger.Logger, opts NetWatchOptions) *NetWatcher {
+	return &NetWatcher{logger: log, opts: opts}
+}
+
+// Start watches until ctx is cancelled, resubscribing after a failure.
+func (w *NetWatcher) Start(ctx context.Context) {
+	defer helper.RecoverPanic(w.logger, "net-watcher")
+
+	ring := newNetEventRing(w.opts.BufferSize)
+	netEventsMu.Lock()
+	netEvents = ring
+	netEventsMu.Unlock()
+
+	w.logger.Infof("Network change watcher started (debounce %s)", w.opts.Debounce)
+	watcher := network.NewNetEventWatcher(w.logger, w.opts.Debounce)
+	for {
+		err := watcher.Run(ctx, func(ev network.NetEvent) {
+			ring.push(ev)
+			w.report(ev)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		w.logger.Warnf("Network change watcher stopped: %v; resubscribing in %s", err, netWatchRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(netWatchRetry):
+		}
+	}
+}
+
+// report logs the structured change event, which CLIENT_LOGS returns; the
+// fields are what the log pipeline indexes on.
+func (w *NetWatcher) report(ev network.NetEvent) {
+	fields := logger.Fields{
+		"event":  "network_change",
+		"kind":   ev.Kind,
+		"action": ev.Action,
+		"object": ev.Object,
+		"count":  ev.Count,
+	}
+	if ev.Interface != "" {
+		fields["interface"] = ev.Interface
+	}
+	if ev.Table != 0 {
+		fields["table"] = ev.Table
+	}
+	if ev.Detail != "" {
+		fields["detail"] = ev.Detail
+	}
+
+	msg := fmt.Sprintf("Network %s %s %s", ev.Kind, ev.Object, ev.Action)
+	if ev.Detail != "" {
+		msg += " (" + ev.Detail + ")"
+	}
+	if ev.Count > 1 {
+		msg += fmt.Sprintf(", %d updates", ev.Count)
+	}
+	if ev.Action == "removed" || ev.Action == "down" {
+		w.logger.WithFields(fields).Warn(msg)
+	} else {
+		w.logger.WithFields(fields).Info(msg)
+	}
+}
+
+// netEventsResponse answers NetEventsPath.
+func netEventsResponse(req *client.RequestEnvoyAdmin) (int32, any) {
+	if req.GetMethod() == client.HttpMethod_POST {
+		return 404, map[string]string{"error": "unknown network events request"}
+	}
+	netEventsMu.RLock()
+	ring := netEvents
+	netEventsMu.RUnlock()
+	if ring == nil {
+		return 503, map[string]string{"error": "network change watcher is disabled"}
+	}
+	return 200, ring.list()
+}
+
+// netEventRing keeps the last size events.
+type netEventRing struct {
+	mu     sync.Mutex
+	events []network.NetEvent
+	next   int
+	full   bool
+}
+
+func newNetEventRing(size int) *netEventRing {
+	if size <= 0 {
+		size = 1
+	}
+	return &netEventRing{events: make([]network.NetEvent, size)}
+}
+
+func (r *netEventRing) push(ev network.NetEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[r.next] = ev
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the kept events, oldest first.
+func (r *netEventRing) list() []network.NetEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]network.NetEvent{}, r.events[:r.next]...)
+	}
+	return append(append([]network.NetEvent{}, r.events[r.next:]...), r.events[:r.next]...)
+}
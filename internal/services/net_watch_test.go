@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+)
+
+func TestNetEventRing(t *testing.T) {
+	ring := newNetEventRing(3)
+	if got := ring.list(); len(got) != 0 {
+		t.Fatalf("empty ring = %+v", got)
+	}
+	for _, object := range []string{"a", "b"} {
+		ring.push(network.NetEvent{Object: object})
+	}
+	if got := ring.list(); len(got) != 2 || got[0].Object != "a" || got[1].Object != "b" {
+		t.Fatalf("partial ring = %+v", got)
+	}
+	for _, object := range []string{"c", "d", "e"} {
+		ring.push(network.NetEvent{Object: object})
+	}
+	got := ring.list()
+	if len(got) != 3 || got[0].Object != "c" || got[1].Object != "d" || got[2].Object != "e" {
+		t.Fatalf("wrapped ring = %+v", got)
+	}
+}
@@ -5,9 +5,17 @@ import (
 
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
 	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
 	client "github.com/CloudNativeWorks/elchi-proto/client"
 )
 
+// NetplanPlanPath is the virtual admin path the control plane POSTs a netplan
+// YAML to, as a PROXY command, to get the plan SUB_NETPLAN_APPLY would carry
+// out: the file diff, the rendered backend config and the predicted link,
+// address and route changes. NetplanConfig has no dry-run flag, so plans are
+// requested here; nothing is written.
+const NetplanPlanPath = "/elchi/netplan/plan"
+
 func (s *Services) NetworkService(_ context.Context, cmd *client.Command) *client.CommandResponse {
 	switch cmd.SubType {
 	// Netplan operations
@@ -49,3 +57,15 @@ func (s *Services) NetworkService(_ context.Context, cmd *client.Command) *clien
 
 	return helper.NewErrorResponse(cmd, "invalid sub command")
 }
+
+// netplanPlanResponse answers NetplanPlanPath.
+func netplanPlanResponse(req *client.RequestEnvoyAdmin, log *logger.Logger) (int32, any) {
+	if req.GetMethod() != client.HttpMethod_POST {
+		return 405, map[string]string{"error": "POST the proposed netplan YAML"}
+	}
+	plan, err := network.NewNetplanManager(log).PlanNetplanConfig(req.GetBody())
+	if err != nil {
+		return 400, map[string]string{"error": err.Error()}
+	}
+	return 200, plan
+}
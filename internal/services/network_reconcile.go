@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// NetworkDriftPath is the virtual admin path the control plane sends as a
+// PROXY command for the kernel-vs-netplan route and rule drift. GET returns a
+// fresh report; POST "/elchi/network/drift/repair" re-adds what is missing,
+// as the reconcile loop does every tick.
+const NetworkDriftPath = "/elchi/network/drift"
+
+// networkDriftResponse answers NetworkDriftPath.
+func networkDriftResponse(req *client.RequestEnvoyAdmin, log *logger.Logger) (int32, any) {
+	dc := network.NewDriftChecker(log)
+	post := req.GetMethod() == client.HttpMethod_POST
+	switch {
+	case req.GetPath() == NetworkDriftPath && !post:
+		drift, err := dc.Check()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, drift
+	case req.GetPath() == NetworkDriftPath+"/repair" && post:
+		drift, err := dc.Repair()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		logDriftRepairs(log, drift)
+		return 200, drift
+	}
+	return 404, map[string]string{"error": "unknown network drift request"}
+}
+
+// reconcileNetworkRoutes re-adds persisted routes and rules missing from the
+// kernel (deleted by hand, lost on a networkctl reload). Routes and rules
+// that are only in the kernel are reported once per change, never removed.
+func (r *Reconciler) reconcileNetworkRoutes() {
+	drift, err := network.NewDriftChecker(r.logger).Repair()
+	if err != nil {
+		r.reportFailure("network-routes", fmt.Sprintf("reconcile network routes: %v", err))
+		return
+	}
+	logDriftRepairs(r.logger, drift)
+	if len(drift.RepairErrors) > 0 {
+		r.reportFailure("network-routes", "reconcile network routes: could not re-add "+strings.Join(drift.RepairErrors, "; "))
+	} else {
+		r.clearFailure("network-routes")
+	}
+
+	extra := ""
+	if len(drift.ExtraRoutes)+len(drift.ExtraRules) > 0 {
+		extra = fmt.Sprintf("%d routes and %d rules in the kernel are not persisted", len(drift.ExtraRoutes), len(drift.ExtraRules))
+	}
+	if extra == r.lastFailure["network-extra"] {
+		return
+	}
+	if extra == "" {
+		delete(r.lastFailure, "network-extra")
+		return
+	}
+	r.lastFailure["network-extra"] = extra
+	r.logger.WithFields(logger.Fields{
+		"event":        "network_drift_detected",
+		"extra_routes": len(drift.ExtraRoutes),
+		"extra_rules":  len(drift.ExtraRules),
+	}).Warn("reconcile network routes: " + extra)
+}
+
+func logDriftRepairs(log *logger.Logger, drift *network.RouteDrift) {
+	for _, desc := range drift.Repaired {
+		log.WithFields(logger.Fields{"event": "network_drift_repaired", "entry": desc}).Warn("reconcile network routes: re-added missing " + desc)
+	}
+}
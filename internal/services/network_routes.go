@@ -0,0 +1,75 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// NetworkRoutesPath is the virtual admin path the control plane sends as a
+// PROXY command for routes client.Route cannot describe: weighted multipath
+// nexthops, mtu, advmss, initcwnd and protocol tags. GET lists the routes of
+// all tables; POST with a network.RouteSpec body adds one, POST
+// "/elchi/network/routes/replace" replaces the route to the same
+// destination, table and metric, and POST "/elchi/network/routes/delete"
+// deletes it.
+const NetworkRoutesPath = "/elchi/network/routes"
+
+// networkRoutesResponse answers NetworkRoutesPath.
+func networkRoutesResponse(req *client.RequestEnvoyAdmin, log *logger.Logger) (int32, any) {
+	action := strings.Trim(strings.TrimPrefix(req.GetPath(), NetworkRoutesPath), "/")
+	post := req.GetMethod() == client.HttpMethod_POST
+
+	if !post {
+		if action != "" {
+			return 404, map[string]string{"error": "unknown network routes request"}
+		}
+		specs, err := network.ListRouteSpecs()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		if specs == nil {
+			specs = []network.RouteSpec{}
+		}
+		return 200, specs
+	}
+
+	var spec network.RouteSpec
+	if err := json.Unmarshal([]byte(req.GetBody()), &spec); err != nil {
+		return 400, map[string]string{"error": "invalid body: " + err.Error()}
+	}
+	if spec.Route == nil {
+		return 400, map[string]string{"error": "invalid body: route is required"}
+	}
+
+	rm := network.NewRouteManagerNew(log)
+	var err error
+	var done string
+	switch action {
+	case "":
+		done = "added"
+		err = rm.AddRouteSpec(spec)
+	case "replace":
+		done = "replaced"
+		err = rm.ReplaceRouteSpec(spec)
+	case "delete":
+		done = "deleted"
+		err = rm.DeleteRouteSpec(spec)
+	default:
+		return 404, map[string]string{"error": "unknown network routes request"}
+	}
+	if err != nil {
+		return 400, map[string]string{"error": err.Error()}
+	}
+
+	log.WithFields(logger.Fields{
+		"event":    "route_" + done,
+		"to":       spec.To,
+		"table":    spec.Table,
+		"nexthops": len(spec.Nexthops),
+	}).Info("Route to " + spec.To + " " + done)
+	return 200, map[string]any{"route": spec, "warnings": rm.PersistWarnings()}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// NetworkSnapshotsPath is the virtual admin path the control plane sends as
+// a PROXY command to save and restore the Elchi-managed network state. GET
+// lists the snapshots and GET "/elchi/network/snapshots/<id>" returns one
+// manifest; POST with an optional {"label"} creates one, POST
+// "<id>/restore" reapplies it, POST "<id>/delete" removes it and POST
+// "prune" with {"keep"} drops all but the newest.
+const NetworkSnapshotsPath = "/elchi/network/snapshots"
+
+type snapshotRequest struct {
+	Label string `json:"label"`
+	Keep  int    `json:"keep"`
+	network.SnapshotRestoreOptions
+}
+
+// networkSnapshotsResponse answers NetworkSnapshotsPath.
+func (s *Services) networkSnapshotsResponse(req *client.RequestEnvoyAdmin) (int32, any) {
+	rest := strings.Trim(strings.TrimPrefix(req.GetPath(), NetworkSnapshotsPath), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	post := req.GetMethod() == client.HttpMethod_POST
+
+	sm := network.NewSnapshotManager(s.logger)
+	if s.grpcClient != nil {
+		sm.SetGRPCConnection(s.grpcClient.GetConnection(), s.grpcClient.GetClientID())
+	}
+	body := snapshotRequest{Keep: network.DefaultSnapshotKeep, SnapshotRestoreOptions: network.DefaultSnapshotRestoreOptions()}
+	if post && req.GetBody() != "" {
+		if err := json.Unmarshal([]byte(req.GetBody()), &body); err != nil {
+			return 400, map[string]string{"error": "invalid body: " + err.Error()}
+		}
+	}
+
+	switch {
+	case id == "" && !post:
+		snaps, err := sm.List()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, snaps
+	case id == "" && post:
+		snap, err := sm.Create(body.Label)
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		s.logger.WithFields(logger.Fields{"event": "network_snapshot_created", "snapshot": snap.ID, "label": body.Label}).Info("Created network snapshot " + snap.ID)
+		return 200, snap
+	case id == "prune" && action == "" && post:
+		removed, err := sm.Prune(body.Keep)
+		if err != nil {
+			return 400, map[string]string{"error": err.Error()}
+		}
+		if removed == nil {
+			removed = []string{}
+		}
+		return 200, map[string]any{"removed": removed}
+	case action == "" && !post:
+		snap, err := sm.Get(id)
+		if errors.Is(err, network.ErrSnapshotNotFound) {
+			return 404, map[string]string{"error": err.Error()}
+		}
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, snap
+	case action == "restore" && post:
+		result, err := sm.Restore(id, body.SnapshotRestoreOptions)
+		if errors.Is(err, network.ErrSnapshotNotFound) {
+			return 404, map[string]string{"error": err.Error()}
+		}
+		if err != nil {
+			s.logger.WithFields(logger.Fields{"event": "network_snapshot_restore_failed", "snapshot": id, "error": err.Error()}).Error("Network snapshot restore failed")
+			return 400, map[string]any{"error": err.Error(), "result": result}
+		}
+		s.logger.WithFields(logger.Fields{"event": "network_snapshot_restored", "snapshot": id, "pre_restore": result.PreRestore}).Info("Restored network snapshot " + id)
+		return 200, result
+	case action == "delete" && post:
+		if err := sm.Delete(id); errors.Is(err, network.ErrSnapshotNotFound) {
+			return 404, map[string]string{"error": err.Error()}
+		} else if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, map[string]string{"removed": id}
+	}
+	return 404, map[string]string{"error": "unknown network snapshot request"}
+}
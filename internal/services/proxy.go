@@ -9,6 +9,7 @@ import (
 
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/proxy"
 	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
 	client "github.com/CloudNativeWorks/elchi-proto/client"
 )
 
@@ -24,6 +25,58 @@ func (s *Services) ProxyEnvoyAdmin(ctx context.Context, cmd *client.Command) *cl
 		return helper.NewErrorResponse(cmd, "invalid method")
 	}
 
+	// Virtual paths answered by the client itself. They run without the
+	// envoy admin timeout: a firewall connectivity test or a netplan try
+	// during a snapshot restore takes longer than an admin request.
+	if path := req.GetPath(); strings.HasPrefix(path, "/elchi/") {
+		if err := proxy.CurrentPolicy().VirtualAllowed(req.GetMethod().String(), path); err != nil {
+			s.logger.WithFields(logger.Fields{"event": "virtual_path_denied", "path": path, "method": req.GetMethod().String()}).Warn("Refused virtual admin path")
+			return jsonAdminResponse(cmd, 403, map[string]string{"error": err.Error()})
+		}
+	}
+	switch path := req.GetPath(); {
+	case path == StatsSummaryPath:
+		statsCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return jsonAdminResponse(cmd, 200, currentStatsSummary(statsCtx, s.logger))
+	case path == CrashesPath || strings.HasPrefix(path, CrashesPath+"/"):
+		status, body := crashesResponse(path)
+		return jsonAdminResponse(cmd, status, body)
+	case path == ConfigDiffPath || strings.HasPrefix(path, ConfigDiffPath+"/"):
+		status, body := configDiffResponse(path, req.GetPort())
+		return jsonAdminResponse(cmd, status, body)
+	case path == CertsPath || strings.HasPrefix(path, CertsPath+"/"):
+		status, body := certsResponse(req, s.logger)
+		return jsonAdminResponse(cmd, status, body)
+	case path == NetplanPlanPath:
+		status, body := netplanPlanResponse(req, s.logger)
+		return jsonAdminResponse(cmd, status, body)
+	case path == FirewallPath || path == FirewallPath+"/delete":
+		status, body := s.firewallResponse(req)
+		return jsonAdminResponse(cmd, status, body)
+	case path == VRFsPath || strings.HasPrefix(path, VRFsPath+"/"):
+		status, body := vrfsResponse(req, s.logger)
+		return jsonAdminResponse(cmd, status, body)
+	case path == InterfacesPath || strings.HasPrefix(path, InterfacesPath+"/"):
+		status, body := s.interfacesResponse(req)
+		return jsonAdminResponse(cmd, status, body)
+	case path == NetworkSnapshotsPath || strings.HasPrefix(path, NetworkSnapshotsPath+"/"):
+		status, body := s.networkSnapshotsResponse(req)
+		return jsonAdminResponse(cmd, status, body)
+	case path == NetworkDriftPath || path == NetworkDriftPath+"/repair":
+		status, body := networkDriftResponse(req, s.logger)
+		return jsonAdminResponse(cmd, status, body)
+	case path == NetworkRoutesPath || strings.HasPrefix(path, NetworkRoutesPath+"/"):
+		status, body := networkRoutesResponse(req, s.logger)
+		return jsonAdminResponse(cmd, status, body)
+	case path == SysctlPath || path == SysctlPath+"/delete":
+		status, body := sysctlResponse(req, s.logger)
+		return jsonAdminResponse(cmd, status, body)
+	case path == NetEventsPath:
+		status, body := netEventsResponse(req)
+		return jsonAdminResponse(cmd, status, body)
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
@@ -40,23 +93,27 @@ func (s *Services) ProxyEnvoyAdmin(ctx context.Context, cmd *client.Command) *cl
 			"/runtime",
 		}
 
+		// The snapshot shares one response-size budget so the combined reply
+		// stays under the same cap as a single forwarded request. Every entry
+		// is a read-only endpoint, so it is always fetched with GET.
+		budget := proxy.CurrentPolicy().MaxResponseBytes()
 		results := make(map[string]string)
 		for _, path := range paths {
 			clearKey := strings.TrimPrefix(path, "/")
 			adminReq := &client.RequestEnvoyAdmin{
 				Name:    req.Name,
-				Method:  req.Method,
+				Method:  client.HttpMethod_GET,
 				Path:    path,
-				Body:    req.Body,
 				Port:    req.Port,
 				Queries: req.Queries,
 			}
-			resp, err := proxy.Do(ctx, adminReq)
+			resp, err := proxy.ForwardLimited(ctx, adminReq, max(budget, 1))
 			if err != nil {
-				s.logger.Errorf("ProxyEnvoyAdmin proxy.Do error for path %s: %v", path, err)
+				s.logger.Errorf("ProxyEnvoyAdmin proxy.Forward error for path %s: %v", path, err)
 				results[clearKey] = fmt.Sprintf("error: %v", err)
 				continue
 			}
+			budget -= int64(len(resp.Body))
 			results[clearKey] = resp.Body
 		}
 
@@ -78,9 +135,9 @@ func (s *Services) ProxyEnvoyAdmin(ctx context.Context, cmd *client.Command) *cl
 		}
 	}
 
-	resp, err := proxy.Do(ctx, req)
+	resp, err := proxy.Forward(ctx, req)
 	if err != nil {
-		s.logger.Errorf("ProxyEnvoyAdmin proxy.Do error: %v", err)
+		s.logger.Errorf("ProxyEnvoyAdmin proxy.Forward error: %v", err)
 		return helper.NewErrorResponse(cmd, fmt.Sprintf("proxy error: %v", err))
 	}
 
@@ -93,3 +150,40 @@ func (s *Services) ProxyEnvoyAdmin(ctx context.Context, cmd *client.Command) *cl
 		},
 	}
 }
+
+// jsonAdminResponse wraps v as the JSON body of an envoy admin response. A
+// status of 400 or above fails the command, with the body's "error" (or the
+// status) as its Error.
+func jsonAdminResponse(cmd *client.Command, status int32, v any) *client.CommandResponse {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return helper.NewErrorResponse(cmd, fmt.Sprintf("failed to marshal response: %v", err))
+	}
+	var errMsg string
+	if status >= 400 {
+		errMsg = fmt.Sprintf("virtual admin path returned status %d", status)
+		switch m := v.(type) {
+		case map[string]string:
+			if m["error"] != "" {
+				errMsg = m["error"]
+			}
+		case map[string]any:
+			if e, ok := m["error"].(string); ok && e != "" {
+				errMsg = e
+			}
+		}
+	}
+	return &client.CommandResponse{
+		Identity:  cmd.Identity,
+		CommandId: cmd.CommandId,
+		Success:   status < 400,
+		Error:     errMsg,
+		Result: &client.CommandResponse_EnvoyAdmin{
+			EnvoyAdmin: &client.ResponseEnvoyAdmin{
+				StatusCode: status,
+				Body:       string(body),
+				Headers:    map[string]string{"Content-Type": "application/json"},
+			},
+		},
+	}
+}
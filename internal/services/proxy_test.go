@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+func TestProxyVirtualPathPolicy(t *testing.T) {
+	if err := logger.Init(logger.Config{Level: "error", Format: "text", Module: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	s := &Services{logger: logger.NewLogger("proxy-test")}
+	cmd := &client.Command{CommandId: "1", Payload: &client.Command_EnvoyAdmin{EnvoyAdmin: &client.RequestEnvoyAdmin{
+		Method: client.HttpMethod_POST,
+		Path:   SysctlPath + "/delete",
+	}}}
+
+	// The default policy allows GET only, so the host is never touched.
+	resp := s.ProxyEnvoyAdmin(context.Background(), cmd)
+	if resp.GetSuccess() || resp.GetEnvoyAdmin().GetStatusCode() != 403 || resp.GetError() == "" {
+		t.Fatalf("POST on a virtual path = %+v", resp)
+	}
+}
+
+func TestJSONAdminResponseStatus(t *testing.T) {
+	cmd := &client.Command{CommandId: "1"}
+	if resp := jsonAdminResponse(cmd, 200, map[string]string{"ok": "yes"}); !resp.GetSuccess() || resp.GetError() != "" {
+		t.Fatalf("200 = %+v", resp)
+	}
+	resp := jsonAdminResponse(cmd, 400, map[string]any{"error": "bad rule", "result": nil})
+	if resp.GetSuccess() || resp.GetError() != "bad rule" || resp.GetEnvoyAdmin().GetStatusCode() != 400 {
+		t.Fatalf("400 = %+v", resp)
+	}
+	if resp := jsonAdminResponse(cmd, 404, []string{}); resp.GetSuccess() || resp.GetError() == "" {
+		t.Fatalf("404 without an error field = %+v", resp)
+	}
+}
@@ -61,6 +61,10 @@ type Reconciler struct {
 	// config logged once does not spam the log every tick. Keyed by subsystem; the
 	// entry is cleared on success or when the failure text changes.
 	lastFailure map[string]string
+	// versionGC is the scheduled version GC setup (see SetVersionGC); lastVersionGC
+	// is when it last ran, as the GC interval is much longer than the reconcile tick.
+	versionGC     VersionGCOptions
+	lastVersionGC time.Time
 }
 
 // NewReconciler builds a reconciler with its own command runner.
@@ -122,6 +126,9 @@ func (r *Reconciler) reconcileOnce(ctx context.Context) {
 	r.reconcileRsyslog(ctx)
 	r.reconcileFilebeat(ctx)
 	r.reconcileLogrotate(ctx)
+	r.reconcileNetworkRoutes()
+	r.reconcileSysctl()
+	r.reconcileVersionGC(ctx)
 }
 
 // needsReassert is the pure reconcile decision: re-apply only when the control
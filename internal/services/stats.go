@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/certs"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/proxy"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/stats"
+	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+const (
+	// DefaultStatsInterval is used when stats.interval is unset or malformed.
+	DefaultStatsInterval = 15 * time.Second
+
+	// StatsSummaryPath is the virtual admin path the control plane sends as a
+	// PROXY command to get the host-wide summary (like "/envoy" for the admin
+	// snapshot); it never reaches an envoy.
+	StatsSummaryPath = "/elchi/stats"
+
+	statsScrapeTimeout = 5 * time.Second
+)
+
+// ListenerStats is one deployment's entry in a StatsSnapshot.
+type ListenerStats struct {
+	Name  string `json:"name"`
+	Port  uint32 `json:"port"`
+	Error string `json:"error,omitempty"`
+	stats.Summary
+}
+
+// StatsSnapshot is the result of one scrape of every deployment.
+type StatsSnapshot struct {
+	CollectedAt time.Time       `json:"collected_at"`
+	Totals      stats.Summary   `json:"totals"`
+	Listeners   []ListenerStats `json:"listeners"`
+	// Certificates is the latest certificate expiry scan, when cert_watch
+	// is enabled.
+	Certificates *certs.Report `json:"certificates,omitempty"`
+	metrics      []byte
+}
+
+// StatsCollector scrapes /stats/prometheus from every deployment on the host,
+// keeps the merged exposition and a per-listener summary, and serves both from
+// one local endpoint.
+type StatsCollector struct {
+	logger *logger.Logger
+
+	mu       sync.RWMutex
+	snapshot *StatsSnapshot
+	prev     map[uint32]stats.Counters
+}
+
+var (
+	activeStatsMu sync.RWMutex
+	activeStats   *StatsCollector
+)
+
+// NewStatsCollector builds an idle collector; Start runs it.
+func NewStatsCollector(log *logger.Logger) *StatsCollector {
+	return &StatsCollector{logger: log, prev: make(map[uint32]stats.Counters)}
+}
+
+// Start scrapes every interval and serves /metrics and /summary on listen
+// (empty disables the endpoint) until ctx is cancelled. The collector is also
+// registered for the PROXY StatsSummaryPath while it runs.
+func (c *StatsCollector) Start(ctx context.Context, interval time.Duration, listen string) {
+	defer helper.RecoverPanic(c.logger, "stats-collector")
+
+	activeStatsMu.Lock()
+	activeStats = c
+	activeStatsMu.Unlock()
+	defer func() {
+		activeStatsMu.Lock()
+		activeStats = nil
+		activeStatsMu.Unlock()
+	}()
+
+	if listen != "" {
+		srv := &http.Server{Addr: listen, Handler: c.Handler(), ReadHeaderTimeout: 5 * time.Second}
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			c.logger.Errorf("Stats endpoint disabled: %v", err)
+		} else {
+			go func() {
+				if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					c.logger.Errorf("Stats endpoint stopped: %v", err)
+				}
+			}()
+			defer srv.Close()
+			c.logger.Infof("Serving envoy stats on http://%s/metrics", listen)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Collect(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect scrapes every deployment once and replaces the snapshot.
+func (c *StatsCollector) Collect(ctx context.Context) *StatsSnapshot {
+	deployments, err := listDeployments()
+	if err != nil {
+		c.logger.Warnf("Stats: failed to list deployments: %v", err)
+	}
+
+	type scraped struct {
+		d    deployment
+		body []byte
+		err  error
+	}
+	results := make([]scraped, len(deployments))
+	var wg sync.WaitGroup
+	for i, d := range deployments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := scrapeDeployment(ctx, d.Port)
+			results[i] = scraped{d: d, body: body, err: err}
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	snap := &StatsSnapshot{CollectedAt: now, Listeners: make([]ListenerStats, 0, len(results)), Certificates: latestCertReport()}
+	var sources []stats.Source
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[uint32]bool, len(results))
+	for _, r := range results {
+		name := strings.TrimSuffix(r.d.Filename, fmt.Sprintf("-%d", r.d.Port))
+		ls := ListenerStats{Name: name, Port: r.d.Port}
+		seen[r.d.Port] = true
+		if r.err != nil {
+			ls.Error = r.err.Error()
+			delete(c.prev, r.d.Port)
+			snap.Listeners = append(snap.Listeners, ls)
+			continue
+		}
+		var prev *stats.Counters
+		if p, ok := c.prev[r.d.Port]; ok {
+			prev = &p
+		}
+		summary, counters := stats.Summarize(stats.ParseSamples(r.body), prev, now)
+		c.prev[r.d.Port] = counters
+		ls.Summary = summary
+		snap.Totals.Add(summary)
+		snap.Listeners = append(snap.Listeners, ls)
+		sources = append(sources, stats.Source{Listener: name, Port: r.d.Port, Text: r.body})
+	}
+	for port := range c.prev {
+		if !seen[port] {
+			delete(c.prev, port)
+		}
+	}
+	snap.metrics = stats.Merge(sources)
+	c.snapshot = snap
+	return snap
+}
+
+// Snapshot returns the latest snapshot, or nil before the first scrape.
+func (c *StatsCollector) Snapshot() *StatsSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snapshot
+}
+
+// Handler serves the merged exposition on /metrics and the summary as JSON on
+// /summary.
+func (c *StatsCollector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		snap := c.Snapshot()
+		if snap == nil {
+			http.Error(w, "no stats collected yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write(snap.metrics)
+	})
+	mux.HandleFunc("/summary", func(w http.ResponseWriter, _ *http.Request) {
+		snap := c.Snapshot()
+		if snap == nil {
+			http.Error(w, "no stats collected yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(snap)
+	})
+	return mux
+}
+
+// currentStatsSummary returns the running collector's snapshot, or scrapes once
+// when stats collection is disabled (rates are then zero: there is no previous
+// sample to compare with).
+func currentStatsSummary(ctx context.Context, log *logger.Logger) *StatsSnapshot {
+	activeStatsMu.RLock()
+	c := activeStats
+	activeStatsMu.RUnlock()
+	if c != nil {
+		if snap := c.Snapshot(); snap != nil {
+			return snap
+		}
+	} else {
+		c = NewStatsCollector(log)
+	}
+	return c.Collect(ctx)
+}
+
+func scrapeDeployment(ctx context.Context, port uint32) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, statsScrapeTimeout)
+	defer cancel()
+	resp, err := proxy.Do(ctx, &client.RequestEnvoyAdmin{
+		Port:   port,
+		Method: client.HttpMethod_GET,
+		Path:   "/stats/prometheus",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return []byte(resp.Body), nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// SysctlPath is the virtual admin path the control plane sends as a PROXY
+// command to manage kernel settings in /etc/sysctl.d/90-elchi.conf. GET
+// returns the current against the desired values and the profiles; POST
+// with a network.SysctlConfig body applies it, and POST
+// "/elchi/sysctl/delete" removes the conf file.
+const SysctlPath = "/elchi/sysctl"
+
+// sysctlResponse answers SysctlPath.
+func sysctlResponse(req *client.RequestEnvoyAdmin, log *logger.Logger) (int32, any) {
+	sm := network.NewSysctlManager(log)
+	post := req.GetMethod() == client.HttpMethod_POST
+
+	switch {
+	case req.GetPath() == SysctlPath && !post:
+		report, err := sm.Status()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		return 200, map[string]any{"report": report, "profiles": network.SysctlProfiles}
+	case req.GetPath() == SysctlPath+"/delete" && post:
+		if err := sm.Remove(); err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		log.WithFields(logger.Fields{"event": "sysctl_removed"}).Info("Removed managed sysctl config")
+		return 200, map[string]string{"removed": network.SysctlConfPath}
+	case req.GetPath() == SysctlPath && post:
+		var cfg network.SysctlConfig
+		if err := json.Unmarshal([]byte(req.GetBody()), &cfg); err != nil {
+			return 400, map[string]string{"error": "invalid body: " + err.Error()}
+		}
+		report, err := sm.Apply(cfg)
+		if err != nil && report == nil {
+			return 400, map[string]string{"error": err.Error()}
+		}
+		log.WithFields(logger.Fields{
+			"event":    "sysctl_applied",
+			"profiles": cfg.Profiles,
+			"keys":     len(report.Values),
+			"changed":  report.Repaired,
+		}).Info("Applied sysctl config")
+		if err != nil {
+			return 500, map[string]any{"error": err.Error(), "report": report}
+		}
+		return 200, report
+	}
+	return 404, map[string]string{"error": "unknown sysctl request"}
+}
+
+// reconcileSysctl puts back the managed kernel settings and conf file when
+// they drifted (a manual sysctl -w, an edited or deleted conf file). It
+// does nothing until a config was applied.
+func (r *Reconciler) reconcileSysctl() {
+	report, err := network.NewSysctlManager(r.logger).Repair()
+	if err != nil {
+		r.reportFailure("sysctl", "reconcile sysctl: "+err.Error())
+		return
+	}
+	if report == nil {
+		return
+	}
+	for _, desc := range report.Repaired {
+		r.logger.WithFields(logger.Fields{"event": "sysctl_drift_repaired", "entry": desc}).Warn("reconcile sysctl: restored " + desc)
+	}
+	if len(report.RepairErrors) > 0 {
+		r.reportFailure("sysctl", "reconcile sysctl: could not restore "+strings.Join(report.RepairErrors, "; "))
+		return
+	}
+	r.clearFailure("sysctl")
+}
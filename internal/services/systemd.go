@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/drain"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/journal"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/systemd"
 	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
@@ -30,6 +31,12 @@ func (s *Services) SystemdServiceAction(ctx context.Context, cmd *client.Command
 	identifier := fmt.Sprintf("%s-%d", serviceReq.GetName(), serviceReq.GetPort())
 	action := cmd.GetSubType()
 
+	var drained *drain.Result
+	if action == client.SubCommandType_SUB_RESTART && drain.Current().OnRestart {
+		res := s.drainListeners(ctx, identifier, serviceReq.GetPort())
+		drained = &res
+	}
+
 	status, err := systemd.ServiceControl(ctx, identifier, action, s.logger, s.runner)
 	if err != nil {
 		return helper.NewErrorResponse(cmd, err.Error())
@@ -41,6 +48,9 @@ func (s *Services) SystemdServiceAction(ctx context.Context, cmd *client.Command
 			Message: fmt.Sprintf("service %s %s", identifier, action),
 		},
 	}
+	if drained != nil {
+		logs = append(logs, &client.Logs{Message: drained.String(), Component: "drain"})
+	}
 
 	return &client.CommandResponse{
 		Identity:  cmd.Identity,
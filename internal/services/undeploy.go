@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/drain"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/files"
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
 	"github.com/CloudNativeWorks/elchi-client/pkg/helper"
@@ -12,6 +14,22 @@ import (
 	client "github.com/CloudNativeWorks/elchi-proto/client"
 )
 
+// drainListeners drains the envoy of serviceName with the configured options
+// before it is stopped, logging the outcome.
+func (s *Services) drainListeners(ctx context.Context, serviceName string, port uint32) drain.Result {
+	res := drain.Run(ctx, port, drain.Current())
+	s.logger.WithFields(logger.Fields{
+		"event":     "envoy_drain",
+		"service":   serviceName,
+		"strategy":  res.Strategy,
+		"initial":   res.Initial,
+		"remaining": res.Remaining,
+		"waited":    res.Waited.Round(time.Millisecond).String(),
+		"drained":   res.Drained,
+	}).Info(res.String())
+	return res
+}
+
 func (s *Services) UndeployService(ctx context.Context, cmd *client.Command) *client.CommandResponse {
 	undeployReq := cmd.GetUndeploy()
 	if undeployReq == nil {
@@ -49,6 +67,10 @@ func (s *Services) UndeployService(ctx context.Context, cmd *client.Command) *cl
 	}
 
 	if serviceExists {
+		// The outcome is reported by the envoy_drain log entry; Files stays the
+		// list of deleted files.
+		s.drainListeners(ctx, serviceName, undeployReq.GetPort())
+
 		s.logger.WithFields(logger.Fields{
 			"service_name": serviceName,
 		}).Debug("Stopping and disabling service")
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/CloudNativeWorks/elchi-client/internal/operations/upgrade"
@@ -16,6 +17,20 @@ func (s *Services) UpgradeListenerService(ctx context.Context, cmd *client.Comma
 		return helper.NewErrorResponse(cmd, "upgrade listener request is nil")
 	}
 
+	// Port 0 with the name upgrade.BatchName addresses the host rather than
+	// one listener: every service on FromVersion matching the selector.
+	batch, isBatch, err := upgrade.ParseBatchName(upgradeReq.GetName())
+	switch {
+	case err != nil:
+		return helper.NewErrorResponse(cmd, err.Error())
+	case isBatch && upgradeReq.GetPort() != 0:
+		return helper.NewErrorResponse(cmd, fmt.Sprintf("a host-wide upgrade (name %q) must use port 0", upgrade.BatchName))
+	case isBatch:
+		return s.upgradeAllListeners(ctx, cmd, upgradeReq, batch)
+	case upgradeReq.GetPort() == 0:
+		return helper.NewErrorResponse(cmd, fmt.Sprintf("port is required; use name %q with port 0 for a host-wide upgrade", upgrade.BatchName))
+	}
+
 	s.logger.Infof("Upgrading listener: %s from version %s to %s on port %d",
 		upgradeReq.GetName(),
 		upgradeReq.GetFromVersion(),
@@ -61,3 +76,46 @@ func (s *Services) UpgradeListenerService(ctx context.Context, cmd *client.Comma
 		},
 	}
 }
+
+func (s *Services) upgradeAllListeners(ctx context.Context, cmd *client.Command, upgradeReq *client.RequestUpgradeListener, opts upgrade.BatchOptions) *client.CommandResponse {
+	opts.FromVersion = upgradeReq.GetFromVersion()
+	opts.ToVersion = upgradeReq.GetToVersion()
+	opts.Graceful = upgradeReq.GetGraceful()
+
+	s.logger.Infof("Upgrading all listeners matching %q from version %s to %s", opts.Selector, opts.FromVersion, opts.ToVersion)
+	results, err := upgrade.UpgradeListeners(ctx, opts, s.logger, s.runner)
+	if err != nil && results == nil {
+		s.logger.Errorf("Failed to upgrade listeners: %v", err)
+		return helper.NewErrorResponse(cmd, fmt.Sprintf("failed to upgrade listeners: %v", err))
+	}
+
+	// The response has no repeated field, so the per-service report travels as
+	// JSON in EnvoyRestarted; SystemdServiceUpdated carries the counts.
+	report, jerr := json.Marshal(results)
+	if jerr != nil {
+		return helper.NewErrorResponse(cmd, fmt.Sprintf("failed to encode upgrade report: %v", jerr))
+	}
+	summary := upgrade.SummarizeBatch(results)
+	resp := &client.CommandResponse{
+		Identity:  cmd.Identity,
+		CommandId: cmd.CommandId,
+		Success:   err == nil,
+		Result: &client.CommandResponse_UpgradeListener{
+			UpgradeListener: &client.ResponseUpgradeListener{
+				Name:                  upgradeReq.GetName(),
+				FromVersion:           opts.FromVersion,
+				ToVersion:             opts.ToVersion,
+				Graceful:              opts.Graceful,
+				SystemdServiceUpdated: summary,
+				EnvoyRestarted:        string(report),
+			},
+		},
+	}
+	if err != nil {
+		s.logger.Errorf("Batch upgrade failed (%s): %v", summary, err)
+		resp.Error = fmt.Sprintf("%v (%s)", err, summary)
+	} else {
+		s.logger.Infof("Batch upgrade from %s to %s finished: %s", opts.FromVersion, opts.ToVersion, summary)
+	}
+	return resp
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/common"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/envoy"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/proxy"
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/waf"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	"github.com/CloudNativeWorks/elchi-client/pkg/models"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// VersionGCOptions configures the scheduled version GC (the version_gc section).
+// The schedule piggybacks on the reconcile loop, so disabling that loop disables
+// scheduled GC as well.
+type VersionGCOptions struct {
+	// Interval between scheduled passes; <= 0 disables them.
+	Interval time.Duration
+	Policy   common.GCPolicy
+}
+
+// VersionGCReport is the per-component outcome of a version GC run.
+type VersionGCReport struct {
+	Envoy *common.GCResult `json:"envoy,omitempty"`
+	WAF   *common.GCResult `json:"waf,omitempty"`
+	// WAFSkipped explains why WAF versions were left alone (see wafVersionsInUse).
+	WAFSkipped string `json:"waf_skipped,omitempty"`
+	// Partials are abandoned partial downloads (see common.PartialDownloadTTL).
+	Partials *common.GCResult `json:"partials,omitempty"`
+}
+
+// ReclaimedBytes sums the space reclaimed across components.
+func (r *VersionGCReport) ReclaimedBytes() int64 {
+	var total int64
+	if r.Envoy != nil {
+		total += r.Envoy.ReclaimedBytes
+	}
+	if r.WAF != nil {
+		total += r.WAF.ReclaimedBytes
+	}
+	if r.Partials != nil {
+		total += r.Partials.ReclaimedBytes
+	}
+	return total
+}
+
+// RunVersionGC garbage-collects unused envoy and WAF versions and abandoned
+// partial downloads. It is shared by the `elchi-client versions gc` command and
+// the scheduled pass in the reconcile loop.
+func RunVersionGC(ctx context.Context, policy common.GCPolicy, dryRun bool, log *logger.Logger) (*VersionGCReport, error) {
+	report := &VersionGCReport{}
+	var errs []string
+
+	envoyInUse, err := envoyVersionsInUse()
+	if err != nil {
+		// Without a complete in-use set any deletion could hit a live version.
+		errs = append(errs, fmt.Sprintf("envoy: %v", err))
+	} else {
+		res, err := envoy.NewManager().PruneUnused(envoyInUse, policy, dryRun)
+		report.Envoy = res
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("envoy: %v", err))
+		}
+	}
+
+	wafInUse, err := wafVersionsInUse(ctx)
+	if err != nil {
+		report.WAFSkipped = err.Error()
+		log.Warnf("Skipping WAF version GC: %v", err)
+	} else {
+		res, err := waf.NewManager().PruneUnused(wafInUse, policy, dryRun)
+		report.WAF = res
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("waf: %v", err))
+		}
+	}
+
+	// Partial downloads are not versions, so the keep policy does not apply;
+	// only their age does.
+	res, err := common.PrunePartialDownloads(common.DownloadCacheDir, common.PartialDownloadTTL, dryRun)
+	report.Partials = res
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("partial downloads: %v", err))
+	}
+
+	log.WithFields(logger.Fields{
+		"reclaimed_bytes": report.ReclaimedBytes(),
+		"dry_run":         dryRun,
+		"keep":            policy.KeepN,
+		"keep_newer_than": policy.KeepNewerThan.String(),
+	}).Info("Version GC completed")
+
+	if len(errs) > 0 {
+		return report, fmt.Errorf("version gc: %s", strings.Join(errs, "; "))
+	}
+	return report, nil
+}
+
+// envoyVersionsInUse collects every envoy version referenced by an installed
+// unit file or bootstrap, plus any version a running process was started from
+// (a unit rewritten by an upgrade still has the old binary running until its
+// restart completes).
+func envoyVersionsInUse() (map[string]bool, error) {
+	contents, err := readReferenceSources()
+	if err != nil {
+		return nil, err
+	}
+	contents = append(contents, runningCommandLines()...)
+	return common.FindVersionReferences(envoy.DefaultBaseDir, "envoy", contents...), nil
+}
+
+// wafVersionsInUse collects WAF versions referenced on disk AND in every
+// deployment's live config. The wasm path usually arrives over xDS, so it is
+// only visible in /config_dump: if any deployment cannot be inspected the
+// in-use set is unknowable and an error is returned so no WAF version is
+// deleted (fail closed).
+func wafVersionsInUse(ctx context.Context) (map[string]bool, error) {
+	contents, err := readReferenceSources()
+	if err != nil {
+		return nil, err
+	}
+
+	deployments, err := listDeployments()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	for _, d := range deployments {
+		dumpCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		resp, err := proxy.Do(dumpCtx, &client.RequestEnvoyAdmin{
+			Port:   d.Port,
+			Method: client.HttpMethod_GET,
+			Path:   "/config_dump",
+		})
+		cancel()
+		if err != nil || resp.StatusCode != 200 {
+			if err == nil {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+			return nil, fmt.Errorf("cannot read live config of %s: %v", d.Filename, err)
+		}
+		contents = append(contents, []byte(resp.Body))
+	}
+	return common.FindVersionReferences(waf.DefaultBaseDir, "coraza.wasm", contents...), nil
+}
+
+// readReferenceSources returns the contents of all unit files and bootstraps.
+func readReferenceSources() ([][]byte, error) {
+	var contents [][]byte
+	for _, pattern := range []string{
+		filepath.Join(models.SystemdPath, "*.service"),
+		filepath.Join(models.BootstrapsPath, "*.yaml"),
+	} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			contents = append(contents, data)
+		}
+	}
+	return contents, nil
+}
+
+// runningCommandLines returns the command line of every process. cmdline is
+// world-readable, unlike the /proc/<pid>/exe link, which needs ptrace rights
+// over envoyuser's processes; envoy is always exec'd by its full path so the
+// binary shows up in argv[0]. Processes exiting mid-scan are skipped.
+func runningCommandLines() [][]byte {
+	entries, err := os.ReadDir(models.ProcPath)
+	if err != nil {
+		return nil
+	}
+	var out [][]byte
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		if cmdline, err := os.ReadFile(filepath.Join(models.ProcPath, e.Name(), "cmdline")); err == nil {
+			out = append(out, cmdline)
+		}
+	}
+	return out
+}
+
+// SetVersionGC enables the scheduled version GC with the given options. Until it
+// is called the reconciler does not collect versions.
+func (r *Reconciler) SetVersionGC(opts VersionGCOptions) {
+	r.versionGC = opts
+}
+
+// reconcileVersionGC runs the scheduled version GC when its interval elapsed.
+func (r *Reconciler) reconcileVersionGC(ctx context.Context) {
+	interval := r.versionGC.Interval
+	if interval <= 0 || time.Since(r.lastVersionGC) < interval {
+		return
+	}
+	r.lastVersionGC = time.Now()
+
+	if _, err := RunVersionGC(ctx, r.versionGC.Policy, false, r.logger); err != nil {
+		r.reportFailure("version-gc", fmt.Sprintf("scheduled version GC failed: %v", err))
+		return
+	}
+	r.clearFailure("version-gc")
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/CloudNativeWorks/elchi-client/internal/operations/network"
+	"github.com/CloudNativeWorks/elchi-client/pkg/logger"
+	client "github.com/CloudNativeWorks/elchi-proto/client"
+)
+
+// VRFsPath is the virtual admin path the control plane sends as a PROXY
+// command to manage VRF devices; NetworkRequest has no VRF operations. GET
+// lists the VRFs; POST "/elchi/vrfs/<name>" with {"table","interfaces"}
+// creates the VRF or sets its members, and POST "/elchi/vrfs/<name>/delete"
+// removes it.
+const VRFsPath = "/elchi/vrfs"
+
+type vrfRequest struct {
+	Table      uint32   `json:"table"`
+	Interfaces []string `json:"interfaces"`
+}
+
+// vrfsResponse answers VRFsPath.
+func vrfsResponse(req *client.RequestEnvoyAdmin, log *logger.Logger) (int32, any) {
+	rest := strings.Trim(strings.TrimPrefix(req.GetPath(), VRFsPath), "/")
+	name, action, _ := strings.Cut(rest, "/")
+	post := req.GetMethod() == client.HttpMethod_POST
+	vm := network.NewVRFManager(log)
+
+	switch {
+	case name == "" && !post:
+		vrfs, err := vm.ListVRFs()
+		if err != nil {
+			return 500, map[string]string{"error": err.Error()}
+		}
+		if vrfs == nil {
+			vrfs = []network.VRF{}
+		}
+		return 200, vrfs
+	case name != "" && action == "" && post:
+		var body vrfRequest
+		if err := json.Unmarshal([]byte(req.GetBody()), &body); err != nil {
+			return 400, map[string]string{"error": "invalid body: " + err.Error()}
+		}
+		v := network.VRF{Name: name, Table: body.Table, Interfaces: body.Interfaces}
+		if err := vm.ApplyVRF(v); err != nil {
+			return 400, map[string]string{"error": err.Error()}
+		}
+		log.WithFields(logger.Fields{
+			"event":      "vrf_applied",
+			"vrf":        name,
+			"table":      body.Table,
+			"interfaces": body.Interfaces,
+		}).Info("Applied VRF " + name)
+		return 200, map[string]any{"vrf": v, "warnings": vm.PersistWarnings()}
+	case name != "" && action == "delete" && post:
+		if err := vm.DeleteVRF(name); err != nil {
+			return 400, map[string]string{"error": err.Error()}
+		}
+		log.WithFields(logger.Fields{"event": "vrf_deleted", "vrf": name}).Info("Deleted VRF " + name)
+		return 200, map[string]any{"removed": name, "warnings": vm.PersistWarnings()}
+	}
+	return 404, map[string]string{"error": "unknown VRF request"}
+}
@@ -0,0 +1,19 @@
+package template
+
+// FirewallUnitTemplate loads the persisted inet elchi nftables table before
+// the network comes up. The only argument is the ruleset path.
+const FirewallUnitTemplate = `[Unit]
+Description=Elchi host firewall (nftables table inet elchi)
+DefaultDependencies=no
+After=local-fs.target
+Before=network-pre.target
+Wants=network-pre.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/sbin/nft -f %s
+
+[Install]
+WantedBy=multi-user.target
+`
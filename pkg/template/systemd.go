@@ -1,5 +1,7 @@
 package template
 
+import "strings"
+
 var SystemdTemplate = `[Unit]
 Description=Elchi Envoy (%s)
 Requires=network-online.target
@@ -46,3 +48,34 @@ SyslogIdentifier=elchi-%s
 [Install]
 WantedBy=multi-user.target
 `
+
+// pythonHotRestarterExec is the ExecStart prefix in SystemdTemplate that runs
+// envoy under hotrestarter.py.
+const pythonHotRestarterExec = "/usr/bin/env python3 /var/lib/elchi/hotrestarter/hotrestarter.py"
+
+// NativeSystemdTemplate returns SystemdTemplate with envoy supervised by the
+// built-in `<binary> hotrestart` command instead of hotrestarter.py. Only the
+// ExecStart prefix differs, so it takes exactly the same Sprintf arguments and
+// every other unit setting stays defined in one place.
+func NativeSystemdTemplate(binary string) string {
+	// The result is a format string: a literal % in the path must be escaped.
+	execStart := strings.ReplaceAll(binary, "%", "%%") + " hotrestart"
+	return strings.Replace(SystemdTemplate, pythonHotRestarterExec, execStart, 1)
+}
+
+// unitTemplate is the template deploy/update paths render units from. It is
+// selected once at startup (UseNativeHotRestarter) before any command runs.
+var unitTemplate = SystemdTemplate
+
+// UseNativeHotRestarter switches newly rendered units to the native supervisor
+// at binary. Units already on disk are rewritten the next time their deployment
+// is checked, since the rendered content no longer matches.
+func UseNativeHotRestarter(binary string) {
+	unitTemplate = NativeSystemdTemplate(binary)
+}
+
+// UnitTemplate returns the envoy unit template currently in use. It has the
+// same argument shape as SystemdTemplate.
+func UnitTemplate() string {
+	return unitTemplate
+}
@@ -54,3 +54,27 @@ func TestPythonWrapperIsRawScript(t *testing.T) {
 		t.Errorf("PythonWrapper contains a malformed-verb marker")
 	}
 }
+
+// The native variant must differ from SystemdTemplate only in the supervisor
+// prefix and keep the exact Sprintf arg shape the deploy paths rely on.
+func TestNativeSystemdTemplateSwapsOnlyExecStart(t *testing.T) {
+	tmpl := NativeSystemdTemplate("/usr/local/bin/elchi-client")
+	if strings.Contains(tmpl, "hotrestarter.py") {
+		t.Fatalf("native template still references hotrestarter.py")
+	}
+	if !strings.Contains(tmpl, "ExecStart=/usr/local/bin/elchi-client hotrestart \\") {
+		t.Fatalf("native template missing hotrestart ExecStart:\n%s", tmpl)
+	}
+	out := fmt.Sprintf(tmpl, "web", "1.34.0", "web-80", "1.34.0", "web-80", 80, "web-80", "web-80")
+	if strings.Contains(out, "%!") {
+		t.Fatalf("native template has a verb/arg mismatch:\n%s", out)
+	}
+	if !strings.Contains(out, "--base-id 80") {
+		t.Errorf("rendered native unit lost the base-id")
+	}
+
+	// A % in the binary path must not turn into a format verb.
+	if out := fmt.Sprintf(NativeSystemdTemplate("/opt/100%/elchi-client"), "web", "1.34.0", "web-80", "1.34.0", "web-80", 80, "web-80", "web-80"); !strings.Contains(out, "/opt/100%/elchi-client hotrestart") {
+		t.Errorf("percent in binary path was not escaped:\n%s", out)
+	}
+}
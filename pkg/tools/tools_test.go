@@ -32,3 +32,38 @@ func TestGetIPv4CIDR(t *testing.T) {
 		})
 	}
 }
+
+// GetHostCIDRs derives every address of a deployment's dummy interface, so a
+// dual-stack deployment gets one host route per family.
+func TestGetHostCIDRs(t *testing.T) {
+	accepted := map[string][]string{
+		"10.0.0.1":                 {"10.0.0.1/32"},
+		"2001:db8::5":              {"2001:db8::5/128"},
+		"10.0.0.1, 2001:db8::5":    {"10.0.0.1/32", "2001:db8::5/128"},
+		"2001:0db8::0005,10.0.0.1": {"2001:db8::5/128", "10.0.0.1/32"},
+	}
+	for in, want := range accepted {
+		got, err := GetHostCIDRs(in)
+		if err != nil {
+			t.Errorf("GetHostCIDRs(%q): %v", in, err)
+			continue
+		}
+		if len(got) != len(want) || got[0] != want[0] || got[len(got)-1] != want[len(want)-1] {
+			t.Errorf("GetHostCIDRs(%q) = %v, want %v", in, got, want)
+		}
+	}
+
+	rejected := map[string]string{
+		"empty":        "",
+		"CIDR":         "2001:db8::5/64",
+		"two IPv4":     "10.0.0.1,10.0.0.2",
+		"two IPv6":     "2001:db8::5,2001:db8::6",
+		"link-local":   "fe80::1",
+		"empty member": "10.0.0.1,",
+	}
+	for name, in := range rejected {
+		if _, err := GetHostCIDRs(in); err == nil {
+			t.Errorf("%s: GetHostCIDRs(%q) should have errored", name, in)
+		}
+	}
+}
@@ -25,6 +25,41 @@ func GetIPv4CIDR(ipv4 string) (string, error) {
 	return ipv4 + "/32", nil
 }
 
+// GetHostCIDRs turns a downstream address into the host CIDRs of its dummy
+// interface: an IPv4 becomes a /32 and an IPv6 a /128. A dual-stack
+// deployment lists one of each, separated by a comma.
+func GetHostCIDRs(addresses string) ([]string, error) {
+	var cidrs []string
+	var v4, v6 bool
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if strings.Contains(address, "/") {
+			return nil, fmt.Errorf("invalid address: CIDR notation not allowed, got %s", address)
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address: %q", address)
+		}
+		if ip.To4() != nil {
+			if v4 {
+				return nil, fmt.Errorf("more than one IPv4 address in %s", addresses)
+			}
+			v4 = true
+			cidrs = append(cidrs, ip.String()+"/32")
+			continue
+		}
+		if ip.IsLinkLocalUnicast() {
+			return nil, fmt.Errorf("link-local address %s cannot be a downstream address", address)
+		}
+		if v6 {
+			return nil, fmt.Errorf("more than one IPv6 address in %s", addresses)
+		}
+		v6 = true
+		cidrs = append(cidrs, ip.String()+"/128")
+	}
+	return cidrs, nil
+}
+
 // this is for development debuging
 func PrettyPrint(data any) {
 	if data == nil {